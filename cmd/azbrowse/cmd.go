@@ -16,6 +16,7 @@ import (
 	"github.com/lawrencegripper/azbrowse/internal/pkg/config"
 	"github.com/lawrencegripper/azbrowse/internal/pkg/errorhandling"
 	"github.com/lawrencegripper/azbrowse/internal/pkg/eventing"
+	"github.com/lawrencegripper/azbrowse/internal/pkg/expanders"
 	"github.com/lawrencegripper/azbrowse/internal/pkg/filesystem"
 	"github.com/lawrencegripper/azbrowse/internal/pkg/storage"
 	"github.com/lawrencegripper/azbrowse/internal/pkg/tracing"
@@ -59,6 +60,8 @@ func createRootCmd() *cobra.Command {
 	var fuzzerDurationMinutes int
 	var tenantID string
 	var subscription string
+	var managementGroups bool
+	var offline bool
 
 	// Start tracking the last node navigated to in storage for the `resume` command
 	go func() {
@@ -84,6 +87,7 @@ func createRootCmd() *cobra.Command {
 			if demo {
 				settings.HideGuids = true
 			}
+			settings.Offline = offline
 
 			if debug {
 				settings.EnableTracing = true
@@ -110,6 +114,11 @@ func createRootCmd() *cobra.Command {
 				settings.ShouldRender = false
 			}
 
+			// Management group IDs can only be navigated to via the management groups root
+			if managementGroups || strings.HasPrefix(strings.ToLower(settings.NavigateToID), strings.ToLower(expanders.ManagementGroupsRootID)) {
+				settings.ShowManagementGroups = true
+			}
+
 			if fuzzerDurationMinutes > 0 {
 				settings.FuzzerEnabled = true
 				settings.FuzzerDurationMinutes = fuzzerDurationMinutes
@@ -150,8 +159,10 @@ func createRootCmd() *cobra.Command {
 	cmd.Flags().StringVarP(&navigateTo, "navigate", "n", "", "(optional) navigate to resource by resource ID")
 	cmd.Flags().StringVar(&tenantID, "tenant-id", "", "(optional) specify the tenant id to get an access token for (see az account list -o json)")
 	cmd.Flags().StringVarP(&subscription, "subscription", "s", "", "(optional) specify a subscription to load")
+	cmd.Flags().BoolVar(&managementGroups, "management-groups", false, "(optional) show the management group hierarchy alongside the subscriptions")
 	cmd.Flags().BoolVarP(&resume, "resume", "r", false, "(optional) resume navigating from your last session")
 	cmd.Flags().BoolVar(&debug, "debug", false, "run in debug mode")
+	cmd.Flags().BoolVar(&offline, "offline", false, "(optional) browse using only responses cached by previous sessions")
 	cmd.Flags().BoolVar(&demo, "demo", false, "run in demo mode to filter sensitive output")
 	cmd.Flags().IntVar(&fuzzerDurationMinutes, "fuzzer", -1, "run fuzzer (optionally specify the duration in minutes)")
 
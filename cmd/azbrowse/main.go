@@ -47,7 +47,9 @@ func run(settings *config.Settings) {
 	ctx, span := configureTracing(settings)
 
 	// Note self update now requires storage loaded first.
-	confirmAndSelfUpdate()
+	if !settings.Offline {
+		confirmAndSelfUpdate()
+	}
 
 	// Start tracking async responses from ARM
 	responseProcessor, err := views.StartWatchingAsyncARMRequests(ctx)
@@ -62,6 +64,19 @@ func run(settings *config.Settings) {
 	// Initialize the expanders which will let the user walk the tree of
 	// resources in Azure
 	expanders.InitializeExpanders(armClient)
+	var declarativeErr error
+	responseCacheConfig := config.ResponseCacheConfig{}
+	if userConfig, err := config.Load(); err == nil {
+		expanders.ShowAdvisorIndicators = userConfig.ShowAdvisorIndicators
+		expanders.SetExpanderTimeouts(userConfig.ExpanderTimeouts)
+		expanders.InitializePlugins(armClient, userConfig.Plugins)
+		declarativeErr = expanders.InitializeDeclarativeExpanders(armClient, userConfig.GetExpandersDir())
+		responseCacheConfig = userConfig.ResponseCache
+	}
+	if responseCacheConfig.Enabled || settings.Offline {
+		armClient.SetResponseCache(newResponseCache(responseCacheConfig, settings))
+	}
+	defer expanders.StopPlugins()
 
 	// Start up gocui and configure some settings
 	g, err := gocui.NewGui(gocui.OutputNormal)
@@ -80,17 +95,19 @@ func run(settings *config.Settings) {
 	defer errorhandling.RecoveryWithCleanup()
 
 	// Asynconously update the cache we're holding for autocomplete
-	go func() {
-		// No error checking as these are fire and forget cache update methods.
-		// if they fail we don't want to interrupt normal operation
-		defer errorhandling.RecoveryWithCleanup()
-		accountItems, _ := getAccountListAndUpdateCache() //nolint: errcheck
-		var allSubscriptionGUIDs []string
-		for _, sub := range accountItems {
-			allSubscriptionGUIDs = append(allSubscriptionGUIDs, sub.ID)
-		}
-		getResourceListAndUpdateCache(allSubscriptionGUIDs, armClient) //nolint: errcheck
-	}()
+	if !settings.Offline {
+		go func() {
+			// No error checking as these are fire and forget cache update methods.
+			// if they fail we don't want to interrupt normal operation
+			defer errorhandling.RecoveryWithCleanup()
+			accountItems, _ := getAccountListAndUpdateCache() //nolint: errcheck
+			var allSubscriptionGUIDs []string
+			for _, sub := range accountItems {
+				allSubscriptionGUIDs = append(allSubscriptionGUIDs, sub.ID)
+			}
+			getResourceListAndUpdateCache(allSubscriptionGUIDs, armClient) //nolint: errcheck
+		}()
+	}
 
 	// Configure the gui instance
 	g.Highlight = true
@@ -101,8 +118,23 @@ func run(settings *config.Settings) {
 	// bind up all the keys use to interact with the views
 	list := setupViewsAndKeybindings(ctx, g, settings, armClient)
 
+	// Report invalid expander definitions now the statusbar is listening
+	if declarativeErr != nil {
+		eventing.SendStatusEvent(&eventing.StatusEvent{
+			Failure: true,
+			Message: declarativeErr.Error(),
+			Timeout: time.Second * 15,
+		})
+	}
+	if settings.Offline {
+		eventing.SendStatusEvent(&eventing.StatusEvent{
+			Message: "Offline: showing responses cached by previous sessions",
+			Timeout: time.Second * 15,
+		})
+	}
+
 	// Start a go routine to populate the list with root of the nodes
-	startPopulatingList(ctx, g, list, armClient)
+	startPopulatingList(ctx, g, list, armClient, settings)
 
 	// Start a go routine to handling automated naviging to an item via the
 	// `--navigate` command
@@ -123,6 +155,25 @@ func run(settings *config.Settings) {
 	}
 }
 
+// newResponseCache creates the armclient response cache from the user config
+func newResponseCache(cacheConfig config.ResponseCacheConfig, settings *config.Settings) *armclient.ResponseCache {
+	typeTTLs := map[string]time.Duration{}
+	for armType, seconds := range cacheConfig.TypeTTLs {
+		typeTTLs[armType] = time.Duration(seconds) * time.Second
+	}
+	ttl := cacheConfig.GetTTL()
+	if !cacheConfig.Enabled {
+		// Offline without caching enabled only serves responses cached in previous sessions
+		ttl = 0
+	}
+	return &armclient.ResponseCache{
+		DefaultTTL: ttl,
+		TypeTTLs:   typeTTLs,
+		TenantID:   settings.TenantID,
+		Offline:    settings.Offline,
+	}
+}
+
 func configureTracing(settings *config.Settings) (context.Context, opentracing.Span) {
 	var ctx context.Context
 	var span opentracing.Span
@@ -163,7 +214,7 @@ func configureTracing(settings *config.Settings) (context.Context, opentracing.S
 	return ctx, span
 }
 
-func startPopulatingList(ctx context.Context, g *gocui.Gui, list *views.ListWidget, armClient *armclient.Client) {
+func startPopulatingList(ctx context.Context, g *gocui.Gui, list *views.ListWidget, armClient *armclient.Client, settings *config.Settings) {
 	go func() {
 		defer errorhandling.RecoveryWithCleanup()
 
@@ -187,6 +238,9 @@ func startPopulatingList(ctx context.Context, g *gocui.Gui, list *views.ListWidg
 				ItemType:  expanders.TentantItemType,
 				ID:        "AvailableSubscriptions",
 				ExpandURL: expanders.ExpandURLNotSupported,
+				Metadata: map[string]string{
+					expanders.ShowManagementGroupsMeta: fmt.Sprintf("%t", settings.ShowManagementGroups),
+				},
 			})
 
 			if err != nil {
@@ -224,6 +278,21 @@ func setupViewsAndKeybindings(ctx context.Context, g *gocui.Gui, settings *confi
 
 	commandPanelFilterCommand := keybindings.NewCommandPanelFilterHandler(commandPanel, list)
 	commandPanelAzureSearchQueryCommand := keybindings.NewCommandPanelAzureSearchQueryHandler(commandPanel, content, list)
+	commandPanelKQLQueryCommand := keybindings.NewCommandPanelKQLQueryHandler(commandPanel, list)
+	commandPanelKQLQueryTimeRangeCommand := keybindings.NewCommandPanelKQLQueryTimeRangeHandler(commandPanel, list)
+	commandPanelKQLQueryExportCommand := keybindings.NewCommandPanelKQLQueryExportHandler(commandPanel, content)
+	commandPanelAddRoleAssignmentCommand := keybindings.NewCommandPanelAddRoleAssignmentHandler(g, commandPanel, list, ctx)
+	commandPanelRemoveRoleAssignmentCommand := keybindings.NewCommandPanelRemoveRoleAssignmentHandler(commandPanel, list, ctx)
+	commandPanelAddLockCommand := keybindings.NewCommandPanelAddLockHandler(g, commandPanel, list, ctx)
+	commandPanelRemoveLockCommand := keybindings.NewCommandPanelRemoveLockHandler(commandPanel, list, ctx)
+	commandPanelMetricsTimeRangeCommand := keybindings.NewCommandPanelMetricsTimeRangeHandler(g, commandPanel, list)
+	commandPanelMetricsIntervalCommand := keybindings.NewCommandPanelMetricsIntervalHandler(commandPanel, list)
+	commandPanelMetricsAggregationCommand := keybindings.NewCommandPanelMetricsAggregationHandler(commandPanel, list)
+	commandPanelMetricsDimensionCommand := keybindings.NewCommandPanelMetricsDimensionHandler(g, commandPanel, list)
+	commandPanelListWatchCommand := keybindings.NewCommandPanelListWatchHandler(commandPanel, list)
+	commandPanelTailLogsCommand := keybindings.NewCommandPanelTailLogsHandler(g, commandPanel, list, views.NewLogTail(g, content), ctx)
+	commandPanelVMRunCommand := keybindings.NewCommandPanelVMRunCommandHandler(g, commandPanel, list, content, ctx)
+	commandPanelGoToReferenceCommand := keybindings.NewCommandPanelGoToReferenceHandler(commandPanel, list, content)
 
 	listActionsCommand := keybindings.NewListActionsHandler(list, ctx)
 	listOpenCommand := keybindings.NewListOpenHandler(list, ctx)
@@ -231,17 +300,36 @@ func setupViewsAndKeybindings(ctx context.Context, g *gocui.Gui, settings *confi
 	itemCopyItemIDCommand := keybindings.NewItemCopyItemIDHandler(content, status)
 	listDebugCopyItemDataCommand := keybindings.NewListDebugCopyItemDataHandler(list, status)
 	listSortCommand := keybindings.NewListSortHandler(list)
+	expanderHealthCommand := keybindings.NewExpanderHealthHandler(content)
+	listExplainExpandCommand := keybindings.NewListExplainExpandHandler(list, content)
 
 	commands := []keybindings.Command{
 		commandPanelFilterCommand,
 		copyCommand,
 		commandPanelAzureSearchQueryCommand,
+		commandPanelKQLQueryCommand,
+		commandPanelKQLQueryTimeRangeCommand,
+		commandPanelKQLQueryExportCommand,
+		commandPanelAddRoleAssignmentCommand,
+		commandPanelRemoveRoleAssignmentCommand,
+		commandPanelAddLockCommand,
+		commandPanelRemoveLockCommand,
+		commandPanelMetricsTimeRangeCommand,
+		commandPanelMetricsIntervalCommand,
+		commandPanelMetricsAggregationCommand,
+		commandPanelMetricsDimensionCommand,
+		commandPanelListWatchCommand,
+		commandPanelTailLogsCommand,
+		commandPanelVMRunCommand,
+		commandPanelGoToReferenceCommand,
 		listActionsCommand,
 		listOpenCommand,
 		listUpdateCommand,
 		itemCopyItemIDCommand,
 		toggleDemoModeCommand,
 		listSortCommand,
+		expanderHealthCommand,
+		listExplainExpandCommand,
 	}
 	if settings.EnableTracing {
 		commands = append(commands, listDebugCopyItemDataCommand)
@@ -277,6 +365,7 @@ func setupViewsAndKeybindings(ctx context.Context, g *gocui.Gui, settings *confi
 	keybindings.AddHandler(keybindings.NewListUpHandler(list))
 	keybindings.AddHandler(keybindings.NewListExpandHandler(list))
 	keybindings.AddHandler(keybindings.NewListRefreshHandler(list))
+	keybindings.AddHandler(keybindings.NewListCancelExpandHandler(list))
 	keybindings.AddHandler(keybindings.NewListBackHandler(list))
 	keybindings.AddHandler(keybindings.NewListBackLegacyHandler(list))
 	keybindings.AddHandler(listActionsCommand)
@@ -291,8 +380,25 @@ func setupViewsAndKeybindings(ctx context.Context, g *gocui.Gui, settings *confi
 	keybindings.AddHandler(keybindings.NewListHomeHandler(list))
 	keybindings.AddHandler(keybindings.NewListClearFilterHandler(list))
 	keybindings.AddHandler(commandPanelAzureSearchQueryCommand)
+	keybindings.AddHandler(commandPanelKQLQueryCommand)
+	keybindings.AddHandler(commandPanelKQLQueryTimeRangeCommand)
+	keybindings.AddHandler(commandPanelKQLQueryExportCommand)
+	keybindings.AddHandler(commandPanelAddRoleAssignmentCommand)
+	keybindings.AddHandler(commandPanelRemoveRoleAssignmentCommand)
+	keybindings.AddHandler(commandPanelAddLockCommand)
+	keybindings.AddHandler(commandPanelRemoveLockCommand)
+	keybindings.AddHandler(commandPanelMetricsTimeRangeCommand)
+	keybindings.AddHandler(commandPanelMetricsIntervalCommand)
+	keybindings.AddHandler(commandPanelMetricsAggregationCommand)
+	keybindings.AddHandler(commandPanelMetricsDimensionCommand)
+	keybindings.AddHandler(commandPanelListWatchCommand)
+	keybindings.AddHandler(commandPanelTailLogsCommand)
+	keybindings.AddHandler(commandPanelVMRunCommand)
+	keybindings.AddHandler(commandPanelGoToReferenceCommand)
 	keybindings.AddHandler(itemCopyItemIDCommand)
 	keybindings.AddHandler(listSortCommand)
+	keybindings.AddHandler(expanderHealthCommand)
+	keybindings.AddHandler(listExplainExpandCommand)
 	if settings.EnableTracing {
 		keybindings.AddHandler(listDebugCopyItemDataCommand)
 	}
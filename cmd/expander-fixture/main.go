@@ -0,0 +1,48 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/lawrencegripper/azbrowse/internal/pkg/expanders/expanderstest"
+	"github.com/lawrencegripper/azbrowse/pkg/armclient"
+)
+
+// Captures a response from ARM into a fixture file for use with expanderstest, eg
+//
+//   go run ./cmd/expander-fixture -o ./internal/pkg/expanders/testdata/armsamples/sql/firewallRules.json \
+//       "/subscriptions/<sub>/resourceGroups/<rg>/providers/Microsoft.Sql/servers/<server>/firewallRules?api-version=2014-04-01"
+//
+// Authentication uses the Azure CLI. Secrets are redacted from the response and GUIDs replaced with
+// the zero GUID but check the fixture before committing it
+
+func main() {
+	var output string
+	var method string
+	var body string
+	var tenantID string
+	flag.StringVar(&output, "o", "", "the fixture file to write")
+	flag.StringVar(&method, "method", "GET", "the request method")
+	flag.StringVar(&body, "body", "", "(optional) the request body")
+	flag.StringVar(&tenantID, "tenant-id", "", "(optional) the tenant id to get an access token for")
+	flag.Usage = func() {
+		fmt.Fprintln(flag.CommandLine.Output(), "Usage: expander-fixture -o <file> <path>")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if output == "" || flag.NArg() != 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	client := armclient.NewClientFromCLI(tenantID)
+	err := expanderstest.CaptureFixture(context.Background(), client, method, flag.Arg(0), body, output)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	fmt.Println("Wrote " + output)
+}
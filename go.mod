@@ -20,7 +20,7 @@ require (
 	github.com/go-openapi/swag v0.18.0 // indirect
 	github.com/go-xmlfmt/xmlfmt v0.0.0-20191208150333-d5b6f63a941b
 	github.com/google/go-cmp v0.3.1 // indirect
-	github.com/guptarohit/asciigraph v0.4.2-0.20190112130928-1bc9b2452856
+	github.com/guptarohit/asciigraph v0.7.3
 	github.com/hokaccha/go-prettyjson v0.0.0-20180920040306-f579f869bbfe // indirect
 	github.com/mailru/easyjson v0.0.0-20190221075403-6243d8e04c3f // indirect
 	github.com/mattn/go-runewidth v0.0.3 // indirect
@@ -11,54 +11,81 @@ import (
 var navigateToInProgress = true
 
 // NavigateTo will navigate through the tree to a node with
-// a matching ItemID or as far as it can get
+// a matching ItemID or as far as it can get.
+// Navigation starts from the nodes in the next `list.navigated` event so
+// either call this before the list is populated or follow it with `list.GoHome()`
 func NavigateTo(list *views.ListWidget, itemID string) {
 
 	navigateToIDLower := strings.ToLower(itemID)
+	navigateToInProgress = true
+	// Subscribe before returning so the caller's navigation isn't missed
+	navigatedChannel := eventing.SubscribeToTopic("list.navigated")
 	go func() {
-		navigatedChannel := eventing.SubscribeToTopic("list.navigated")
+		defer eventing.Unsubscribe(navigatedChannel)
 		var lastNavigatedNode *expanders.TreeNode
 
 		for {
 			navigateStateInterface := <-navigatedChannel
 
-			if navigateToInProgress {
-				navigateState := navigateStateInterface.(views.ListNavigatedEventState)
-				if !navigateState.Success {
-					// we got as far as we could - now stop!
-					navigateToInProgress = false
-					list.SetShouldRender(true)
-					continue
-				}
-				nodeList := navigateState.NewNodes
-
-				if lastNavigatedNode != nil && lastNavigatedNode != list.CurrentExpandedItem() {
-					navigateToInProgress = false
-					list.SetShouldRender(true)
-				} else {
+			navigateState := navigateStateInterface.(views.ListNavigatedEventState)
+			if !navigateState.Success {
+				// we got as far as we could - now stop!
+				navigateToInProgress = false
+				list.SetShouldRender(true)
+				return
+			}
+			nodeList := navigateState.NewNodes
 
-					gotNode := false
-					for nodeIndex, node := range nodeList {
-						// use prefix matching
-						// but need additional checks as target of /foo/bar would be matched by  /foo/bar  and /foo/ba
-						// additional check is that the lengths match, or the next char in target is a '/'
-						nodeIDLower := strings.ToLower(node.ID)
-						if strings.HasPrefix(navigateToIDLower, nodeIDLower) && (len(itemID) == len(nodeIDLower) || navigateToIDLower[len(nodeIDLower)] == '/') {
-							list.ChangeSelection(nodeIndex)
-							lastNavigatedNode = node
-							list.ExpandCurrentSelection()
-							gotNode = true
-							break
-						}
-					}
+			if lastNavigatedNode != nil && lastNavigatedNode != list.CurrentExpandedItem() {
+				navigateToInProgress = false
+				list.SetShouldRender(true)
+				return
+			}
 
-					if !gotNode {
-						// we got as far as we could - now stop!
-						navigateToInProgress = false
-						list.SetShouldRender(true)
-					}
+			gotNode := false
+			for nodeIndex, node := range nodeList {
+				// use prefix matching
+				// but need additional checks as target of /foo/bar would be matched by  /foo/bar  and /foo/ba
+				// additional check is that the lengths match, or the next char in target is a '/'
+				if isNavigateTarget(navigateToIDLower, node.ID) || hasNavigateTargetDescendant(navigateToIDLower, node) {
+					list.ChangeSelection(nodeIndex)
+					lastNavigatedNode = node
+					list.ExpandCurrentSelection()
+					gotNode = true
+					break
 				}
 			}
+
+			if !gotNode {
+				// we got as far as we could - now stop!
+				navigateToInProgress = false
+				list.SetShouldRender(true)
+				return
+			}
 		}
 	}()
 }
+
+// isNavigateTarget checks whether the target ID is the node ID or is below it
+func isNavigateTarget(navigateToIDLower string, nodeID string) bool {
+	// use prefix matching
+	// but need additional checks as target of /foo/bar would be matched by  /foo/bar  and /foo/ba
+	// additional check is that the lengths match, or the next char in target is a '/'
+	nodeIDLower := strings.ToLower(nodeID)
+	return strings.HasPrefix(navigateToIDLower, nodeIDLower) && (len(navigateToIDLower) == len(nodeIDLower) || navigateToIDLower[len(nodeIDLower)] == '/')
+}
+
+// hasNavigateTargetDescendant checks the descendants tracked for nodes whose children
+// don't share their ID prefix (e.g. nested management groups)
+func hasNavigateTargetDescendant(navigateToIDLower string, node *expanders.TreeNode) bool {
+	descendantIDs, ok := node.Metadata[expanders.DescendantIDsMeta]
+	if !ok {
+		return false
+	}
+	for _, descendantID := range strings.Split(descendantIDs, ",") {
+		if isNavigateTarget(navigateToIDLower, descendantID) {
+			return true
+		}
+	}
+	return false
+}
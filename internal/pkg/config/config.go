@@ -5,6 +5,7 @@ import (
 	"io/ioutil"
 	"os"
 	"os/user"
+	"time"
 )
 
 // Settings to enable different behavior on startup
@@ -14,14 +15,36 @@ type Settings struct {
 	NavigateToID          string
 	FuzzerEnabled         bool
 	FuzzerDurationMinutes int
+	Offline               bool   // serve responses only from the response cache
 	TenantID              string // the tenant ID to get an access token for from `az account get-access-token`
 	ShouldRender          bool
+	ShowManagementGroups  bool // add the "Management Groups" root alongside the subscriptions
 }
 
 // Config represents the user configuration options
 type Config struct {
-	KeyBindings map[string]interface{} `json:"keyBindings,omitempty"`
-	Editor      EditorConfig           `json:"editor,omitempty"`
+	KeyBindings           map[string]interface{} `json:"keyBindings,omitempty"`
+	Editor                EditorConfig           `json:"editor,omitempty"`
+	ShowAdvisorIndicators bool                   `json:"showAdvisorIndicators,omitempty"` // Flag resources with Azure Advisor recommendations when listing a resource group
+	Plugins               []PluginConfig         `json:"plugins,omitempty"`               // Out-of-process expanders to start
+	ExpandersDir          string                 `json:"expandersDir,omitempty"`          // The directory to load declarative expander definitions from (defaults to ~/.azbrowse/expanders)
+	ExpanderTimeouts      map[string]int         `json:"expanderTimeouts,omitempty"`      // Timeouts in seconds by expander name, "default" applies to expanders not listed (defaults to 45)
+	ResponseCache         ResponseCacheConfig    `json:"responseCache,omitempty"`         // Cache ARM responses to speed up navigating to recently viewed items
+}
+
+// ResponseCacheConfig represents the user options for caching ARM responses
+type ResponseCacheConfig struct {
+	Enabled    bool           `json:"enabled,omitempty"`    // Serve GET requests from the cache within the TTL
+	TTLSeconds int            `json:"ttlSeconds,omitempty"` // How long responses are served from the cache (defaults to 300)
+	TypeTTLs   map[string]int `json:"typeTTLs,omitempty"`   // TTL in seconds by ARM type (eg Microsoft.Insights/metrics), 0 disables caching for the type
+}
+
+// GetTTL returns how long responses are served from the cache
+func (c ResponseCacheConfig) GetTTL() time.Duration {
+	if c.TTLSeconds > 0 {
+		return time.Duration(c.TTLSeconds) * time.Second
+	}
+	return 5 * time.Minute
 }
 
 // EditorConfig represents the user options for external editor
@@ -32,6 +55,12 @@ type EditorConfig struct {
 	RevertToStandardBuffer  bool          `json:"revertToStandardBuffer,omitempty"`  // Set to true to revert to standard buffer while editing (e.g. for terminal-based editors)
 }
 
+// PluginConfig represents an out-of-process expander started as a subprocess
+type PluginConfig struct {
+	Name    string        `json:"name,omitempty"`    // The name used for the plugin in status messages (defaults to the name the plugin reports)
+	Command CommandConfig `json:"command,omitempty"` // The command to start the plugin
+}
+
 // CommandConfig respresents the options for launching a command
 type CommandConfig struct {
 	Executable string   `json:"executable,omitempty"` // The program to run
@@ -67,6 +96,19 @@ func Load() (Config, error) {
 	return config, nil
 }
 
+// GetExpandersDir returns the directory to load declarative expander definitions from
+func (c Config) GetExpandersDir() string {
+	if c.ExpandersDir != "" {
+		return c.ExpandersDir
+	}
+	expandersDir := "/root/.azbrowse/expanders"
+	user, err := user.Current()
+	if err == nil {
+		expandersDir = user.HomeDir + "/.azbrowse/expanders"
+	}
+	return expandersDir
+}
+
 var (
 	debuggingEnabled = false
 )
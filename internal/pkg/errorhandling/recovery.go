@@ -60,6 +60,8 @@ func RegisterGuiAndStartHistoryTracking(ctx context.Context, g *gocui.Gui) {
 
 				if navigateState == "GOBACK" && len(history) > 0 {
 					history = history[:len(history)-1]
+				} else if navigateState == "GOHOME" {
+					history = []string{}
 				} else {
 					history = append(history, navigateState)
 				}
@@ -74,6 +74,13 @@ func (s *StatusEvent) Done() {
 	s.Update()
 }
 
+// Clear removes the status event from the statusbar, e.g. when the operation was cancelled
+func (s *StatusEvent) Clear() {
+	s.InProgress = false
+	s.SetTimeout(time.Nanosecond)
+	s.Update()
+}
+
 // SendFailureStatusFromError sends a status event representing the error mesage and reason
 func SendFailureStatusFromError(reason string, err error) *StatusEvent {
 	event, _ := SendStatusEvent(&StatusEvent{
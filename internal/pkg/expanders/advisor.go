@@ -0,0 +1,340 @@
+package expanders
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/lawrencegripper/azbrowse/internal/pkg/style"
+	"github.com/lawrencegripper/azbrowse/pkg/armclient"
+	"github.com/nbio/st"
+	"gopkg.in/h2non/gock.v1"
+)
+
+const advisorAPIVersion = "2020-01-01"
+
+// Item types for the nodes added by the AdvisorExpander
+const (
+	advisorType               = "advisor"
+	advisorCategoryType       = "advisor.category"
+	advisorRecommendationType = "advisor.recommendation"
+)
+
+// Metadata keys used on advisor nodes
+const (
+	advisorScopeMeta    = "AdvisorScope"    // the subscription or resource group recommendations are listed for
+	advisorCategoryMeta = "AdvisorCategory" // the category of recommendations listed
+)
+
+// ShowAdvisorIndicators adds an indicator to resources with Advisor recommendations when
+// listing a resource group. It is opt-in as it adds a request to every resource group expansion
+var ShowAdvisorIndicators bool
+
+// advisorCategories lists the recommendation categories in the order they are shown
+var advisorCategories = []struct {
+	Name    string
+	Display string
+}{
+	{Name: "Cost", Display: "Cost"},
+	{Name: "Security", Display: "Security"},
+	{Name: "HighAvailability", Display: "Reliability"},
+	{Name: "Performance", Display: "Performance"},
+	{Name: "OperationalExcellence", Display: "Operational excellence"},
+}
+
+// advisorImpactOrder is used to show high impact recommendations first
+var advisorImpactOrder = map[string]int{
+	"High":   0,
+	"Medium": 1,
+	"Low":    2,
+}
+
+// advisorRecommendation is an Azure Advisor recommendation for a resource
+type advisorRecommendation struct {
+	ID         string `json:"id"`
+	Name       string `json:"name"`
+	Properties struct {
+		Category         string `json:"category"`
+		Impact           string `json:"impact"`
+		ImpactedField    string `json:"impactedField"`
+		ImpactedValue    string `json:"impactedValue"`
+		LastUpdated      string `json:"lastUpdated"`
+		ShortDescription struct {
+			Problem  string `json:"problem"`
+			Solution string `json:"solution"`
+		} `json:"shortDescription"`
+		ResourceMetadata struct {
+			ResourceID string `json:"resourceId"`
+		} `json:"resourceMetadata"`
+	} `json:"properties"`
+}
+
+// Check interface
+var _ Expander = &AdvisorExpander{}
+
+// AdvisorExpander adds an "Advisor" node to subscriptions and resource groups listing
+// Azure Advisor recommendations by category
+type AdvisorExpander struct {
+	ExpanderBase
+	client *armclient.Client
+}
+
+func (e *AdvisorExpander) setClient(c *armclient.Client) {
+	e.client = c
+}
+
+// Name returns the name of the expander
+func (e *AdvisorExpander) Name() string {
+	return "AdvisorExpander"
+}
+
+// DoesExpand checks if this is an Advisor or category node
+func (e *AdvisorExpander) DoesExpand(ctx context.Context, currentItem *TreeNode) (bool, error) {
+	switch currentItem.ItemType {
+	case advisorType, advisorCategoryType:
+		return true, nil
+	}
+	return false, nil
+}
+
+// Expand lists the categories for an Advisor node or the recommendations in a category
+func (e *AdvisorExpander) Expand(ctx context.Context, currentItem *TreeNode) ExpanderResult {
+	if currentItem.ItemType == advisorCategoryType {
+		return e.expandCategory(ctx, currentItem)
+	}
+	return e.expandAdvisor(ctx, currentItem)
+}
+
+// newAdvisorNode creates the "Advisor" node listing recommendations at scope
+func newAdvisorNode(parent *TreeNode, scope string) *TreeNode {
+	return &TreeNode{
+		Parentid:       parent.ID,
+		Namespace:      "None",
+		Display:        style.Subtle("[Microsoft.Advisor]") + "\n  Advisor",
+		Name:           "Advisor",
+		ID:             scope + "/<advisor>",
+		ExpandURL:      ExpandURLNotSupported,
+		ItemType:       advisorType,
+		SubscriptionID: parent.SubscriptionID,
+		Metadata: map[string]string{
+			"SuppressSwaggerExpand": "true",
+			"SuppressGenericExpand": "true",
+			advisorScopeMeta:        scope,
+		},
+	}
+}
+
+func (e *AdvisorExpander) expandAdvisor(ctx context.Context, currentItem *TreeNode) ExpanderResult {
+	data, recommendations, err := listAdvisorRecommendations(ctx, e.client, currentItem.SubscriptionID, currentItem.Metadata[advisorScopeMeta], "")
+	if err != nil {
+		return ExpanderResult{
+			Err:               err,
+			Response:          ExpanderResponse{Response: data, ResponseType: ResponseJSON},
+			SourceDescription: "AdvisorExpander request",
+			IsPrimaryResponse: true,
+		}
+	}
+
+	counts := map[string]int{}
+	for _, recommendation := range recommendations {
+		counts[recommendation.Properties.Category]++
+	}
+
+	newItems := []*TreeNode{}
+	for _, category := range advisorCategories {
+		newItems = append(newItems, &TreeNode{
+			Parentid:       currentItem.ID,
+			Namespace:      "None",
+			Name:           category.Display,
+			Display:        fmt.Sprintf("%s %s", category.Display, style.Subtle(fmt.Sprintf("(%d)", counts[category.Name]))),
+			ID:             currentItem.ID + "/" + category.Name,
+			ExpandURL:      ExpandURLNotSupported,
+			ItemType:       advisorCategoryType,
+			SubscriptionID: currentItem.SubscriptionID,
+			Metadata: map[string]string{
+				"SuppressSwaggerExpand": "true",
+				"SuppressGenericExpand": "true",
+				advisorScopeMeta:        currentItem.Metadata[advisorScopeMeta],
+				advisorCategoryMeta:     category.Name,
+			},
+		})
+	}
+
+	return ExpanderResult{
+		Nodes:             newItems,
+		Response:          ExpanderResponse{Response: data, ResponseType: ResponseJSON},
+		SourceDescription: "AdvisorExpander request",
+		IsPrimaryResponse: true,
+	}
+}
+
+func (e *AdvisorExpander) expandCategory(ctx context.Context, currentItem *TreeNode) ExpanderResult {
+	data, recommendations, err := listAdvisorRecommendations(ctx, e.client, currentItem.SubscriptionID, currentItem.Metadata[advisorScopeMeta], currentItem.Metadata[advisorCategoryMeta])
+	if err != nil {
+		return ExpanderResult{
+			Err:               err,
+			Response:          ExpanderResponse{Response: data, ResponseType: ResponseJSON},
+			SourceDescription: "AdvisorExpander request",
+			IsPrimaryResponse: true,
+		}
+	}
+
+	sort.SliceStable(recommendations, func(i, j int) bool {
+		return advisorImpactOrder[recommendations[i].Properties.Impact] < advisorImpactOrder[recommendations[j].Properties.Impact]
+	})
+
+	newItems := []*TreeNode{}
+	for _, recommendation := range recommendations {
+		resourceID := recommendation.Properties.ResourceMetadata.ResourceID
+		newItems = append(newItems, &TreeNode{
+			Parentid:  currentItem.ID,
+			Namespace: "None",
+			Name:      recommendation.Properties.ShortDescription.Problem,
+			Display: recommendation.Properties.ShortDescription.Problem + "\n  " +
+				style.Subtle("Impact: "+recommendation.Properties.Impact) + "\n  " +
+				style.Subtle("Resource: "+recommendation.Properties.ImpactedValue),
+			ID:              recommendation.ID,
+			ExpandURL:       recommendation.ID + "?api-version=" + advisorAPIVersion,
+			ItemType:        advisorRecommendationType,
+			SubscriptionID:  currentItem.SubscriptionID,
+			StatusIndicator: DrawStatus("Advisor/" + recommendation.Properties.Impact),
+			Metadata: map[string]string{
+				"SuppressSwaggerExpand": "true",
+				// Selecting the recommendation takes you to the affected resource
+				NavigateToIDMeta: resourceID,
+			},
+		})
+	}
+
+	return ExpanderResult{
+		Nodes:             newItems,
+		Response:          ExpanderResponse{Response: data, ResponseType: ResponseJSON},
+		SourceDescription: "AdvisorExpander request",
+		IsPrimaryResponse: true,
+	}
+}
+
+// listAdvisorRecommendations returns the recommendations for a subscription or resource group,
+// optionally filtered to a category
+func listAdvisorRecommendations(ctx context.Context, client *armclient.Client, subscriptionID string, scope string, category string) (string, []advisorRecommendation, error) {
+	// Recommendations are listed at the subscription and filtered to resource groups
+	filters := []string{}
+	if resourceGroup := armclient.GetResourceGroupFromResourceID(scope); resourceGroup != "" {
+		filters = append(filters, "ResourceGroup eq '"+resourceGroup+"'")
+	}
+	if category != "" {
+		filters = append(filters, "Category eq '"+category+"'")
+	}
+	requestURL := "/subscriptions/" + subscriptionID + "/providers/Microsoft.Advisor/recommendations?api-version=" + advisorAPIVersion
+	if len(filters) > 0 {
+		requestURL += "&$filter=" + url.QueryEscape(strings.Join(filters, " and "))
+	}
+
+	data, err := client.DoRequest(ctx, "GET", requestURL)
+	if err != nil {
+		return data, nil, fmt.Errorf("Failed retrieving advisor recommendations: %s", err)
+	}
+	var recommendations struct {
+		Value []advisorRecommendation `json:"value"`
+	}
+	err = json.Unmarshal([]byte(data), &recommendations)
+	if err != nil {
+		return data, nil, fmt.Errorf("Error unmarshalling advisor recommendations: %s", err)
+	}
+	return data, recommendations.Value, nil
+}
+
+// GetAdvisorRecommendedResourceIDs returns the highest impact of the recommendations, keyed by
+// lower cased resource ID, for resources in the resource group which have Advisor recommendations
+func GetAdvisorRecommendedResourceIDs(ctx context.Context, client *armclient.Client, subscriptionID string, resourceGroupID string) (map[string]string, error) {
+	_, recommendations, err := listAdvisorRecommendations(ctx, client, subscriptionID, resourceGroupID, "")
+	if err != nil {
+		return nil, err
+	}
+
+	impacts := map[string]string{}
+	for _, recommendation := range recommendations {
+		resourceID := strings.ToLower(recommendation.Properties.ResourceMetadata.ResourceID)
+		impact, found := impacts[resourceID]
+		if !found || advisorImpactOrder[recommendation.Properties.Impact] < advisorImpactOrder[impact] {
+			impacts[resourceID] = recommendation.Properties.Impact
+		}
+	}
+	return impacts, nil
+}
+
+func (e *AdvisorExpander) testCases() (bool, *[]expanderTestCase) {
+	const subID = "00000000-0000-0000-0000-000000000000"
+	const rgID = "/subscriptions/" + subID + "/resourceGroups/stable"
+	const recommendationsResponseFile = "./testdata/armsamples/advisor/recommendations.json"
+
+	advisorNode := newAdvisorNode(&TreeNode{ID: rgID, SubscriptionID: subID}, rgID)
+	costNode := &TreeNode{
+		ID:             advisorNode.ID + "/Cost",
+		ItemType:       advisorCategoryType,
+		SubscriptionID: subID,
+		Metadata: map[string]string{
+			advisorScopeMeta:    rgID,
+			advisorCategoryMeta: "Cost",
+		},
+	}
+
+	mockResponse := func(t *testing.T) string {
+		data, err := ioutil.ReadFile(recommendationsResponseFile)
+		if err != nil {
+			t.Error(err)
+			t.FailNow()
+		}
+		return string(data)
+	}
+	advisorGockConfig := func(t *testing.T) {
+		gock.New("https://management.azure.com").
+			Get("/subscriptions/"+subID+"/providers/Microsoft.Advisor/recommendations").
+			MatchParam("$filter", "^ResourceGroup eq 'stable'$").
+			Reply(200).
+			JSON(mockResponse(t))
+	}
+	costGockConfig := func(t *testing.T) {
+		gock.New("https://management.azure.com").
+			Get("/subscriptions/"+subID+"/providers/Microsoft.Advisor/recommendations").
+			MatchParam("$filter", "^ResourceGroup eq 'stable' and Category eq 'Cost'$").
+			Reply(200).
+			JSON(mockResponse(t))
+	}
+
+	return true, &[]expanderTestCase{
+		{
+			name:              "Advisor->Categories",
+			nodeToExpand:      advisorNode,
+			statusCode:        200,
+			configureGockFunc: &advisorGockConfig,
+			treeNodeCheckerFunc: func(t *testing.T, r ExpanderResult) {
+				st.Expect(t, r.Err, nil)
+				st.Expect(t, len(r.Nodes), len(advisorCategories))
+				st.Expect(t, r.Nodes[0].Name, "Cost")
+				st.Expect(t, r.Nodes[0].Display, "Cost "+style.Subtle("(2)"))
+				st.Expect(t, r.Nodes[2].Name, "Reliability")
+				st.Expect(t, r.Nodes[2].Metadata[advisorCategoryMeta], "HighAvailability")
+			},
+		},
+		{
+			name:              "Advisor->Recommendations",
+			nodeToExpand:      costNode,
+			statusCode:        200,
+			configureGockFunc: &costGockConfig,
+			treeNodeCheckerFunc: func(t *testing.T, r ExpanderResult) {
+				st.Expect(t, r.Err, nil)
+				st.Expect(t, len(r.Nodes), 3)
+				// High impact recommendations are listed first
+				st.Expect(t, r.Nodes[0].Name, "Right-size or shutdown underutilized virtual machines")
+				st.Expect(t, r.Nodes[0].Metadata[NavigateToIDMeta], rgID+"/providers/Microsoft.Compute/virtualMachines/avm")
+				st.Expect(t, r.Nodes[2].Name, "Enable soft delete for your blobs")
+			},
+		},
+	}
+}
@@ -20,6 +20,8 @@ type analyticsItem struct {
 	Version      string `json:"Version"`
 }
 
+const appInsightsComponentTemplate = "/subscriptions/{subscriptionId}/resourceGroups/{resourceGroupName}/providers/Microsoft.Insights/components/{resourceName}"
+
 // Check interface
 var _ Expander = &AppInsightsExpander{}
 
@@ -42,7 +44,7 @@ func (e *AppInsightsExpander) Name() string {
 func (e *AppInsightsExpander) DoesExpand(ctx context.Context, currentItem *TreeNode) (bool, error) {
 	swaggerResourceType := currentItem.SwaggerResourceType
 	if currentItem.ItemType == "resource" && swaggerResourceType != nil {
-		if swaggerResourceType.Endpoint.TemplateURL == "/subscriptions/{subscriptionId}/resourceGroups/{resourceGroupName}/providers/Microsoft.Insights/components/{resourceName}" {
+		if swaggerResourceType.Endpoint.TemplateURL == appInsightsComponentTemplate {
 			return true, nil
 		}
 	}
@@ -58,7 +60,7 @@ func (e *AppInsightsExpander) Expand(ctx context.Context, currentItem *TreeNode)
 	swaggerResourceType := currentItem.SwaggerResourceType
 	if currentItem.Namespace != "AppInsights" &&
 		swaggerResourceType != nil &&
-		swaggerResourceType.Endpoint.TemplateURL == "/subscriptions/{subscriptionId}/resourceGroups/{resourceGroupName}/providers/Microsoft.Insights/components/{resourceName}" {
+		swaggerResourceType.Endpoint.TemplateURL == appInsightsComponentTemplate {
 		newItems := []*TreeNode{}
 		resourceAPIVersion, err := armclient.GetAPIVersion(currentItem.ArmType)
 		if err != nil {
@@ -85,6 +87,7 @@ func (e *AppInsightsExpander) Expand(ctx context.Context, currentItem *TreeNode)
 				"AppInsightsID":         currentItem.ID,
 			},
 		})
+		newItems = append(newItems, newLogAnalyticsQueryNode(currentItem, currentItem.ID+"/api/query?api-version="+appInsightsQueryAPIVersion, "Query (KQL)", ""))
 
 		return ExpanderResult{
 			Err:               nil,
@@ -144,12 +147,16 @@ func (e *AppInsightsExpander) expandAnalyticsItems(ctx context.Context, currentI
 		}
 		newItem := TreeNode{
 			Parentid:  currentItem.ID,
+			ID:        appInsightsID + "/" + collectionName + "/" + item.ID,
 			Namespace: "AppInsights",
 			ItemType:  "AppInsights.AnalyticsItem",
 			Name:      item.Name,
 			ExpandURL: appInsightsID + "/" + collectionName + "/item?api-version=" + resourceAPIVersion + "&id=" + item.ID,
 			DeleteURL: appInsightsID + "/" + collectionName + "/item?api-version=" + resourceAPIVersion + "&id=" + item.ID,
 			Display:   style.Subtle("["+item.Type+" - "+item.Scope+"]") + "\n " + item.Name,
+			Metadata: map[string]string{
+				"AppInsightsID": appInsightsID,
+			},
 		}
 		newItems = append(newItems, &newItem)
 	}
@@ -176,6 +183,14 @@ func (e *AppInsightsExpander) expandAnalyticsItem(ctx context.Context, currentIt
 		}
 	}
 
+	// Saved queries can be run directly from the item
+	var item analyticsItem
+	err = json.Unmarshal([]byte(data), &item)
+	if err == nil && item.Type == "query" && currentItem.Metadata["AppInsightsID"] != "" {
+		appInsightsID := currentItem.Metadata["AppInsightsID"]
+		newItems = append(newItems, newLogAnalyticsQueryNode(currentItem, appInsightsID+"/api/query?api-version="+appInsightsQueryAPIVersion, "Run query", item.Content))
+	}
+
 	return ExpanderResult{
 		IsPrimaryResponse: true,
 		Nodes:             newItems,
@@ -0,0 +1,860 @@
+package expanders
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/lawrencegripper/azbrowse/internal/pkg/style"
+	"github.com/lawrencegripper/azbrowse/pkg/armclient"
+	"github.com/lawrencegripper/azbrowse/pkg/endpoints"
+	"github.com/lawrencegripper/azbrowse/pkg/swagger"
+	"github.com/nbio/st"
+	"gopkg.in/h2non/gock.v1"
+)
+
+const appServiceTemplateURL = "/subscriptions/{subscriptionId}/resourceGroups/{resourceGroupName}/providers/Microsoft.Web/sites/{name}"
+const appServiceAPIVersion = "2019-08-01"
+
+// Item types for the nodes added under an App Service
+const (
+	appServiceDeploymentsType   = "appService.deployments"
+	appServiceDeploymentType    = "appService.deployment"
+	appServiceDeploymentLogType = "appService.deploymentLog"
+	appServiceLogStreamType     = "appService.logStream"
+	appServiceProcessesType     = "appService.processes"
+	appServiceProcessType       = "appService.process"
+	appServiceDirectoryType     = "appService.directory"
+	appServiceFileType          = "appService.file"
+	appServiceSettingsType      = "appService.settings"
+	appServiceFunctionsType     = "appService.functions"
+	appServiceFunctionType      = "appService.function"
+	appServiceFunctionKeysType  = "appService.functionKeys"
+)
+
+// Metadata keys used on the nodes added under an App Service
+const (
+	appServiceScmHostMeta = "ScmHost" // the Kudu host name, looked up when a Kudu node is first expanded
+	appServiceSiteIDMeta  = "SiteID"  // the site the node belongs to
+)
+
+// appServiceSiteResponse is the subset of the site used to find the Kudu (SCM) endpoint
+type appServiceSiteResponse struct {
+	Properties struct {
+		HostNameSslStates []struct {
+			Name     string `json:"name"`
+			HostType string `json:"hostType"`
+		} `json:"hostNameSslStates"`
+	} `json:"properties"`
+}
+
+type kuduDeployment struct {
+	ID           string `json:"id"`
+	Status       int    `json:"status"`
+	Author       string `json:"author"`
+	Deployer     string `json:"deployer"`
+	Message      string `json:"message"`
+	Active       bool   `json:"active"`
+	ReceivedTime string `json:"received_time"`
+	LogURL       string `json:"log_url"`
+}
+
+type kuduDeploymentLogEntry struct {
+	ID         string `json:"id"`
+	LogTime    string `json:"log_time"`
+	Message    string `json:"message"`
+	DetailsURL string `json:"details_url"`
+}
+
+type kuduProcess struct {
+	ID       int    `json:"id"`
+	Name     string `json:"name"`
+	Href     string `json:"href"`
+	UserName string `json:"user_name"`
+}
+
+type kuduFileEntry struct {
+	Name  string `json:"name"`
+	Size  int64  `json:"size"`
+	MTime string `json:"mtime"`
+	Mime  string `json:"mime"`
+	Href  string `json:"href"`
+}
+
+type appServiceFunction struct {
+	ID         string `json:"id"`
+	Properties struct {
+		Name       string `json:"name"`
+		Language   string `json:"language"`
+		IsDisabled bool   `json:"isDisabled"`
+		Config     struct {
+			Bindings []struct {
+				Type      string `json:"type"`
+				Direction string `json:"direction"`
+			} `json:"bindings"`
+		} `json:"config"`
+	} `json:"properties"`
+}
+
+// Check interface
+var _ Expander = &AppServiceExpander{}
+
+// AppServiceExpander expands the data-plane aspects of an App Service or Function App
+// using the Kudu (SCM) endpoint
+type AppServiceExpander struct {
+	ExpanderBase
+	client *armclient.Client
+}
+
+func (e *AppServiceExpander) setClient(c *armclient.Client) {
+	e.client = c
+}
+
+// Name returns the name of the expander
+func (e *AppServiceExpander) Name() string {
+	return "AppServiceExpander"
+}
+
+// DoesExpand checks if this is an App Service or one of the nodes added under it
+func (e *AppServiceExpander) DoesExpand(ctx context.Context, currentItem *TreeNode) (bool, error) {
+	swaggerResourceType := currentItem.SwaggerResourceType
+	if currentItem.ItemType == ResourceType && swaggerResourceType != nil {
+		if swaggerResourceType.Endpoint.TemplateURL == appServiceTemplateURL {
+			return true, nil
+		}
+	}
+	return strings.HasPrefix(currentItem.ItemType, "appService."), nil
+}
+
+// Expand adds the Kudu, settings and functions items for an App Service
+func (e *AppServiceExpander) Expand(ctx context.Context, currentItem *TreeNode) ExpanderResult {
+	switch currentItem.ItemType {
+	case appServiceDeploymentsType, appServiceProcessesType, appServiceDirectoryType:
+		if err := resolveAppServiceScmHost(ctx, e.client, currentItem); err != nil {
+			return e.errorResult(err, "")
+		}
+	}
+
+	switch currentItem.ItemType {
+	case ResourceType:
+		return e.expandSite(ctx, currentItem)
+	case appServiceDeploymentsType:
+		return e.expandDeployments(ctx, currentItem)
+	case appServiceDeploymentType:
+		return e.expandDeploymentLog(ctx, currentItem)
+	case appServiceDeploymentLogType, appServiceProcessType:
+		return e.expandKuduJSON(ctx, currentItem)
+	case appServiceLogStreamType:
+		return ExpanderResult{
+			Response:          ExpanderResponse{Response: "Use the `TailLogs` action to stream the application and HTTP logs", ResponseType: ResponsePlainText},
+			SourceDescription: "AppServiceExpander request",
+			IsPrimaryResponse: true,
+		}
+	case appServiceProcessesType:
+		return e.expandProcesses(ctx, currentItem)
+	case appServiceDirectoryType:
+		return e.expandDirectory(ctx, currentItem)
+	case appServiceFileType:
+		return e.expandFile(ctx, currentItem)
+	case appServiceSettingsType:
+		return e.expandSettings(ctx, currentItem)
+	case appServiceFunctionsType:
+		return e.expandFunctions(ctx, currentItem)
+	case appServiceFunctionType:
+		// The generic expander shows the function, including its bindings
+		return ExpanderResult{
+			Nodes:             []*TreeNode{e.newFunctionKeysNode(currentItem)},
+			Response:          ExpanderResponse{Response: ""},
+			SourceDescription: "AppServiceExpander request",
+			IsPrimaryResponse: false,
+		}
+	case appServiceFunctionKeysType:
+		return e.expandFunctionKeys(ctx, currentItem)
+	}
+
+	return ExpanderResult{
+		Err:               fmt.Errorf("Error - unhandled Expand"),
+		Response:          ExpanderResponse{Response: "Error!"},
+		SourceDescription: "AppServiceExpander request",
+	}
+}
+
+// expandSite adds the nodes without fetching the site, which the swagger expander already does.
+// The Kudu nodes are given paths on the SCM host, which is looked up when one is first expanded
+func (e *AppServiceExpander) expandSite(ctx context.Context, currentItem *TreeNode) ExpanderResult {
+	newItems := []*TreeNode{
+		e.newChildNode(currentItem, "", "[Kudu]", "Deployments", "deployments", appServiceDeploymentsType, "/api/deployments"),
+		e.newChildNode(currentItem, "", "[Kudu]", "Log stream", "logstream", appServiceLogStreamType, ExpandURLNotSupported),
+		e.newChildNode(currentItem, "", "[Kudu]", "Processes", "processes", appServiceProcessesType, "/api/processes"),
+		e.newChildNode(currentItem, "", "[Kudu]", "wwwroot", "wwwroot", appServiceDirectoryType, "/api/vfs/site/wwwroot/"),
+	}
+
+	newItems = append(newItems,
+		e.newChildNode(currentItem, "", "[Microsoft.Web]", "App settings", "appsettings", appServiceSettingsType,
+			currentItem.ID+"/config/appsettings/list?api-version="+appServiceAPIVersion),
+		e.newChildNode(currentItem, "", "[Microsoft.Web]", "Connection strings", "connectionstrings", appServiceSettingsType,
+			currentItem.ID+"/config/connectionstrings/list?api-version="+appServiceAPIVersion),
+	)
+
+	// The kind is recorded when the site is listed in its resource group, if it isn't known the node is
+	// added anyway as listing the functions of a web app returns an empty list
+	kind, kindKnown := currentItem.Metadata[resourceKindMeta]
+	if !kindKnown || strings.Contains(strings.ToLower(kind), "functionapp") {
+		newItems = append(newItems,
+			e.newChildNode(currentItem, "", "[Microsoft.Web]", "Functions", "functions", appServiceFunctionsType,
+				currentItem.ID+"/functions?api-version="+appServiceAPIVersion),
+		)
+	}
+
+	return ExpanderResult{
+		Nodes:             newItems,
+		Response:          ExpanderResponse{Response: ""}, // Swagger expander will supply the response
+		SourceDescription: "AppServiceExpander request",
+		IsPrimaryResponse: false,
+	}
+}
+
+func (e *AppServiceExpander) newChildNode(parent *TreeNode, scmHost string, provider string, name string, tag string, itemType string, expandURL string) *TreeNode {
+	return &TreeNode{
+		Parentid:       parent.ID,
+		Namespace:      "None",
+		Display:        style.Subtle(provider) + "\n  " + name,
+		Name:           name,
+		ID:             parent.ID + "/<" + tag + ">",
+		ExpandURL:      expandURL,
+		ItemType:       itemType,
+		SubscriptionID: parent.SubscriptionID,
+		Metadata: map[string]string{
+			"SuppressSwaggerExpand": "true",
+			"SuppressGenericExpand": "true",
+			appServiceScmHostMeta:   scmHost,
+			appServiceSiteIDMeta:    parent.ID,
+		},
+	}
+}
+
+func (e *AppServiceExpander) newKuduNode(parent *TreeNode, name string, display string, id string, itemType string, expandURL string) *TreeNode {
+	return &TreeNode{
+		Parentid:       parent.ID,
+		Namespace:      "None",
+		Display:        display,
+		Name:           name,
+		ID:             id,
+		ExpandURL:      expandURL,
+		ItemType:       itemType,
+		SubscriptionID: parent.SubscriptionID,
+		Metadata: map[string]string{
+			"SuppressSwaggerExpand": "true",
+			"SuppressGenericExpand": "true",
+			appServiceScmHostMeta:   parent.Metadata[appServiceScmHostMeta],
+			appServiceSiteIDMeta:    parent.Metadata[appServiceSiteIDMeta],
+		},
+	}
+}
+
+func (e *AppServiceExpander) expandDeployments(ctx context.Context, currentItem *TreeNode) ExpanderResult {
+	data, err := doKuduRequest(ctx, e.client, currentItem.Metadata[appServiceScmHostMeta], "GET", currentItem.ExpandURL)
+	if err != nil {
+		return e.errorResult(fmt.Errorf("Failed retrieving deployments: %s", err), data)
+	}
+	var deployments []kuduDeployment
+	err = json.Unmarshal([]byte(data), &deployments)
+	if err != nil {
+		return e.errorResult(fmt.Errorf("Error unmarshalling deployments: %s", err), data)
+	}
+
+	newItems := []*TreeNode{}
+	for _, deployment := range deployments {
+		display := deployment.ID
+		if deployment.Active {
+			display += " " + style.Highlight("(active)")
+		}
+		display += "\n  " + style.Subtle("Received: "+deployment.ReceivedTime) +
+			"\n  " + style.Subtle("Deployer: "+deployment.Deployer)
+		if deployment.Author != "" {
+			display += "\n  " + style.Subtle("Author: "+deployment.Author)
+		}
+		if message := strings.TrimSpace(deployment.Message); message != "" {
+			display += "\n  " + style.Subtle("Message: "+strings.Split(message, "\n")[0])
+		}
+
+		node := e.newKuduNode(currentItem, deployment.ID, display, currentItem.ID+"/"+deployment.ID, appServiceDeploymentType, deployment.LogURL)
+		node.StatusIndicator = DrawStatus(kuduDeploymentStatus(deployment.Status))
+		newItems = append(newItems, node)
+	}
+
+	return ExpanderResult{
+		Nodes:             newItems,
+		Response:          ExpanderResponse{Response: data, ResponseType: ResponseJSON},
+		SourceDescription: "AppServiceExpander request",
+		IsPrimaryResponse: true,
+	}
+}
+
+// kuduDeploymentStatus maps the Kudu DeployStatus enum onto the states understood by DrawStatus
+func kuduDeploymentStatus(status int) string {
+	switch status {
+	case 3:
+		return "Failed"
+	case 4:
+		return "Succeeded"
+	}
+	return "Running"
+}
+
+func (e *AppServiceExpander) expandDeploymentLog(ctx context.Context, currentItem *TreeNode) ExpanderResult {
+	data, err := doKuduRequest(ctx, e.client, currentItem.Metadata[appServiceScmHostMeta], "GET", currentItem.ExpandURL)
+	if err != nil {
+		return e.errorResult(fmt.Errorf("Failed retrieving deployment log: %s", err), data)
+	}
+	var entries []kuduDeploymentLogEntry
+	err = json.Unmarshal([]byte(data), &entries)
+	if err != nil {
+		return e.errorResult(fmt.Errorf("Error unmarshalling deployment log: %s", err), data)
+	}
+
+	newItems := []*TreeNode{}
+	for _, entry := range entries {
+		// Only entries with details have anything more to show
+		if entry.DetailsURL == "" {
+			continue
+		}
+		display := entry.Message + "\n  " + style.Subtle(entry.LogTime)
+		newItems = append(newItems, e.newKuduNode(currentItem, entry.Message, display, currentItem.ID+"/"+entry.ID, appServiceDeploymentLogType, entry.DetailsURL))
+	}
+
+	return ExpanderResult{
+		Nodes:             newItems,
+		Response:          ExpanderResponse{Response: data, ResponseType: ResponseJSON},
+		SourceDescription: "AppServiceExpander request",
+		IsPrimaryResponse: true,
+	}
+}
+
+func (e *AppServiceExpander) expandKuduJSON(ctx context.Context, currentItem *TreeNode) ExpanderResult {
+	data, err := doKuduRequest(ctx, e.client, currentItem.Metadata[appServiceScmHostMeta], "GET", currentItem.ExpandURL)
+	if err != nil {
+		return e.errorResult(fmt.Errorf("Failed retrieving %s: %s", currentItem.Name, err), data)
+	}
+	return ExpanderResult{
+		Response:          ExpanderResponse{Response: data, ResponseType: ResponseJSON},
+		SourceDescription: "AppServiceExpander request",
+		IsPrimaryResponse: true,
+	}
+}
+
+func (e *AppServiceExpander) expandProcesses(ctx context.Context, currentItem *TreeNode) ExpanderResult {
+	data, err := doKuduRequest(ctx, e.client, currentItem.Metadata[appServiceScmHostMeta], "GET", currentItem.ExpandURL)
+	if err != nil {
+		return e.errorResult(fmt.Errorf("Failed retrieving processes: %s", err), data)
+	}
+	var processes []kuduProcess
+	err = json.Unmarshal([]byte(data), &processes)
+	if err != nil {
+		return e.errorResult(fmt.Errorf("Error unmarshalling processes: %s", err), data)
+	}
+
+	newItems := []*TreeNode{}
+	for _, process := range processes {
+		id := strconv.Itoa(process.ID)
+		display := process.Name + "\n  " + style.Subtle("Id: "+id)
+		if process.UserName != "" {
+			display += "\n  " + style.Subtle("User: "+process.UserName)
+		}
+		newItems = append(newItems, e.newKuduNode(currentItem, process.Name, display, currentItem.ID+"/"+id, appServiceProcessType, process.Href))
+	}
+
+	return ExpanderResult{
+		Nodes:             newItems,
+		Response:          ExpanderResponse{Response: data, ResponseType: ResponseJSON},
+		SourceDescription: "AppServiceExpander request",
+		IsPrimaryResponse: true,
+	}
+}
+
+func (e *AppServiceExpander) expandDirectory(ctx context.Context, currentItem *TreeNode) ExpanderResult {
+	data, err := doKuduRequest(ctx, e.client, currentItem.Metadata[appServiceScmHostMeta], "GET", currentItem.ExpandURL)
+	if err != nil {
+		return e.errorResult(fmt.Errorf("Failed retrieving files: %s", err), data)
+	}
+	var entries []kuduFileEntry
+	err = json.Unmarshal([]byte(data), &entries)
+	if err != nil {
+		return e.errorResult(fmt.Errorf("Error unmarshalling files: %s", err), data)
+	}
+
+	// Show directories before files
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entries[i].Mime == "inode/directory" && entries[j].Mime != "inode/directory"
+	})
+
+	newItems := []*TreeNode{}
+	for _, entry := range entries {
+		if entry.Mime == "inode/directory" {
+			newItems = append(newItems, e.newKuduNode(currentItem, entry.Name, entry.Name+"/", currentItem.ID+"/"+entry.Name, appServiceDirectoryType, entry.Href))
+			continue
+		}
+		display := entry.Name + "\n  " + style.Subtle("Size: "+strconv.FormatInt(entry.Size, 10)+" bytes")
+		newItems = append(newItems, e.newKuduNode(currentItem, entry.Name, display, currentItem.ID+"/"+entry.Name, appServiceFileType, entry.Href))
+	}
+
+	return ExpanderResult{
+		Nodes:             newItems,
+		Response:          ExpanderResponse{Response: data, ResponseType: ResponseJSON},
+		SourceDescription: "AppServiceExpander request",
+		IsPrimaryResponse: true,
+	}
+}
+
+func (e *AppServiceExpander) expandFile(ctx context.Context, currentItem *TreeNode) ExpanderResult {
+	data, err := doKuduRequest(ctx, e.client, currentItem.Metadata[appServiceScmHostMeta], "GET", currentItem.ExpandURL)
+	if err != nil {
+		return e.errorResult(fmt.Errorf("Failed retrieving file: %s", err), data)
+	}
+	return ExpanderResult{
+		Response:          ExpanderResponse{Response: data, ResponseType: getResponseTypeForFileName(currentItem.Name)},
+		SourceDescription: "AppServiceExpander request",
+		IsPrimaryResponse: true,
+	}
+}
+
+func getResponseTypeForFileName(name string) ExpanderResponseType {
+	switch strings.ToLower(name[strings.LastIndex(name, ".")+1:]) {
+	case "json":
+		return ResponseJSON
+	case "yaml", "yml":
+		return ResponseYAML
+	case "xml", "config":
+		return ResponseXML
+	}
+	return ResponsePlainText
+}
+
+func (e *AppServiceExpander) expandSettings(ctx context.Context, currentItem *TreeNode) ExpanderResult {
+	// The list action returns the values decrypted, a GET on the config returns them redacted
+	data, err := e.client.DoRequest(ctx, "POST", currentItem.ExpandURL)
+	if err != nil {
+		return e.errorResult(fmt.Errorf("Failed retrieving %s: %s", strings.ToLower(currentItem.Name), err), data)
+	}
+	return ExpanderResult{
+		Response:          ExpanderResponse{Response: data, ResponseType: ResponseJSON},
+		SourceDescription: "AppServiceExpander request",
+		IsPrimaryResponse: true,
+	}
+}
+
+func (e *AppServiceExpander) expandFunctions(ctx context.Context, currentItem *TreeNode) ExpanderResult {
+	data, err := e.client.DoRequest(ctx, "GET", currentItem.ExpandURL)
+	if err != nil {
+		return e.errorResult(fmt.Errorf("Failed retrieving functions: %s", err), data)
+	}
+	var functions struct {
+		Value []appServiceFunction `json:"value"`
+	}
+	err = json.Unmarshal([]byte(data), &functions)
+	if err != nil {
+		return e.errorResult(fmt.Errorf("Error unmarshalling functions: %s", err), data)
+	}
+
+	newItems := []*TreeNode{}
+	for _, function := range functions.Value {
+		bindings := []string{}
+		for _, binding := range function.Properties.Config.Bindings {
+			bindings = append(bindings, binding.Type+" ("+binding.Direction+")")
+		}
+		display := function.Properties.Name + "\n  " + style.Subtle("Bindings: "+strings.Join(bindings, ", "))
+		if function.Properties.Language != "" {
+			display += "\n  " + style.Subtle("Language: "+function.Properties.Language)
+		}
+		if function.Properties.IsDisabled {
+			display += "\n  " + style.Subtle("Disabled")
+		}
+
+		newItems = append(newItems, &TreeNode{
+			Parentid:       currentItem.ID,
+			Namespace:      "None",
+			Display:        display,
+			Name:           function.Properties.Name,
+			ID:             function.ID,
+			ExpandURL:      function.ID + "?api-version=" + appServiceAPIVersion,
+			ItemType:       appServiceFunctionType,
+			SubscriptionID: currentItem.SubscriptionID,
+			Metadata: map[string]string{
+				"SuppressSwaggerExpand": "true",
+			},
+		})
+	}
+
+	return ExpanderResult{
+		Nodes:             newItems,
+		Response:          ExpanderResponse{Response: data, ResponseType: ResponseJSON},
+		SourceDescription: "AppServiceExpander request",
+		IsPrimaryResponse: true,
+	}
+}
+
+func (e *AppServiceExpander) newFunctionKeysNode(function *TreeNode) *TreeNode {
+	return &TreeNode{
+		Parentid:       function.ID,
+		Namespace:      "None",
+		Display:        style.Subtle("[Microsoft.Web]") + "\n  Keys",
+		Name:           "Keys",
+		ID:             function.ID + "/<keys>",
+		ExpandURL:      function.ID + "/listkeys?api-version=" + appServiceAPIVersion,
+		ItemType:       appServiceFunctionKeysType,
+		SubscriptionID: function.SubscriptionID,
+		Metadata: map[string]string{
+			"SuppressSwaggerExpand": "true",
+			"SuppressGenericExpand": "true",
+		},
+	}
+}
+
+func (e *AppServiceExpander) expandFunctionKeys(ctx context.Context, currentItem *TreeNode) ExpanderResult {
+	data, err := e.client.DoRequest(ctx, "POST", currentItem.ExpandURL)
+	if err != nil {
+		return e.errorResult(fmt.Errorf("Failed retrieving function keys: %s", err), data)
+	}
+	var keys map[string]string
+	err = json.Unmarshal([]byte(data), &keys)
+	if err != nil {
+		return e.errorResult(fmt.Errorf("Error unmarshalling function keys: %s", err), data)
+	}
+
+	// listkeys returns a map of key name to key, list them with a "key" property
+	// so they are redacted in demo mode
+	type functionKey struct {
+		Name string `json:"name"`
+		Key  string `json:"key"`
+	}
+	functionKeys := []functionKey{}
+	for name, key := range keys {
+		functionKeys = append(functionKeys, functionKey{Name: name, Key: key})
+	}
+	sort.Slice(functionKeys, func(i, j int) bool { return functionKeys[i].Name < functionKeys[j].Name })
+
+	response, err := json.MarshalIndent(map[string]interface{}{"keys": functionKeys}, "", "  ")
+	if err != nil {
+		return e.errorResult(fmt.Errorf("Error formatting function keys: %s", err), data)
+	}
+
+	return ExpanderResult{
+		Response:          ExpanderResponse{Response: string(response), ResponseType: ResponseJSON},
+		SourceDescription: "AppServiceExpander request",
+		IsPrimaryResponse: true,
+	}
+}
+
+func (e *AppServiceExpander) errorResult(err error, data string) ExpanderResult {
+	return ExpanderResult{
+		Err:               err,
+		Response:          ExpanderResponse{Response: data, ResponseType: ResponseJSON},
+		SourceDescription: "AppServiceExpander request",
+		IsPrimaryResponse: true,
+	}
+}
+
+// resolveAppServiceScmHost looks up the site's SCM host the first time one of the Kudu nodes added to
+// the site is used, recording it on the node and making the node's path a URL on the SCM host
+func resolveAppServiceScmHost(ctx context.Context, client *armclient.Client, node *TreeNode) error {
+	if node.Metadata[appServiceScmHostMeta] != "" {
+		return nil
+	}
+	data, err := client.DoRequest(ctx, "GET", node.Metadata[appServiceSiteIDMeta]+"?api-version="+appServiceAPIVersion)
+	if err != nil {
+		return fmt.Errorf("Failed retrieving site: %s", err)
+	}
+	var site appServiceSiteResponse
+	err = json.Unmarshal([]byte(data), &site)
+	if err != nil {
+		return fmt.Errorf("Error unmarshalling site: %s", err)
+	}
+	for _, hostName := range site.Properties.HostNameSslStates {
+		if hostName.HostType == "Repository" {
+			node.Metadata[appServiceScmHostMeta] = hostName.Name
+			if strings.HasPrefix(node.ExpandURL, "/") {
+				node.ExpandURL = "https://" + hostName.Name + node.ExpandURL
+			}
+			return nil
+		}
+	}
+	return fmt.Errorf("The site doesn't have an SCM (Kudu) host")
+}
+
+// doKuduRequest makes a request to the Kudu (SCM) endpoint, which accepts the ARM token
+func doKuduRequest(ctx context.Context, client *armclient.Client, scmHost string, verb string, url string) (string, error) {
+	response, err := doKuduRawRequest(ctx, client, scmHost, verb, url)
+	if err != nil {
+		return "", err
+	}
+	defer response.Body.Close() //nolint: errcheck
+	buf, err := ioutil.ReadAll(response.Body)
+	if err != nil {
+		return "", fmt.Errorf("Failed to read body: %s", err)
+	}
+	return string(buf), nil
+}
+
+// doKuduRawRequest only sends the ARM token to the site's SCM host as the URLs can come from Kudu responses
+func doKuduRawRequest(ctx context.Context, client *armclient.Client, scmHost string, verb string, url string) (*http.Response, error) {
+	request, err := http.NewRequest(verb, url, nil)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to create request: %s", err)
+	}
+	if scmHost == "" || request.URL.Scheme != "https" || !strings.EqualFold(request.URL.Hostname(), scmHost) {
+		return nil, fmt.Errorf("Refusing to send request to %s as it isn't the site's SCM host (%s)", url, scmHost)
+	}
+	response, err := client.DoRawRequest(ctx, request)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to make request: %s", err)
+	}
+	if response.StatusCode < 200 || response.StatusCode >= 300 {
+		defer response.Body.Close() //nolint: errcheck
+		buf, _ := ioutil.ReadAll(response.Body)
+		return nil, fmt.Errorf("Response failed with %s (%s): %s", response.Status, url, string(buf))
+	}
+	return response, nil
+}
+
+// streamAppServiceLogs follows the Kudu log stream, container is either "application" or "http"
+func streamAppServiceLogs(ctx context.Context, client *armclient.Client, node *TreeNode, container string, lines chan<- string) error {
+	if err := resolveAppServiceScmHost(ctx, client, node); err != nil {
+		return err
+	}
+	streamURL := "https://" + node.Metadata[appServiceScmHostMeta] + "/api/logstream/" + container
+	response, err := doKuduRawRequest(ctx, client, node.Metadata[appServiceScmHostMeta], "GET", streamURL)
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil
+		}
+		return err
+	}
+	defer response.Body.Close() //nolint: errcheck
+
+	scanner := bufio.NewScanner(response.Body)
+	for scanner.Scan() {
+		select {
+		case lines <- scanner.Text():
+		case <-ctx.Done():
+			return nil
+		}
+	}
+	if ctx.Err() != nil {
+		return nil
+	}
+	return scanner.Err()
+}
+
+func (e *AppServiceExpander) testCases() (bool, *[]expanderTestCase) {
+	const siteID = "/subscriptions/00000000-0000-0000-0000-000000000000/resourceGroups/stable/providers/Microsoft.Web/sites/afunctionapp"
+	const scmHost = "afunctionapp.scm.azurewebsites.net"
+	const testFolder = "./testdata/armsamples/appService/"
+
+	siteNode := &TreeNode{
+		ID:                  siteID,
+		ItemType:            ResourceType,
+		SwaggerResourceType: &swagger.ResourceType{Endpoint: endpoints.MustGetEndpointInfoFromURL(appServiceTemplateURL, appServiceAPIVersion)},
+		Metadata:            map[string]string{resourceKindMeta: "functionapp"},
+	}
+	webAppNode := &TreeNode{
+		ID:                  siteID,
+		ItemType:            ResourceType,
+		SwaggerResourceType: siteNode.SwaggerResourceType,
+		Metadata:            map[string]string{resourceKindMeta: "app"},
+	}
+	parentNode := &TreeNode{ID: siteID, Metadata: map[string]string{appServiceScmHostMeta: scmHost}}
+
+	mockResponse := func(t *testing.T, fileName string) string {
+		data, err := ioutil.ReadFile(testFolder + fileName)
+		if err != nil {
+			t.Error(err)
+			t.FailNow()
+		}
+		return string(data)
+	}
+	scmHostLookupGockConfig := func(t *testing.T) {
+		gock.New("https://management.azure.com").
+			Get(siteID).
+			Reply(200).
+			JSON(mockResponse(t, "site.json"))
+		gock.New("https://" + scmHost).
+			Get("/api/deployments").
+			Reply(200).
+			JSON(mockResponse(t, "deployments.json"))
+	}
+	deploymentsGockConfig := func(t *testing.T) {
+		gock.New("https://" + scmHost).
+			Get("/api/deployments").
+			Reply(200).
+			JSON(mockResponse(t, "deployments.json"))
+	}
+	wwwrootGockConfig := func(t *testing.T) {
+		gock.New("https://" + scmHost).
+			Get("/api/vfs/site/wwwroot/").
+			Reply(200).
+			JSON(mockResponse(t, "wwwroot.json"))
+	}
+	appSettingsGockConfig := func(t *testing.T) {
+		gock.New("https://management.azure.com").
+			Post(siteID + "/config/appsettings/list").
+			Reply(200).
+			JSON(mockResponse(t, "appsettings.json"))
+	}
+	noRequestsGockConfig := func(t *testing.T) {}
+	functionsGockConfig := func(t *testing.T) {
+		gock.New("https://management.azure.com").
+			Get(siteID + "/functions").
+			Reply(200).
+			JSON(mockResponse(t, "functions.json"))
+	}
+	functionKeysGockConfig := func(t *testing.T) {
+		gock.New("https://management.azure.com").
+			Post(siteID + "/functions/HttpTrigger1/listkeys").
+			Reply(200).
+			JSON(`{"default": "aGVsbG8ta2V5", "other": "b3RoZXIta2V5"}`)
+	}
+
+	return true, &[]expanderTestCase{
+		{
+			name:              "Site->Nodes",
+			nodeToExpand:      siteNode,
+			statusCode:        200,
+			configureGockFunc: &noRequestsGockConfig,
+			treeNodeCheckerFunc: func(t *testing.T, r ExpanderResult) {
+				st.Expect(t, r.Err, nil)
+				st.Expect(t, r.IsPrimaryResponse, false)
+				st.Expect(t, len(r.Nodes), 7)
+
+				// The SCM host is looked up when the node is expanded
+				st.Expect(t, r.Nodes[0].ItemType, appServiceDeploymentsType)
+				st.Expect(t, r.Nodes[0].ExpandURL, "/api/deployments")
+				st.Expect(t, r.Nodes[0].Metadata[appServiceScmHostMeta], "")
+				st.Expect(t, r.Nodes[0].Metadata[appServiceSiteIDMeta], siteID)
+				st.Expect(t, r.Nodes[1].ItemType, appServiceLogStreamType)
+				st.Expect(t, r.Nodes[2].ItemType, appServiceProcessesType)
+				st.Expect(t, r.Nodes[3].ItemType, appServiceDirectoryType)
+				st.Expect(t, r.Nodes[4].ExpandURL, siteID+"/config/appsettings/list?api-version="+appServiceAPIVersion)
+				st.Expect(t, r.Nodes[5].ExpandURL, siteID+"/config/connectionstrings/list?api-version="+appServiceAPIVersion)
+				st.Expect(t, r.Nodes[6].ItemType, appServiceFunctionsType)
+			},
+		},
+		{
+			name:              "WebApp->NoFunctionsNode",
+			nodeToExpand:      webAppNode,
+			statusCode:        200,
+			configureGockFunc: &noRequestsGockConfig,
+			treeNodeCheckerFunc: func(t *testing.T, r ExpanderResult) {
+				st.Expect(t, r.Err, nil)
+				st.Expect(t, len(r.Nodes), 6)
+				for _, node := range r.Nodes {
+					st.Reject(t, node.ItemType, appServiceFunctionsType)
+				}
+			},
+		},
+		{
+			name:              "Deployments->LooksUpScmHost",
+			nodeToExpand:      e.newChildNode(siteNode, "", "[Kudu]", "Deployments", "deployments", appServiceDeploymentsType, "/api/deployments"),
+			statusCode:        200,
+			configureGockFunc: &scmHostLookupGockConfig,
+			treeNodeCheckerFunc: func(t *testing.T, r ExpanderResult) {
+				st.Expect(t, r.Err, nil)
+				st.Expect(t, len(r.Nodes), 2)
+				st.Expect(t, r.Nodes[0].Metadata[appServiceScmHostMeta], scmHost)
+				st.Expect(t, r.Nodes[0].ExpandURL, "https://"+scmHost+"/api/deployments/a1b2c3/log")
+			},
+		},
+		{
+			name:              "Deployments->Deployments",
+			nodeToExpand:      e.newChildNode(parentNode, scmHost, "[Kudu]", "Deployments", "deployments", appServiceDeploymentsType, "https://"+scmHost+"/api/deployments"),
+			statusCode:        200,
+			configureGockFunc: &deploymentsGockConfig,
+			treeNodeCheckerFunc: func(t *testing.T, r ExpanderResult) {
+				st.Expect(t, r.Err, nil)
+				st.Expect(t, r.IsPrimaryResponse, true)
+				st.Expect(t, len(r.Nodes), 2)
+
+				st.Expect(t, r.Nodes[0].Name, "a1b2c3")
+				st.Expect(t, r.Nodes[0].ExpandURL, "https://"+scmHost+"/api/deployments/a1b2c3/log")
+				st.Expect(t, r.Nodes[0].StatusIndicator, DrawStatus("Succeeded"))
+				st.Expect(t, r.Nodes[1].StatusIndicator, DrawStatus("Failed"))
+			},
+		},
+		{
+			name:              "wwwroot->Files",
+			nodeToExpand:      e.newChildNode(parentNode, scmHost, "[Kudu]", "wwwroot", "wwwroot", appServiceDirectoryType, "https://"+scmHost+"/api/vfs/site/wwwroot/"),
+			statusCode:        200,
+			configureGockFunc: &wwwrootGockConfig,
+			treeNodeCheckerFunc: func(t *testing.T, r ExpanderResult) {
+				st.Expect(t, r.Err, nil)
+				st.Expect(t, len(r.Nodes), 2)
+
+				// Directories are listed first
+				st.Expect(t, r.Nodes[0].Name, "HttpTrigger1")
+				st.Expect(t, r.Nodes[0].ItemType, appServiceDirectoryType)
+				st.Expect(t, r.Nodes[1].Name, "host.json")
+				st.Expect(t, r.Nodes[1].ItemType, appServiceFileType)
+			},
+		},
+		{
+			name:              "Directory->OtherHostNotRequested",
+			nodeToExpand:      e.newChildNode(parentNode, scmHost, "[Kudu]", "wwwroot", "wwwroot", appServiceDirectoryType, "https://attacker.example.com/api/vfs/site/wwwroot/"),
+			statusCode:        200,
+			configureGockFunc: &noRequestsGockConfig,
+			treeNodeCheckerFunc: func(t *testing.T, r ExpanderResult) {
+				st.Reject(t, r.Err, nil)
+				st.Expect(t, strings.Contains(r.Err.Error(), "isn't the site's SCM host"), true)
+			},
+		},
+		{
+			name:              "AppSettings->Settings",
+			nodeToExpand:      e.newChildNode(parentNode, scmHost, "[Microsoft.Web]", "App settings", "appsettings", appServiceSettingsType, siteID+"/config/appsettings/list?api-version="+appServiceAPIVersion),
+			statusCode:        200,
+			configureGockFunc: &appSettingsGockConfig,
+			treeNodeCheckerFunc: func(t *testing.T, r ExpanderResult) {
+				st.Expect(t, r.Err, nil)
+				st.Expect(t, r.IsPrimaryResponse, true)
+				st.Expect(t, strings.Contains(r.Response.Response, "FUNCTIONS_WORKER_RUNTIME"), true)
+			},
+		},
+		{
+			name:              "Functions->Functions",
+			nodeToExpand:      e.newChildNode(parentNode, scmHost, "[Microsoft.Web]", "Functions", "functions", appServiceFunctionsType, siteID+"/functions?api-version="+appServiceAPIVersion),
+			statusCode:        200,
+			configureGockFunc: &functionsGockConfig,
+			treeNodeCheckerFunc: func(t *testing.T, r ExpanderResult) {
+				st.Expect(t, r.Err, nil)
+				st.Expect(t, len(r.Nodes), 1)
+				st.Expect(t, r.Nodes[0].Name, "HttpTrigger1")
+				st.Expect(t, r.Nodes[0].ID, siteID+"/functions/HttpTrigger1")
+				st.Expect(t, strings.Contains(r.Nodes[0].Display, "httpTrigger (in)"), true)
+			},
+		},
+		{
+			name:              "FunctionKeys->Keys",
+			nodeToExpand:      e.newFunctionKeysNode(&TreeNode{ID: siteID + "/functions/HttpTrigger1"}),
+			statusCode:        200,
+			configureGockFunc: &functionKeysGockConfig,
+			treeNodeCheckerFunc: func(t *testing.T, r ExpanderResult) {
+				st.Expect(t, r.Err, nil)
+				var keys struct {
+					Keys []struct {
+						Name string `json:"name"`
+						Key  string `json:"key"`
+					} `json:"keys"`
+				}
+				err := json.Unmarshal([]byte(r.Response.Response), &keys)
+				st.Expect(t, err, nil)
+				st.Expect(t, len(keys.Keys), 2)
+				st.Expect(t, keys.Keys[0].Name, "default")
+				st.Expect(t, keys.Keys[0].Key, "aGVsbG8ta2V5")
+			},
+		},
+	}
+}
@@ -145,6 +145,7 @@ func (e *ContainerRegistryExpander) expandRepositories(ctx context.Context, curr
 		"registry:catalog:*",
 		"repositories",
 		"",
+		true,
 		func(currentItem *TreeNode, item string) *TreeNode {
 			return &TreeNode{
 				Parentid:  currentItem.ID,
@@ -161,23 +162,7 @@ func (e *ContainerRegistryExpander) expandRepositories(ctx context.Context, curr
 				},
 			}
 		},
-		func(currentItem *TreeNode, lastItem string) *TreeNode {
-			return &TreeNode{
-				Parentid:  currentItem.ID,
-				ID:        currentItem.ID + "/<more>",
-				Namespace: "containerRegistry",
-				Name:      "more...",
-				Display:   "more...",
-				ItemType:  SubResourceType,
-				ExpandURL: ExpandURLNotSupported,
-				Metadata: map[string]string{
-					"RegistryID":            registryID,
-					"SuppressSwaggerExpand": "true",
-					"SuppressGenericExpand": "true",
-					"lastItem":              lastItem,
-				},
-			}
-		})
+		"")
 }
 
 func (e *ContainerRegistryExpander) expandRepository(ctx context.Context, currentItem *TreeNode) ExpanderResult {
@@ -202,8 +187,8 @@ func (e *ContainerRegistryExpander) expandRepository(ctx context.Context, curren
 	response := string(responseBuf)
 
 	newItems := []*TreeNode{
-		e.getCreateTagsNodeFunc(loginServer, repository, "Tags")(currentItem, ""),
-		e.getCreateManifestsNodeFunc(loginServer, repository, "Manifests")(currentItem, ""),
+		e.newTagsNode(currentItem, loginServer, repository),
+		e.newManifestsNode(currentItem, loginServer, repository),
 	}
 
 	return ExpanderResult{
@@ -241,8 +226,9 @@ func (e *ContainerRegistryExpander) expandRepositoryTags(ctx context.Context, cu
 		fmt.Sprintf("repository:%s:pull", repository),
 		"tags",
 		"name",
+		true,
 		e.getCreateTagNodeFunc(loginServer, repository),
-		e.getCreateTagsNodeFunc(loginServer, repository, "more..."))
+		"")
 }
 
 func (e *ContainerRegistryExpander) expandRepositoryTag(ctx context.Context, currentItem *TreeNode) ExpanderResult {
@@ -317,8 +303,9 @@ func (e *ContainerRegistryExpander) expandRepositoryManifests(ctx context.Contex
 		fmt.Sprintf("repository:%s:pull", repository),
 		"manifests",
 		"digest",
+		true,
 		e.getCreateManifestNodeFunc(loginServer, repository),
-		e.getCreateManifestsNodeFunc(loginServer, repository, "more..."))
+		"")
 }
 
 func (e *ContainerRegistryExpander) expandRepositoryManifest(ctx context.Context, currentItem *TreeNode) ExpanderResult {
@@ -333,8 +320,9 @@ func (e *ContainerRegistryExpander) expandRepositoryManifest(ctx context.Context
 		fmt.Sprintf("repository:%s:metadata_read", repository),
 		"manifest.tags",
 		"",
+		false,
 		e.getCreateTagNodeFunc(loginServer, repository),
-		nil)
+		"")
 }
 func (e *ContainerRegistryExpander) deleteRepositoryManifest(ctx context.Context, currentItem *TreeNode) (bool, error) {
 
@@ -397,44 +385,40 @@ func (e *ContainerRegistryExpander) getCreateTagNodeFunc(loginServer string, rep
 	}
 }
 
-func (e *ContainerRegistryExpander) getCreateManifestsNodeFunc(loginServer string, repository string, title string) createItemNode {
-	return func(currentItem *TreeNode, lastItem string) *TreeNode {
-		return &TreeNode{
-			Parentid:  currentItem.ID,
-			Namespace: "containerRegistry",
-			ID:        currentItem.ID + "/" + title,
-			Name:      title,
-			Display:   title,
-			ItemType:  "containerRegistry.repository.manifests",
-			ExpandURL: ExpandURLNotSupported,
-			Metadata: map[string]string{
-				"loginServer": loginServer,
-				"repository":  repository,
-				"lastItem":    lastItem,
-			},
-		}
+func (e *ContainerRegistryExpander) newManifestsNode(currentItem *TreeNode, loginServer string, repository string) *TreeNode {
+	return &TreeNode{
+		Parentid:  currentItem.ID,
+		Namespace: "containerRegistry",
+		ID:        currentItem.ID + "/Manifests",
+		Name:      "Manifests",
+		Display:   "Manifests",
+		ItemType:  "containerRegistry.repository.manifests",
+		ExpandURL: ExpandURLNotSupported,
+		Metadata: map[string]string{
+			"loginServer": loginServer,
+			"repository":  repository,
+		},
 	}
 }
 
-func (e *ContainerRegistryExpander) getCreateTagsNodeFunc(loginServer string, repository string, title string) createItemNode {
-	return func(currentItem *TreeNode, lastItem string) *TreeNode {
-		return &TreeNode{
-			Parentid:  currentItem.ID,
-			ID:        currentItem.ID + "/" + title,
-			Namespace: "containerRegistry",
-			Name:      title,
-			Display:   title,
-			ItemType:  "containerRegistry.repository.tags",
-			ExpandURL: ExpandURLNotSupported,
-			Metadata: map[string]string{
-				"loginServer": loginServer,
-				"repository":  repository,
-				"lastItem":    lastItem,
-			},
-		}
+func (e *ContainerRegistryExpander) newTagsNode(currentItem *TreeNode, loginServer string, repository string) *TreeNode {
+	return &TreeNode{
+		Parentid:  currentItem.ID,
+		ID:        currentItem.ID + "/Tags",
+		Namespace: "containerRegistry",
+		Name:      "Tags",
+		Display:   "Tags",
+		ItemType:  "containerRegistry.repository.tags",
+		ExpandURL: ExpandURLNotSupported,
+		Metadata: map[string]string{
+			"loginServer": loginServer,
+			"repository":  repository,
+		},
 	}
 }
 
+// expandNode lists the items from the url. When paged is set the result has a continuation
+// token if there are more items, and lastItem is the last item of the previous page
 func (e *ContainerRegistryExpander) expandNode(
 	ctx context.Context,
 	currentItem *TreeNode, // requires loginServer and repository Metadata
@@ -442,8 +426,9 @@ func (e *ContainerRegistryExpander) expandNode(
 	accessTokenScope string,
 	collectionPath string,
 	itemPath string,
+	paged bool,
 	createItemNodeFunc createItemNode,
-	createContinuationFunc createItemNode) ExpanderResult {
+	lastItem string) ExpanderResult {
 
 	span, ctx := tracing.StartSpanFromContext(ctx, "expand(containerregistry):"+currentItem.ItemType+":"+currentItem.Name+":"+currentItem.ID, tracing.SetTag("item", currentItem))
 	defer span.Finish()
@@ -451,7 +436,6 @@ func (e *ContainerRegistryExpander) expandNode(
 	// TODO - add context around errors
 
 	loginServer := currentItem.Metadata["loginServer"]
-	lastItem := currentItem.Metadata["lastItem"]
 
 	// get token
 	accessToken, err := e.getRegistryToken(ctx, loginServer, accessTokenScope)
@@ -464,7 +448,7 @@ func (e *ContainerRegistryExpander) expandNode(
 
 	// query
 	continuation := ""
-	if lastItem != "" && createContinuationFunc != nil {
+	if lastItem != "" && paged {
 		continuation = fmt.Sprintf("?last=%s", lastItem)
 	}
 	urlTemp := fmt.Sprintf("%s%s", url, continuation)
@@ -482,25 +466,28 @@ func (e *ContainerRegistryExpander) expandNode(
 		newItems = append(newItems, createItemNodeFunc(currentItem, item))
 	}
 
-	if len(newItems) > 0 {
-		if createContinuationFunc != nil {
-			newLastItem := items[len(items)-1]
-			continuation = fmt.Sprintf("?last=%s", newLastItem)
-			urlTemp = fmt.Sprintf("%s%s", url, continuation)
-			_, nextItems, _ := e.getItemsForURL(ctx, urlTemp, accessToken, collectionPath, itemPath)
-			if len(nextItems) > 0 {
-				newItems = append(newItems, createContinuationFunc(currentItem, newLastItem))
-			}
-		}
-	}
-
-	return ExpanderResult{
+	result := ExpanderResult{
 		Err:               nil,
 		Response:          ExpanderResponse{Response: response, ResponseType: ResponseJSON},
 		SourceDescription: "ContainerRegistryExpander request",
 		Nodes:             newItems,
 		IsPrimaryResponse: true,
 	}
+
+	if len(newItems) > 0 && paged {
+		newLastItem := items[len(items)-1]
+		continuation = fmt.Sprintf("?last=%s", newLastItem)
+		urlTemp = fmt.Sprintf("%s%s", url, continuation)
+		_, nextItems, _ := e.getItemsForURL(ctx, urlTemp, accessToken, collectionPath, itemPath)
+		if len(nextItems) > 0 {
+			result.ContinuationToken = newLastItem
+			result.LoadMore = func(ctx context.Context, lastItem string) ExpanderResult {
+				return e.expandNode(ctx, currentItem, url, accessTokenScope, collectionPath, itemPath, paged, createItemNodeFunc, lastItem)
+			}
+		}
+	}
+
+	return result
 }
 func (e *ContainerRegistryExpander) getItemsForURL(ctx context.Context, url string, accessToken string, collectionPath string, itemPath string) (string, []string, error) {
 	span, ctx := tracing.StartSpanFromContext(ctx, "getItemsForURL(containerregistry):"+url, tracing.SetTag("url", url))
@@ -1,11 +1,13 @@
 package expanders
 
 import (
+	"bufio"
 	"bytes"
 	"context"
 	"fmt"
 	"io/ioutil"
 	"net/http"
+	"net/url"
 	"strconv"
 	"strings"
 
@@ -34,6 +36,8 @@ type podResponse struct {
 var _ SwaggerAPISet = SwaggerAPISetContainerService{}
 var maxTailLines = 100
 
+const podLogTemplateURL = "/api/v1/namespaces/{namespace}/pods/{name}/log"
+
 // SwaggerAPISetContainerService holds the config for working with an AKS cluster API
 type SwaggerAPISetContainerService struct {
 	resourceTypes []swagger.ResourceType
@@ -105,43 +109,94 @@ func (c SwaggerAPISetContainerService) doRequestWithBody(ctx context.Context, ve
 	return "", fmt.Errorf("Response failed with %s (%s): %s", response.Status, url, data)
 }
 
+// getPodContainers returns the names of the containers in the pod for a pod log URL
+func (c SwaggerAPISetContainerService) getPodContainers(ctx context.Context, logURL string) ([]string, error) {
+	if index := strings.Index(logURL, "?"); index >= 0 {
+		logURL = logURL[:index]
+	}
+	podURL := c.serverURL + strings.TrimSuffix(logURL, "/log")
+	data, err := c.doRequest(ctx, "GET", podURL)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to make request: %s", err)
+	}
+
+	var podInfo podResponse
+	err = yaml.Unmarshal([]byte(data), &podInfo)
+	if err != nil {
+		return nil, fmt.Errorf("Error parsing YAML response: %s", err)
+	}
+	if len(podInfo.Spec.Containers) == 0 {
+		return nil, fmt.Errorf("No containers in response")
+	}
+
+	containers := []string{}
+	for _, container := range podInfo.Spec.Containers {
+		containers = append(containers, container.Name)
+	}
+	return containers, nil
+}
+
+// streamPodLogs follows the logs for a container in the pod, sending each line until the context is cancelled or the log ends
+func (c SwaggerAPISetContainerService) streamPodLogs(ctx context.Context, logURL string, container string, lines chan<- string) error {
+	if index := strings.Index(logURL, "?"); index >= 0 {
+		logURL = logURL[:index]
+	}
+	streamURL := c.serverURL + logURL + "?follow=true&tailLines=" + strconv.Itoa(maxTailLines)
+	if container != "" {
+		streamURL += "&container=" + url.QueryEscape(container)
+	}
+
+	request, err := http.NewRequest("GET", streamURL, nil)
+	if err != nil {
+		return fmt.Errorf("Failed to create request: %s", err)
+	}
+	response, err := c.httpClient.Do(request.WithContext(ctx))
+	if err != nil {
+		return fmt.Errorf("Failed to make request: %s", err)
+	}
+	defer response.Body.Close() //nolint: errcheck
+	if response.StatusCode < 200 || response.StatusCode >= 300 {
+		buf, _ := ioutil.ReadAll(response.Body)
+		return fmt.Errorf("Response failed with %s (%s): %s", response.Status, streamURL, string(buf))
+	}
+
+	scanner := bufio.NewScanner(response.Body)
+	for scanner.Scan() {
+		select {
+		case lines <- scanner.Text():
+		case <-ctx.Done():
+			return nil
+		}
+	}
+	if ctx.Err() != nil {
+		return nil
+	}
+	return scanner.Err()
+}
+
 // ExpandResource returns metadata about child resources of the specified resource node
 func (c SwaggerAPISetContainerService) ExpandResource(ctx context.Context, currentItem *TreeNode, resourceType swagger.ResourceType) (APISetExpandResponse, error) {
 
-	if resourceType.Endpoint.TemplateURL == "/api/v1/namespaces/{namespace}/pods/{name}/log" {
+	if resourceType.Endpoint.TemplateURL == podLogTemplateURL {
 		if !strings.Contains(currentItem.ExpandURL, "?") { // we haven't already set the container name/tailLines!
 
-			logURL := c.serverURL + currentItem.ExpandURL
-			containerURL := logURL[:len(logURL)-3]
-			data, err := c.doRequest(ctx, "GET", containerURL)
-			if err != nil {
-				err = fmt.Errorf("Failed to make request: %s", err)
-				return APISetExpandResponse{}, err
-			}
-
-			var podInfo podResponse
-			err = yaml.Unmarshal([]byte(data), &podInfo)
+			containers, err := c.getPodContainers(ctx, currentItem.ExpandURL)
 			if err != nil {
-				err = fmt.Errorf("Error parsing YAML response: %s", err)
-				return APISetExpandResponse{Response: data}, err
-			}
-			if podInfo.Spec.Containers == nil || len(podInfo.Spec.Containers) == 0 {
-				err = fmt.Errorf("No containers in response: %s", err)
 				return APISetExpandResponse{}, err
 			}
 
-			if len(podInfo.Spec.Containers) == 1 {
+			if len(containers) == 1 {
 				// if only a single resopnse then set the tailLines param and  fall through to just return logs for the single container
 				currentItem.ExpandURL += "?tailLines=" + strconv.Itoa(maxTailLines)
 			} else {
-				if len(podInfo.Spec.Containers) > 1 {
+				if len(containers) > 1 {
 					subResources := []SubResource{}
-					for _, container := range podInfo.Spec.Containers {
+					for _, container := range containers {
 						subResource := SubResource{
-							ID:           currentItem.ID + "/" + container.Name,
-							Name:         container.Name,
+							ID:           currentItem.ID + "/" + container,
+							Name:         container,
 							ResourceType: resourceType,
-							ExpandURL:    currentItem.ExpandURL + "?container=" + container.Name + "&tailLines=" + strconv.Itoa(maxTailLines),
+							ExpandURL:    currentItem.ExpandURL + "?container=" + container + "&tailLines=" + strconv.Itoa(maxTailLines),
 						}
 						subResources = append(subResources, subResource)
 					}
@@ -0,0 +1,537 @@
+package expanders
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"sort"
+	"strings"
+	"testing"
+	"text/tabwriter"
+	"time"
+
+	"github.com/guptarohit/asciigraph"
+	"github.com/lawrencegripper/azbrowse/internal/pkg/style"
+	"github.com/lawrencegripper/azbrowse/pkg/armclient"
+	"github.com/nbio/st"
+	"gopkg.in/h2non/gock.v1"
+)
+
+const costAPIVersion = "2019-11-01"
+const costTagNamesAPIVersion = "2019-10-01"
+
+// Item types for the nodes added by the CostExpander
+const (
+	costType        = "cost"
+	costGroupedType = "cost.grouped"
+	costTagsType    = "cost.tags"
+)
+
+// Metadata keys used on cost nodes
+const (
+	costScopeMeta        = "CostScope"        // the subscription or resource group the query runs against
+	costGroupingTypeMeta = "CostGroupingType" // Dimension or TagKey
+	costGroupingNameMeta = "CostGroupingName" // the dimension or tag to group by
+)
+
+// costBarWidth is the maximum width of the bars drawn next to each row of the cost table
+const costBarWidth = 30
+
+// costQuery is the body of a Cost Management query
+type costQuery struct {
+	Type      string           `json:"type"`
+	Timeframe string           `json:"timeframe"`
+	Dataset   costQueryDataset `json:"dataset"`
+}
+
+type costQueryDataset struct {
+	Granularity string                          `json:"granularity"`
+	Aggregation map[string]costQueryAggregation `json:"aggregation"`
+	Grouping    []costQueryGrouping             `json:"grouping,omitempty"`
+}
+
+type costQueryAggregation struct {
+	Name     string `json:"name"`
+	Function string `json:"function"`
+}
+
+type costQueryGrouping struct {
+	Type string `json:"type"`
+	Name string `json:"name"`
+}
+
+// costQueryResult is the tabular response of a Cost Management query
+type costQueryResult struct {
+	Properties struct {
+		Columns []struct {
+			Name string `json:"name"`
+			Type string `json:"type"`
+		} `json:"columns"`
+		Rows [][]interface{} `json:"rows"`
+	} `json:"properties"`
+}
+
+// column returns the index of the named column or -1 if it isn't in the result
+func (r costQueryResult) column(name string) int {
+	for index, column := range r.Properties.Columns {
+		if strings.EqualFold(column.Name, name) {
+			return index
+		}
+	}
+	return -1
+}
+
+// costRow is a single grouped total from a cost query
+type costRow struct {
+	Name string
+	Cost float64
+}
+
+// Check interface
+var _ Expander = &CostExpander{}
+
+// CostExpander adds a "Cost" node to subscriptions and resource groups and queries the
+// Cost Management API for the current billing month
+type CostExpander struct {
+	ExpanderBase
+	client *armclient.Client
+}
+
+func (e *CostExpander) setClient(c *armclient.Client) {
+	e.client = c
+}
+
+// Name returns the name of the expander
+func (e *CostExpander) Name() string {
+	return "CostExpander"
+}
+
+// DoesExpand checks if this is one of the cost nodes
+func (e *CostExpander) DoesExpand(ctx context.Context, currentItem *TreeNode) (bool, error) {
+	switch currentItem.ItemType {
+	case costType, costGroupedType, costTagsType:
+		return true, nil
+	}
+	return false, nil
+}
+
+// Expand charts the daily cost for a Cost node, lists tags for the tags node or renders grouped costs
+func (e *CostExpander) Expand(ctx context.Context, currentItem *TreeNode) ExpanderResult {
+	switch currentItem.ItemType {
+	case costTagsType:
+		return e.expandTags(ctx, currentItem)
+	case costGroupedType:
+		return e.expandGrouped(ctx, currentItem)
+	}
+	return e.expandCost(ctx, currentItem)
+}
+
+// newCostNode creates the "Cost" node showing the month to date cost of scope
+func newCostNode(parent *TreeNode, scope string) *TreeNode {
+	return &TreeNode{
+		Parentid:       parent.ID,
+		Namespace:      "None",
+		Display:        style.Subtle("[Microsoft.CostManagement]") + "\n  Cost",
+		Name:           "Cost",
+		ID:             scope + "/<cost>",
+		ExpandURL:      ExpandURLNotSupported,
+		ItemType:       costType,
+		SubscriptionID: parent.SubscriptionID,
+		Metadata: map[string]string{
+			"SuppressSwaggerExpand": "true",
+			"SuppressGenericExpand": "true",
+			costScopeMeta:           scope,
+		},
+	}
+}
+
+func newCostGroupedNode(parent *TreeNode, name string, groupingType string, groupingName string) *TreeNode {
+	return &TreeNode{
+		Parentid:       parent.ID,
+		Namespace:      "None",
+		Display:        name,
+		Name:           name,
+		ID:             parent.ID + "/" + strings.ToLower(groupingType) + "/" + groupingName,
+		ExpandURL:      ExpandURLNotSupported,
+		ItemType:       costGroupedType,
+		SubscriptionID: parent.SubscriptionID,
+		Metadata: map[string]string{
+			"SuppressSwaggerExpand": "true",
+			"SuppressGenericExpand": "true",
+			costScopeMeta:           parent.Metadata[costScopeMeta],
+			costGroupingTypeMeta:    groupingType,
+			costGroupingNameMeta:    groupingName,
+		},
+	}
+}
+
+// newCostTagsNode creates the "By tag" node which lists the tags costs can be grouped by
+func newCostTagsNode(parent *TreeNode) *TreeNode {
+	node := newCostGroupedNode(parent, "By tag", "TagKey", "")
+	node.ID = parent.ID + "/tags"
+	node.ItemType = costTagsType
+	return node
+}
+
+func (e *CostExpander) expandCost(ctx context.Context, currentItem *TreeNode) ExpanderResult {
+	newItems := []*TreeNode{
+		newCostGroupedNode(currentItem, "By resource", "Dimension", "ResourceId"),
+		newCostGroupedNode(currentItem, "By service name", "Dimension", "ServiceName"),
+	}
+	if !strings.Contains(strings.ToLower(currentItem.Metadata[costScopeMeta]), "/resourcegroups/") {
+		newItems = append(newItems, newCostGroupedNode(currentItem, "By resource group", "Dimension", "ResourceGroupName"))
+	}
+	newItems = append(newItems, newCostTagsNode(currentItem))
+
+	result, data, err := e.query(ctx, currentItem.Metadata[costScopeMeta], "Daily", nil)
+	if err != nil {
+		return ExpanderResult{
+			Err:               fmt.Errorf("Failed retrieving cost: %s", err),
+			Nodes:             newItems,
+			Response:          ExpanderResponse{Response: data, ResponseType: ResponseJSON},
+			SourceDescription: "CostExpander request",
+			IsPrimaryResponse: true,
+		}
+	}
+
+	return ExpanderResult{
+		Nodes:             newItems,
+		Response:          ExpanderResponse{Response: renderCostChart(result), ResponseType: ResponsePlainText},
+		SourceDescription: "CostExpander request",
+		IsPrimaryResponse: true,
+	}
+}
+
+func (e *CostExpander) expandTags(ctx context.Context, currentItem *TreeNode) ExpanderResult {
+	data, err := e.client.DoRequest(ctx, "GET", "/subscriptions/"+currentItem.SubscriptionID+"/tagNames?api-version="+costTagNamesAPIVersion)
+	if err != nil {
+		return ExpanderResult{
+			Err:               fmt.Errorf("Failed retrieving tag names: %s", err),
+			Response:          ExpanderResponse{Response: data, ResponseType: ResponseJSON},
+			SourceDescription: "CostExpander request",
+			IsPrimaryResponse: true,
+		}
+	}
+
+	var tags struct {
+		Value []struct {
+			TagName string `json:"tagName"`
+		} `json:"value"`
+	}
+	err = json.Unmarshal([]byte(data), &tags)
+	if err != nil {
+		return ExpanderResult{
+			Err:               fmt.Errorf("Error unmarshalling tag names: %s", err),
+			Response:          ExpanderResponse{Response: data, ResponseType: ResponseJSON},
+			SourceDescription: "CostExpander request",
+			IsPrimaryResponse: true,
+		}
+	}
+
+	newItems := []*TreeNode{}
+	for _, tag := range tags.Value {
+		newItems = append(newItems, newCostGroupedNode(currentItem, tag.TagName, "TagKey", tag.TagName))
+	}
+
+	return ExpanderResult{
+		Nodes:             newItems,
+		Response:          ExpanderResponse{Response: data, ResponseType: ResponseJSON},
+		SourceDescription: "CostExpander request",
+		IsPrimaryResponse: true,
+	}
+}
+
+func (e *CostExpander) expandGrouped(ctx context.Context, currentItem *TreeNode) ExpanderResult {
+	grouping := &costQueryGrouping{
+		Type: currentItem.Metadata[costGroupingTypeMeta],
+		Name: currentItem.Metadata[costGroupingNameMeta],
+	}
+	result, data, err := e.query(ctx, currentItem.Metadata[costScopeMeta], "None", grouping)
+	if err != nil {
+		return ExpanderResult{
+			Err:               fmt.Errorf("Failed retrieving cost: %s", err),
+			Response:          ExpanderResponse{Response: data, ResponseType: ResponseJSON},
+			SourceDescription: "CostExpander request",
+			IsPrimaryResponse: true,
+		}
+	}
+
+	return ExpanderResult{
+		Response:          ExpanderResponse{Response: renderCostTable(result, *grouping), ResponseType: ResponsePlainText},
+		SourceDescription: "CostExpander request",
+		IsPrimaryResponse: true,
+	}
+}
+
+// query runs a month to date Cost Management query against scope
+func (e *CostExpander) query(ctx context.Context, scope string, granularity string, grouping *costQueryGrouping) (costQueryResult, string, error) {
+	query := costQuery{
+		Type:      "ActualCost",
+		Timeframe: "BillingMonthToDate",
+		Dataset: costQueryDataset{
+			Granularity: granularity,
+			Aggregation: map[string]costQueryAggregation{
+				"totalCost": {Name: "PreTaxCost", Function: "Sum"},
+			},
+		},
+	}
+	if grouping != nil {
+		query.Dataset.Grouping = []costQueryGrouping{*grouping}
+	}
+
+	var result costQueryResult
+	body, err := json.Marshal(query)
+	if err != nil {
+		return result, "", err
+	}
+	data, err := e.client.DoRequestWithBody(ctx, "POST", scope+"/providers/Microsoft.CostManagement/query?api-version="+costAPIVersion, string(body))
+	if err != nil {
+		return result, data, err
+	}
+	err = json.Unmarshal([]byte(data), &result)
+	if err != nil {
+		return result, data, fmt.Errorf("Error unmarshalling cost query: %s", err)
+	}
+	return result, data, nil
+}
+
+// getCostCurrency returns the currency of the query results
+func getCostCurrency(result costQueryResult) string {
+	currency := result.column("Currency")
+	if currency < 0 {
+		return ""
+	}
+	for _, row := range result.Properties.Rows {
+		if value, ok := row[currency].(string); ok {
+			return value
+		}
+	}
+	return ""
+}
+
+// getCostRows sums the cost of each group in the result, sorted by cost descending
+func getCostRows(result costQueryResult, grouping costQueryGrouping) []costRow {
+	cost := result.column("PreTaxCost")
+	if cost < 0 {
+		return []costRow{}
+	}
+	name := result.column(grouping.Name)
+	tagValue := -1
+	if grouping.Type == "TagKey" {
+		name = result.column("TagKey")
+		tagValue = result.column("TagValue")
+	}
+
+	totals := map[string]float64{}
+	for _, row := range result.Properties.Rows {
+		value, _ := row[cost].(float64)
+		group := ""
+		if name >= 0 {
+			group, _ = row[name].(string)
+		}
+		if tagValue >= 0 {
+			if tag, _ := row[tagValue].(string); group != "" {
+				group = group + "=" + tag
+			}
+		}
+		if grouping.Name == "ResourceId" && group != "" {
+			group = armclient.GetResourceGroupFromResourceID(group) + "/" + lastSegment(group)
+		}
+		if group == "" {
+			group = "(none)"
+		}
+		totals[group] += value
+	}
+
+	rows := []costRow{}
+	for group, total := range totals {
+		rows = append(rows, costRow{Name: group, Cost: total})
+	}
+	sort.Slice(rows, func(i, j int) bool {
+		if rows[i].Cost == rows[j].Cost {
+			return rows[i].Name < rows[j].Name
+		}
+		return rows[i].Cost > rows[j].Cost
+	})
+	return rows
+}
+
+// renderCostTable renders the grouped costs as a table with a bar showing each group's share
+func renderCostTable(result costQueryResult, grouping costQueryGrouping) string {
+	rows := getCostRows(result, grouping)
+	currency := getCostCurrency(result)
+
+	total := float64(0)
+	for _, row := range rows {
+		total += row.Cost
+	}
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "Total month to date: %.2f %s\n\n", total, currency)
+	if len(rows) == 0 {
+		buf.WriteString("No cost data for the current billing month\n")
+		return buf.String()
+	}
+
+	table := tabwriter.NewWriter(&buf, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(table, grouping.Name+"\tCost\t")
+	for _, row := range rows {
+		bar := 0
+		if rows[0].Cost > 0 {
+			bar = int(math.Round(row.Cost / rows[0].Cost * costBarWidth))
+		}
+		fmt.Fprintf(table, "%s\t%.2f\t%s\n", row.Name, row.Cost, strings.Repeat("█", bar))
+	}
+	_ = table.Flush()
+	return buf.String()
+}
+
+// renderCostChart plots the daily cost and the running total for the billing month
+func renderCostChart(result costQueryResult) string {
+	cost := result.column("PreTaxCost")
+	date := result.column("UsageDate")
+	currency := getCostCurrency(result)
+	if cost < 0 || date < 0 {
+		return "No cost data for the current billing month\n"
+	}
+
+	// UsageDate is returned as a number in the form 20200131
+	daily := map[int]float64{}
+	for _, row := range result.Properties.Rows {
+		value, _ := row[cost].(float64)
+		day, _ := row[date].(float64)
+		daily[int(day)] += value
+	}
+	if len(daily) == 0 {
+		return "No cost data for the current billing month\n"
+	}
+	days := []int{}
+	for day := range daily {
+		days = append(days, day)
+	}
+	sort.Ints(days)
+
+	dailyCost := []float64{}
+	runningTotal := []float64{}
+	total := float64(0)
+	for _, day := range days {
+		total += daily[day]
+		dailyCost = append(dailyCost, daily[day])
+		runningTotal = append(runningTotal, total)
+	}
+
+	caption := style.Title("Cost") +
+		style.Subtle(fmt.Sprintf(" (Month to date: %.2f %s)", total, currency))
+	if len(days) < 2 {
+		return "\n\n" + caption + "\n"
+	}
+
+	width := ItemWidgetWidth - 15
+	height := ItemWidgetHeight - 10
+	if width <= 0 {
+		width = len(days)
+	}
+	if height <= 0 {
+		height = 10
+	}
+	graph := asciigraph.PlotMany([][]float64{dailyCost, runningTotal},
+		asciigraph.Height(height),
+		asciigraph.Width(width),
+		asciigraph.SeriesColors(metricsSeriesColors[:2]...),
+		asciigraph.SeriesLegends("Daily", "Running total"),
+	)
+
+	first, _ := time.ParseInLocation("20060102", fmt.Sprint(days[0]), time.Local)
+	last, _ := time.ParseInLocation("20060102", fmt.Sprint(days[len(days)-1]), time.Local)
+	return "\n\n" + caption + "\n\n" + addMetricGraphTimeAxis(graph, width, first, last)
+}
+
+func (e *CostExpander) testCases() (bool, *[]expanderTestCase) {
+	const subID = "/subscriptions/00000000-0000-0000-0000-000000000000"
+	const rgID = subID + "/resourceGroups/stable"
+	const testFolder = "./testdata/armsamples/cost/"
+
+	costNode := newCostNode(&TreeNode{ID: rgID, SubscriptionID: "00000000-0000-0000-0000-000000000000"}, rgID)
+	groupedNode := newCostGroupedNode(costNode, "By resource", "Dimension", "ResourceId")
+	tagsNode := newCostTagsNode(costNode)
+
+	mockResponse := func(t *testing.T, fileName string) string {
+		data, err := ioutil.ReadFile(testFolder + fileName)
+		if err != nil {
+			t.Error(err)
+			t.FailNow()
+		}
+		return string(data)
+	}
+	dailyGockConfig := func(t *testing.T) {
+		gock.New("https://management.azure.com").
+			Post(rgID+"/providers/Microsoft.CostManagement/query").
+			MatchParam("api-version", costAPIVersion).
+			BodyString(`"granularity":"Daily"`).
+			Reply(200).
+			JSON(mockResponse(t, "daily.json"))
+	}
+	groupedGockConfig := func(t *testing.T) {
+		gock.New("https://management.azure.com").
+			Post(rgID + "/providers/Microsoft.CostManagement/query").
+			BodyString(`"grouping":\[{"type":"Dimension","name":"ResourceId"}\]`).
+			Reply(200).
+			JSON(mockResponse(t, "byResource.json"))
+	}
+	tagsGockConfig := func(t *testing.T) {
+		gock.New("https://management.azure.com").
+			Get(subID + "/tagNames").
+			Reply(200).
+			JSON(mockResponse(t, "tagNames.json"))
+	}
+
+	return true, &[]expanderTestCase{
+		{
+			name:              "Cost->Chart",
+			nodeToExpand:      costNode,
+			statusCode:        200,
+			configureGockFunc: &dailyGockConfig,
+			treeNodeCheckerFunc: func(t *testing.T, r ExpanderResult) {
+				st.Expect(t, r.Err, nil)
+				st.Expect(t, r.Response.ResponseType, ResponsePlainText)
+				st.Expect(t, strings.Contains(r.Response.Response, "Month to date: 14.50 USD"), true)
+
+				// Resource groups can't be grouped by resource group
+				st.Expect(t, len(r.Nodes), 3)
+				st.Expect(t, r.Nodes[0].Metadata[costGroupingNameMeta], "ResourceId")
+				st.Expect(t, r.Nodes[1].Metadata[costGroupingNameMeta], "ServiceName")
+				st.Expect(t, r.Nodes[2].ItemType, costTagsType)
+			},
+		},
+		{
+			name:              "Cost->ByResource",
+			nodeToExpand:      groupedNode,
+			statusCode:        200,
+			configureGockFunc: &groupedGockConfig,
+			treeNodeCheckerFunc: func(t *testing.T, r ExpanderResult) {
+				st.Expect(t, r.Err, nil)
+				lines := strings.Split(r.Response.Response, "\n")
+				st.Expect(t, lines[0], "Total month to date: 14.50 USD")
+				// Sorted by cost, most expensive first
+				st.Expect(t, strings.Contains(lines[3], "stable/avm"), true)
+				st.Expect(t, strings.Contains(lines[4], "stable/astorage"), true)
+			},
+		},
+		{
+			name:              "Cost->Tags",
+			nodeToExpand:      tagsNode,
+			statusCode:        200,
+			configureGockFunc: &tagsGockConfig,
+			treeNodeCheckerFunc: func(t *testing.T, r ExpanderResult) {
+				st.Expect(t, r.Err, nil)
+				st.Expect(t, len(r.Nodes), 2)
+				st.Expect(t, r.Nodes[0].Name, "environment")
+				st.Expect(t, r.Nodes[0].ItemType, costGroupedType)
+				st.Expect(t, r.Nodes[0].Metadata[costGroupingTypeMeta], "TagKey")
+			},
+		},
+	}
+}
@@ -0,0 +1,442 @@
+package expanders
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/lawrencegripper/azbrowse/internal/pkg/style"
+	"github.com/lawrencegripper/azbrowse/pkg/armclient"
+	"github.com/lawrencegripper/azbrowse/pkg/endpoints"
+	"github.com/valyala/fastjson"
+	"gopkg.in/yaml.v2"
+)
+
+// declarativeType is the item type of the node added by a DeclarativeExpander to list its items
+const declarativeType = "declarative"
+
+// declarativeNameMeta holds the name of the definition that created a declarative node
+const declarativeNameMeta = "DeclarativeName"
+
+// Default selectors used when a definition doesn't specify them
+const (
+	declarativeDefaultItemsSelector = "$.value"
+	declarativeDefaultNameSelector  = "$.name"
+	declarativeDefaultIDSelector    = "$.id"
+)
+
+// DeclarativeDefinition describes an expander which adds a node under matching items
+// that lists the results of a GET request
+type DeclarativeDefinition struct {
+	Name       string              `yaml:"name" json:"name"`                     // Identifies the definition, also used as the node name
+	Display    string              `yaml:"display" json:"display,omitempty"`     // The text shown for the node (defaults to Name)
+	Match      DeclarativeMatch    `yaml:"match" json:"match"`                   // The items to add the node to
+	URL        string              `yaml:"url" json:"url"`                       // The URL template to list items from, e.g. /subscriptions/{subscription}/.../servers/{server}/firewallRules
+	APIVersion string              `yaml:"apiVersion" json:"apiVersion"`         // The api-version for the list, item and delete requests
+	Selectors  DeclarativeSelector `yaml:"selectors" json:"selectors,omitempty"` // Selectors for the values used to build each item
+	DeleteURL  string              `yaml:"deleteUrl" json:"deleteUrl,omitempty"` // Optional URL template to delete an item
+	fileName   string              // The file the definition was loaded from
+}
+
+// DeclarativeMatch specifies the items a definition applies to.
+// When both are set, both must match
+type DeclarativeMatch struct {
+	ArmType  string `yaml:"armType" json:"armType,omitempty"`   // e.g. Microsoft.Sql/servers (case-insensitive)
+	ItemType string `yaml:"itemType" json:"itemType,omitempty"` // e.g. resource, subscription
+}
+
+// DeclarativeSelector holds the JSONPath selectors for the listed items.
+// Only simple paths such as `$.properties.state` or `$.value[0].name` are supported
+type DeclarativeSelector struct {
+	Items  string `yaml:"items" json:"items,omitempty"`   // Selects the array of items in the response (defaults to $.value)
+	Name   string `yaml:"name" json:"name,omitempty"`     // Selects the item name (defaults to $.name)
+	ID     string `yaml:"id" json:"id,omitempty"`         // Selects the item ID (defaults to $.id)
+	Status string `yaml:"status" json:"status,omitempty"` // Optionally selects a status shown using DrawStatus
+}
+
+// Check interface
+var _ Expander = &DeclarativeExpander{}
+
+// DeclarativeExpander adds a node to items matching its definition which lists
+// the items returned from the definition's URL
+type DeclarativeExpander struct {
+	client     *armclient.Client
+	definition DeclarativeDefinition
+
+	urlTemplate       *endpoints.EndpointInfo
+	deleteURLTemplate *endpoints.EndpointInfo
+	itemsPath         []string
+	namePath          []string
+	idPath            []string
+	statusPath        []string
+}
+
+// NewDeclarativeExpander validates the definition and creates an expander for it
+func NewDeclarativeExpander(client *armclient.Client, definition DeclarativeDefinition) (*DeclarativeExpander, error) {
+	if definition.Name == "" {
+		return nil, fmt.Errorf("Definition is missing a name")
+	}
+	if definition.Match.ArmType == "" && definition.Match.ItemType == "" {
+		return nil, fmt.Errorf("Definition '%s' must match on armType and/or itemType", definition.Name)
+	}
+	if definition.URL == "" {
+		return nil, fmt.Errorf("Definition '%s' is missing a url", definition.Name)
+	}
+
+	e := &DeclarativeExpander{
+		client:     client,
+		definition: definition,
+	}
+
+	urlTemplate, err := endpoints.GetEndpointInfoFromURL(definition.URL, definition.APIVersion)
+	if err != nil {
+		return nil, fmt.Errorf("Definition '%s' has an invalid url: %s", definition.Name, err)
+	}
+	e.urlTemplate = &urlTemplate
+
+	if definition.DeleteURL != "" {
+		deleteURLTemplate, err := endpoints.GetEndpointInfoFromURL(definition.DeleteURL, definition.APIVersion)
+		if err != nil {
+			return nil, fmt.Errorf("Definition '%s' has an invalid deleteUrl: %s", definition.Name, err)
+		}
+		e.deleteURLTemplate = &deleteURLTemplate
+	}
+
+	selectors := []struct {
+		selector     string
+		defaultValue string
+		path         *[]string
+	}{
+		{definition.Selectors.Items, declarativeDefaultItemsSelector, &e.itemsPath},
+		{definition.Selectors.Name, declarativeDefaultNameSelector, &e.namePath},
+		{definition.Selectors.ID, declarativeDefaultIDSelector, &e.idPath},
+		{definition.Selectors.Status, "", &e.statusPath},
+	}
+	for _, s := range selectors {
+		selector := s.selector
+		if selector == "" {
+			selector = s.defaultValue
+		}
+		if selector == "" {
+			continue
+		}
+		path, err := parseJSONPath(selector)
+		if err != nil {
+			return nil, fmt.Errorf("Definition '%s' has an invalid selector: %s", definition.Name, err)
+		}
+		*s.path = path
+	}
+
+	return e, nil
+}
+
+// LoadDeclarativeDefinitions reads the definitions from the .yaml, .yml and .json files in dir.
+// Files which fail to load are reported in the error and the remaining definitions are returned
+func LoadDeclarativeDefinitions(dir string) ([]DeclarativeDefinition, error) {
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			// don't error on no definitions
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	definitions := []DeclarativeDefinition{}
+	failures := []string{}
+	for _, file := range files {
+		if file.IsDir() {
+			continue
+		}
+		fileName := filepath.Join(dir, file.Name())
+
+		var unmarshal func([]byte, interface{}) error
+		switch strings.ToLower(filepath.Ext(fileName)) {
+		case ".yaml", ".yml":
+			unmarshal = yaml.Unmarshal
+		case ".json":
+			unmarshal = json.Unmarshal
+		default:
+			continue
+		}
+
+		data, err := ioutil.ReadFile(fileName)
+		if err != nil {
+			failures = append(failures, fmt.Sprintf("%s: %s", fileName, err))
+			continue
+		}
+		var definition DeclarativeDefinition
+		if err := unmarshal(data, &definition); err != nil {
+			failures = append(failures, fmt.Sprintf("%s: %s", fileName, err))
+			continue
+		}
+		definition.fileName = fileName
+		definitions = append(definitions, definition)
+	}
+
+	if len(failures) > 0 {
+		return definitions, fmt.Errorf("Failed loading expander definitions: %s", strings.Join(failures, "; "))
+	}
+	return definitions, nil
+}
+
+// InitializeDeclarativeExpanders registers an expander for each definition in dir.
+// Invalid definitions are skipped and reported in the error
+func InitializeDeclarativeExpanders(client *armclient.Client, dir string) error {
+	definitions, err := LoadDeclarativeDefinitions(dir)
+	failures := []string{}
+	if err != nil {
+		failures = append(failures, err.Error())
+	}
+
+	names := map[string]bool{}
+	for _, definition := range definitions {
+		if names[definition.Name] {
+			failures = append(failures, fmt.Sprintf("%s: duplicate definition name '%s'", definition.fileName, definition.Name))
+			continue
+		}
+		expander, err := NewDeclarativeExpander(client, definition)
+		if err != nil {
+			failures = append(failures, fmt.Sprintf("%s: %s", definition.fileName, err))
+			continue
+		}
+		names[definition.Name] = true
+		register = append(register, expander)
+	}
+
+	if len(failures) > 0 {
+		return fmt.Errorf("%s", strings.Join(failures, "; "))
+	}
+	return nil
+}
+
+// testCases returns false as definitions are loaded from config.
+// Test_DeclarativeExpander runs the expanderTestCase harness against a test definition
+func (e *DeclarativeExpander) testCases() (bool, *[]expanderTestCase) {
+	return false, nil
+}
+
+func (e *DeclarativeExpander) setClient(c *armclient.Client) {
+	e.client = c
+}
+
+// Name returns the name of the expander
+func (e *DeclarativeExpander) Name() string {
+	return "Declarative:" + e.definition.Name
+}
+
+// DoesExpand checks if the item matches the definition or is the node listing its items
+func (e *DeclarativeExpander) DoesExpand(ctx context.Context, currentItem *TreeNode) (bool, error) {
+	if currentItem.ItemType == declarativeType {
+		return currentItem.Metadata[declarativeNameMeta] == e.definition.Name, nil
+	}
+	if !e.matches(currentItem) {
+		return false, nil
+	}
+	// Only add the node if the URL can be built from the item
+	_, err := e.buildURL(e.urlTemplate, currentItem.ID, "")
+	return err == nil, nil
+}
+
+// Expand adds the node listing the items, or lists the items for that node
+func (e *DeclarativeExpander) Expand(ctx context.Context, currentItem *TreeNode) ExpanderResult {
+	if currentItem.ItemType == declarativeType {
+		return e.expandItems(ctx, currentItem)
+	}
+
+	listURL, err := e.buildURL(e.urlTemplate, currentItem.ID, "")
+	if err != nil {
+		return ExpanderResult{
+			Err:               err,
+			SourceDescription: e.Name() + " request",
+		}
+	}
+
+	display := e.definition.Display
+	if display == "" {
+		display = e.definition.Name
+	}
+	return ExpanderResult{
+		Nodes: []*TreeNode{
+			{
+				Parentid:       currentItem.ID,
+				Namespace:      "None",
+				Display:        style.Subtle("[Declarative]") + "\n  " + display,
+				Name:           e.definition.Name,
+				ID:             currentItem.ID + "/<declarative:" + e.definition.Name + ">",
+				ExpandURL:      listURL,
+				ItemType:       declarativeType,
+				SubscriptionID: currentItem.SubscriptionID,
+				Metadata: map[string]string{
+					"SuppressSwaggerExpand": "true",
+					"SuppressGenericExpand": "true",
+					declarativeNameMeta:     e.definition.Name,
+				},
+			},
+		},
+		SourceDescription: e.Name() + " request",
+	}
+}
+
+func (e *DeclarativeExpander) expandItems(ctx context.Context, currentItem *TreeNode) ExpanderResult {
+	data, err := e.client.DoRequest(ctx, "GET", currentItem.ExpandURL)
+	if err != nil {
+		return ExpanderResult{
+			Err:               fmt.Errorf("Failed retrieving %s: %s", e.definition.Name, err),
+			Response:          ExpanderResponse{Response: data, ResponseType: ResponseJSON},
+			SourceDescription: e.Name() + " request",
+			IsPrimaryResponse: true,
+		}
+	}
+
+	value, err := fastjson.Parse(data)
+	if err != nil {
+		return ExpanderResult{
+			Err:               fmt.Errorf("Error unmarshalling %s: %s", e.definition.Name, err),
+			Response:          ExpanderResponse{Response: data, ResponseType: ResponseJSON},
+			SourceDescription: e.Name() + " request",
+			IsPrimaryResponse: true,
+		}
+	}
+
+	nodes := []*TreeNode{}
+	items := value.Get(e.itemsPath...)
+	if items != nil && items.Type() == fastjson.TypeArray {
+		for _, item := range items.GetArray() {
+			nodes = append(nodes, e.newItemNode(currentItem, item))
+		}
+	}
+
+	return ExpanderResult{
+		Nodes:             nodes,
+		Response:          ExpanderResponse{Response: data, ResponseType: ResponseJSON},
+		SourceDescription: e.Name() + " request",
+		IsPrimaryResponse: true,
+	}
+}
+
+func (e *DeclarativeExpander) newItemNode(parent *TreeNode, item *fastjson.Value) *TreeNode {
+	name := selectJSONString(item, e.namePath)
+	id := selectJSONString(item, e.idPath)
+
+	node := &TreeNode{
+		Parentid:       parent.ID,
+		Namespace:      "None",
+		Display:        name,
+		Name:           name,
+		ID:             id,
+		ExpandURL:      ExpandURLNotSupported,
+		ItemType:       SubResourceType,
+		SubscriptionID: parent.SubscriptionID,
+		Metadata:       map[string]string{},
+	}
+	if id == "" {
+		// Without an ID there is nothing to request for the item
+		node.ID = parent.ID + "/" + name
+		node.Metadata["SuppressSwaggerExpand"] = "true"
+		node.Metadata["SuppressGenericExpand"] = "true"
+	} else {
+		node.ExpandURL = id + "?api-version=" + e.definition.APIVersion
+	}
+	if e.statusPath != nil {
+		node.StatusIndicator = DrawStatus(selectJSONString(item, e.statusPath))
+	}
+	if e.deleteURLTemplate != nil && id != "" {
+		if deleteURL, err := e.buildURL(e.deleteURLTemplate, parent.Parentid, id); err == nil {
+			node.DeleteURL = deleteURL
+		}
+	}
+	return node
+}
+
+// Delete isn't handled by the expander as nodes are given a DeleteURL when the definition has one
+func (e *DeclarativeExpander) Delete(context context.Context, item *TreeNode) (bool, error) {
+	return false, nil
+}
+
+func (e *DeclarativeExpander) matches(item *TreeNode) bool {
+	match := e.definition.Match
+	if match.ItemType != "" && match.ItemType != item.ItemType {
+		return false
+	}
+	if match.ArmType != "" && !strings.EqualFold(match.ArmType, item.ArmType) {
+		return false
+	}
+	return true
+}
+
+// buildURL fills the named segments of the template from the segments of parentID that line up with it,
+// overlaid with those from itemID
+func (e *DeclarativeExpander) buildURL(template *endpoints.EndpointInfo, parentID string, itemID string) (string, error) {
+	values := map[string]string{}
+	for _, id := range []string{parentID, itemID} {
+		for name, value := range templateValuesFromID(template, id) {
+			values[name] = value
+		}
+	}
+	for _, segment := range template.URLSegments {
+		if segment.Name != "" && values[segment.Name] == "" {
+			return "", fmt.Errorf("No value was found for '%s' in the url for definition '%s'", segment.Name, e.definition.Name)
+		}
+	}
+	return template.BuildURL(values)
+}
+
+// templateValuesFromID returns the values for the longest prefix of the template that matches the ID
+func templateValuesFromID(template *endpoints.EndpointInfo, id string) map[string]string {
+	if id == "" {
+		return nil
+	}
+	for i := len(template.URLSegments); i > 0; i-- {
+		prefix := endpoints.EndpointInfo{URLSegments: template.URLSegments[:i]}
+		if match := prefix.Match(id); match.IsMatch {
+			return match.Values
+		}
+	}
+	return nil
+}
+
+var jsonPathSegmentRegex = regexp.MustCompile(`^([^.\[\]]*)((\[[0-9]+\])*)$`)
+
+// parseJSONPath converts a simple JSONPath (e.g. `$.properties.rules[0].name`) to the keys used with fastjson's Get
+func parseJSONPath(selector string) ([]string, error) {
+	if selector == "$" {
+		return []string{}, nil
+	}
+	if !strings.HasPrefix(selector, "$.") {
+		return nil, fmt.Errorf("'%s' should start with '$.'", selector)
+	}
+	path := []string{}
+	for _, segment := range strings.Split(strings.TrimPrefix(selector, "$."), ".") {
+		match := jsonPathSegmentRegex.FindStringSubmatch(segment)
+		if match == nil || match[1] == "" {
+			return nil, fmt.Errorf("'%s' is not a supported path", selector)
+		}
+		path = append(path, match[1])
+		if match[2] != "" {
+			indexes := strings.Split(strings.Trim(match[2], "[]"), "][")
+			path = append(path, indexes...)
+		}
+	}
+	return path, nil
+}
+
+// selectJSONString returns the value at path as a string, or "" if it doesn't exist
+func selectJSONString(value *fastjson.Value, path []string) string {
+	selected := value.Get(path...)
+	if selected == nil {
+		return ""
+	}
+	switch selected.Type() {
+	case fastjson.TypeString:
+		return string(selected.GetStringBytes())
+	case fastjson.TypeNull:
+		return ""
+	}
+	return selected.String()
+}
@@ -0,0 +1,101 @@
+package expanders
+
+import (
+	"context"
+	"testing"
+
+	"github.com/nbio/st"
+)
+
+const declarativeTestServerID = "/subscriptions/00000000-0000-0000-0000-000000000000/resourceGroups/stable/providers/Microsoft.Sql/servers/sqlserver"
+
+func newTestDeclarativeExpander(t *testing.T) *DeclarativeExpander {
+	definitions, err := LoadDeclarativeDefinitions("./testdata/declarative")
+	st.Expect(t, err, nil)
+	st.Expect(t, len(definitions), 2)
+
+	for _, definition := range definitions {
+		if definition.Name == "SqlFirewallRules" {
+			expander, err := NewDeclarativeExpander(nil, definition)
+			st.Expect(t, err, nil)
+			return expander
+		}
+		_, err := NewDeclarativeExpander(nil, definition)
+		st.Reject(t, err, nil)
+	}
+	t.Fatal("SqlFirewallRules definition not found")
+	return nil
+}
+
+func Test_DeclarativeExpander(t *testing.T) {
+	expander := newTestDeclarativeExpander(t)
+	ctx := context.Background()
+
+	serverNode := &TreeNode{
+		ID:             declarativeTestServerID,
+		ItemType:       ResourceType,
+		ArmType:        "Microsoft.Sql/servers",
+		SubscriptionID: "00000000-0000-0000-0000-000000000000",
+	}
+
+	doesExpand, err := expander.DoesExpand(ctx, &TreeNode{ID: declarativeTestServerID, ItemType: ResourceType, ArmType: "Microsoft.Web/sites"})
+	st.Expect(t, err, nil)
+	st.Expect(t, doesExpand, false)
+	doesExpand, err = expander.DoesExpand(ctx, serverNode)
+	st.Expect(t, err, nil)
+	st.Expect(t, doesExpand, true)
+
+	rulesNode := expander.Expand(ctx, serverNode).Nodes[0]
+	doesExpand, err = expander.DoesExpand(ctx, rulesNode)
+	st.Expect(t, err, nil)
+	st.Expect(t, doesExpand, true)
+
+	noRequestGockConfig := func(t *testing.T) {}
+
+	runExpanderTestCases(t, expander, []expanderTestCase{
+		{
+			name:              "Resource->FirewallRulesNode",
+			nodeToExpand:      serverNode,
+			configureGockFunc: &noRequestGockConfig,
+			treeNodeCheckerFunc: func(t *testing.T, r ExpanderResult) {
+				st.Expect(t, r.Err, nil)
+				st.Expect(t, r.IsPrimaryResponse, false)
+				st.Expect(t, len(r.Nodes), 1)
+				st.Expect(t, r.Nodes[0].Name, "SqlFirewallRules")
+				st.Expect(t, r.Nodes[0].ItemType, declarativeType)
+				st.Expect(t, r.Nodes[0].ExpandURL, declarativeTestServerID+"/firewallRules?api-version=2014-04-01")
+			},
+		},
+		{
+			name:         "FirewallRulesNode->Rules",
+			nodeToExpand: rulesNode,
+			urlPath:      declarativeTestServerID + "/firewallRules",
+			statusCode:   200,
+			responseFile: "./testdata/armsamples/declarative/firewallRules.json",
+			treeNodeCheckerFunc: func(t *testing.T, r ExpanderResult) {
+				st.Expect(t, r.Err, nil)
+				st.Expect(t, r.IsPrimaryResponse, true)
+				st.Expect(t, len(r.Nodes), 2)
+
+				st.Expect(t, r.Nodes[0].Name, "AllowAllWindowsAzureIps")
+				st.Expect(t, r.Nodes[0].StatusIndicator, DrawStatus("Succeeded"))
+				st.Expect(t, r.Nodes[0].ExpandURL, declarativeTestServerID+"/firewallRules/AllowAllWindowsAzureIps?api-version=2014-04-01")
+				st.Expect(t, r.Nodes[0].DeleteURL, declarativeTestServerID+"/firewallRules/AllowAllWindowsAzureIps?api-version=2014-04-01")
+
+				st.Expect(t, r.Nodes[1].Name, "office")
+				st.Expect(t, r.Nodes[1].StatusIndicator, "")
+			},
+		},
+	})
+}
+
+func Test_DeclarativeExpander_ParseJSONPath(t *testing.T) {
+	path, err := parseJSONPath("$.properties.rules[0][1].name")
+	st.Expect(t, err, nil)
+	st.Expect(t, path, []string{"properties", "rules", "0", "1", "name"})
+
+	_, err = parseJSONPath("properties.name")
+	st.Reject(t, err, nil)
+	_, err = parseJSONPath("$.properties..name")
+	st.Reject(t, err, nil)
+}
@@ -3,6 +3,7 @@ package expanders
 import (
 	"context"
 	"encoding/json"
+	"strings"
 
 	"github.com/lawrencegripper/azbrowse/internal/pkg/style"
 	"github.com/lawrencegripper/azbrowse/pkg/armclient"
@@ -64,14 +65,19 @@ func (e *DeploymentsExpander) Expand(ctx context.Context, currentItem *TreeNode)
 		for i, dep := range deployments.Value {
 			// Update the existing state as we have more up-to-date info
 			objectJSON := string(value.GetArray("value")[i].MarshalTo([]byte("")))
+			// Management group deployments aren't available in older API versions
+			apiVersion := "2017-05-10"
+			if strings.HasPrefix(strings.ToLower(dep.ID), strings.ToLower(ManagementGroupsRootID)) {
+				apiVersion = managementGroupDeploymentsAPIVersion
+			}
 			newItems = append(newItems, &TreeNode{
 				Name:            dep.Name,
 				Display:         dep.Name + "\n   " + style.Subtle("Started:  "+dep.Properties.Timestamp) + "\n   " + style.Subtle("Duration: "+dep.Properties.Duration) + "\n   " + style.Subtle("DeploymentStatus: "+dep.Properties.ProvisioningState+""),
 				ID:              dep.ID,
 				Parentid:        currentItem.ID + "/operations/",
-				ExpandURL:       dep.ID + "/operations/?api-version=2017-05-10",
+				ExpandURL:       dep.ID + "/operations/?api-version=" + apiVersion,
 				ItemType:        deploymentType,
-				DeleteURL:       dep.ID + "?api-version=2017-05-10",
+				DeleteURL:       dep.ID + "?api-version=" + apiVersion,
 				SubscriptionID:  currentItem.SubscriptionID,
 				StatusIndicator: DrawStatus(dep.Properties.ProvisioningState),
 				Metadata: map[string]string{
@@ -2,44 +2,92 @@ package expanders
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
+	"strings"
 	"time"
 
 	"github.com/lawrencegripper/azbrowse/internal/pkg/errorhandling"
 	"github.com/lawrencegripper/azbrowse/internal/pkg/eventing"
+	"github.com/lawrencegripper/azbrowse/internal/pkg/style"
 	"github.com/lawrencegripper/azbrowse/internal/pkg/tracing"
+	"github.com/lawrencegripper/azbrowse/pkg/armclient"
 )
 
 type expanderAndResponse struct {
 	Expander       Expander
 	ExpanderResult ExpanderResult
+	Duration       time.Duration
+}
+
+// ExpandProgress is sent by StreamExpandItem each time an expander completes
+type ExpandProgress struct {
+	Content *ExpanderResponse // The primary response, nil until it has been received
+	Nodes   []*TreeNode       // The nodes received so far, primary response nodes first, followed by error nodes and a placeholder for each running expander
+	Done    bool              // Set on the last update once all expanders have completed or the context was cancelled
+	Err     error             // Set on the last update if the context was cancelled
+}
+
+type expandContextKey int
+
+const quietExpandKey expandContextKey = iota
+
+// WithoutExpandStatus returns a context for which StreamExpandItem doesn't show the "Opening" status message,
+// used for background refreshes. Expander failures are still reported
+func WithoutExpandStatus(ctx context.Context) context.Context {
+	return context.WithValue(ctx, quietExpandKey, true)
 }
 
 // ExpandItem finds child nodes of the item and their content
 func ExpandItem(ctx context.Context, currentItem *TreeNode) (*ExpanderResponse, []*TreeNode, error) {
-	newItems := []*TreeNode{}
+	var progress ExpandProgress
+	for progress = range StreamExpandItem(ctx, currentItem) {
+	}
+	if progress.Err != nil {
+		return nil, nil, progress.Err
+	}
+	return progress.Content, progress.Nodes, nil
+}
 
-	_, done := eventing.SendStatusEvent(&eventing.StatusEvent{
-		Message:    "Opening: " + currentItem.ID,
-		InProgress: true,
-	})
-	defer done()
+// StreamExpandItem finds child nodes of the item and their content, sending the results received so far
+// as each expander completes so that slow expanders don't hold up the others.
+// Expanders which fail, time out or panic are reported with an error node and recorded in the expander health registry.
+// Cancelling ctx stops waiting for the expanders and clears the status message.
+// The channel is closed after the update with Done set
+func StreamExpandItem(ctx context.Context, currentItem *TreeNode) <-chan ExpandProgress {
+	clearStatus, done := func() {}, func() {}
+	if ctx.Value(quietExpandKey) == nil {
+		var statusEvent *eventing.StatusEvent
+		statusEvent, done = eventing.SendStatusEvent(&eventing.StatusEvent{
+			Message:    "Opening: " + currentItem.ID,
+			InProgress: true,
+		})
+		clearStatus = statusEvent.Clear
+	}
 
 	span, ctx := tracing.StartSpanFromContext(ctx, "expand:"+currentItem.ItemType+":"+currentItem.Name, tracing.SetTag("item", currentItem))
-	defer span.Finish()
 
-	// New handler approach
-	handlerExpanding := 0
-
-	completedExpands := make(chan expanderAndResponse)
+	// Buffered so expanders which complete after the expansion is cancelled don't block
+	completedExpands := make(chan expanderAndResponse, len(getRegisteredExpanders()))
+	runningExpanders := []Expander{}
+	errorItems := []*TreeNode{}
+	explanation, runs := newExpandExplanation(currentItem, getRegisteredExpanders())
 
 	// Check which expanders are interested and kick them off
 	spanQuery, _ := tracing.StartSpanFromContext(ctx, "querexpanders", tracing.SetTag("item", currentItem))
 	for _, h := range getRegisteredExpanders() {
-		doesExpand, err := h.DoesExpand(ctx, currentItem)
+		if !isCoreExpander(h) && isExpanderDisabled(h.Name()) {
+			runs[h].Disabled = true
+			continue
+		}
+		doesExpand, err := checkDoesExpand(ctx, h, currentItem)
 		spanQuery.SetTag(h.Name(), doesExpand)
+		runs[h].DoesExpand = doesExpand
 		if err != nil {
-			panic(err)
+			runs[h].Err = err
+			reportExpanderFailure(h.Name(), currentItem, err, recordExpanderResult(h.Name(), 0, err, !isCoreExpander(h)))
+			errorItems = append(errorItems, newExpanderErrorNode(currentItem, h.Name(), err))
+			continue
 		}
 		if !doesExpand {
 			continue
@@ -51,84 +99,324 @@ func ExpandItem(ctx context.Context, currentItem *TreeNode) (*ExpanderResponse,
 			// recover from panic, if one occurrs, and leave terminal usable
 			defer errorhandling.RecoveryWithCleanup()
 
+			start := time.Now()
+			result := runExpander(ctx, hCurrent, currentItem, !isCoreExpander(hCurrent))
 			completedExpands <- expanderAndResponse{
 				Expander:       hCurrent,
-				ExpanderResult: hCurrent.Expand(ctx, currentItem),
+				ExpanderResult: result,
+				Duration:       time.Since(start),
 			}
 		}()
 
-		handlerExpanding = handlerExpanding + 1
+		runningExpanders = append(runningExpanders, hCurrent)
 	}
 	spanQuery.Finish()
 
-	// Lets give all the expanders 45secs to completed (unless debugging)
-	hasPrimaryResponse := false
-	timeout := time.After(time.Second * 45)
-	var newContent ExpanderResponse
-
-	observedError := false
-	for index := 0; index < handlerExpanding; index++ {
-		select {
-		case done := <-completedExpands:
-			result := done.ExpanderResult
-			span, _ := tracing.StartSpanFromContext(ctx, "subexpand:"+result.SourceDescription, tracing.SetTag("result", done))
-			// Did it fail?
-			if result.Err != nil {
-				eventing.SendStatusEvent(&eventing.StatusEvent{
-					Failure: true,
-					Message: "Expander '" + result.SourceDescription + "' failed on resource: " + currentItem.ID + "Err: " + result.Err.Error(),
-					Timeout: time.Duration(time.Second * 15),
-				})
-				observedError = true
+	// Buffered so that sending never waits on the receiver (one update per expander plus the final update)
+	progressChan := make(chan ExpandProgress, len(runningExpanders)+1)
+
+	go func() {
+		// recover from panic, if one occurrs, and leave terminal usable
+		defer errorhandling.RecoveryWithCleanup()
+		defer close(progressChan)
+		defer span.Finish()
+		defer func() {
+			if ctx.Err() != nil {
+				clearStatus()
+				return
 			}
-			if result.IsPrimaryResponse {
-				if hasPrimaryResponse {
-					panic(fmt.Sprintf("Two handlers returned a primary response for this item... failing. ID: %s EXPANDER: %s", currentItem.ID, result.SourceDescription))
+			done()
+		}()
+
+		handlerExpanding := len(runningExpanders)
+		primaryItems := []*TreeNode{}
+		otherItems := []*TreeNode{}
+
+		// snapshot copies the nodes so receivers can modify the slice (e.g. sorting)
+		snapshot := func(withPlaceholders bool) []*TreeNode {
+			nodes := make([]*TreeNode, 0, len(primaryItems)+len(otherItems)+len(errorItems)+len(runningExpanders))
+			nodes = append(nodes, primaryItems...)
+			nodes = append(nodes, otherItems...)
+			nodes = append(nodes, errorItems...)
+			if withPlaceholders {
+				for _, expander := range runningExpanders {
+					nodes = append(nodes, newLoadingNode(currentItem, expander))
 				}
-				// Log that we have a primary response
-				hasPrimaryResponse = true
-				newContent = result.Response
 			}
-			if result.Nodes == nil {
-				continue
+			return nodes
+		}
+
+		// finishExplanation records the explanation on the item, it's called before the last update is sent
+		finishExplanation := func(cancelled bool) {
+			explanation.Cancelled = cancelled
+			if resourceType := currentItem.SwaggerResourceType; resourceType != nil && resourceType.Endpoint != nil {
+				explanation.SwaggerTemplateURL = resourceType.Endpoint.TemplateURL
 			}
-			for _, node := range result.Nodes {
-				node.Expander = done.Expander
+			currentItem.setExpandExplanation(explanation)
+		}
+
+		// Each expander has its own timeout (see SetExpanderTimeouts) so will always complete
+		primaryExpanderName := ""
+		var newContent ExpanderResponse
+
+		for index := 0; index < handlerExpanding; index++ {
+			select {
+			case done := <-completedExpands:
+				result := done.ExpanderResult
+				span, _ := tracing.StartSpanFromContext(ctx, "subexpand:"+result.SourceDescription, tracing.SetTag("result", done))
+				runningExpanders = removeExpander(runningExpanders, done.Expander)
+
+				isPrimaryResponse := result.IsPrimaryResponse
+				if isPrimaryResponse && primaryExpanderName != "" {
+					// Only one expander can provide the content so report the conflict and keep the nodes
+					isPrimaryResponse = false
+					result.Err = fmt.Errorf("Returned a primary response but %s already provided one", primaryExpanderName)
+					reportExpanderFailure(done.Expander.Name(), currentItem, result.Err, recordExpanderResult(done.Expander.Name(), 0, result.Err, !isCoreExpander(done.Expander)))
+				} else if result.Err != nil && ctx.Err() == nil {
+					// errors from cancelled requests aren't reported
+					reportExpanderFailure(result.SourceDescription, currentItem, result.Err, false)
+				}
+				if result.Err != nil && ctx.Err() == nil {
+					errorItems = append(errorItems, newExpanderErrorNode(currentItem, done.Expander.Name(), result.Err))
+				}
+				if isPrimaryResponse {
+					primaryExpanderName = done.Expander.Name()
+					newContent = result.Response
+				}
+				run := runs[done.Expander]
+				run.Completed = true
+				run.Duration = done.Duration
+				run.Nodes = len(result.Nodes)
+				run.Primary = isPrimaryResponse
+				run.Err = result.Err
+				if result.ContinuationToken != "" && result.LoadMore != nil {
+					result.Nodes = append(result.Nodes, newLoadMoreNode(currentItem.ID, done.Expander, result))
+				}
+				for _, node := range result.Nodes {
+					// Nodes for the next page of a paged result belong to the expander which created the first page
+					if node.Expander == nil {
+						node.Expander = done.Expander
+					}
+				}
+				// Add the items it found
+				if isPrimaryResponse {
+					primaryItems = append(primaryItems, result.Nodes...)
+				} else {
+					otherItems = append(otherItems, result.Nodes...)
+				}
+				span.Finish()
+
+				progress := ExpandProgress{Nodes: snapshot(true)}
+				if primaryExpanderName != "" {
+					content := newContent
+					progress.Content = &content
+				}
+				progressChan <- progress
+			case <-ctx.Done():
+				finishExplanation(true)
+				progress := ExpandProgress{
+					Nodes: snapshot(false),
+					Done:  true,
+					Err:   ctx.Err(),
+				}
+				if primaryExpanderName != "" {
+					progress.Content = &newContent
+				}
+				progressChan <- progress
+				return
 			}
-			// Add the items it found
-			if result.IsPrimaryResponse {
-				newItems = append(result.Nodes, newItems...)
-			} else {
-				newItems = append(newItems, result.Nodes...)
+		}
+
+		if err := ctx.Err(); err != nil {
+			finishExplanation(true)
+			progressChan <- ExpandProgress{
+				Content: &newContent,
+				Nodes:   snapshot(false),
+				Done:    true,
+				Err:     err,
 			}
-			span.Finish()
-		case <-timeout:
-			eventing.SendStatusEvent(&eventing.StatusEvent{
-				Failure: true,
-				Message: "Timed out opening:" + currentItem.ID,
-				Timeout: time.Duration(time.Second * 10),
-			})
-			return nil, nil, fmt.Errorf("Timed out opening: %s", currentItem.ID)
+			return
+		}
+
+		// Use the default handler to get the resource JSON for display
+		defaultExpanderWorksOnThisItem, _ := GetDefaultExpander().DoesExpand(ctx, currentItem)
+		if primaryExpanderName == "" && defaultExpanderWorksOnThisItem {
+			start := time.Now()
+			result := runExpander(ctx, GetDefaultExpander(), currentItem, false)
+			explanation.DefaultExpander = &ExpanderRun{
+				Name:       GetDefaultExpander().Name(),
+				DoesExpand: true,
+				Completed:  true,
+				Duration:   time.Since(start),
+				Nodes:      len(result.Nodes),
+				Primary:    true,
+				Err:        result.Err,
+			}
+			if result.Err != nil {
+				eventing.SendStatusEvent(&eventing.StatusEvent{
+					InProgress: true,
+					Message:    "Failed to expand resource: " + result.Err.Error(),
+					Timeout:    time.Duration(time.Second * 3),
+				})
+			}
+			newContent = result.Response
+		}
+
+		finishExplanation(false)
+		progressChan <- ExpandProgress{
+			Content: &newContent,
+			Nodes:   snapshot(false),
+			Done:    true,
+		}
+	}()
+
+	return progressChan
+}
+
+// checkDoesExpand calls DoesExpand on the expander converting a panic to an error
+func checkDoesExpand(ctx context.Context, expander Expander, currentItem *TreeNode) (doesExpand bool, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			doesExpand = false
+			err = fmt.Errorf("DoesExpand %w: %v", errExpanderPanicked, r)
+		}
+	}()
+	return expander.DoesExpand(ctx, currentItem)
+}
+
+// runExpander calls Expand on the expander with its timeout, converting a panic or timeout to an error,
+// and records the outcome in the expander health registry
+func runExpander(ctx context.Context, expander Expander, currentItem *TreeNode, canDisable bool) ExpanderResult {
+	timeout := getExpanderTimeout(expander.Name())
+	expanderCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	expanderCtx, cacheUsage := armclient.WithResponseCacheUsage(expanderCtx)
+
+	start := time.Now()
+	// Buffered so an expander which completes after timing out doesn't block
+	resultChan := make(chan ExpanderResult, 1)
+	go func() {
+		defer func() {
+			if r := recover(); r != nil {
+				resultChan <- ExpanderResult{
+					Err:               fmt.Errorf("Expand %w: %v", errExpanderPanicked, r),
+					SourceDescription: expander.Name(),
+				}
+			}
+		}()
+		resultChan <- expander.Expand(expanderCtx, currentItem)
+	}()
+
+	var result ExpanderResult
+	select {
+	case result = <-resultChan:
+	case <-expanderCtx.Done():
+		err := ctx.Err()
+		if err == nil {
+			err = fmt.Errorf("%w after %s", errExpanderTimedOut, timeout)
+		}
+		result = ExpanderResult{
+			Err:               err,
+			SourceDescription: expander.Name(),
+		}
+	}
+	if result.SourceDescription == "" {
+		result.SourceDescription = expander.Name()
+	}
+	if cacheUsage.Stale() {
+		for _, node := range result.Nodes {
+			node.Stale = true
 		}
 	}
 
-	// Use the default handler to get the resource JSON for display
-	defaultExpanderWorksOnThisItem, _ := GetDefaultExpander().DoesExpand(ctx, currentItem)
-	if !hasPrimaryResponse && defaultExpanderWorksOnThisItem {
-		result := GetDefaultExpander().Expand(ctx, currentItem)
-		if result.Err != nil {
-			eventing.SendStatusEvent(&eventing.StatusEvent{
-				InProgress: true,
-				Message:    "Failed to expand resource: " + result.Err.Error(),
-				Timeout:    time.Duration(time.Second * 3),
-			})
+	// Don't count requests cancelled by the user against the expander
+	if ctx.Err() == nil {
+		if recordExpanderResult(expander.Name(), time.Since(start), result.Err, canDisable) {
+			reportExpanderDisabled(expander.Name())
 		}
-		newContent = result.Response
 	}
+	return result
+}
+
+// reportExpanderFailure sends a status event for the failure
+func reportExpanderFailure(source string, currentItem *TreeNode, err error, disabled bool) {
+	eventing.SendStatusEvent(&eventing.StatusEvent{
+		Failure: true,
+		Message: "Expander '" + source + "' failed on resource: " + currentItem.ID + "Err: " + err.Error(),
+		Timeout: time.Duration(time.Second * 15),
+	})
+	if disabled {
+		reportExpanderDisabled(source)
+	}
+}
+
+// reportExpanderDisabled sends a status event when an expander is disabled
+func reportExpanderDisabled(name string) {
+	eventing.SendStatusEvent(&eventing.StatusEvent{
+		Failure: true,
+		Message: fmt.Sprintf("Expander '%s' has been disabled for this session after %d consecutive failures", name, expanderDisableThreshold),
+		Timeout: time.Duration(time.Second * 15),
+	})
+}
+
+// newExpanderErrorNode creates the node reporting an expander failure. Opening it shows the error
+func newExpanderErrorNode(parent *TreeNode, expanderName string, err error) *TreeNode {
+	errorJSON, _ := json.Marshal(map[string]string{ //nolint: errcheck
+		"expander": expanderName,
+		"item":     parent.ID,
+		"error":    err.Error(),
+	})
+	return &TreeNode{
+		Parentid:       parent.ID,
+		Namespace:      "None",
+		Display:        style.Warning("✖ "+expanderName+" failed") + "\n  " + style.Subtle(truncateErrorMessage(err.Error(), 80)),
+		Name:           expanderName + " error",
+		ID:             parent.ID + "/<error:" + expanderName + ">",
+		ExpandURL:      ExpandURLNotSupported,
+		ItemType:       ErrorType,
+		SubscriptionID: parent.SubscriptionID,
+		Metadata: map[string]string{
+			"SuppressSwaggerExpand": "true",
+			"SuppressGenericExpand": "true",
+			"jsonItem":              string(errorJSON),
+		},
+	}
+}
+
+// truncateErrorMessage shortens the message to a single line of at most length characters
+func truncateErrorMessage(message string, length int) string {
+	message = strings.Join(strings.Fields(message), " ")
+	runes := []rune(message)
+	if len(runes) > length {
+		return string(runes[:length-3]) + "..."
+	}
+	return message
+}
 
-	if !observedError {
-		done()
+// newLoadingNode creates the placeholder shown for an expander which hasn't completed
+func newLoadingNode(parent *TreeNode, expander Expander) *TreeNode {
+	return &TreeNode{
+		Parentid:       parent.ID,
+		Namespace:      "None",
+		Display:        style.Subtle("Loading " + expander.Name() + "..."),
+		Name:           expander.Name(),
+		ID:             parent.ID + "/<loading:" + expander.Name() + ">",
+		ExpandURL:      ExpandURLNotSupported,
+		ItemType:       LoadingType,
+		SubscriptionID: parent.SubscriptionID,
+		Metadata: map[string]string{
+			"SuppressSwaggerExpand": "true",
+			"SuppressGenericExpand": "true",
+		},
 	}
+}
 
-	return &newContent, newItems, nil
+func removeExpander(expanders []Expander, expander Expander) []Expander {
+	remaining := []Expander{}
+	for _, e := range expanders {
+		if e != expander {
+			remaining = append(remaining, e)
+		}
+	}
+	return remaining
 }
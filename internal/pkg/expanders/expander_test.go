@@ -0,0 +1,406 @@
+package expanders
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/lawrencegripper/azbrowse/internal/pkg/eventing"
+	"github.com/lawrencegripper/azbrowse/pkg/armclient"
+	"github.com/nbio/st"
+)
+
+// streamTestExpander returns a single node after a delay
+type streamTestExpander struct {
+	ExpanderBase
+	name      string
+	delay     time.Duration
+	isPrimary bool
+}
+
+func (e *streamTestExpander) Name() string { return e.name }
+func (e *streamTestExpander) DoesExpand(ctx context.Context, currentItem *TreeNode) (bool, error) {
+	return true, nil
+}
+func (e *streamTestExpander) Expand(ctx context.Context, currentItem *TreeNode) ExpanderResult {
+	select {
+	case <-time.After(e.delay):
+	case <-ctx.Done():
+		return ExpanderResult{Err: ctx.Err(), SourceDescription: e.name}
+	}
+	return ExpanderResult{
+		Nodes:             []*TreeNode{{ID: currentItem.ID + "/" + e.name, Name: e.name}},
+		Response:          ExpanderResponse{Response: e.name, ResponseType: ResponsePlainText},
+		IsPrimaryResponse: e.isPrimary,
+		SourceDescription: e.name,
+	}
+}
+func (e *streamTestExpander) testCases() (bool, *[]expanderTestCase) { return false, nil }
+func (e *streamTestExpander) setClient(c *armclient.Client)          {}
+
+func Test_StreamExpandItem_SendsResultsAsExpandersComplete(t *testing.T) {
+	defer func(previousRegister []Expander, previousDefault *DefaultExpander) {
+		register, defaultExpander = previousRegister, previousDefault
+	}(register, defaultExpander)
+
+	defaultExpander = &DefaultExpander{}
+	register = []Expander{
+		&streamTestExpander{name: "slow", delay: 500 * time.Millisecond},
+		&streamTestExpander{name: "primary", delay: 200 * time.Millisecond, isPrimary: true},
+		&streamTestExpander{name: "fast"},
+	}
+	item := &TreeNode{ID: "/item", ExpandURL: ExpandURLNotSupported}
+
+	updates := []ExpandProgress{}
+	for progress := range StreamExpandItem(context.Background(), item) {
+		updates = append(updates, progress)
+	}
+	st.Expect(t, len(updates), 4)
+
+	nodeNames := func(progress ExpandProgress) []string {
+		names := []string{}
+		for _, node := range progress.Nodes {
+			if node.ItemType == LoadingType {
+				names = append(names, "loading:"+node.Name)
+			} else {
+				names = append(names, node.Name)
+			}
+		}
+		return names
+	}
+
+	// fast completes first with placeholders for the running expanders
+	st.Expect(t, updates[0].Content == nil, true)
+	st.Expect(t, nodeNames(updates[0]), []string{"fast", "loading:slow", "loading:primary"})
+
+	// the primary response is sent as soon as it's received and its nodes come first
+	st.Expect(t, updates[1].Content.Response, "primary")
+	st.Expect(t, nodeNames(updates[1]), []string{"primary", "fast", "loading:slow"})
+
+	st.Expect(t, nodeNames(updates[2]), []string{"primary", "fast", "slow"})
+	st.Expect(t, updates[2].Done, false)
+
+	st.Expect(t, updates[3].Done, true)
+	st.Expect(t, updates[3].Err, nil)
+	st.Expect(t, updates[3].Content.Response, "primary")
+	st.Expect(t, nodeNames(updates[3]), []string{"primary", "fast", "slow"})
+
+	content, nodes, err := ExpandItem(context.Background(), item)
+	st.Expect(t, err, nil)
+	st.Expect(t, content.Response, "primary")
+	st.Expect(t, len(nodes), 3)
+	st.Expect(t, nodes[0].Name, "primary")
+}
+
+func Test_StreamExpandItem_WithoutExpandStatus(t *testing.T) {
+	defer func(previousRegister []Expander, previousDefault *DefaultExpander) {
+		register, defaultExpander = previousRegister, previousDefault
+	}(register, defaultExpander)
+
+	defaultExpander = &DefaultExpander{}
+	register = []Expander{
+		&streamTestExpander{name: "primary", isPrimary: true},
+	}
+	item := &TreeNode{ID: "/item", ExpandURL: ExpandURLNotSupported}
+
+	statusEvents := eventing.SubscribeToStatusEvents()
+	defer eventing.Unsubscribe(statusEvents)
+
+	_, _, err := ExpandItem(WithoutExpandStatus(context.Background()), item)
+	st.Expect(t, err, nil)
+
+	for {
+		select {
+		case event := <-statusEvents:
+			t.Errorf("Expected no status events, got %q", event.(*eventing.StatusEvent).Message)
+		case <-time.After(100 * time.Millisecond):
+			return
+		}
+	}
+}
+
+func Test_StreamExpandItem_StopsWhenCancelled(t *testing.T) {
+	defer func(previousRegister []Expander, previousDefault *DefaultExpander) {
+		register, defaultExpander = previousRegister, previousDefault
+	}(register, defaultExpander)
+
+	defaultExpander = &DefaultExpander{}
+	register = []Expander{
+		&streamTestExpander{name: "slow", delay: time.Minute},
+		&streamTestExpander{name: "fast"},
+	}
+	item := &TreeNode{ID: "/item", ExpandURL: ExpandURLNotSupported}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	progressChan := StreamExpandItem(ctx, item)
+
+	progress := <-progressChan
+	st.Expect(t, len(progress.Nodes), 2)
+	cancel()
+
+	for progress = range progressChan {
+	}
+	st.Expect(t, progress.Done, true)
+	st.Expect(t, progress.Err, context.Canceled)
+	st.Expect(t, len(progress.Nodes), 1)
+	st.Expect(t, progress.Nodes[0].Name, "fast")
+}
+
+// failingTestExpander fails every expansion with err (defaults to "boom"), optionally by panicking
+type failingTestExpander struct {
+	ExpanderBase
+	panics bool
+	err    error
+}
+
+func (e *failingTestExpander) Name() string { return "failing" }
+func (e *failingTestExpander) DoesExpand(ctx context.Context, currentItem *TreeNode) (bool, error) {
+	return true, nil
+}
+func (e *failingTestExpander) Expand(ctx context.Context, currentItem *TreeNode) ExpanderResult {
+	if e.panics {
+		panic("boom")
+	}
+	if e.err != nil {
+		return ExpanderResult{Err: e.err, SourceDescription: "failing"}
+	}
+	return ExpanderResult{Err: fmt.Errorf("boom"), SourceDescription: "failing"}
+}
+func (e *failingTestExpander) testCases() (bool, *[]expanderTestCase) { return false, nil }
+func (e *failingTestExpander) setClient(c *armclient.Client)          {}
+
+func resetExpanderHealth() {
+	expanderHealthRegistry.Lock()
+	defer expanderHealthRegistry.Unlock()
+	expanderHealthRegistry.entries = map[string]*ExpanderHealth{}
+}
+
+func Test_StreamExpandItem_ReportsFailuresAsErrorNodes(t *testing.T) {
+	defer func(previousRegister []Expander, previousDefault *DefaultExpander, previousTimeouts map[string]time.Duration) {
+		register, defaultExpander, expanderTimeouts = previousRegister, previousDefault, previousTimeouts
+		resetExpanderHealth()
+	}(register, defaultExpander, expanderTimeouts)
+	resetExpanderHealth()
+
+	defaultExpander = &DefaultExpander{}
+	expanderTimeouts = map[string]time.Duration{}
+	SetExpanderTimeouts(map[string]int{"slow": 1})
+	register = []Expander{
+		&streamTestExpander{name: "slow", delay: time.Minute},
+		&streamTestExpander{name: "primary", isPrimary: true},
+		&streamTestExpander{name: "secondprimary", isPrimary: true, delay: 100 * time.Millisecond},
+		&failingTestExpander{panics: true},
+	}
+	item := &TreeNode{ID: "/item", ExpandURL: ExpandURLNotSupported}
+
+	content, nodes, err := ExpandItem(context.Background(), item)
+	st.Expect(t, err, nil)
+	st.Expect(t, content.Response, "primary")
+
+	// the nodes from the second primary response are kept and the failures are listed last
+	st.Expect(t, len(nodes), 5)
+	st.Expect(t, nodes[0].Name, "primary")
+	st.Expect(t, nodes[1].Name, "secondprimary")
+	errorNodes := map[string]string{}
+	for _, node := range nodes[2:] {
+		st.Expect(t, node.ItemType, ErrorType)
+		st.Expect(t, node.ExpandURL, ExpandURLNotSupported)
+		errorNodes[node.Name] = node.Metadata["jsonItem"]
+	}
+	st.Expect(t, strings.Contains(errorNodes["failing error"], "Expand panicked: boom"), true)
+	st.Expect(t, strings.Contains(errorNodes["secondprimary error"], "already provided one"), true)
+	st.Expect(t, strings.Contains(errorNodes["slow error"], "Timed out after 1s"), true)
+
+	health := GetExpanderHealth()
+	st.Expect(t, len(health), 4)
+	st.Expect(t, health[0].Name, "failing")
+	st.Expect(t, health[0].Failures, 1)
+	st.Expect(t, health[1].Name, "primary")
+	st.Expect(t, health[1].Failures, 0)
+}
+
+func Test_StreamExpandItem_DisablesFailingExpander(t *testing.T) {
+	defer func(previousRegister []Expander, previousDefault *DefaultExpander, previousThreshold int) {
+		register, defaultExpander, expanderDisableThreshold = previousRegister, previousDefault, previousThreshold
+		resetExpanderHealth()
+	}(register, defaultExpander, expanderDisableThreshold)
+	resetExpanderHealth()
+
+	defaultExpander = &DefaultExpander{}
+	expanderDisableThreshold = 2
+	register = []Expander{
+		&failingTestExpander{err: fmt.Errorf("Request returned a non-success status code of 503 with a status message of 503 Service Unavailable")},
+		&streamTestExpander{name: "working"},
+	}
+	item := &TreeNode{ID: "/item", ExpandURL: ExpandURLNotSupported}
+
+	for i := 0; i < 2; i++ {
+		_, nodes, err := ExpandItem(context.Background(), item)
+		st.Expect(t, err, nil)
+		st.Expect(t, len(nodes), 2)
+		st.Expect(t, nodes[1].ItemType, ErrorType)
+	}
+
+	// the failing expander is no longer called
+	_, nodes, err := ExpandItem(context.Background(), item)
+	st.Expect(t, err, nil)
+	st.Expect(t, len(nodes), 1)
+	st.Expect(t, nodes[0].Name, "working")
+
+	health := GetExpanderHealth()
+	st.Expect(t, health[0].Name, "failing")
+	st.Expect(t, health[0].Calls, 2)
+	st.Expect(t, health[0].Disabled, true)
+	st.Expect(t, strings.Contains(RenderExpanderHealth(health), "Disabled"), true)
+}
+
+func Test_StreamExpandItem_DoesNotDisableForExpectedErrors(t *testing.T) {
+	defer func(previousRegister []Expander, previousDefault *DefaultExpander, previousThreshold int) {
+		register, defaultExpander, expanderDisableThreshold = previousRegister, previousDefault, previousThreshold
+		resetExpanderHealth()
+	}(register, defaultExpander, expanderDisableThreshold)
+	resetExpanderHealth()
+
+	defaultExpander = &DefaultExpander{}
+	expanderDisableThreshold = 2
+	register = []Expander{
+		&failingTestExpander{err: fmt.Errorf("Request returned a non-success status code of 403 with a status message of 403 Forbidden")},
+	}
+	item := &TreeNode{ID: "/item", ExpandURL: ExpandURLNotSupported}
+
+	for i := 0; i < 3; i++ {
+		_, nodes, err := ExpandItem(context.Background(), item)
+		st.Expect(t, err, nil)
+		st.Expect(t, len(nodes), 1)
+		st.Expect(t, nodes[0].ItemType, ErrorType)
+	}
+
+	health := GetExpanderHealth()
+	st.Expect(t, health[0].Failures, 3)
+	st.Expect(t, health[0].ConsecutiveFailures, 0)
+	st.Expect(t, health[0].Disabled, false)
+}
+
+func Test_IsCoreExpander(t *testing.T) {
+	st.Expect(t, isCoreExpander(&SwaggerResourceExpander{}), true)
+	st.Expect(t, isCoreExpander(&ResourceGroupResourceExpander{}), true)
+	st.Expect(t, isCoreExpander(&LoadMoreExpander{}), true)
+	st.Expect(t, isCoreExpander(&HealthExpander{}), false)
+}
+
+func Test_TruncateErrorMessage(t *testing.T) {
+	st.Expect(t, truncateErrorMessage("short\nmessage", 20), "short message")
+	st.Expect(t, truncateErrorMessage("ééééééééé", 6), "ééé...")
+}
+
+// pagedTestExpander returns a page of nodes per call with the page number as the continuation token
+type pagedTestExpander struct {
+	ExpanderBase
+	pages int
+}
+
+func (e *pagedTestExpander) Name() string { return "paged" }
+func (e *pagedTestExpander) DoesExpand(ctx context.Context, currentItem *TreeNode) (bool, error) {
+	return currentItem.ItemType != LoadMoreType, nil
+}
+func (e *pagedTestExpander) Expand(ctx context.Context, currentItem *TreeNode) ExpanderResult {
+	return e.expandPage(currentItem, 1)
+}
+func (e *pagedTestExpander) expandPage(currentItem *TreeNode, page int) ExpanderResult {
+	result := ExpanderResult{
+		Nodes:             []*TreeNode{{ID: fmt.Sprintf("%s/%d", currentItem.ID, page), Name: fmt.Sprintf("page%d", page)}},
+		Response:          ExpanderResponse{Response: fmt.Sprintf("page%d", page), ResponseType: ResponsePlainText},
+		IsPrimaryResponse: true,
+		SourceDescription: "paged",
+	}
+	if page < e.pages {
+		result.ContinuationToken = fmt.Sprint(page + 1)
+		result.LoadMore = func(ctx context.Context, continuationToken string) ExpanderResult {
+			var nextPage int
+			fmt.Sscan(continuationToken, &nextPage) //nolint: errcheck
+			return e.expandPage(currentItem, nextPage)
+		}
+	}
+	return result
+}
+func (e *pagedTestExpander) testCases() (bool, *[]expanderTestCase) { return false, nil }
+func (e *pagedTestExpander) setClient(c *armclient.Client)          {}
+
+func Test_StreamExpandItem_AddsLoadMoreNodeForPagedResults(t *testing.T) {
+	defer func(previousRegister []Expander, previousDefault *DefaultExpander) {
+		register, defaultExpander = previousRegister, previousDefault
+	}(register, defaultExpander)
+
+	defaultExpander = &DefaultExpander{}
+	paged := &pagedTestExpander{pages: 3}
+	register = []Expander{paged, &LoadMoreExpander{}}
+	item := &TreeNode{ID: "/item", ExpandURL: ExpandURLNotSupported}
+
+	_, nodes, err := ExpandItem(context.Background(), item)
+	st.Expect(t, err, nil)
+	st.Expect(t, len(nodes), 2)
+	st.Expect(t, nodes[0].Name, "page1")
+	st.Expect(t, nodes[1].ItemType, LoadMoreType)
+	st.Expect(t, nodes[1].Parentid, "/item")
+
+	// Expanding the "more..." node returns the next page and another "more..." node
+	content, nodes, err := ExpandItem(context.Background(), nodes[1])
+	st.Expect(t, err, nil)
+	st.Expect(t, content.Response, "page2")
+	st.Expect(t, len(nodes), 2)
+	st.Expect(t, nodes[0].Name, "page2")
+	st.Expect(t, nodes[0].Expander, Expander(paged))
+	st.Expect(t, nodes[1].ItemType, LoadMoreType)
+	st.Expect(t, nodes[1].Parentid, "/item")
+
+	_, nodes, err = ExpandItem(context.Background(), nodes[1])
+	st.Expect(t, err, nil)
+	st.Expect(t, len(nodes), 1)
+	st.Expect(t, nodes[0].Name, "page3")
+}
+
+func Test_StreamExpandItem_RecordsExpandExplanation(t *testing.T) {
+	defer func(previousRegister []Expander, previousDefault *DefaultExpander) {
+		register, defaultExpander = previousRegister, previousDefault
+		resetExpanderHealth()
+	}(register, defaultExpander)
+	resetExpanderHealth()
+
+	defaultExpander = &DefaultExpander{}
+	register = []Expander{
+		&streamTestExpander{name: "primary", delay: 50 * time.Millisecond, isPrimary: true},
+		&LoadMoreExpander{},
+		&failingTestExpander{},
+	}
+	item := &TreeNode{ID: "/item", ExpandURL: ExpandURLNotSupported}
+	st.Expect(t, item.ExpandExplanation() == nil, true)
+
+	_, _, err := ExpandItem(context.Background(), item)
+	st.Expect(t, err, nil)
+
+	explanation := item.ExpandExplanation()
+	st.Expect(t, explanation.ItemID, "/item")
+	st.Expect(t, explanation.Cancelled, false)
+	st.Expect(t, explanation.DefaultExpander == nil, true)
+	st.Expect(t, len(explanation.Expanders), 3)
+
+	primary := explanation.Expanders[0]
+	st.Expect(t, primary.DoesExpand, true)
+	st.Expect(t, primary.Completed, true)
+	st.Expect(t, primary.Primary, true)
+	st.Expect(t, primary.Nodes, 1)
+	st.Expect(t, primary.Duration >= 50*time.Millisecond, true)
+
+	st.Expect(t, explanation.Expanders[1].Name, "LoadMoreExpander")
+	st.Expect(t, explanation.Expanders[1].DoesExpand, false)
+
+	failing := explanation.Expanders[2]
+	st.Expect(t, failing.Primary, false)
+	st.Expect(t, failing.Err.Error(), "boom")
+
+	rendered := RenderExpandExplanation(explanation)
+	st.Expect(t, strings.Contains(rendered, "Swagger:  (no match)"), true)
+	st.Expect(t, strings.Contains(rendered, "Expander"), true)
+}
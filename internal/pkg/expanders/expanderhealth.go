@@ -0,0 +1,188 @@
+package expanders
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"github.com/lawrencegripper/azbrowse/internal/pkg/style"
+)
+
+// expanderDisableThreshold is the number of consecutive faults (see isExpanderFault) after which an expander is disabled for the session
+var expanderDisableThreshold = 5
+
+// Errors for failures caused by the expander rather than the requests it made
+var (
+	errExpanderTimedOut = errors.New("Timed out")
+	errExpanderPanicked = errors.New("panicked")
+)
+
+// serverErrorRegex matches the armclient error for a 5xx response
+var serverErrorRegex = regexp.MustCompile(`status code of 5[0-9][0-9]`)
+
+// isExpanderFault checks if the error is a timeout, a panic or a 5xx response. Other errors, such as
+// a 403 for a resource the user can't read, are expected and don't count towards disabling the expander
+func isExpanderFault(err error) bool {
+	return errors.Is(err, errExpanderTimedOut) || errors.Is(err, errExpanderPanicked) || serverErrorRegex.MatchString(err.Error())
+}
+
+// isCoreExpander checks if the expander is needed to browse the tree, these are never disabled.
+// LoadMoreExpander is included so the rest of a paged list can always be loaded
+func isCoreExpander(expander Expander) bool {
+	switch expander.(type) {
+	case *TenantExpander, *SubscriptionExpander, *ResourceGroupResourceExpander, *SwaggerResourceExpander, *LoadMoreExpander:
+		return true
+	}
+	return false
+}
+
+// defaultExpanderTimeout is how long an expander has to complete unless configured with SetExpanderTimeouts
+var defaultExpanderTimeout = 45 * time.Second
+
+// expanderTimeouts holds the configured timeouts by expander name
+var expanderTimeouts = map[string]time.Duration{}
+
+// SetExpanderTimeouts sets the timeouts (in seconds) by expander name.
+// The "default" entry applies to expanders which aren't listed
+func SetExpanderTimeouts(timeouts map[string]int) {
+	for name, seconds := range timeouts {
+		if seconds <= 0 {
+			continue
+		}
+		if name == "default" {
+			defaultExpanderTimeout = time.Duration(seconds) * time.Second
+			continue
+		}
+		expanderTimeouts[name] = time.Duration(seconds) * time.Second
+	}
+}
+
+func getExpanderTimeout(name string) time.Duration {
+	if timeout, ok := expanderTimeouts[name]; ok {
+		return timeout
+	}
+	return defaultExpanderTimeout
+}
+
+// ExpanderHealth records the calls and failures for an expander this session
+type ExpanderHealth struct {
+	Name                string
+	Calls               int
+	Failures            int
+	ConsecutiveFailures int // The number of faults since the last successful call
+	TotalDuration       time.Duration
+	MaxDuration         time.Duration
+	LastError           string
+	Disabled            bool // Set once the expander has faulted expanderDisableThreshold times in a row
+}
+
+// AverageDuration returns the mean time taken by the expander
+func (h ExpanderHealth) AverageDuration() time.Duration {
+	if h.Calls == 0 {
+		return 0
+	}
+	return h.TotalDuration / time.Duration(h.Calls)
+}
+
+var expanderHealthRegistry = struct {
+	sync.Mutex
+	entries map[string]*ExpanderHealth
+}{entries: map[string]*ExpanderHealth{}}
+
+// recordExpanderResult updates the registry with the outcome of a call to an expander.
+// Returns true if the expander has just been disabled
+func recordExpanderResult(name string, duration time.Duration, err error, canDisable bool) bool {
+	expanderHealthRegistry.Lock()
+	defer expanderHealthRegistry.Unlock()
+
+	entry, ok := expanderHealthRegistry.entries[name]
+	if !ok {
+		entry = &ExpanderHealth{Name: name}
+		expanderHealthRegistry.entries[name] = entry
+	}
+	entry.Calls++
+	entry.TotalDuration += duration
+	if duration > entry.MaxDuration {
+		entry.MaxDuration = duration
+	}
+	if err == nil {
+		entry.ConsecutiveFailures = 0
+		return false
+	}
+	entry.Failures++
+	entry.LastError = err.Error()
+	if !isExpanderFault(err) {
+		return false
+	}
+	entry.ConsecutiveFailures++
+	if canDisable && !entry.Disabled && entry.ConsecutiveFailures >= expanderDisableThreshold {
+		entry.Disabled = true
+		return true
+	}
+	return false
+}
+
+func isExpanderDisabled(name string) bool {
+	expanderHealthRegistry.Lock()
+	defer expanderHealthRegistry.Unlock()
+
+	entry, ok := expanderHealthRegistry.entries[name]
+	return ok && entry.Disabled
+}
+
+// GetExpanderHealth returns the health of the expanders which have been called, sorted by name
+func GetExpanderHealth() []ExpanderHealth {
+	expanderHealthRegistry.Lock()
+	defer expanderHealthRegistry.Unlock()
+
+	entries := []ExpanderHealth{}
+	for _, entry := range expanderHealthRegistry.entries {
+		entries = append(entries, *entry)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Name < entries[j].Name
+	})
+	return entries
+}
+
+// RenderExpanderHealth renders the expander health as a plain text table
+func RenderExpanderHealth(entries []ExpanderHealth) string {
+	if len(entries) == 0 {
+		return "No expanders have been called yet"
+	}
+
+	var buf bytes.Buffer
+	w := tabwriter.NewWriter(&buf, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "Expander\tCalls\tFailures\tAvg\tMax\tStatus\tLast error")
+	for _, entry := range entries {
+		status := "OK"
+		if entry.Disabled {
+			status = "Disabled"
+		} else if entry.ConsecutiveFailures > 0 {
+			status = fmt.Sprintf("Failing (%d)", entry.ConsecutiveFailures)
+		}
+		fmt.Fprintf(w, "%s\t%d\t%d\t%s\t%s\t%s\t%s\n",
+			entry.Name,
+			entry.Calls,
+			entry.Failures,
+			entry.AverageDuration().Round(time.Millisecond),
+			entry.MaxDuration.Round(time.Millisecond),
+			status,
+			truncateErrorMessage(entry.LastError, 80))
+	}
+	w.Flush() //nolint: errcheck
+
+	// Highlight after the table is aligned so the escape codes don't affect column widths
+	lines := bytes.Split(buf.Bytes(), []byte("\n"))
+	for i, entry := range entries {
+		if entry.Disabled || entry.ConsecutiveFailures > 0 {
+			lines[i+1] = []byte(style.Warning(string(lines[i+1])))
+		}
+	}
+	return fmt.Sprintf("Expanders are disabled for the session after %d consecutive timeouts, panics or 5xx responses\n\n%s", expanderDisableThreshold, bytes.Join(lines, []byte("\n")))
+}
@@ -0,0 +1,50 @@
+package expanderstest
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+
+	"github.com/lawrencegripper/azbrowse/internal/pkg/views"
+	"github.com/lawrencegripper/azbrowse/pkg/armclient"
+)
+
+// zeroGUID replaces the GUIDs in captured fixtures
+const zeroGUID = "00000000-0000-0000-0000-000000000000"
+
+var guidRegex = regexp.MustCompile(`(?i)[0-9a-f]{8}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{12}`)
+
+// redactFixture hides secrets in the response in the same way as demo mode. Unlike demo mode, IDs, names
+// and locations are kept and GUIDs are replaced with zeroGUID so the fixture can still drive expanders that use them
+func redactFixture(response string) string {
+	response = guidRegex.ReplaceAllString(response, zeroGUID)
+	return views.StripSecrets(response)
+}
+
+// CaptureFixture requests the path from ARM and writes the response to file for use as a Fixture.
+// Secrets are redacted and GUIDs replaced with zeroGUID, see redactFixture
+func CaptureFixture(ctx context.Context, client *armclient.Client, method string, path string, body string, file string) error {
+	response, err := client.DoRequestWithBody(ctx, method, path, body)
+	if err != nil {
+		return fmt.Errorf("Failed requesting %s: %s", path, err)
+	}
+
+	response = redactFixture(response)
+	var indented bytes.Buffer
+	if err := json.Indent(&indented, []byte(response), "", "  "); err == nil {
+		response = indented.String() + "\n"
+	}
+
+	if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+		return fmt.Errorf("Failed creating fixture folder: %s", err)
+	}
+	if err := ioutil.WriteFile(file, []byte(response), 0644); err != nil {
+		return fmt.Errorf("Failed writing fixture: %s", err)
+	}
+	return nil
+}
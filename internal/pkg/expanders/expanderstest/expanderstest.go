@@ -0,0 +1,156 @@
+// Package expanderstest provides helpers for testing expanders from outside the expanders package.
+// Requests made by the expander are served from fixture files, which can be captured from ARM with
+// `go run ./cmd/expander-fixture`
+package expanderstest
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/lawrencegripper/azbrowse/internal/pkg/expanders"
+	"github.com/lawrencegripper/azbrowse/pkg/armclient"
+	"github.com/nbio/st"
+	"gopkg.in/h2non/gock.v1"
+)
+
+// TestServer is the ARM endpoint the fixtures are served from
+const TestServer = "https://management.azure.com"
+
+// Fixture is a fake response to a request the expander makes
+type Fixture struct {
+	Method     string            // The request method, defaults to GET
+	Path       string            // The request path, eg /subscriptions/1/resourceGroups
+	Params     map[string]string // Query parameters the request must have
+	StatusCode int               // The response status code, defaults to 200
+	File       string            // The file containing the response body
+	Body       string            // The response body, used when File isn't set
+}
+
+// TestCase expands Node, serving the requests from Fixtures, and passes the result to Check
+type TestCase struct {
+	Name     string
+	Node     *expanders.TreeNode
+	Fixtures []Fixture
+	Check    func(t *testing.T, r expanders.ExpanderResult)
+}
+
+// NewNode returns a node for the ID with the subscription set from the ID
+func NewNode(id string, itemType string) *expanders.TreeNode {
+	return &expanders.TreeNode{
+		ID:             id,
+		Name:           id[strings.LastIndex(id, "/")+1:],
+		ItemType:       itemType,
+		SubscriptionID: subscriptionFromID(id),
+	}
+}
+
+// NewResourceNode returns a resource node for the ID, as created when listing a resource group
+func NewResourceNode(id string, armType string, apiVersion string) *expanders.TreeNode {
+	node := NewNode(id, expanders.ResourceType)
+	node.ArmType = armType
+	node.ExpandURL = id + "?api-version=" + apiVersion
+	node.DeleteURL = node.ExpandURL
+	return node
+}
+
+// subscriptionFromID returns the subscription GUID from an ID starting /subscriptions/<guid>
+func subscriptionFromID(id string) string {
+	segments := strings.Split(strings.TrimPrefix(id, "/"), "/")
+	if len(segments) > 1 && strings.EqualFold(segments[0], "subscriptions") {
+		return segments[1]
+	}
+	return ""
+}
+
+// RegisterFixtures registers the fixtures with gock. Call gock.Off once the test completes
+func RegisterFixtures(t *testing.T, fixtures ...Fixture) {
+	for _, fixture := range fixtures {
+		body := fixture.Body
+		if fixture.File != "" {
+			data, err := ioutil.ReadFile(fixture.File)
+			if err != nil {
+				t.Error(err)
+				t.FailNow()
+			}
+			body = string(data)
+		}
+		method := fixture.Method
+		if method == "" {
+			method = "GET"
+		}
+		statusCode := fixture.StatusCode
+		if statusCode == 0 {
+			statusCode = 200
+		}
+
+		request := gock.New(TestServer)
+		request.Method = strings.ToUpper(method)
+		request.Path(fixture.Path)
+		for key, value := range fixture.Params {
+			request.MatchParam(key, value)
+		}
+		request.Reply(statusCode).BodyString(body).SetHeader("Content-Type", "application/json")
+	}
+}
+
+// NewClient returns an ARM client whose requests are served by the registered fixtures
+func NewClient() *armclient.Client {
+	httpClient := &http.Client{Transport: &http.Transport{}}
+	gock.InterceptClient(httpClient)
+	return armclient.NewClientFromConfig(httpClient, expanders.DummyTokenFunc(), 5000)
+}
+
+// Run runs the test cases against an expander created by newExpander with a client from NewClient,
+// checking that every fixture is requested
+func Run(t *testing.T, newExpander func(client *armclient.Client) expanders.Expander, testCases []TestCase) {
+	for _, tt := range testCases {
+		expander := newExpander(NewClient())
+		t.Run(expander.Name()+":"+tt.Name, func(t *testing.T) {
+			defer gock.Off()
+			RegisterFixtures(t, tt.Fixtures...)
+
+			result := expander.Expand(context.Background(), tt.Node)
+
+			if tt.Check != nil {
+				tt.Check(t, result)
+			}
+			// Verify that we don't have pending mocks
+			st.Expect(t, gock.IsDone(), true)
+		})
+	}
+}
+
+// ExpectNoError fails the test if the expander returned an error
+func ExpectNoError(t *testing.T, r expanders.ExpanderResult) {
+	t.Helper()
+	if r.Err != nil {
+		t.Fatalf("Expected no error, got: %s", r.Err)
+	}
+}
+
+// ExpectNodeNames fails the test unless the names of the nodes returned are names, in order
+func ExpectNodeNames(t *testing.T, r expanders.ExpanderResult, names ...string) {
+	t.Helper()
+	actual := []string{}
+	for _, node := range r.Nodes {
+		actual = append(actual, node.Name)
+	}
+	if strings.Join(actual, "\n") != strings.Join(names, "\n") {
+		t.Fatalf("Expected nodes %q, got %q", names, actual)
+	}
+}
+
+// FindNode returns the first node with the name, failing the test if there isn't one
+func FindNode(t *testing.T, r expanders.ExpanderResult, name string) *expanders.TreeNode {
+	t.Helper()
+	for _, node := range r.Nodes {
+		if node.Name == name {
+			return node
+		}
+	}
+	t.Fatalf("Node %q not found", name)
+	return nil
+}
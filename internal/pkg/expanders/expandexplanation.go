@@ -0,0 +1,134 @@
+package expanders
+
+import (
+	"bytes"
+	"fmt"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"github.com/lawrencegripper/azbrowse/internal/pkg/style"
+)
+
+// ExpanderRun records how an expander handled an item
+type ExpanderRun struct {
+	Name       string
+	Disabled   bool          // The expander was skipped as it has been disabled for the session
+	DoesExpand bool          // The result of DoesExpand
+	Completed  bool          // False if the expansion was cancelled before the expander completed
+	Duration   time.Duration // How long Expand took
+	Nodes      int           // The number of nodes returned
+	Primary    bool          // The expander provided the content
+	Err        error
+}
+
+// ExpandExplanation records which expanders provided the children and content of an item
+type ExpandExplanation struct {
+	ItemID             string
+	ItemType           string
+	ArmType            string
+	SwaggerTemplateURL string // The template URL of the matched swagger.ResourceType
+	Expanders          []*ExpanderRun
+	DefaultExpander    *ExpanderRun // Set if the DefaultExpander provided the content
+	Cancelled          bool
+}
+
+// expandExplanationMutex guards TreeNode.expandExplanation, which is set by the expansion goroutine
+// and read from the UI
+var expandExplanationMutex sync.RWMutex
+
+// ExpandExplanation returns how the node's children were found the last time it was expanded
+func (node *TreeNode) ExpandExplanation() *ExpandExplanation {
+	expandExplanationMutex.RLock()
+	defer expandExplanationMutex.RUnlock()
+	return node.expandExplanation
+}
+
+// setExpandExplanation records the explanation on the node once the expansion has finished with it
+func (node *TreeNode) setExpandExplanation(explanation *ExpandExplanation) {
+	expandExplanationMutex.Lock()
+	defer expandExplanationMutex.Unlock()
+	node.expandExplanation = explanation
+}
+
+// newExpandExplanation creates an explanation for the item with an entry for each of the expanders
+func newExpandExplanation(currentItem *TreeNode, expanders []Expander) (*ExpandExplanation, map[Expander]*ExpanderRun) {
+	explanation := &ExpandExplanation{
+		ItemID:   currentItem.ID,
+		ItemType: currentItem.ItemType,
+		ArmType:  currentItem.ArmType,
+	}
+	runs := map[Expander]*ExpanderRun{}
+	for _, expander := range expanders {
+		run := &ExpanderRun{Name: expander.Name()}
+		explanation.Expanders = append(explanation.Expanders, run)
+		runs[expander] = run
+	}
+	return explanation, runs
+}
+
+// RenderExpandExplanation renders the explanation as plain text with a table of the expanders
+func RenderExpandExplanation(explanation *ExpandExplanation) string {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "Item:     %s\n", explanation.ItemID)
+	fmt.Fprintf(&buf, "ItemType: %s\n", explanation.ItemType)
+	if explanation.ArmType != "" {
+		fmt.Fprintf(&buf, "ArmType:  %s\n", explanation.ArmType)
+	}
+	swaggerTemplateURL := explanation.SwaggerTemplateURL
+	if swaggerTemplateURL == "" {
+		swaggerTemplateURL = "(no match)"
+	}
+	fmt.Fprintf(&buf, "Swagger:  %s\n", swaggerTemplateURL)
+	if explanation.Cancelled {
+		fmt.Fprintln(&buf, style.Warning("The expansion was cancelled before all the expanders completed"))
+	}
+	fmt.Fprintln(&buf)
+
+	runs := explanation.Expanders
+	if explanation.DefaultExpander != nil {
+		runs = append(runs, explanation.DefaultExpander)
+	}
+
+	var table bytes.Buffer
+	w := tabwriter.NewWriter(&table, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "Expander\tDoesExpand\tDuration\tNodes\tPrimary\tError")
+	for _, run := range runs {
+		doesExpand := fmt.Sprint(run.DoesExpand)
+		duration := ""
+		nodes := ""
+		primary := ""
+		errorMessage := ""
+		switch {
+		case run.Disabled:
+			doesExpand = "disabled"
+		case run.DoesExpand && !run.Completed:
+			duration = "cancelled"
+		case run.DoesExpand:
+			duration = run.Duration.Round(time.Millisecond).String()
+			nodes = fmt.Sprint(run.Nodes)
+		}
+		if run.Primary {
+			primary = "yes"
+		}
+		if run.Err != nil {
+			errorMessage = truncateErrorMessage(run.Err.Error(), 80)
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n", run.Name, doesExpand, duration, nodes, primary, errorMessage)
+	}
+	w.Flush() //nolint: errcheck
+
+	// Highlight after the table is aligned so the escape codes don't affect column widths
+	lines := bytes.Split(table.Bytes(), []byte("\n"))
+	for i, run := range runs {
+		if run.Err != nil {
+			lines[i+1] = []byte(style.Warning(string(lines[i+1])))
+		} else if run.Primary {
+			lines[i+1] = []byte(style.Highlight(string(lines[i+1])))
+		} else if !run.DoesExpand {
+			lines[i+1] = []byte(style.Subtle(string(lines[i+1])))
+		}
+	}
+	buf.Write(bytes.Join(lines, []byte("\n")))
+	return buf.String()
+}
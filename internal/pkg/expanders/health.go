@@ -0,0 +1,464 @@
+package expanders
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"strings"
+	"testing"
+	"text/tabwriter"
+
+	"github.com/lawrencegripper/azbrowse/internal/pkg/style"
+	"github.com/lawrencegripper/azbrowse/pkg/armclient"
+	"github.com/nbio/st"
+	"gopkg.in/h2non/gock.v1"
+)
+
+const resourceHealthAPIVersion = "2020-05-01"
+const serviceHealthAPIVersion = "2018-07-01"
+
+// Item types for the nodes added by the HealthExpander
+const (
+	healthType             = "health"
+	serviceHealthType      = "serviceHealth"
+	serviceHealthEventType = "serviceHealth.event"
+)
+
+// Metadata keys used on health nodes
+const (
+	healthScopeMeta = "HealthScope" // the resource or subscription health is shown for
+)
+
+// availabilityStatus is the Resource Health status of a resource at a point in time
+type availabilityStatus struct {
+	ID         string `json:"id"`
+	Properties struct {
+		AvailabilityState  string `json:"availabilityState"`
+		Title              string `json:"title"`
+		Summary            string `json:"summary"`
+		ReasonType         string `json:"reasonType"`
+		OccuredTime        string `json:"occuredTime"`
+		ReportedTime       string `json:"reportedTime"`
+		ResolutionETA      string `json:"resolutionETA"`
+		RecommendedActions []struct {
+			Action string `json:"action"`
+		} `json:"recommendedActions"`
+	} `json:"properties"`
+}
+
+// resourceID returns the ID of the resource the status applies to
+func (s availabilityStatus) resourceID() string {
+	index := strings.Index(strings.ToLower(s.ID), "/providers/microsoft.resourcehealth/availabilitystatuses/")
+	if index < 0 {
+		return s.ID
+	}
+	return s.ID[:index]
+}
+
+// serviceHealthEvent is a Service Health event (incident, maintenance, advisory...) affecting a subscription
+type serviceHealthEvent struct {
+	ID         string `json:"id"`
+	Name       string `json:"name"`
+	Properties struct {
+		EventType       string `json:"eventType"`
+		Status          string `json:"status"`
+		Title           string `json:"title"`
+		Level           string `json:"level"`
+		ImpactStartTime string `json:"impactStartTime"`
+		LastUpdateTime  string `json:"lastUpdateTime"`
+		IsHIR           bool   `json:"isHIR"`
+		Impact          []struct {
+			ImpactedService string `json:"impactedService"`
+			ImpactedRegions []struct {
+				ImpactedRegion string `json:"impactedRegion"`
+			} `json:"impactedRegions"`
+		} `json:"impact"`
+	} `json:"properties"`
+}
+
+// Check interface
+var _ Expander = &HealthExpander{}
+
+// HealthExpander adds a "Health" node to resources showing Resource Health and a
+// "Service Health" node to subscriptions listing active events
+type HealthExpander struct {
+	ExpanderBase
+	client *armclient.Client
+}
+
+func (e *HealthExpander) setClient(c *armclient.Client) {
+	e.client = c
+}
+
+// Name returns the name of the expander
+func (e *HealthExpander) Name() string {
+	return "HealthExpander"
+}
+
+// DoesExpand checks if this is a resource or one of the health nodes
+func (e *HealthExpander) DoesExpand(ctx context.Context, currentItem *TreeNode) (bool, error) {
+	switch currentItem.ItemType {
+	case ResourceType, healthType, serviceHealthType:
+		return true, nil
+	}
+	return false, nil
+}
+
+// Expand adds the Health node to a resource or shows the health for a health node
+func (e *HealthExpander) Expand(ctx context.Context, currentItem *TreeNode) ExpanderResult {
+	switch currentItem.ItemType {
+	case healthType:
+		return e.expandHealth(ctx, currentItem)
+	case serviceHealthType:
+		return e.expandServiceHealth(ctx, currentItem)
+	}
+
+	return ExpanderResult{
+		Nodes:             []*TreeNode{newHealthNode(currentItem, currentItem.ID)},
+		Response:          ExpanderResponse{Response: ""}, // Swagger expander will supply the response
+		SourceDescription: "HealthExpander request",
+		IsPrimaryResponse: false,
+	}
+}
+
+// newHealthNode creates the "Health" node showing the Resource Health of the resource
+func newHealthNode(parent *TreeNode, resourceID string) *TreeNode {
+	return &TreeNode{
+		Parentid:       parent.ID,
+		Namespace:      "None",
+		Display:        style.Subtle("[Microsoft.ResourceHealth]") + "\n  Health",
+		Name:           "Health",
+		ID:             resourceID + "/<health>",
+		ExpandURL:      ExpandURLNotSupported,
+		ItemType:       healthType,
+		SubscriptionID: parent.SubscriptionID,
+		Metadata: map[string]string{
+			"SuppressSwaggerExpand": "true",
+			"SuppressGenericExpand": "true",
+			healthScopeMeta:         resourceID,
+		},
+	}
+}
+
+// newServiceHealthNode creates the "Service Health" node listing active events for the subscription
+func newServiceHealthNode(parent *TreeNode, subscriptionID string) *TreeNode {
+	return &TreeNode{
+		Parentid:       parent.ID,
+		Namespace:      "None",
+		Display:        style.Subtle("[Microsoft.ResourceHealth]") + "\n  Service Health",
+		Name:           "Service Health",
+		ID:             subscriptionID + "/<servicehealth>",
+		ExpandURL:      ExpandURLNotSupported,
+		ItemType:       serviceHealthType,
+		SubscriptionID: parent.SubscriptionID,
+		Metadata: map[string]string{
+			"SuppressSwaggerExpand": "true",
+			"SuppressGenericExpand": "true",
+			healthScopeMeta:         subscriptionID,
+		},
+	}
+}
+
+func (e *HealthExpander) expandHealth(ctx context.Context, currentItem *TreeNode) ExpanderResult {
+	// The list returns the current status followed by the history
+	data, err := e.client.DoRequest(ctx, "GET", currentItem.Metadata[healthScopeMeta]+"/providers/Microsoft.ResourceHealth/availabilityStatuses?api-version="+resourceHealthAPIVersion)
+	if err != nil {
+		return ExpanderResult{
+			Err:               fmt.Errorf("Failed retrieving resource health: %s", err),
+			Response:          ExpanderResponse{Response: data, ResponseType: ResponseJSON},
+			SourceDescription: "HealthExpander request",
+			IsPrimaryResponse: true,
+		}
+	}
+
+	var statuses struct {
+		Value []availabilityStatus `json:"value"`
+	}
+	err = json.Unmarshal([]byte(data), &statuses)
+	if err != nil {
+		return ExpanderResult{
+			Err:               fmt.Errorf("Error unmarshalling resource health: %s", err),
+			Response:          ExpanderResponse{Response: data, ResponseType: ResponseJSON},
+			SourceDescription: "HealthExpander request",
+			IsPrimaryResponse: true,
+		}
+	}
+
+	return ExpanderResult{
+		Response:          ExpanderResponse{Response: renderAvailabilityStatuses(statuses.Value), ResponseType: ResponsePlainText},
+		SourceDescription: "HealthExpander request",
+		IsPrimaryResponse: true,
+	}
+}
+
+func renderAvailabilityStatuses(statuses []availabilityStatus) string {
+	if len(statuses) == 0 {
+		return "No health information is available for this resource\n"
+	}
+
+	var buf bytes.Buffer
+	current := statuses[0].Properties
+	fmt.Fprintln(&buf, strings.TrimSpace("Current status: "+current.AvailabilityState+" "+DrawStatus("Health/"+current.AvailabilityState)))
+	if current.Title != "" {
+		fmt.Fprintf(&buf, "\n%s\n", current.Title)
+	}
+	if current.Summary != "" {
+		fmt.Fprintf(&buf, "%s\n", current.Summary)
+	}
+	if current.ReasonType != "" {
+		fmt.Fprintf(&buf, "\nReason: %s\n", current.ReasonType)
+	}
+	if current.ResolutionETA != "" {
+		fmt.Fprintf(&buf, "Resolution ETA: %s\n", current.ResolutionETA)
+	}
+	if len(current.RecommendedActions) > 0 {
+		buf.WriteString("\nRecommended actions:\n")
+		for _, action := range current.RecommendedActions {
+			fmt.Fprintf(&buf, "  - %s\n", action.Action)
+		}
+	}
+
+	if len(statuses) > 1 {
+		buf.WriteString("\nHistory:\n\n")
+		table := tabwriter.NewWriter(&buf, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(table, "Occurred\tState\tReason\tSummary")
+		for _, status := range statuses[1:] {
+			fmt.Fprintf(table, "%s\t%s\t%s\t%s\n",
+				status.Properties.OccuredTime,
+				status.Properties.AvailabilityState,
+				status.Properties.ReasonType,
+				status.Properties.Summary)
+		}
+		_ = table.Flush()
+	}
+	return buf.String()
+}
+
+func (e *HealthExpander) expandServiceHealth(ctx context.Context, currentItem *TreeNode) ExpanderResult {
+	subscriptionID := currentItem.Metadata[healthScopeMeta]
+	data, err := e.client.DoRequest(ctx, "GET", subscriptionID+"/providers/Microsoft.ResourceHealth/events?api-version="+serviceHealthAPIVersion+
+		"&$filter="+url.QueryEscape("Properties/Status eq 'Active'"))
+	if err != nil {
+		return ExpanderResult{
+			Err:               fmt.Errorf("Failed retrieving service health events: %s", err),
+			Response:          ExpanderResponse{Response: data, ResponseType: ResponseJSON},
+			SourceDescription: "HealthExpander request",
+			IsPrimaryResponse: true,
+		}
+	}
+
+	var events struct {
+		Value []serviceHealthEvent `json:"value"`
+	}
+	err = json.Unmarshal([]byte(data), &events)
+	if err != nil {
+		return ExpanderResult{
+			Err:               fmt.Errorf("Error unmarshalling service health events: %s", err),
+			Response:          ExpanderResponse{Response: data, ResponseType: ResponseJSON},
+			SourceDescription: "HealthExpander request",
+			IsPrimaryResponse: true,
+		}
+	}
+
+	// Only show events for regions we have resources in. If the regions
+	// can't be found then all the events are shown rather than none.
+	regions, err := e.getRegionsInUse(ctx, currentItem.SubscriptionID)
+	if err != nil {
+		regions = nil
+	}
+
+	newItems := []*TreeNode{}
+	for _, event := range events.Value {
+		if !isServiceHealthEventInRegions(event, regions) {
+			continue
+		}
+
+		display := event.Properties.Title + "\n  " + style.Subtle(event.Properties.EventType)
+		impacted := []string{}
+		for _, impact := range event.Properties.Impact {
+			impacted = append(impacted, impact.ImpactedService)
+		}
+		if len(impacted) > 0 {
+			display += style.Subtle(": " + strings.Join(impacted, ", "))
+		}
+
+		newItems = append(newItems, &TreeNode{
+			Parentid:        currentItem.ID,
+			Namespace:       "None",
+			Name:            event.Properties.Title,
+			Display:         display,
+			ID:              event.ID,
+			ExpandURL:       event.ID + "?api-version=" + serviceHealthAPIVersion,
+			ItemType:        serviceHealthEventType,
+			SubscriptionID:  currentItem.SubscriptionID,
+			StatusIndicator: DrawStatus("Health/Degraded"),
+			Metadata: map[string]string{
+				"SuppressSwaggerExpand": "true",
+			},
+		})
+	}
+
+	return ExpanderResult{
+		Nodes:             newItems,
+		Response:          ExpanderResponse{Response: data, ResponseType: ResponseJSON},
+		SourceDescription: "HealthExpander request",
+		IsPrimaryResponse: true,
+	}
+}
+
+// getRegionsInUse returns the normalised names of the regions resources in the subscription are deployed to
+func (e *HealthExpander) getRegionsInUse(ctx context.Context, subscriptionID string) (map[string]bool, error) {
+	data, err := e.client.DoResourceGraphQuery(ctx, subscriptionID, "distinct location")
+	if err != nil {
+		return nil, err
+	}
+	var query struct {
+		Data struct {
+			Rows [][]interface{} `json:"rows"`
+		} `json:"data"`
+	}
+	err = json.Unmarshal([]byte(data), &query)
+	if err != nil {
+		return nil, err
+	}
+
+	regions := map[string]bool{}
+	for _, row := range query.Data.Rows {
+		if len(row) > 0 {
+			regions[normaliseRegion(fmt.Sprint(row[0]))] = true
+		}
+	}
+	return regions, nil
+}
+
+// normaliseRegion converts region display names ("West Europe") and names ("westeurope") to the same form
+func normaliseRegion(region string) string {
+	return strings.ToLower(strings.Replace(region, " ", "", -1))
+}
+
+// isServiceHealthEventInRegions checks if any of the event's impacted regions are in regions.
+// Global events and events without regions always match, as does a nil set of regions.
+func isServiceHealthEventInRegions(event serviceHealthEvent, regions map[string]bool) bool {
+	if regions == nil {
+		return true
+	}
+	hasRegions := false
+	for _, impact := range event.Properties.Impact {
+		for _, region := range impact.ImpactedRegions {
+			hasRegions = true
+			name := normaliseRegion(region.ImpactedRegion)
+			if name == "global" || regions[name] {
+				return true
+			}
+		}
+	}
+	return !hasRegions
+}
+
+// GetUnhealthyResourceIDs returns the current availability state, keyed by lower cased resource ID,
+// of resources under scope which are Degraded or Unavailable
+func GetUnhealthyResourceIDs(ctx context.Context, client *armclient.Client, scope string) (map[string]string, error) {
+	data, err := client.DoRequest(ctx, "GET", scope+"/providers/Microsoft.ResourceHealth/availabilityStatuses?api-version="+resourceHealthAPIVersion)
+	if err != nil {
+		return nil, fmt.Errorf("Failed retrieving resource health: %s", err)
+	}
+	var statuses struct {
+		Value []availabilityStatus `json:"value"`
+	}
+	err = json.Unmarshal([]byte(data), &statuses)
+	if err != nil {
+		return nil, fmt.Errorf("Error unmarshalling resource health: %s", err)
+	}
+
+	unhealthy := map[string]string{}
+	for _, status := range statuses.Value {
+		state := status.Properties.AvailabilityState
+		if state == "Degraded" || state == "Unavailable" {
+			unhealthy[strings.ToLower(status.resourceID())] = state
+		}
+	}
+	return unhealthy, nil
+}
+
+func (e *HealthExpander) testCases() (bool, *[]expanderTestCase) {
+	const subID = "/subscriptions/00000000-0000-0000-0000-000000000000"
+	const vmID = subID + "/resourceGroups/stable/providers/Microsoft.Compute/virtualMachines/avm"
+	const testFolder = "./testdata/armsamples/health/"
+
+	subNode := &TreeNode{ID: subID, SubscriptionID: "00000000-0000-0000-0000-000000000000"}
+	vmNode := &TreeNode{ID: vmID, ItemType: ResourceType, SubscriptionID: "00000000-0000-0000-0000-000000000000"}
+
+	mockResponse := func(t *testing.T, fileName string) string {
+		data, err := ioutil.ReadFile(testFolder + fileName)
+		if err != nil {
+			t.Error(err)
+			t.FailNow()
+		}
+		return string(data)
+	}
+	noRequestGockConfig := func(t *testing.T) {}
+	healthGockConfig := func(t *testing.T) {
+		gock.New("https://management.azure.com").
+			Get(vmID + "/providers/Microsoft.ResourceHealth/availabilityStatuses").
+			Reply(200).
+			JSON(mockResponse(t, "availabilityStatuses.json"))
+	}
+	serviceHealthGockConfig := func(t *testing.T) {
+		gock.New("https://management.azure.com").
+			Get(subID+"/providers/Microsoft.ResourceHealth/events").
+			MatchParam("$filter", "Properties/Status eq 'Active'").
+			Reply(200).
+			JSON(mockResponse(t, "events.json"))
+		gock.New("https://management.azure.com").
+			Post("/providers/Microsoft.ResourceGraph/resources").
+			Reply(200).
+			JSON(mockResponse(t, "locations.json"))
+	}
+
+	return true, &[]expanderTestCase{
+		{
+			name:              "Resource->HealthNode",
+			nodeToExpand:      vmNode,
+			statusCode:        200,
+			configureGockFunc: &noRequestGockConfig,
+			treeNodeCheckerFunc: func(t *testing.T, r ExpanderResult) {
+				st.Expect(t, r.Err, nil)
+				st.Expect(t, r.IsPrimaryResponse, false)
+				st.Expect(t, len(r.Nodes), 1)
+				st.Expect(t, r.Nodes[0].ItemType, healthType)
+				st.Expect(t, r.Nodes[0].Metadata[healthScopeMeta], vmID)
+			},
+		},
+		{
+			name:              "Health->Statuses",
+			nodeToExpand:      newHealthNode(vmNode, vmID),
+			statusCode:        200,
+			configureGockFunc: &healthGockConfig,
+			treeNodeCheckerFunc: func(t *testing.T, r ExpanderResult) {
+				st.Expect(t, r.Err, nil)
+				st.Expect(t, r.Response.ResponseType, ResponsePlainText)
+				lines := strings.Split(r.Response.Response, "\n")
+				st.Expect(t, lines[0], "Current status: Degraded "+DrawStatus("Health/Degraded"))
+				st.Expect(t, strings.Contains(r.Response.Response, "History:"), true)
+				st.Expect(t, strings.Contains(r.Response.Response, "2020-08-01T09:12:00Z  Unavailable  Unplanned"), true)
+			},
+		},
+		{
+			name:              "ServiceHealth->Events",
+			nodeToExpand:      newServiceHealthNode(subNode, subID),
+			statusCode:        200,
+			configureGockFunc: &serviceHealthGockConfig,
+			treeNodeCheckerFunc: func(t *testing.T, r ExpanderResult) {
+				st.Expect(t, r.Err, nil)
+				// The event only affecting Japan East is filtered out
+				st.Expect(t, len(r.Nodes), 2)
+				st.Expect(t, r.Nodes[0].Name, "Virtual Machines - West Europe - Mitigated")
+				st.Expect(t, r.Nodes[1].Name, "Azure Active Directory - Global")
+				st.Expect(t, r.Nodes[0].ItemType, serviceHealthEventType)
+			},
+		},
+	}
+}
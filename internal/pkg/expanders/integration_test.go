@@ -28,51 +28,55 @@ func Test_Expanders(t *testing.T) {
 		}
 
 		t.Log("Testing expander. Name:" + expander.Name())
+		runExpanderTestCases(t, expander, *testCases)
+	}
+}
 
-		for _, tt := range *testCases {
-			t.Run(expander.Name()+":"+tt.name, func(t *testing.T) {
+// runExpanderTestCases runs the test cases against the expander using a mock ARM server
+func runExpanderTestCases(t *testing.T, expander Expander, testCases []expanderTestCase) {
+	for _, tt := range testCases {
+		t.Run(expander.Name()+":"+tt.name, func(t *testing.T) {
 
-				const testServer = "https://management.azure.com"
-				testPath := tt.urlPath
+			const testServer = "https://management.azure.com"
+			testPath := tt.urlPath
 
-				expectedJSONResponse := "Error No response content supplied to test framework"
-				if tt.responseFile != "" {
-					dat, err := ioutil.ReadFile(tt.responseFile)
-					if err != nil {
-						t.Error(err)
-						t.FailNow()
-					}
-					expectedJSONResponse = string(dat)
+			expectedJSONResponse := "Error No response content supplied to test framework"
+			if tt.responseFile != "" {
+				dat, err := ioutil.ReadFile(tt.responseFile)
+				if err != nil {
+					t.Error(err)
+					t.FailNow()
 				}
+				expectedJSONResponse = string(dat)
+			}
 
-				defer gock.Off()
-				if tt.configureGockFunc != nil {
-					setupGock := *tt.configureGockFunc
-					setupGock(t)
-				} else {
-					gock.New(testServer).
-						Get(testPath).
-						Reply(tt.statusCode).
-						JSON(expectedJSONResponse)
-				}
+			defer gock.Off()
+			if tt.configureGockFunc != nil {
+				setupGock := *tt.configureGockFunc
+				setupGock(t)
+			} else {
+				gock.New(testServer).
+					Get(testPath).
+					Reply(tt.statusCode).
+					JSON(expectedJSONResponse)
+			}
 
-				httpClient := &http.Client{Transport: &http.Transport{}}
-				gock.InterceptClient(httpClient)
+			httpClient := &http.Client{Transport: &http.Transport{}}
+			gock.InterceptClient(httpClient)
 
-				// Set the ARM client to use out test server
-				client := armclient.NewClientFromConfig(httpClient, DummyTokenFunc(), 5000)
-				// set dummy client
-				expander.setClient(client)
+			// Set the ARM client to use out test server
+			client := armclient.NewClientFromConfig(httpClient, DummyTokenFunc(), 5000)
+			// set dummy client
+			expander.setClient(client)
 
-				ctx := context.Background()
+			ctx := context.Background()
 
-				result := expander.Expand(ctx, tt.nodeToExpand)
+			result := expander.Expand(ctx, tt.nodeToExpand)
 
-				tt.treeNodeCheckerFunc(t, result)
+			tt.treeNodeCheckerFunc(t, result)
 
-				// Verify that we don't have pending mocks
-				st.Expect(t, gock.IsDone(), true)
-			})
-		}
+			// Verify that we don't have pending mocks
+			st.Expect(t, gock.IsDone(), true)
+		})
 	}
 }
@@ -0,0 +1,80 @@
+package expanders
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/lawrencegripper/azbrowse/pkg/armclient"
+)
+
+// Check interface
+var _ Expander = &LoadMoreExpander{}
+
+// LoadMoreExpander expands the "more..." node added for a paged result, loading the next page.
+// The list appends the next page in place of the node rather than navigating to it
+type LoadMoreExpander struct {
+	ExpanderBase
+}
+
+func (e *LoadMoreExpander) setClient(c *armclient.Client) {
+	// noop
+}
+
+// Name returns the name of the expander
+func (e *LoadMoreExpander) Name() string {
+	return "LoadMoreExpander"
+}
+
+// DoesExpand checks if this is a "more..." node
+func (e *LoadMoreExpander) DoesExpand(ctx context.Context, currentItem *TreeNode) (bool, error) {
+	return currentItem.ItemType == LoadMoreType && currentItem.loadMore != nil, nil
+}
+
+// Expand returns the next page of nodes followed by a "more..." node if there are further pages
+func (e *LoadMoreExpander) Expand(ctx context.Context, currentItem *TreeNode) ExpanderResult {
+	result := currentItem.loadMore(ctx, currentItem.continuationToken)
+	if result.Err != nil {
+		return ExpanderResult{
+			Err:               fmt.Errorf("Failed loading more items: %s", result.Err),
+			SourceDescription: "LoadMoreExpander request",
+		}
+	}
+
+	// The nodes belong to the expander which created the first page
+	for _, node := range result.Nodes {
+		node.Expander = currentItem.Expander
+	}
+	if result.ContinuationToken != "" && result.LoadMore != nil {
+		result.Nodes = append(result.Nodes, newLoadMoreNode(currentItem.Parentid, currentItem.Expander, result))
+	}
+
+	return ExpanderResult{
+		Response:          result.Response,
+		SourceDescription: "LoadMoreExpander request",
+		Nodes:             result.Nodes,
+		IsPrimaryResponse: true,
+	}
+}
+
+func (e *LoadMoreExpander) testCases() (bool, *[]expanderTestCase) {
+	return false, nil
+}
+
+// newLoadMoreNode creates the "more..." node for a paged result
+func newLoadMoreNode(parentID string, expander Expander, result ExpanderResult) *TreeNode {
+	return &TreeNode{
+		Parentid:          parentID,
+		ID:                parentID + "/<more>",
+		Name:              "more...",
+		Display:           "more...",
+		ItemType:          LoadMoreType,
+		ExpandURL:         ExpandURLNotSupported,
+		Expander:          expander,
+		continuationToken: result.ContinuationToken,
+		loadMore:          result.LoadMore,
+		Metadata: map[string]string{
+			"SuppressSwaggerExpand": "true",
+			"SuppressGenericExpand": "true",
+		},
+	}
+}
@@ -0,0 +1,311 @@
+package expanders
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"github.com/lawrencegripper/azbrowse/internal/pkg/style"
+	"github.com/lawrencegripper/azbrowse/pkg/armclient"
+	"github.com/nbio/st"
+	"gopkg.in/h2non/gock.v1"
+)
+
+const locksAPIVersion = "2016-09-01"
+
+// LocksType identifies the "Locks" node added to subscriptions, resource groups and resources
+const LocksType = "locks"
+
+// LockType identifies a single management lock
+const LockType = "lock"
+
+// Metadata keys used on lock nodes
+const (
+	LockScopeMeta     = "Scope"     // the scope the locks are listed for
+	LockInheritedMeta = "Inherited" // "true" when the lock is applied at a parent scope
+	LockLevelMeta     = "Level"     // CanNotDelete or ReadOnly
+)
+
+// LockLevels lists the levels a management lock can be created with
+var LockLevels = []string{"CanNotDelete", "ReadOnly"}
+
+// Lock is a management lock applied at a scope
+type Lock struct {
+	ID         string `json:"id"`
+	Name       string `json:"name"`
+	Properties struct {
+		Level string `json:"level"`
+		Notes string `json:"notes"`
+	} `json:"properties"`
+}
+
+// Scope returns the scope the lock is applied at
+func (l Lock) Scope() string {
+	index := strings.Index(strings.ToLower(l.ID), "/providers/microsoft.authorization/locks/")
+	if index < 0 {
+		return l.ID
+	}
+	return l.ID[:index]
+}
+
+// Check interface
+var _ Expander = &LocksExpander{}
+
+// LocksExpander adds a "Locks" node to resources and lists the management locks at a scope
+type LocksExpander struct {
+	ExpanderBase
+	client *armclient.Client
+}
+
+func (e *LocksExpander) setClient(c *armclient.Client) {
+	e.client = c
+}
+
+// Name returns the name of the expander
+func (e *LocksExpander) Name() string {
+	return "LocksExpander"
+}
+
+// DoesExpand checks if this is a resource or a Locks node
+func (e *LocksExpander) DoesExpand(ctx context.Context, currentItem *TreeNode) (bool, error) {
+	if currentItem.ItemType == ResourceType || currentItem.ItemType == LocksType {
+		return true, nil
+	}
+	return false, nil
+}
+
+// Expand adds the Locks node to a resource or lists the locks for a Locks node
+func (e *LocksExpander) Expand(ctx context.Context, currentItem *TreeNode) ExpanderResult {
+	if currentItem.ItemType == LocksType {
+		return e.expandLocks(ctx, currentItem)
+	}
+
+	return ExpanderResult{
+		Nodes:             []*TreeNode{newLocksNode(currentItem, currentItem.ID)},
+		Response:          ExpanderResponse{Response: ""}, // Swagger expander will supply the response
+		SourceDescription: "LocksExpander request",
+		IsPrimaryResponse: false,
+	}
+}
+
+// newLocksNode creates the "Locks" node listing management locks at scope
+func newLocksNode(parent *TreeNode, scope string) *TreeNode {
+	return &TreeNode{
+		Parentid:       parent.ID,
+		Namespace:      "None",
+		Display:        style.Subtle("[Microsoft.Authorization]") + "\n  Locks",
+		Name:           "Locks",
+		ID:             scope + "/<locks>",
+		ExpandURL:      ExpandURLNotSupported,
+		ItemType:       LocksType,
+		SubscriptionID: parent.SubscriptionID,
+		Metadata: map[string]string{
+			"SuppressSwaggerExpand": "true",
+			"SuppressGenericExpand": "true",
+			LockScopeMeta:           scope,
+		},
+	}
+}
+
+func (e *LocksExpander) expandLocks(ctx context.Context, currentItem *TreeNode) ExpanderResult {
+	scope := currentItem.Metadata[LockScopeMeta]
+
+	// atScope() returns locks at this scope and the scopes above it, but not those below
+	data, err := e.client.DoRequest(ctx, "GET", scope+"/providers/Microsoft.Authorization/locks?$filter=atScope()&api-version="+locksAPIVersion)
+	if err != nil {
+		return ExpanderResult{
+			Err:               fmt.Errorf("Failed retrieving locks: %s", err),
+			Response:          ExpanderResponse{Response: data, ResponseType: ResponseJSON},
+			SourceDescription: "LocksExpander request",
+			IsPrimaryResponse: true,
+		}
+	}
+
+	var locks struct {
+		Value []Lock `json:"value"`
+	}
+	err = json.Unmarshal([]byte(data), &locks)
+	if err != nil {
+		return ExpanderResult{
+			Err:               fmt.Errorf("Error unmarshalling locks: %s", err),
+			Response:          ExpanderResponse{Response: data, ResponseType: ResponseJSON},
+			SourceDescription: "LocksExpander request",
+			IsPrimaryResponse: true,
+		}
+	}
+
+	newItems := []*TreeNode{}
+	for _, lock := range locks.Value {
+		display := lock.Name + "\n  " + style.Subtle("Level: "+lock.Properties.Level)
+		if lock.Properties.Notes != "" {
+			display += "\n  " + style.Subtle("Notes: "+lock.Properties.Notes)
+		}
+
+		lockURL := lock.ID + "?api-version=" + locksAPIVersion
+		deleteURL := lockURL
+		inherited := !strings.EqualFold(strings.TrimSuffix(lock.Scope(), "/"), strings.TrimSuffix(scope, "/"))
+		if inherited {
+			// Inherited locks have to be removed at the scope they were applied
+			display += "\n  " + style.Subtle("Inherited from: "+lock.Scope())
+			deleteURL = ""
+		}
+
+		newItems = append(newItems, &TreeNode{
+			Parentid:       currentItem.ID,
+			Namespace:      "None",
+			Name:           lock.Name,
+			Display:        display,
+			ID:             lock.ID,
+			ExpandURL:      lockURL,
+			DeleteURL:      deleteURL,
+			ItemType:       LockType,
+			SubscriptionID: currentItem.SubscriptionID,
+			Metadata: map[string]string{
+				"SuppressSwaggerExpand": "true",
+				LockScopeMeta:           lock.Scope(),
+				LockInheritedMeta:       fmt.Sprintf("%t", inherited),
+				LockLevelMeta:           lock.Properties.Level,
+			},
+		})
+	}
+
+	return ExpanderResult{
+		Nodes:             newItems,
+		Response:          ExpanderResponse{Response: data, ResponseType: ResponseJSON},
+		SourceDescription: "LocksExpander request",
+		IsPrimaryResponse: true,
+	}
+}
+
+// CreateLock applies a lock with the given level at scope
+func CreateLock(ctx context.Context, client *armclient.Client, scope string, name string, level string, notes string) error {
+	body, err := json.Marshal(map[string]interface{}{
+		"properties": map[string]string{
+			"level": level,
+			"notes": notes,
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	lockURL := scope + "/providers/Microsoft.Authorization/locks/" + name + "?api-version=" + locksAPIVersion
+	data, err := client.DoRequestWithBody(ctx, "PUT", lockURL, string(body))
+	if err != nil {
+		return fmt.Errorf("Failed creating lock: %s %s", err, data)
+	}
+	return nil
+}
+
+// DeleteLock removes a management lock
+func DeleteLock(ctx context.Context, client *armclient.Client, lockID string) error {
+	data, err := client.DoRequest(ctx, "DELETE", lockID+"?api-version="+locksAPIVersion)
+	if err != nil {
+		return fmt.Errorf("Failed removing lock: %s %s", err, data)
+	}
+	return nil
+}
+
+// GetBlockingLocks returns the locks which will cause deleting the item to fail.
+// Both CanNotDelete and ReadOnly locks block a delete, whether they are applied to the item,
+// inherited from a parent scope or applied to something contained in the item (e.g. a resource in a group).
+// Items which can't be locked return no locks.
+func GetBlockingLocks(ctx context.Context, client *armclient.Client, item *TreeNode) ([]Lock, error) {
+	if item.ItemType != SubscriptionType && item.ItemType != resourceGroupType && item.ItemType != ResourceType {
+		return []Lock{}, nil
+	}
+
+	blocking := []Lock{}
+	seen := map[string]bool{}
+	// The unfiltered list covers the scope and everything below it, atScope() covers the parents
+	for _, filter := range []string{"$filter=atScope()&", ""} {
+		data, err := client.DoRequest(ctx, "GET", item.ID+"/providers/Microsoft.Authorization/locks?"+filter+"api-version="+locksAPIVersion)
+		if err != nil {
+			return nil, fmt.Errorf("Failed retrieving locks: %s", err)
+		}
+
+		var locks struct {
+			Value []Lock `json:"value"`
+		}
+		err = json.Unmarshal([]byte(data), &locks)
+		if err != nil {
+			return nil, fmt.Errorf("Error unmarshalling locks: %s", err)
+		}
+
+		for _, lock := range locks.Value {
+			if seen[strings.ToLower(lock.ID)] {
+				continue
+			}
+			seen[strings.ToLower(lock.ID)] = true
+			blocking = append(blocking, lock)
+		}
+	}
+	return blocking, nil
+}
+
+func (e *LocksExpander) testCases() (bool, *[]expanderTestCase) {
+	const rgID = "/subscriptions/00000000-0000-0000-0000-000000000000/resourceGroups/stable"
+	const locksResponseFile = "./testdata/armsamples/locks/locks.json"
+
+	locksNode := newLocksNode(&TreeNode{ID: rgID, SubscriptionID: "00000000-0000-0000-0000-000000000000"}, rgID)
+	locksGockConfig := func(t *testing.T) {
+		locks, err := ioutil.ReadFile(locksResponseFile)
+		if err != nil {
+			t.Error(err)
+			t.FailNow()
+		}
+		gock.New("https://management.azure.com").
+			Get(rgID+"/providers/Microsoft.Authorization/locks").
+			MatchParam("$filter", `atScope\(\)`).
+			Reply(200).
+			JSON(string(locks))
+	}
+
+	noRequestGockConfig := func(t *testing.T) {}
+
+	return true, &[]expanderTestCase{
+		{
+			name: "Resource->LocksNode",
+			nodeToExpand: &TreeNode{
+				ID:       rgID + "/providers/Microsoft.Storage/storageAccounts/astorageaccount",
+				ItemType: ResourceType,
+			},
+			statusCode:        200,
+			configureGockFunc: &noRequestGockConfig,
+			treeNodeCheckerFunc: func(t *testing.T, r ExpanderResult) {
+				st.Expect(t, r.Err, nil)
+				st.Expect(t, r.IsPrimaryResponse, false)
+				st.Expect(t, len(r.Nodes), 1)
+				st.Expect(t, r.Nodes[0].ItemType, LocksType)
+				st.Expect(t, r.Nodes[0].Metadata[LockScopeMeta], rgID+"/providers/Microsoft.Storage/storageAccounts/astorageaccount")
+			},
+		},
+		{
+			name:              "LocksNode->Locks",
+			nodeToExpand:      locksNode,
+			statusCode:        200,
+			configureGockFunc: &locksGockConfig,
+			treeNodeCheckerFunc: func(t *testing.T, r ExpanderResult) {
+				st.Expect(t, r.Err, nil)
+				st.Expect(t, r.IsPrimaryResponse, true)
+				st.Expect(t, len(r.Nodes), 2)
+
+				// Applied at the RG
+				st.Expect(t, r.Nodes[0].Name, "dontdelete")
+				st.Expect(t, r.Nodes[0].Metadata[LockLevelMeta], "CanNotDelete")
+				st.Expect(t, r.Nodes[0].Metadata[LockInheritedMeta], "false")
+				st.Expect(t, r.Nodes[0].DeleteURL, r.Nodes[0].ID+"?api-version="+locksAPIVersion)
+
+				// Inherited from the subscription
+				st.Expect(t, r.Nodes[1].Name, "readonly")
+				st.Expect(t, r.Nodes[1].Metadata[LockLevelMeta], "ReadOnly")
+				st.Expect(t, r.Nodes[1].Metadata[LockInheritedMeta], "true")
+				st.Expect(t, r.Nodes[1].Metadata[LockScopeMeta], "/subscriptions/00000000-0000-0000-0000-000000000000")
+				st.Expect(t, r.Nodes[1].DeleteURL, "")
+			},
+		},
+	}
+}
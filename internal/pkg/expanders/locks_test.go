@@ -0,0 +1,79 @@
+package expanders
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/lawrencegripper/azbrowse/pkg/armclient"
+	"github.com/nbio/st"
+	"gopkg.in/h2non/gock.v1"
+)
+
+func Test_GetBlockingLocks(t *testing.T) {
+	const rgID = "/subscriptions/00000000-0000-0000-0000-000000000000/resourceGroups/stable"
+	const resourceID = rgID + "/providers/Microsoft.Storage/storageAccounts/astorageaccount"
+	const resourceLock = `{"id": "` + resourceID + `/providers/Microsoft.Authorization/locks/keep", "name": "keep", "properties": {"level": "CanNotDelete"}}`
+	const rgLock = `{"id": "` + rgID + `/providers/Microsoft.Authorization/locks/dontdelete", "name": "dontdelete", "properties": {"level": "CanNotDelete"}}`
+
+	tests := []struct {
+		name          string
+		atScopeLocks  string // locks at the resource and its parents
+		allLocks      string // locks at the resource and below it
+		expectedLocks []string
+		expectedScope string
+	}{
+		{
+			name:          "LockOnResource",
+			atScopeLocks:  `{"value": [` + resourceLock + `]}`,
+			allLocks:      `{"value": [` + resourceLock + `]}`,
+			expectedLocks: []string{"keep"},
+			expectedScope: resourceID,
+		},
+		{
+			name:          "LockOnResourceGroup",
+			atScopeLocks:  `{"value": [` + rgLock + `]}`,
+			allLocks:      `{"value": []}`,
+			expectedLocks: []string{"dontdelete"},
+			expectedScope: rgID,
+		},
+		{
+			name:          "NoLocks",
+			atScopeLocks:  `{"value": []}`,
+			allLocks:      `{"value": []}`,
+			expectedLocks: []string{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			defer gock.Off()
+			gock.New("https://management.azure.com").
+				Get(resourceID+"/providers/Microsoft.Authorization/locks").
+				MatchParam("$filter", `atScope\(\)`).
+				Reply(200).
+				JSON(tt.atScopeLocks)
+			gock.New("https://management.azure.com").
+				Get(resourceID + "/providers/Microsoft.Authorization/locks").
+				Reply(200).
+				JSON(tt.allLocks)
+
+			httpClient := &http.Client{Transport: &http.Transport{}}
+			gock.InterceptClient(httpClient)
+			client := armclient.NewClientFromConfig(httpClient, DummyTokenFunc(), 5000)
+
+			locks, err := GetBlockingLocks(context.Background(), client, &TreeNode{ID: resourceID, ItemType: ResourceType})
+			st.Expect(t, err, nil)
+
+			lockNames := []string{}
+			for _, lock := range locks {
+				lockNames = append(lockNames, lock.Name)
+			}
+			st.Expect(t, lockNames, tt.expectedLocks)
+			if len(locks) > 0 {
+				st.Expect(t, locks[0].Scope(), tt.expectedScope)
+			}
+			st.Expect(t, gock.IsDone(), true)
+		})
+	}
+}
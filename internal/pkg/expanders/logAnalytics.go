@@ -0,0 +1,395 @@
+package expanders
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"strconv"
+	"strings"
+	"testing"
+	"text/tabwriter"
+
+	"github.com/lawrencegripper/azbrowse/internal/pkg/style"
+	"github.com/lawrencegripper/azbrowse/pkg/armclient"
+	"github.com/nbio/st"
+	"gopkg.in/h2non/gock.v1"
+)
+
+const logAnalyticsWorkspaceTemplate = "/subscriptions/{subscriptionId}/resourcegroups/{resourceGroupName}/providers/Microsoft.OperationalInsights/workspaces/{workspaceName}"
+const logAnalyticsNamespace = "logAnalytics"
+
+// LogAnalyticsQueryType identifies a node which runs a KQL query against a workspace or App Insights component
+const LogAnalyticsQueryType = "logAnalytics.query"
+
+const (
+	logAnalyticsWorkspaceQueryAPIVersion = "2017-10-01"
+	appInsightsQueryAPIVersion           = "2018-04-20"
+
+	// maximum characters shown for a single cell when rendering results as a table
+	logAnalyticsMaxCellWidth = 60
+)
+
+// Metadata keys used on LogAnalyticsQueryType nodes
+const (
+	LogAnalyticsQueryMeta         = "Query"         // the KQL query to run
+	LogAnalyticsQueryTimespanMeta = "QueryTimespan" // ISO8601 duration for the query
+	LogAnalyticsQueryResultMeta   = "QueryResult"   // raw JSON from the last successful run
+	logAnalyticsQueryURLMeta      = "QueryURL"
+)
+
+// LogAnalyticsTimespan is a time range which can be selected for a query
+type LogAnalyticsTimespan struct {
+	Value   string // ISO8601 duration passed to the query API
+	Display string
+}
+
+// LogAnalyticsTimespans lists the time ranges offered when running a query
+var LogAnalyticsTimespans = []LogAnalyticsTimespan{
+	{Value: "PT1H", Display: "Last hour"},
+	{Value: "PT4H", Display: "Last 4 hours"},
+	{Value: "PT12H", Display: "Last 12 hours"},
+	{Value: "P1D", Display: "Last 24 hours"},
+	{Value: "P2D", Display: "Last 48 hours"},
+	{Value: "P7D", Display: "Last 7 days"},
+	{Value: "P30D", Display: "Last 30 days"},
+}
+
+const logAnalyticsDefaultTimespan = "P1D"
+
+// GetLogAnalyticsTimespanDisplay returns the display text for a timespan value
+func GetLogAnalyticsTimespanDisplay(value string) string {
+	for _, timespan := range LogAnalyticsTimespans {
+		if timespan.Value == value {
+			return timespan.Display
+		}
+	}
+	return value
+}
+
+// LogAnalyticsQueryResponse is the response from the workspace and App Insights query APIs
+type LogAnalyticsQueryResponse struct {
+	Tables []struct {
+		Name    string `json:"name"`
+		Columns []struct {
+			Name string `json:"name"`
+			Type string `json:"type"`
+		} `json:"columns"`
+		Rows [][]interface{} `json:"rows"`
+	} `json:"tables"`
+}
+
+// Check interface
+var _ Expander = &LogAnalyticsExpander{}
+
+// LogAnalyticsExpander adds a KQL query node to Log Analytics workspaces and runs the queries
+type LogAnalyticsExpander struct {
+	ExpanderBase
+	client *armclient.Client
+}
+
+func (e *LogAnalyticsExpander) setClient(c *armclient.Client) {
+	e.client = c
+}
+
+// Name returns the name of the expander
+func (e *LogAnalyticsExpander) Name() string {
+	return "LogAnalyticsExpander"
+}
+
+// DoesExpand checks if this is a workspace or a query node
+func (e *LogAnalyticsExpander) DoesExpand(ctx context.Context, currentItem *TreeNode) (bool, error) {
+	swaggerResourceType := currentItem.SwaggerResourceType
+	if currentItem.ItemType == ResourceType && swaggerResourceType != nil {
+		if swaggerResourceType.Endpoint.TemplateURL == logAnalyticsWorkspaceTemplate {
+			return true, nil
+		}
+	}
+	if currentItem.ItemType == LogAnalyticsQueryType {
+		return true, nil
+	}
+	return false, nil
+}
+
+// Expand adds the query node to a workspace or runs the query for a query node
+func (e *LogAnalyticsExpander) Expand(ctx context.Context, currentItem *TreeNode) ExpanderResult {
+	if currentItem.ItemType == LogAnalyticsQueryType {
+		return e.expandQuery(ctx, currentItem)
+	}
+
+	return ExpanderResult{
+		Nodes: []*TreeNode{
+			newLogAnalyticsQueryNode(currentItem, currentItem.ID+"/api/query?api-version="+logAnalyticsWorkspaceQueryAPIVersion, "Query (KQL)", ""),
+		},
+		Response:          ExpanderResponse{Response: ""}, // Swagger expander will supply the response
+		SourceDescription: "LogAnalyticsExpander request",
+		IsPrimaryResponse: false,
+	}
+}
+
+// newLogAnalyticsQueryNode creates a node which runs a KQL query against queryURL when expanded
+func newLogAnalyticsQueryNode(parent *TreeNode, queryURL string, name string, query string) *TreeNode {
+	return &TreeNode{
+		Parentid:       parent.ID,
+		ID:             parent.ID + "/<query>",
+		Namespace:      logAnalyticsNamespace,
+		Name:           name,
+		Display:        style.Subtle("[KQL]") + "\n  " + name,
+		ItemType:       LogAnalyticsQueryType,
+		ExpandURL:      ExpandURLNotSupported,
+		SubscriptionID: parent.SubscriptionID,
+		Metadata: map[string]string{
+			"SuppressSwaggerExpand":       "true",
+			"SuppressGenericExpand":       "true",
+			logAnalyticsQueryURLMeta:      queryURL,
+			LogAnalyticsQueryMeta:         query,
+			LogAnalyticsQueryTimespanMeta: logAnalyticsDefaultTimespan,
+		},
+	}
+}
+
+func (e *LogAnalyticsExpander) expandQuery(ctx context.Context, currentItem *TreeNode) ExpanderResult {
+	query := currentItem.Metadata[LogAnalyticsQueryMeta]
+	if strings.TrimSpace(query) == "" {
+		return ExpanderResult{
+			Response: ExpanderResponse{
+				Response: "\n" + style.Title("No query set") +
+					"\n\nUse 'Run KQL query' from the command panel to enter a query and" +
+					"\n'Set KQL query time range' to change the time range (currently: " +
+					GetLogAnalyticsTimespanDisplay(currentItem.Metadata[LogAnalyticsQueryTimespanMeta]) + ").",
+				ResponseType: ResponsePlainText,
+			},
+			SourceDescription: "LogAnalyticsExpander request",
+			IsPrimaryResponse: true,
+		}
+	}
+
+	timespan := currentItem.Metadata[LogAnalyticsQueryTimespanMeta]
+	if timespan == "" {
+		timespan = logAnalyticsDefaultTimespan
+	}
+	body, err := json.Marshal(map[string]string{
+		"query":    query,
+		"timespan": timespan,
+	})
+	if err != nil {
+		return ExpanderResult{
+			Err:               err,
+			SourceDescription: "LogAnalyticsExpander request",
+			IsPrimaryResponse: true,
+		}
+	}
+
+	data, err := e.client.DoRequestWithBody(ctx, "POST", currentItem.Metadata[logAnalyticsQueryURLMeta], string(body))
+	if err != nil {
+		return ExpanderResult{
+			Err:               fmt.Errorf("Failed running query: %s", err),
+			Response:          ExpanderResponse{Response: data, ResponseType: ResponseJSON},
+			SourceDescription: "LogAnalyticsExpander request",
+			IsPrimaryResponse: true,
+		}
+	}
+
+	table, rowCount, err := LogAnalyticsQueryResultToTable(data)
+	if err != nil {
+		return ExpanderResult{
+			Err:               err,
+			Response:          ExpanderResponse{Response: data, ResponseType: ResponseJSON},
+			SourceDescription: "LogAnalyticsExpander request",
+			IsPrimaryResponse: true,
+		}
+	}
+	currentItem.Metadata[LogAnalyticsQueryResultMeta] = data
+
+	header := style.Title(query) + "\n" +
+		style.Subtle(GetLogAnalyticsTimespanDisplay(timespan)+" - "+strconv.Itoa(rowCount)+" rows") + "\n\n"
+
+	return ExpanderResult{
+		Response:          ExpanderResponse{Response: header + table, ResponseType: ResponsePlainText},
+		SourceDescription: "LogAnalyticsExpander request",
+		IsPrimaryResponse: true,
+	}
+}
+
+func parseLogAnalyticsQueryResponse(data string) (LogAnalyticsQueryResponse, error) {
+	var response LogAnalyticsQueryResponse
+	decoder := json.NewDecoder(strings.NewReader(data))
+	decoder.UseNumber() // avoid losing precision on large numbers
+	if err := decoder.Decode(&response); err != nil {
+		return response, fmt.Errorf("Error unmarshalling query response: %s", err)
+	}
+	return response, nil
+}
+
+func formatLogAnalyticsCell(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case json.Number:
+		return v.String()
+	case bool:
+		return strconv.FormatBool(v)
+	default:
+		buf, err := json.Marshal(v)
+		if err != nil {
+			return fmt.Sprint(v)
+		}
+		return string(buf)
+	}
+}
+
+// LogAnalyticsQueryResultToTable renders a query response as text columns, returning the text and the row count
+func LogAnalyticsQueryResultToTable(data string) (string, int, error) {
+	response, err := parseLogAnalyticsQueryResponse(data)
+	if err != nil {
+		return "", 0, err
+	}
+
+	var buf bytes.Buffer
+	rowCount := 0
+	for i, table := range response.Tables {
+		if len(response.Tables) > 1 {
+			if i > 0 {
+				buf.WriteString("\n")
+			}
+			buf.WriteString(style.Title(table.Name) + "\n")
+		}
+
+		columnNames := []string{}
+		for _, column := range table.Columns {
+			columnNames = append(columnNames, column.Name)
+		}
+		var tableBuf bytes.Buffer
+		writer := tabwriter.NewWriter(&tableBuf, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(writer, strings.Join(columnNames, "\t"))
+		for _, row := range table.Rows {
+			cells := []string{}
+			for _, value := range row {
+				cell := strings.Join(strings.Fields(formatLogAnalyticsCell(value)), " ") // keep each row on one line
+				if len(cell) > logAnalyticsMaxCellWidth {
+					cell = cell[:logAnalyticsMaxCellWidth-3] + "..."
+				}
+				cells = append(cells, cell)
+			}
+			fmt.Fprintln(writer, strings.Join(cells, "\t"))
+			rowCount++
+		}
+		if err := writer.Flush(); err != nil {
+			return "", 0, err
+		}
+
+		lines := strings.SplitN(tableBuf.String(), "\n", 2)
+		buf.WriteString(style.Highlight(lines[0]) + "\n")
+		if len(lines) > 1 {
+			buf.WriteString(lines[1])
+		}
+	}
+	return buf.String(), rowCount, nil
+}
+
+// LogAnalyticsCSVTable is a table from a query response converted to CSV
+type LogAnalyticsCSVTable struct {
+	Name string
+	CSV  string
+}
+
+// LogAnalyticsQueryResultToCSV converts each table of a query response to CSV
+func LogAnalyticsQueryResultToCSV(data string) ([]LogAnalyticsCSVTable, error) {
+	response, err := parseLogAnalyticsQueryResponse(data)
+	if err != nil {
+		return nil, err
+	}
+	if len(response.Tables) < 1 {
+		return nil, fmt.Errorf("Query response contains no tables")
+	}
+
+	tables := []LogAnalyticsCSVTable{}
+	for _, table := range response.Tables {
+		var buf bytes.Buffer
+		writer := csv.NewWriter(&buf)
+		columnNames := []string{}
+		for _, column := range table.Columns {
+			columnNames = append(columnNames, column.Name)
+		}
+		if err := writer.Write(columnNames); err != nil {
+			return nil, err
+		}
+		for _, row := range table.Rows {
+			cells := []string{}
+			for _, value := range row {
+				cells = append(cells, formatLogAnalyticsCell(value))
+			}
+			if err := writer.Write(cells); err != nil {
+				return nil, err
+			}
+		}
+		writer.Flush()
+		if err := writer.Error(); err != nil {
+			return nil, err
+		}
+		tables = append(tables, LogAnalyticsCSVTable{Name: table.Name, CSV: buf.String()})
+	}
+	return tables, nil
+}
+
+func (e *LogAnalyticsExpander) testCases() (bool, *[]expanderTestCase) {
+	const workspaceID = "/subscriptions/00000000-0000-0000-0000-000000000000/resourceGroups/stable/providers/Microsoft.OperationalInsights/workspaces/aworkspace"
+	const queryResponseFile = "./testdata/armsamples/logAnalytics/queryResponse.json"
+
+	queryNode := newLogAnalyticsQueryNode(&TreeNode{ID: workspaceID}, workspaceID+"/api/query?api-version="+logAnalyticsWorkspaceQueryAPIVersion, "Query (KQL)", "Heartbeat | take 2")
+	queryGockConfig := func(t *testing.T) {
+		dat, err := ioutil.ReadFile(queryResponseFile)
+		if err != nil {
+			t.Error(err)
+			t.FailNow()
+		}
+		gock.New("https://management.azure.com").
+			Post(workspaceID + "/api/query").
+			MatchType("json").
+			JSON(map[string]string{"query": "Heartbeat | take 2", "timespan": logAnalyticsDefaultTimespan}).
+			Reply(200).
+			JSON(string(dat))
+	}
+
+	emptyQueryNode := newLogAnalyticsQueryNode(&TreeNode{ID: workspaceID}, workspaceID+"/api/query?api-version="+logAnalyticsWorkspaceQueryAPIVersion, "Query (KQL)", "")
+	noRequestGockConfig := func(t *testing.T) {}
+
+	return true, &[]expanderTestCase{
+		{
+			name:              "QueryNode->Table",
+			nodeToExpand:      queryNode,
+			statusCode:        200,
+			configureGockFunc: &queryGockConfig,
+			treeNodeCheckerFunc: func(t *testing.T, r ExpanderResult) {
+				st.Expect(t, r.Err, nil)
+				st.Expect(t, r.IsPrimaryResponse, true)
+				st.Expect(t, r.Response.ResponseType, ResponsePlainText)
+				st.Expect(t, strings.Contains(r.Response.Response, "2 rows"), true)
+				st.Expect(t, strings.Contains(r.Response.Response, "Computer"), true)
+				st.Expect(t, strings.Contains(r.Response.Response, "vm-one"), true)
+				st.Expect(t, queryNode.Metadata[LogAnalyticsQueryResultMeta] != "", true)
+
+				tables, err := LogAnalyticsQueryResultToCSV(queryNode.Metadata[LogAnalyticsQueryResultMeta])
+				st.Expect(t, err, nil)
+				st.Expect(t, len(tables), 1)
+				st.Expect(t, tables[0].Name, "PrimaryResult")
+				st.Expect(t, tables[0].CSV, "TimeGenerated,Computer,Count\n2020-03-01T10:00:00Z,vm-one,12345678901234567890\n2020-03-01T10:01:00Z,\"vm-two, west\",\n")
+			},
+		},
+		{
+			name:              "QueryNode->NoQuery",
+			nodeToExpand:      emptyQueryNode,
+			statusCode:        200,
+			configureGockFunc: &noRequestGockConfig,
+			treeNodeCheckerFunc: func(t *testing.T, r ExpanderResult) {
+				st.Expect(t, r.Err, nil)
+				st.Expect(t, r.IsPrimaryResponse, true)
+				st.Expect(t, strings.Contains(r.Response.Response, "No query set"), true)
+			},
+		},
+	}
+}
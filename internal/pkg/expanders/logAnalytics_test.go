@@ -0,0 +1,21 @@
+package expanders
+
+import (
+	"testing"
+
+	"github.com/nbio/st"
+)
+
+func TestLogAnalyticsQueryResultToCSV_ExportsEveryTable(t *testing.T) {
+	response := `{"tables": [
+		{"name": "PrimaryResult", "columns": [{"name": "Computer", "type": "string"}], "rows": [["vm-one"]]},
+		{"name": "Summary", "columns": [{"name": "Count", "type": "long"}], "rows": [[1]]}
+	]}`
+
+	tables, err := LogAnalyticsQueryResultToCSV(response)
+	st.Expect(t, err, nil)
+	st.Expect(t, tables, []LogAnalyticsCSVTable{
+		{Name: "PrimaryResult", CSV: "Computer\nvm-one\n"},
+		{Name: "Summary", CSV: "Count\n1\n"},
+	})
+}
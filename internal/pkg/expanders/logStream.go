@@ -0,0 +1,170 @@
+package expanders
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/lawrencegripper/azbrowse/pkg/armclient"
+)
+
+// containerInstanceLogPollInterval is how often container instance logs are fetched
+// when tailing as the ARM API doesn't support following the log
+var containerInstanceLogPollInterval = 5 * time.Second
+
+// CanStreamLogs checks if the node is a container instance, AKS pod or App Service log which can be tailed
+func CanStreamLogs(node *TreeNode) bool {
+	if node == nil {
+		return false
+	}
+	return node.ExpandReturnType == "containerInstance.logs" || getPodLogAPISet(node) != nil || node.ItemType == appServiceLogStreamType
+}
+
+func getPodLogAPISet(node *TreeNode) *SwaggerAPISetContainerService {
+	if node.SwaggerResourceType == nil || node.SwaggerResourceType.Endpoint.TemplateURL != podLogTemplateURL {
+		return nil
+	}
+	swaggerAPISet := GetSwaggerResourceExpander().GetAPISet(node.Metadata["SwaggerAPISetID"])
+	if swaggerAPISet == nil {
+		return nil
+	}
+	apiSet, ok := (*swaggerAPISet).(SwaggerAPISetContainerService)
+	if !ok {
+		return nil
+	}
+	return &apiSet
+}
+
+// GetLogStreamContainers returns the containers whose logs can be tailed from the node, the first is the node's own container
+func GetLogStreamContainers(ctx context.Context, client *armclient.Client, node *TreeNode) ([]string, error) {
+	if node.ItemType == appServiceLogStreamType {
+		// Kudu streams the application and HTTP logs separately
+		return []string{"application", "http"}, nil
+	}
+	if apiSet := getPodLogAPISet(node); apiSet != nil {
+		containers, err := apiSet.getPodContainers(ctx, node.ExpandURL)
+		if err != nil {
+			return nil, err
+		}
+		// A container may already have been picked from a multi-container pod
+		if expandURL, err := url.Parse(node.ExpandURL); err == nil {
+			if container := expandURL.Query().Get("container"); container != "" {
+				return moveToFront(containers, container), nil
+			}
+		}
+		return containers, nil
+	}
+
+	containerName := node.Metadata["ContainerName"]
+	data, err := client.DoRequest(ctx, "GET", node.Parentid+"?api-version="+getAPIVersionFromURL(node.ExpandURL))
+	if err != nil {
+		// Other containers are a nice to have, the node's own container can still be tailed
+		return []string{containerName}, nil
+	}
+	var containerGroupResponse ContainerGroupResponse
+	err = json.Unmarshal([]byte(data), &containerGroupResponse)
+	if err != nil {
+		return nil, fmt.Errorf("Error unmarshalling container group: %s", err)
+	}
+	containers := []string{}
+	for _, container := range containerGroupResponse.Properties.Containers {
+		containers = append(containers, container.Name)
+	}
+	return moveToFront(containers, containerName), nil
+}
+
+func moveToFront(values []string, value string) []string {
+	result := []string{value}
+	for _, v := range values {
+		if v != value {
+			result = append(result, v)
+		}
+	}
+	return result
+}
+
+func getAPIVersionFromURL(rawURL string) string {
+	parsedURL, err := url.Parse(rawURL)
+	if err != nil {
+		return ""
+	}
+	return parsedURL.Query().Get("api-version")
+}
+
+// StreamLogs sends new log lines for the container until the context is cancelled or the log ends
+func StreamLogs(ctx context.Context, client *armclient.Client, node *TreeNode, container string, lines chan<- string) error {
+	if apiSet := getPodLogAPISet(node); apiSet != nil {
+		return apiSet.streamPodLogs(ctx, node.ExpandURL, container, lines)
+	}
+	if node.ExpandReturnType == "containerInstance.logs" {
+		return streamContainerInstanceLogs(ctx, client, node, container, lines)
+	}
+	if node.ItemType == appServiceLogStreamType {
+		return streamAppServiceLogs(ctx, client, node, container, lines)
+	}
+	return fmt.Errorf("Logs can't be streamed for %s", node.Name)
+}
+
+func streamContainerInstanceLogs(ctx context.Context, client *armclient.Client, node *TreeNode, container string, lines chan<- string) error {
+	logsURL := node.Parentid + "/containers/" + container + "/logs?tail=400&api-version=" + getAPIVersionFromURL(node.ExpandURL)
+
+	previousLines := []string{}
+	for {
+		data, err := client.DoRequest(ctx, "GET", logsURL)
+		if ctx.Err() != nil {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("Failed to get logs: %s", err)
+		}
+		var containerLogResponse ContainerLogResponse
+		err = json.Unmarshal([]byte(data), &containerLogResponse)
+		if err != nil {
+			return fmt.Errorf("Error unmarshalling logs: %s", err)
+		}
+
+		currentLines := strings.Split(strings.TrimRight(containerLogResponse.Content, "\n"), "\n")
+		if containerLogResponse.Content == "" {
+			currentLines = []string{}
+		}
+		for _, line := range getNewLogLines(previousLines, currentLines) {
+			select {
+			case lines <- line:
+			case <-ctx.Done():
+				return nil
+			}
+		}
+		previousLines = currentLines
+
+		select {
+		case <-time.After(containerInstanceLogPollInterval):
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
+
+// getNewLogLines returns the lines in current which follow on from the end of previous.
+// Polled logs are a window over the end of the log so find where the two overlap.
+func getNewLogLines(previous []string, current []string) []string {
+	overlap := len(previous)
+	if len(current) < overlap {
+		overlap = len(current)
+	}
+	for ; overlap > 0; overlap-- {
+		matches := true
+		for i := 0; i < overlap; i++ {
+			if previous[len(previous)-overlap+i] != current[i] {
+				matches = false
+				break
+			}
+		}
+		if matches {
+			return current[overlap:]
+		}
+	}
+	return current
+}
@@ -0,0 +1,27 @@
+package expanders
+
+import (
+	"testing"
+
+	"github.com/nbio/st"
+)
+
+func TestGetNewLogLines(t *testing.T) {
+	tests := []struct {
+		name     string
+		previous []string
+		current  []string
+		expected []string
+	}{
+		{name: "FirstPoll", previous: []string{}, current: []string{"a", "b"}, expected: []string{"a", "b"}},
+		{name: "NoChange", previous: []string{"a", "b"}, current: []string{"a", "b"}, expected: []string{}},
+		{name: "Appended", previous: []string{"a", "b"}, current: []string{"a", "b", "c"}, expected: []string{"c"}},
+		{name: "WindowMoved", previous: []string{"a", "b", "c"}, current: []string{"b", "c", "d", "e"}, expected: []string{"d", "e"}},
+		{name: "NoOverlap", previous: []string{"a", "b"}, current: []string{"x", "y"}, expected: []string{"x", "y"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			st.Expect(t, getNewLogLines(tt.previous, tt.current), tt.expected)
+		})
+	}
+}
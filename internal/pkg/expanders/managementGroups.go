@@ -0,0 +1,346 @@
+package expanders
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/lawrencegripper/azbrowse/internal/pkg/style"
+	"github.com/lawrencegripper/azbrowse/pkg/armclient"
+	"github.com/nbio/st"
+)
+
+const (
+	// ManagementGroupsRootID is the ID of the "Management Groups" node and the prefix of all management group IDs
+	ManagementGroupsRootID = "/providers/Microsoft.Management/managementGroups"
+
+	managementGroupsAPIVersion            = "2020-02-01"
+	managementGroupDeploymentsAPIVersion  = "2019-10-01"
+	managementGroupsType                  = "managementGroups"
+	managementGroupType                   = "managementGroup"
+	managementGroupChildSubscriptionsType = "/subscriptions"
+)
+
+// ManagementGroupResponse is returned when getting a management group with its children
+type ManagementGroupResponse struct {
+	ID         string `json:"id"`
+	Name       string `json:"name"`
+	Properties struct {
+		TenantID    string                 `json:"tenantId"`
+		DisplayName string                 `json:"displayName"`
+		Children    []ManagementGroupChild `json:"children"`
+	} `json:"properties"`
+}
+
+// ManagementGroupChild is a management group or subscription under a management group
+type ManagementGroupChild struct {
+	ID          string                 `json:"id"`
+	Type        string                 `json:"type"`
+	Name        string                 `json:"name"`
+	DisplayName string                 `json:"displayName"`
+	Children    []ManagementGroupChild `json:"children"`
+}
+
+// ManagementGroupListResponse is returned when listing the management groups in a tenant
+type ManagementGroupListResponse struct {
+	Value []ManagementGroupResponse `json:"value"`
+}
+
+// Check interface
+var _ Expander = &ManagementGroupsExpander{}
+
+// ManagementGroupsExpander expands the management group hierarchy for a tenant
+type ManagementGroupsExpander struct {
+	ExpanderBase
+	client *armclient.Client
+}
+
+func (e *ManagementGroupsExpander) setClient(c *armclient.Client) {
+	e.client = c
+}
+
+// Name returns the name of the expander
+func (e *ManagementGroupsExpander) Name() string {
+	return "ManagementGroupsExpander"
+}
+
+// DoesExpand checks if this is a tenant with management groups enabled or a management group node
+func (e *ManagementGroupsExpander) DoesExpand(ctx context.Context, currentItem *TreeNode) (bool, error) {
+	switch currentItem.ItemType {
+	case TentantItemType:
+		return currentItem.Metadata[ShowManagementGroupsMeta] == "true", nil
+	case managementGroupsType, managementGroupType:
+		return true, nil
+	}
+	return false, nil
+}
+
+// Expand adds the "Management Groups" node to a tenant or expands the management group hierarchy
+func (e *ManagementGroupsExpander) Expand(ctx context.Context, currentItem *TreeNode) ExpanderResult {
+	switch currentItem.ItemType {
+	case managementGroupsType:
+		return e.expandManagementGroups(ctx, currentItem)
+	case managementGroupType:
+		return e.expandManagementGroup(ctx, currentItem)
+	}
+
+	return ExpanderResult{
+		Nodes: []*TreeNode{
+			{
+				Parentid:  currentItem.ID,
+				Namespace: "None",
+				Display:   style.Subtle("[Microsoft.Management]") + "\n  Management Groups",
+				Name:      "Management Groups",
+				ID:        ManagementGroupsRootID,
+				ExpandURL: ManagementGroupsRootID + "?api-version=" + managementGroupsAPIVersion,
+				ItemType:  managementGroupsType,
+				Metadata: map[string]string{
+					"SuppressSwaggerExpand": "true",
+					"SuppressGenericExpand": "true",
+				},
+			},
+		},
+		SourceDescription: "ManagementGroupsExpander request",
+		IsPrimaryResponse: false,
+	}
+}
+
+func (e *ManagementGroupsExpander) expandManagementGroups(ctx context.Context, currentItem *TreeNode) ExpanderResult {
+	data, err := e.client.DoRequest(ctx, "GET", currentItem.ExpandURL)
+	if err != nil {
+		return ExpanderResult{
+			Err:               fmt.Errorf("Failed retrieving management groups: %s", err),
+			Response:          ExpanderResponse{Response: data, ResponseType: ResponseJSON},
+			SourceDescription: "ManagementGroupsExpander request",
+			IsPrimaryResponse: true,
+		}
+	}
+	var groups ManagementGroupListResponse
+	err = json.Unmarshal([]byte(data), &groups)
+	if err != nil {
+		return ExpanderResult{
+			Err:               fmt.Errorf("Error unmarshalling management groups: %s", err),
+			Response:          ExpanderResponse{Response: data, ResponseType: ResponseJSON},
+			SourceDescription: "ManagementGroupsExpander request",
+			IsPrimaryResponse: true,
+		}
+	}
+
+	// All groups sit under the tenant root group so if that is visible start the tree
+	// there, otherwise fall back to listing the groups the user has access to
+	newItems := []*TreeNode{}
+	for _, group := range groups.Value {
+		if strings.EqualFold(group.Name, group.Properties.TenantID) {
+			descendantIDs := []string{}
+			for _, other := range groups.Value {
+				if other.ID != group.ID {
+					descendantIDs = append(descendantIDs, other.ID)
+				}
+			}
+			newItems = append(newItems, newManagementGroupNode(currentItem, group.ID, group.Name, group.Properties.DisplayName, descendantIDs))
+			break
+		}
+	}
+	if len(newItems) == 0 {
+		for _, group := range groups.Value {
+			newItems = append(newItems, newManagementGroupNode(currentItem, group.ID, group.Name, group.Properties.DisplayName, nil))
+		}
+	}
+
+	return ExpanderResult{
+		Nodes:             newItems,
+		Response:          ExpanderResponse{Response: data, ResponseType: ResponseJSON},
+		SourceDescription: "ManagementGroupsExpander request",
+		IsPrimaryResponse: true,
+	}
+}
+
+func (e *ManagementGroupsExpander) expandManagementGroup(ctx context.Context, currentItem *TreeNode) ExpanderResult {
+	data, err := e.client.DoRequest(ctx, "GET", currentItem.ExpandURL)
+	if err != nil {
+		return ExpanderResult{
+			Err:               fmt.Errorf("Failed retrieving management group: %s", err),
+			Response:          ExpanderResponse{Response: data, ResponseType: ResponseJSON},
+			SourceDescription: "ManagementGroupsExpander request",
+			IsPrimaryResponse: true,
+		}
+	}
+	var group ManagementGroupResponse
+	err = json.Unmarshal([]byte(data), &group)
+	if err != nil {
+		return ExpanderResult{
+			Err:               fmt.Errorf("Error unmarshalling management group: %s", err),
+			Response:          ExpanderResponse{Response: data, ResponseType: ResponseJSON},
+			SourceDescription: "ManagementGroupsExpander request",
+			IsPrimaryResponse: true,
+		}
+	}
+
+	newItems := []*TreeNode{
+		{
+			Parentid:  currentItem.ID,
+			Namespace: "None",
+			Display:   style.Subtle("[Microsoft.Resources]") + "\n  Deployments",
+			Name:      "Deployments",
+			ID:        currentItem.ID + "/providers/Microsoft.Resources/deployments",
+			ExpandURL: currentItem.ID + "/providers/Microsoft.Resources/deployments?api-version=" + managementGroupDeploymentsAPIVersion,
+			ItemType:  deploymentsType,
+		},
+		{
+			Parentid:  currentItem.ID,
+			Namespace: "None",
+			Display:   style.Subtle("[Microsoft.Insights]") + "\n  Activity Log",
+			Name:      "Activity Log",
+			ID:        currentItem.ID + "/<activitylog>",
+			ExpandURL: GetManagementGroupActivityLogExpandURL(currentItem.ID),
+			ItemType:  activityLogType,
+		},
+		newRoleAssignmentsNode(currentItem, currentItem.ID),
+		newPolicyNode(currentItem, currentItem.ID),
+	}
+
+	for _, child := range group.Properties.Children {
+		if strings.HasPrefix(strings.ToLower(child.ID), managementGroupChildSubscriptionsType+"/") {
+			newItems = append(newItems, &TreeNode{
+				Parentid:       currentItem.ID,
+				Display:        child.DisplayName,
+				Name:           child.DisplayName,
+				ID:             child.ID,
+				ExpandURL:      child.ID + "/resourceGroups?api-version=2018-05-01",
+				ItemType:       SubscriptionType,
+				SubscriptionID: child.Name,
+			})
+			continue
+		}
+		newItems = append(newItems, newManagementGroupNode(currentItem, child.ID, child.Name, child.DisplayName, getManagementGroupDescendantIDs(child.Children)))
+	}
+
+	return ExpanderResult{
+		Nodes:             newItems,
+		Response:          ExpanderResponse{Response: data, ResponseType: ResponseJSON},
+		SourceDescription: "ManagementGroupsExpander request",
+		IsPrimaryResponse: true,
+	}
+}
+
+// newManagementGroupNode creates a node for a management group, tracking the groups below it so they can be navigated to
+func newManagementGroupNode(parent *TreeNode, id string, name string, displayName string, descendantIDs []string) *TreeNode {
+	if displayName == "" {
+		displayName = name
+	}
+	node := &TreeNode{
+		Parentid:  parent.ID,
+		Namespace: "None",
+		Display:   displayName + "\n  " + style.Subtle("[Management group] "+name),
+		Name:      displayName,
+		ID:        id,
+		ExpandURL: id + "?api-version=" + managementGroupsAPIVersion + "&$expand=children&$recurse=true",
+		ItemType:  managementGroupType,
+		Metadata: map[string]string{
+			"SuppressSwaggerExpand": "true",
+			"SuppressGenericExpand": "true",
+		},
+	}
+	if len(descendantIDs) > 0 {
+		node.Metadata[DescendantIDsMeta] = strings.Join(descendantIDs, ",")
+	}
+	return node
+}
+
+func getManagementGroupDescendantIDs(children []ManagementGroupChild) []string {
+	descendantIDs := []string{}
+	for _, child := range children {
+		if strings.HasPrefix(strings.ToLower(child.ID), managementGroupChildSubscriptionsType+"/") {
+			continue
+		}
+		descendantIDs = append(descendantIDs, child.ID)
+		descendantIDs = append(descendantIDs, getManagementGroupDescendantIDs(child.Children)...)
+	}
+	return descendantIDs
+}
+
+// GetManagementGroupActivityLogExpandURL gets the url which should be used to get activity logs for a management group
+func GetManagementGroupActivityLogExpandURL(managementGroupID string) string {
+	queryString := `eventTimestamp ge '` + time.Now().AddDate(0, 0, -30).Format("2006-01-02T15:04:05Z07:00") + `' and eventTimestamp le '` +
+		time.Now().Format("2006-01-02T15:04:05Z07:00") + `' and levels eq 'Critical,Error,Warning,Informational' | orderby eventTimestamp desc`
+	return managementGroupID + `/providers/microsoft.insights/eventtypes/management/values?api-version=2017-03-01-preview&$filter=` +
+		url.QueryEscape(queryString)
+}
+
+func (e *ManagementGroupsExpander) testCases() (bool, *[]expanderTestCase) {
+	const rootGroupID = ManagementGroupsRootID + "/00000000-0000-0000-0000-000000000001"
+	const platformGroupID = ManagementGroupsRootID + "/platform"
+
+	noRequestGockConfig := func(t *testing.T) {}
+
+	return true, &[]expanderTestCase{
+		{
+			name: "Tenant->ManagementGroupsNode",
+			nodeToExpand: &TreeNode{
+				ItemType:  TentantItemType,
+				ID:        "AvailableSubscriptions",
+				ExpandURL: ExpandURLNotSupported,
+				Metadata: map[string]string{
+					ShowManagementGroupsMeta: "true",
+				},
+			},
+			statusCode:        200,
+			configureGockFunc: &noRequestGockConfig,
+			treeNodeCheckerFunc: func(t *testing.T, r ExpanderResult) {
+				st.Expect(t, r.Err, nil)
+				st.Expect(t, r.IsPrimaryResponse, false)
+				st.Expect(t, len(r.Nodes), 1)
+				st.Expect(t, r.Nodes[0].ID, ManagementGroupsRootID)
+			},
+		},
+		{
+			name: "ManagementGroups->TenantRootGroup",
+			nodeToExpand: &TreeNode{
+				ID:        ManagementGroupsRootID,
+				ExpandURL: ManagementGroupsRootID + "?api-version=" + managementGroupsAPIVersion,
+				ItemType:  managementGroupsType,
+			},
+			urlPath:      ManagementGroupsRootID,
+			responseFile: "./testdata/armsamples/managementGroups/list.json",
+			statusCode:   200,
+			treeNodeCheckerFunc: func(t *testing.T, r ExpanderResult) {
+				st.Expect(t, r.Err, nil)
+				st.Expect(t, len(r.Nodes), 1)
+				st.Expect(t, r.Nodes[0].ID, rootGroupID)
+				st.Expect(t, r.Nodes[0].Name, "Tenant Root Group")
+				st.Expect(t, r.Nodes[0].Metadata[DescendantIDsMeta], platformGroupID+","+ManagementGroupsRootID+"/connectivity")
+			},
+		},
+		{
+			name: "ManagementGroup->Children",
+			nodeToExpand: newManagementGroupNode(&TreeNode{ID: ManagementGroupsRootID}, rootGroupID,
+				"00000000-0000-0000-0000-000000000001", "Tenant Root Group", nil),
+			urlPath:      rootGroupID,
+			responseFile: "./testdata/armsamples/managementGroups/rootGroup.json",
+			statusCode:   200,
+			treeNodeCheckerFunc: func(t *testing.T, r ExpanderResult) {
+				st.Expect(t, r.Err, nil)
+
+				// Deployments, activity log, IAM and policy always added to a management group
+				additionalItemsAddedToGroup := 4
+				st.Expect(t, len(r.Nodes), 2+additionalItemsAddedToGroup)
+
+				st.Expect(t, r.Nodes[0].ExpandURL, rootGroupID+"/providers/Microsoft.Resources/deployments?api-version="+managementGroupDeploymentsAPIVersion)
+
+				group := r.Nodes[additionalItemsAddedToGroup]
+				st.Expect(t, group.ID, platformGroupID)
+				st.Expect(t, group.ItemType, managementGroupType)
+				st.Expect(t, group.Metadata[DescendantIDsMeta], ManagementGroupsRootID+"/connectivity")
+
+				sub := r.Nodes[additionalItemsAddedToGroup+1]
+				st.Expect(t, sub.ItemType, SubscriptionType)
+				st.Expect(t, sub.SubscriptionID, "00000000-0000-0000-0000-000000000000")
+				st.Expect(t, sub.ExpandURL, "/subscriptions/00000000-0000-0000-0000-000000000000/resourceGroups?api-version=2018-05-01")
+			},
+		},
+	}
+}
@@ -2,13 +2,20 @@ package expanders
 
 import (
 	"context"
+	"crypto/sha256"
 	"encoding/json"
+	"fmt"
 	"net/url"
+	"sort"
 	"strings"
+	"testing"
 	"time"
+	"unicode/utf8"
 
 	"github.com/guptarohit/asciigraph"
+	"github.com/lawrencegripper/azbrowse/internal/pkg/storage"
 	"github.com/lawrencegripper/azbrowse/internal/pkg/style"
+	"github.com/nbio/st"
 
 	"github.com/lawrencegripper/azbrowse/pkg/armclient"
 )
@@ -24,6 +31,193 @@ var ItemWidgetHeight int
 // ItemWidgetWidth track width of item widget
 var ItemWidgetWidth int
 
+// MetricsGraphType identifies a metric which is drawn as a graph when expanded
+const MetricsGraphType = "metrics.graph"
+
+// Metadata keys used on metric graph nodes
+const (
+	MetricsResourceIDMeta            = "ResourceID"
+	MetricsNameMeta                  = "MetricName"
+	MetricsNamespaceMeta             = "MetricNamespace"
+	MetricsAggregationMeta           = "AggregationType"
+	MetricsSupportedAggregationsMeta = "SupportedAggregationTypes" // comma separated
+	MetricsDimensionsMeta            = "Dimensions"                // comma separated
+	MetricsGraphSettingsMeta         = "GraphSettings"             // JSON serialised MetricGraphSettings
+)
+
+// MetricsOption is a choice offered when configuring a metric graph
+type MetricsOption struct {
+	Value   string
+	Display string
+}
+
+// MetricsCustomTimeRange is the option used to enter a custom "start/end" time range
+const MetricsCustomTimeRange = "custom"
+
+// MetricsTimeRanges lists the relative time ranges offered for metric graphs
+var MetricsTimeRanges = []MetricsOption{
+	{Value: "PT1H", Display: "Last hour"},
+	{Value: "PT4H", Display: "Last 4 hours"},
+	{Value: "P1D", Display: "Last 24 hours"},
+	{Value: "P7D", Display: "Last 7 days"},
+}
+
+var metricsTimeRangeDurations = map[string]time.Duration{
+	"PT1H": time.Hour,
+	"PT4H": 4 * time.Hour,
+	"P1D":  24 * time.Hour,
+	"P7D":  7 * 24 * time.Hour,
+}
+
+// MetricsAutoInterval picks an interval based on the time range
+const MetricsAutoInterval = "auto"
+
+// MetricsIntervals lists the intervals offered for metric graphs
+var MetricsIntervals = []MetricsOption{
+	{Value: MetricsAutoInterval, Display: "Automatic"},
+	{Value: "PT1M", Display: "1 minute"},
+	{Value: "PT5M", Display: "5 minutes"},
+	{Value: "PT15M", Display: "15 minutes"},
+	{Value: "PT30M", Display: "30 minutes"},
+	{Value: "PT1H", Display: "1 hour"},
+	{Value: "PT6H", Display: "6 hours"},
+	{Value: "PT12H", Display: "12 hours"},
+	{Value: "P1D", Display: "1 day"},
+}
+
+const metricsDefaultTimeRange = "PT4H"
+
+// metricsSeriesColors are limited to the 8 colour palette as gocui runs in OutputNormal mode
+var metricsSeriesColors = []asciigraph.AnsiColor{
+	asciigraph.Navy,
+	asciigraph.Green,
+	asciigraph.Maroon,
+	asciigraph.Olive,
+	asciigraph.Purple,
+	asciigraph.Teal,
+}
+
+// MetricGraphSettings controls the data shown on a metric graph
+type MetricGraphSettings struct {
+	TimeRange   string            `json:"timeRange"`   // a MetricsTimeRanges value or a "start/end" RFC3339 interval
+	Interval    string            `json:"interval"`    // a MetricsIntervals value
+	Aggregation string            `json:"aggregation"` // one of the metric's supported aggregation types
+	Dimensions  map[string]string `json:"dimensions"`  // dimension name -> value to filter on, "*" splits by the dimension
+}
+
+// timespan returns the start and end of the time range
+func (s MetricGraphSettings) timespan(now time.Time) (time.Time, time.Time, error) {
+	if duration, found := metricsTimeRangeDurations[s.TimeRange]; found {
+		return now.Add(-duration), now, nil
+	}
+
+	parts := strings.Split(s.TimeRange, "/")
+	if len(parts) != 2 {
+		return time.Time{}, time.Time{}, fmt.Errorf("Time range %q should be in the form 'start/end'", s.TimeRange)
+	}
+	start, err := time.Parse(time.RFC3339, strings.TrimSpace(parts[0]))
+	if err != nil {
+		return time.Time{}, time.Time{}, fmt.Errorf("Failed to parse start of time range: %s", err)
+	}
+	end, err := time.Parse(time.RFC3339, strings.TrimSpace(parts[1]))
+	if err != nil {
+		return time.Time{}, time.Time{}, fmt.Errorf("Failed to parse end of time range: %s", err)
+	}
+	if !end.After(start) {
+		return time.Time{}, time.Time{}, fmt.Errorf("End of time range must be after the start")
+	}
+	return start.UTC(), end.UTC(), nil
+}
+
+// interval returns the interval to request, choosing one from the time range for MetricsAutoInterval
+func (s MetricGraphSettings) interval(start time.Time, end time.Time) string {
+	if s.Interval != "" && s.Interval != MetricsAutoInterval {
+		return s.Interval
+	}
+	duration := end.Sub(start)
+	switch {
+	case duration <= 4*time.Hour:
+		return "PT1M"
+	case duration <= 24*time.Hour:
+		return "PT5M"
+	case duration <= 7*24*time.Hour:
+		return "PT30M"
+	default:
+		return "PT6H"
+	}
+}
+
+// filter returns the $filter expression for the dimensions
+func (s MetricGraphSettings) filter() string {
+	names := []string{}
+	for name := range s.Dimensions {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	clauses := []string{}
+	for _, name := range names {
+		clauses = append(clauses, name+" eq '"+strings.Replace(s.Dimensions[name], "'", "''", -1)+"'")
+	}
+	return strings.Join(clauses, " and ")
+}
+
+// Description summarises the settings for display
+func (s MetricGraphSettings) Description() string {
+	timeRange := s.TimeRange
+	for _, option := range MetricsTimeRanges {
+		if option.Value == s.TimeRange {
+			timeRange = option.Display
+		}
+	}
+	description := "Time range: '" + timeRange + "' Interval: '" + s.Interval + "'"
+	if filter := s.filter(); filter != "" {
+		description += " Dimensions: '" + filter + "'"
+	}
+	return description
+}
+
+// GetMetricGraphSettings returns the settings for a metric graph node, using
+// those last saved for the metric or the defaults if none are set on the node
+func GetMetricGraphSettings(node *TreeNode) MetricGraphSettings {
+	settings := MetricGraphSettings{
+		TimeRange:   metricsDefaultTimeRange,
+		Interval:    MetricsAutoInterval,
+		Aggregation: node.Metadata[MetricsAggregationMeta],
+		Dimensions:  map[string]string{},
+	}
+
+	settingsJSON := node.Metadata[MetricsGraphSettingsMeta]
+	if settingsJSON == "" {
+		settingsJSON, _ = storage.GetCache(metricGraphSettingsCacheKey(node))
+	}
+	if settingsJSON != "" {
+		_ = json.Unmarshal([]byte(settingsJSON), &settings)
+		if settings.Dimensions == nil {
+			settings.Dimensions = map[string]string{}
+		}
+	}
+	return settings
+}
+
+// SetMetricGraphSettings stores the settings on the node and saves them so
+// they are used the next time the metric is shown
+func SetMetricGraphSettings(node *TreeNode, settings MetricGraphSettings) error {
+	settingsJSON, err := json.Marshal(settings)
+	if err != nil {
+		return err
+	}
+	node.Metadata[MetricsGraphSettingsMeta] = string(settingsJSON)
+	return storage.PutCache(metricGraphSettingsCacheKey(node), string(settingsJSON))
+}
+
+func metricGraphSettingsCacheKey(node *TreeNode) string {
+	// Keys are used as file names so hash the ID to keep them short and free of '/'
+	key := node.Metadata[MetricsResourceIDMeta] + "/" + node.Metadata[MetricsNamespaceMeta] + "/" + node.Metadata[MetricsNameMeta]
+	hash := sha256.Sum256([]byte(strings.ToLower(key)))
+	return fmt.Sprintf("metricgraph%x", hash)
+}
+
 // Check interface
 var _ Expander = &MetricsExpander{}
 
@@ -60,7 +254,7 @@ func (e *MetricsExpander) Expand(ctx context.Context, currentItem *TreeNode) Exp
 	}
 
 	// We have a metric definition lets draw the graph
-	if currentItem.ItemType == "metrics.graph" {
+	if currentItem.ItemType == MetricsGraphType {
 		return e.expandGraph(ctx, currentItem)
 	}
 
@@ -103,7 +297,7 @@ func (e *MetricsExpander) expandMetricNamespace(ctx context.Context, currentItem
 			Metadata: map[string]string{
 				"SuppressSwaggerExpand": "true",
 				"SuppressGenericExpand": "true",
-				"ResourceID":            currentItem.ID,
+				MetricsResourceIDMeta:   currentItem.ID,
 			},
 		})
 	}
@@ -136,25 +330,29 @@ func (e *MetricsExpander) expandMetricDefinition(ctx context.Context, currentIte
 	newItems := []*TreeNode{}
 
 	for _, metric := range metricsListResponse.Value {
+		dimensions := []string{}
+		for _, dimension := range metric.Dimensions {
+			dimensions = append(dimensions, dimension.Value)
+		}
+
 		newItems = append(newItems, &TreeNode{
-			Name:     metric.Name.Value,
-			Display:  metric.Name.Value + "\n  " + style.Subtle("Unit: "+metric.Unit),
-			ID:       currentItem.Metadata["ResourceID"] + "/providers/microsoft.Insights/metrics",
-			Parentid: currentItem.ID,
-			ExpandURL: currentItem.Metadata["ResourceID"] + "/providers/microsoft.Insights/metrics?timespan=" +
-				time.Now().UTC().Add(-4*time.Hour).Format("2006-01-02T15:04:05.000Z") + "/" +
-				time.Now().UTC().Format("2006-01-02T15:04:05.000Z") + "&interval=PT1M&metricnames=" +
-				url.QueryEscape(metric.Name.Value) + "&aggregation=" +
-				url.QueryEscape(metric.PrimaryAggregationType) +
-				"&metricNamespace=" + url.QueryEscape(metric.Namespace) +
-				"&autoadjusttimegrain=true&validatedimensions=false&api-version=2018-01-01",
-			ItemType:       "metrics.graph",
+			Name:           metric.Name.Value,
+			Display:        metric.Name.Value + "\n  " + style.Subtle("Unit: "+metric.Unit),
+			ID:             currentItem.Metadata[MetricsResourceIDMeta] + "/providers/microsoft.Insights/metrics",
+			Parentid:       currentItem.ID,
+			ExpandURL:      ExpandURLNotSupported, // built from the graph settings when expanded
+			ItemType:       MetricsGraphType,
 			SubscriptionID: currentItem.SubscriptionID,
 			Metadata: map[string]string{
-				"SuppressSwaggerExpand": "true",
-				"SuppressGenericExpand": "true",
-				"AggregationType":       strings.ToLower(metric.PrimaryAggregationType),
-				"Units":                 strings.ToLower(metric.Unit),
+				"SuppressSwaggerExpand":          "true",
+				"SuppressGenericExpand":          "true",
+				MetricsResourceIDMeta:            currentItem.Metadata[MetricsResourceIDMeta],
+				MetricsNameMeta:                  metric.Name.Value,
+				MetricsNamespaceMeta:             metric.Namespace,
+				MetricsAggregationMeta:           metric.PrimaryAggregationType,
+				MetricsSupportedAggregationsMeta: strings.Join(metric.SupportedAggregationTypes, ","),
+				MetricsDimensionsMeta:            strings.Join(dimensions, ","),
+				"Units":                          strings.ToLower(metric.Unit),
 			},
 		})
 	}
@@ -167,8 +365,31 @@ func (e *MetricsExpander) expandMetricDefinition(ctx context.Context, currentIte
 	}
 }
 
+// getMetricGraphURL builds the request for the metric values shown on a graph
+func getMetricGraphURL(currentItem *TreeNode, settings MetricGraphSettings, start time.Time, end time.Time) string {
+	metricsURL := currentItem.Metadata[MetricsResourceIDMeta] + "/providers/microsoft.Insights/metrics?timespan=" +
+		start.Format("2006-01-02T15:04:05.000Z") + "/" + end.Format("2006-01-02T15:04:05.000Z") +
+		"&interval=" + settings.interval(start, end) +
+		"&metricnames=" + url.QueryEscape(currentItem.Metadata[MetricsNameMeta]) +
+		"&aggregation=" + url.QueryEscape(settings.Aggregation) +
+		"&metricNamespace=" + url.QueryEscape(currentItem.Metadata[MetricsNamespaceMeta])
+	if filter := settings.filter(); filter != "" {
+		metricsURL += "&$filter=" + url.QueryEscape(filter)
+	}
+	return metricsURL + "&autoadjusttimegrain=true&validatedimensions=false&api-version=2018-01-01"
+}
+
 func (e *MetricsExpander) expandGraph(ctx context.Context, currentItem *TreeNode) ExpanderResult {
-	data, err := e.client.DoRequest(ctx, "GET", currentItem.ExpandURL)
+	settings := GetMetricGraphSettings(currentItem)
+	start, end, err := settings.timespan(time.Now().UTC())
+	if err != nil {
+		return ExpanderResult{
+			Err:               err,
+			SourceDescription: "MetricsExpander graph settings",
+		}
+	}
+
+	data, err := e.client.DoRequest(ctx, "GET", getMetricGraphURL(currentItem, settings, start, end))
 	if err != nil {
 		return ExpanderResult{
 			Err:               err,
@@ -185,41 +406,187 @@ func (e *MetricsExpander) expandGraph(ctx context.Context, currentItem *TreeNode
 		}
 	}
 
+	aggregation := strings.ToLower(settings.Aggregation)
 	caption := style.Title(currentItem.Name) +
-		style.Subtle(" (Aggregate: '"+currentItem.Metadata["AggregationType"]+"' Unit: '"+
-			currentItem.Metadata["Units"]+"')")
-
-	graphData := []float64{}
+		style.Subtle(" (Aggregate: '"+aggregation+"' Unit: '"+currentItem.Metadata["Units"]+"')") + "\n" +
+		style.Subtle(settings.Description())
 
 	// handle empty response
 	if len(metricResponse.Value) < 1 || len(metricResponse.Value[0].Timeseries) < 1 {
 		return ExpanderResult{
-			Err:               err,
+			Err:               fmt.Errorf("No data returned for metric %s", currentItem.Name),
 			SourceDescription: "MetricsExpander graphdata failed to deserialise",
 		}
 	}
 
-	for _, datapoint := range metricResponse.Value[0].Timeseries[0].Data {
-		value, success := datapoint[currentItem.Metadata["AggregationType"]].(float64)
-		if success {
-			graphData = append(graphData, value)
-		} else {
-			graphData = append(graphData, float64(0))
+	graphData := [][]float64{}
+	legends := []string{}
+	firstTimestamp, lastTimestamp := start, end
+	for _, timeseries := range metricResponse.Value[0].Timeseries {
+		if len(graphData) == len(metricsSeriesColors) {
+			break
+		}
+		if len(timeseries.Data) < 1 {
+			continue
+		}
+
+		seriesData := []float64{}
+		for _, datapoint := range timeseries.Data {
+			value, success := datapoint[aggregation].(float64)
+			if success {
+				seriesData = append(seriesData, value)
+			} else {
+				seriesData = append(seriesData, float64(0))
+			}
+		}
+		graphData = append(graphData, seriesData)
+
+		legend := []string{}
+		for _, metadataValue := range timeseries.Metadatavalues {
+			legend = append(legend, metadataValue.Name.Value+"="+metadataValue.Value)
+		}
+		if len(legend) == 0 {
+			legend = append(legend, currentItem.Name)
+		}
+		legends = append(legends, strings.Join(legend, ","))
+
+		if len(graphData) == 1 {
+			if timestamp, err := time.Parse(time.RFC3339, fmt.Sprint(timeseries.Data[0]["timeStamp"])); err == nil {
+				firstTimestamp = timestamp
+			}
+			if timestamp, err := time.Parse(time.RFC3339, fmt.Sprint(timeseries.Data[len(timeseries.Data)-1]["timeStamp"])); err == nil {
+				lastTimestamp = timestamp
+			}
+		}
+	}
+	if len(graphData) < 1 {
+		return ExpanderResult{
+			Err:               fmt.Errorf("No data returned for metric %s", currentItem.Name),
+			SourceDescription: "MetricsExpander build graph",
 		}
 	}
 
-	graph := asciigraph.Plot(graphData,
-		asciigraph.Height(ItemWidgetHeight-6),
-		asciigraph.Width(ItemWidgetWidth-15),
-		asciigraph.Caption("time: 4hrs ago ----> now"))
+	width := ItemWidgetWidth - 15
+	if width <= 0 {
+		// The item view hasn't been sized (eg in tests) so plot a point per column
+		width = len(graphData[0])
+	}
+	height := ItemWidgetHeight - 8
+	options := []asciigraph.Option{
+		asciigraph.Height(height),
+		asciigraph.Width(width),
+		asciigraph.SeriesColors(metricsSeriesColors[:len(graphData)]...),
+	}
+	if len(graphData) > 1 {
+		// The legend takes two lines below the graph
+		options[0] = asciigraph.Height(height - 2)
+		options = append(options, asciigraph.SeriesLegends(legends...))
+	}
+	graph := asciigraph.PlotMany(graphData, options...)
 
 	return ExpanderResult{
-		Response:          ExpanderResponse{Response: "\n\n" + caption + "\n\n" + style.Graph(graph)},
+		Response: ExpanderResponse{Response: "\n\n" + caption + "\n\n" +
+			addMetricGraphTimeAxis(graph, width, firstTimestamp.Local(), lastTimestamp.Local())},
 		IsPrimaryResponse: true,
 		SourceDescription: "MetricsExpander build graph",
 	}
 }
 
+// addMetricGraphTimeAxis adds start, middle and end timestamps below the plotted area of the graph
+func addMetricGraphTimeAxis(graph string, width int, start time.Time, end time.Time) string {
+	lines := strings.Split(graph, "\n")
+	for index, line := range lines {
+		// The y-axis labels pad the plot so find where the axis is drawn
+		axis := strings.IndexAny(line, "┤┼")
+		if axis < 0 {
+			continue
+		}
+		offset := utf8.RuneCountInString(line[:axis]) + 1
+
+		format := "15:04"
+		if end.Sub(start) > 24*time.Hour {
+			format = "01-02 15:04"
+		}
+		labels := []string{start.Format(format), start.Add(end.Sub(start) / 2).Format(format), end.Format(format)}
+		labelWidth := len(labels[0])
+
+		axisLine := []rune(strings.Repeat(" ", offset+width+labelWidth))
+		copy(axisLine[offset:], []rune(labels[0]))
+		if width > 3*(labelWidth+1) {
+			copy(axisLine[offset+width/2-labelWidth/2:], []rune(labels[1]))
+		}
+		if width > 2*(labelWidth+1) {
+			copy(axisLine[offset+width-labelWidth:], []rune(labels[2]))
+		}
+
+		// Find the bottom of the plot which is the last line containing the axis
+		bottom := index
+		for i := index; i < len(lines); i++ {
+			if strings.ContainsAny(lines[i], "┤┼") {
+				bottom = i
+			}
+		}
+		result := append([]string{}, lines[:bottom+1]...)
+		result = append(result, style.Subtle(strings.TrimRight(string(axisLine), " ")))
+		return strings.Join(append(result, lines[bottom+1:]...), "\n")
+	}
+	return graph
+}
+
 func (e *MetricsExpander) testCases() (bool, *[]expanderTestCase) {
-	return false, nil
+	const resourceID = "/subscriptions/00000000-0000-0000-0000-000000000000/resourceGroups/stable/providers/Microsoft.Storage/storageAccounts/astorageaccount"
+
+	graphNode := &TreeNode{
+		Name:     "Transactions",
+		ID:       resourceID + "/providers/microsoft.Insights/metrics",
+		ItemType: MetricsGraphType,
+		Metadata: map[string]string{
+			MetricsResourceIDMeta:            resourceID,
+			MetricsNameMeta:                  "Transactions",
+			MetricsNamespaceMeta:             "Microsoft.Storage/storageAccounts",
+			MetricsAggregationMeta:           "Total",
+			MetricsSupportedAggregationsMeta: "Total,Average",
+			MetricsDimensionsMeta:            "ResponseType,ApiName",
+			MetricsGraphSettingsMeta:         `{"timeRange":"PT1H","interval":"PT5M","aggregation":"Total","dimensions":{"ApiName":"*"}}`,
+		},
+	}
+
+	return true, &[]expanderTestCase{
+		{
+			name: "MetricDefinition->Metrics",
+			nodeToExpand: &TreeNode{
+				ID:        resourceID + "/providers/microsoft.insights/metricdefinitions",
+				ExpandURL: resourceID + "/providers/microsoft.insights/metricdefinitions?metricNamespace=microsoft.storage%2Fstorageaccounts&api-version=2018-01-01",
+				ItemType:  "metrics.metricdefinition",
+				Metadata:  map[string]string{MetricsResourceIDMeta: resourceID},
+			},
+			urlPath:      resourceID + "/providers/microsoft.insights/metricdefinitions",
+			responseFile: "./testdata/armsamples/metrics/metricDefinitions.json",
+			statusCode:   200,
+			treeNodeCheckerFunc: func(t *testing.T, r ExpanderResult) {
+				st.Expect(t, r.Err, nil)
+				st.Expect(t, len(r.Nodes), 1)
+				st.Expect(t, r.Nodes[0].ItemType, MetricsGraphType)
+				st.Expect(t, r.Nodes[0].Metadata[MetricsAggregationMeta], "Total")
+				st.Expect(t, r.Nodes[0].Metadata[MetricsSupportedAggregationsMeta], "Total,Average,Minimum,Maximum")
+				st.Expect(t, r.Nodes[0].Metadata[MetricsDimensionsMeta], "ResponseType,GeoType,ApiName")
+			},
+		},
+		{
+			name:         "Graph->SplitByDimension",
+			nodeToExpand: graphNode,
+			urlPath:      resourceID + "/providers/microsoft.Insights/metrics",
+			responseFile: "./testdata/armsamples/metrics/splitMetrics.json",
+			statusCode:   200,
+			treeNodeCheckerFunc: func(t *testing.T, r ExpanderResult) {
+				st.Expect(t, r.Err, nil)
+				st.Expect(t, r.IsPrimaryResponse, true)
+				st.Expect(t, strings.Contains(r.Response.Response, "Dimensions: 'ApiName eq '*''"), true)
+
+				// A series is plotted for each dimension value with a legend
+				st.Expect(t, strings.Contains(r.Response.Response, "apiname=GetBlob"), true)
+				st.Expect(t, strings.Contains(r.Response.Response, "apiname=PutBlob"), true)
+			},
+		},
+	}
 }
@@ -0,0 +1,641 @@
+package expanders
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strings"
+	"testing"
+	"text/tabwriter"
+
+	"github.com/lawrencegripper/azbrowse/internal/pkg/style"
+	"github.com/lawrencegripper/azbrowse/pkg/armclient"
+	"github.com/lawrencegripper/azbrowse/pkg/endpoints"
+	"github.com/lawrencegripper/azbrowse/pkg/swagger"
+	"github.com/nbio/st"
+	"gopkg.in/h2non/gock.v1"
+)
+
+const networkAPIVersion = "2020-05-01"
+
+const (
+	networkInterfaceTemplateURL     = "/subscriptions/{subscriptionId}/resourceGroups/{resourceGroupName}/providers/Microsoft.Network/networkInterfaces/{networkInterfaceName}"
+	networkSecurityGroupTemplateURL = "/subscriptions/{subscriptionId}/resourceGroups/{resourceGroupName}/providers/Microsoft.Network/networkSecurityGroups/{networkSecurityGroupName}"
+	virtualNetworkTemplateURL       = "/subscriptions/{subscriptionId}/resourceGroups/{resourceGroupName}/providers/Microsoft.Network/virtualNetworks/{virtualNetworkName}"
+	subnetTemplateURL               = "/subscriptions/{subscriptionId}/resourceGroups/{resourceGroupName}/providers/Microsoft.Network/virtualNetworks/{virtualNetworkName}/subnets/{subnetName}"
+)
+
+// Item types for the nodes added to network resources
+const (
+	networkEffectiveRulesType    = "network.effectiveRules"
+	networkEffectiveRoutesType   = "network.effectiveRoutes"
+	networkAttachedInterfaceType = "network.attachedInterfaces"
+	networkInterfaceType         = "network.interface"
+	networkTopologyType          = "network.topology"
+)
+
+// networkScopeMeta holds the ID of the resource the network node was added for
+const networkScopeMeta = "NetworkScope"
+
+type networkInterface struct {
+	ID         string `json:"id"`
+	Name       string `json:"name"`
+	Properties struct {
+		VirtualMachine *struct {
+			ID string `json:"id"`
+		} `json:"virtualMachine"`
+		IPConfigurations []struct {
+			ID         string `json:"id"`
+			Name       string `json:"name"`
+			Properties struct {
+				PrivateIPAddress string `json:"privateIPAddress"`
+				Subnet           struct {
+					ID string `json:"id"`
+				} `json:"subnet"`
+			} `json:"properties"`
+		} `json:"ipConfigurations"`
+	} `json:"properties"`
+}
+
+type networkSubnet struct {
+	ID         string `json:"id"`
+	Name       string `json:"name"`
+	Properties struct {
+		AddressPrefix        string   `json:"addressPrefix"`
+		AddressPrefixes      []string `json:"addressPrefixes"`
+		NetworkSecurityGroup *struct {
+			ID string `json:"id"`
+		} `json:"networkSecurityGroup"`
+		RouteTable *struct {
+			ID string `json:"id"`
+		} `json:"routeTable"`
+		IPConfigurations []struct {
+			ID string `json:"id"`
+		} `json:"ipConfigurations"`
+	} `json:"properties"`
+}
+
+type networkVirtualNetwork struct {
+	ID         string `json:"id"`
+	Name       string `json:"name"`
+	Properties struct {
+		AddressSpace struct {
+			AddressPrefixes []string `json:"addressPrefixes"`
+		} `json:"addressSpace"`
+		Subnets                []networkSubnet `json:"subnets"`
+		VirtualNetworkPeerings []struct {
+			Name       string `json:"name"`
+			Properties struct {
+				PeeringState         string `json:"peeringState"`
+				RemoteVirtualNetwork struct {
+					ID string `json:"id"`
+				} `json:"remoteVirtualNetwork"`
+				RemoteAddressSpace struct {
+					AddressPrefixes []string `json:"addressPrefixes"`
+				} `json:"remoteAddressSpace"`
+			} `json:"properties"`
+		} `json:"virtualNetworkPeerings"`
+	} `json:"properties"`
+}
+
+// Check interface
+var _ Expander = &NetworkExpander{}
+
+// NetworkExpander adds effective security rules, effective routes and a topology view to network resources
+type NetworkExpander struct {
+	ExpanderBase
+	client *armclient.Client
+}
+
+func (e *NetworkExpander) setClient(c *armclient.Client) {
+	e.client = c
+}
+
+// Name returns the name of the expander
+func (e *NetworkExpander) Name() string {
+	return "NetworkExpander"
+}
+
+func getNetworkTemplateURL(currentItem *TreeNode) string {
+	if currentItem.ItemType != ResourceType && currentItem.ItemType != SubResourceType {
+		return ""
+	}
+	if currentItem.SwaggerResourceType == nil {
+		return ""
+	}
+	return currentItem.SwaggerResourceType.Endpoint.TemplateURL
+}
+
+// DoesExpand checks if this is a NIC, NSG, subnet, VNet or one of the nodes added to them
+func (e *NetworkExpander) DoesExpand(ctx context.Context, currentItem *TreeNode) (bool, error) {
+	switch getNetworkTemplateURL(currentItem) {
+	case networkInterfaceTemplateURL, networkSecurityGroupTemplateURL, virtualNetworkTemplateURL, subnetTemplateURL:
+		return true, nil
+	}
+	return strings.HasPrefix(currentItem.ItemType, "network."), nil
+}
+
+// Expand adds the network nodes or shows the effective rules, routes and topology
+func (e *NetworkExpander) Expand(ctx context.Context, currentItem *TreeNode) ExpanderResult {
+	switch currentItem.ItemType {
+	case networkEffectiveRulesType:
+		return e.expandEffectiveRules(ctx, currentItem)
+	case networkEffectiveRoutesType:
+		return e.expandEffectiveRoutes(ctx, currentItem)
+	case networkAttachedInterfaceType:
+		return e.expandAttachedInterfaces(ctx, currentItem)
+	case networkInterfaceType:
+		return e.newNetworkResult(e.newEffectiveNodes(currentItem, currentItem.Metadata[networkScopeMeta]))
+	case networkTopologyType:
+		return e.expandTopology(ctx, currentItem)
+	}
+
+	switch getNetworkTemplateURL(currentItem) {
+	case networkInterfaceTemplateURL:
+		return e.newNetworkResult(e.newEffectiveNodes(currentItem, currentItem.ID))
+	case networkSecurityGroupTemplateURL, subnetTemplateURL:
+		return e.newNetworkResult([]*TreeNode{
+			e.newNetworkNode(currentItem, currentItem.ID, "Effective rules and routes", "effective", networkAttachedInterfaceType),
+		})
+	case virtualNetworkTemplateURL:
+		return e.newNetworkResult([]*TreeNode{
+			e.newNetworkNode(currentItem, currentItem.ID, "Topology", "topology", networkTopologyType),
+		})
+	}
+
+	return ExpanderResult{
+		Err:               fmt.Errorf("Error - unhandled Expand"),
+		Response:          ExpanderResponse{Response: "Error!"},
+		SourceDescription: "NetworkExpander request",
+	}
+}
+
+func (e *NetworkExpander) newNetworkResult(nodes []*TreeNode) ExpanderResult {
+	return ExpanderResult{
+		Nodes:             nodes,
+		Response:          ExpanderResponse{Response: ""}, // Swagger or generic expander will supply the response
+		SourceDescription: "NetworkExpander request",
+		IsPrimaryResponse: false,
+	}
+}
+
+func (e *NetworkExpander) newNetworkNode(parent *TreeNode, scope string, name string, tag string, itemType string) *TreeNode {
+	return &TreeNode{
+		Parentid:       parent.ID,
+		Namespace:      "None",
+		Display:        style.Subtle("[Microsoft.Network]") + "\n  " + name,
+		Name:           name,
+		ID:             parent.ID + "/<" + tag + ">",
+		ExpandURL:      ExpandURLNotSupported,
+		ItemType:       itemType,
+		SubscriptionID: parent.SubscriptionID,
+		Metadata: map[string]string{
+			"SuppressSwaggerExpand": "true",
+			"SuppressGenericExpand": "true",
+			networkScopeMeta:        scope,
+		},
+	}
+}
+
+func (e *NetworkExpander) newEffectiveNodes(parent *TreeNode, nicID string) []*TreeNode {
+	return []*TreeNode{
+		e.newNetworkNode(parent, nicID, "Effective security rules", "effectiverules", networkEffectiveRulesType),
+		e.newNetworkNode(parent, nicID, "Effective routes", "effectiveroutes", networkEffectiveRoutesType),
+	}
+}
+
+func (e *NetworkExpander) errorResult(err error, data string) ExpanderResult {
+	return ExpanderResult{
+		Err:               err,
+		Response:          ExpanderResponse{Response: data, ResponseType: ResponseJSON},
+		SourceDescription: "NetworkExpander request",
+		IsPrimaryResponse: true,
+	}
+}
+
+func (e *NetworkExpander) expandEffectiveRules(ctx context.Context, currentItem *TreeNode) ExpanderResult {
+	// Effective rules are calculated on request so ARM returns them as a long running operation
+	data, err := e.client.DoLongRunningRequestWithBody(ctx, "POST", currentItem.Metadata[networkScopeMeta]+"/effectiveNetworkSecurityGroups?api-version="+networkAPIVersion, "")
+	if err != nil {
+		return e.errorResult(fmt.Errorf("Failed retrieving effective security rules: %s", err), data)
+	}
+	var effective struct {
+		Value []struct {
+			NetworkSecurityGroup struct {
+				ID string `json:"id"`
+			} `json:"networkSecurityGroup"`
+			Association struct {
+				Subnet *struct {
+					ID string `json:"id"`
+				} `json:"subnet"`
+				NetworkInterface *struct {
+					ID string `json:"id"`
+				} `json:"networkInterface"`
+			} `json:"association"`
+			EffectiveSecurityRules []struct {
+				Name                     string `json:"name"`
+				Protocol                 string `json:"protocol"`
+				SourcePortRange          string `json:"sourcePortRange"`
+				DestinationPortRange     string `json:"destinationPortRange"`
+				SourceAddressPrefix      string `json:"sourceAddressPrefix"`
+				DestinationAddressPrefix string `json:"destinationAddressPrefix"`
+				Access                   string `json:"access"`
+				Priority                 int    `json:"priority"`
+				Direction                string `json:"direction"`
+			} `json:"effectiveSecurityRules"`
+		} `json:"value"`
+	}
+	err = json.Unmarshal([]byte(data), &effective)
+	if err != nil {
+		return e.errorResult(fmt.Errorf("Error unmarshalling effective security rules: %s", err), data)
+	}
+
+	var buf bytes.Buffer
+	for _, group := range effective.Value {
+		associatedWith := ""
+		if group.Association.Subnet != nil {
+			associatedWith = "subnet " + lastSegment(group.Association.Subnet.ID)
+		}
+		if group.Association.NetworkInterface != nil {
+			associatedWith = "network interface " + lastSegment(group.Association.NetworkInterface.ID)
+		}
+		fmt.Fprintf(&buf, "NSG: %s (associated with %s)\n\n", lastSegment(group.NetworkSecurityGroup.ID), associatedWith)
+
+		rules := group.EffectiveSecurityRules
+		sort.SliceStable(rules, func(i, j int) bool {
+			if rules[i].Direction != rules[j].Direction {
+				return rules[i].Direction == "Inbound"
+			}
+			return rules[i].Priority < rules[j].Priority
+		})
+
+		table := tabwriter.NewWriter(&buf, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(table, "Direction\tPriority\tName\tAccess\tProtocol\tSource\tDestination")
+		for _, rule := range rules {
+			fmt.Fprintf(table, "%s\t%d\t%s\t%s\t%s\t%s:%s\t%s:%s\n",
+				rule.Direction, rule.Priority, rule.Name, rule.Access, rule.Protocol,
+				rule.SourceAddressPrefix, rule.SourcePortRange, rule.DestinationAddressPrefix, rule.DestinationPortRange)
+		}
+		table.Flush() //nolint: errcheck
+		buf.WriteString("\n")
+	}
+	if len(effective.Value) == 0 {
+		buf.WriteString("No network security groups apply to this network interface\n")
+	}
+
+	return ExpanderResult{
+		Response:          ExpanderResponse{Response: buf.String(), ResponseType: ResponsePlainText},
+		SourceDescription: "NetworkExpander request",
+		IsPrimaryResponse: true,
+	}
+}
+
+func (e *NetworkExpander) expandEffectiveRoutes(ctx context.Context, currentItem *TreeNode) ExpanderResult {
+	// Effective routes are calculated on request so ARM returns them as a long running operation
+	data, err := e.client.DoLongRunningRequestWithBody(ctx, "POST", currentItem.Metadata[networkScopeMeta]+"/effectiveRouteTable?api-version="+networkAPIVersion, "")
+	if err != nil {
+		return e.errorResult(fmt.Errorf("Failed retrieving effective routes: %s", err), data)
+	}
+	var effective struct {
+		Value []struct {
+			Source           string   `json:"source"`
+			State            string   `json:"state"`
+			AddressPrefix    []string `json:"addressPrefix"`
+			NextHopType      string   `json:"nextHopType"`
+			NextHopIPAddress []string `json:"nextHopIpAddress"`
+		} `json:"value"`
+	}
+	err = json.Unmarshal([]byte(data), &effective)
+	if err != nil {
+		return e.errorResult(fmt.Errorf("Error unmarshalling effective routes: %s", err), data)
+	}
+
+	var buf bytes.Buffer
+	table := tabwriter.NewWriter(&buf, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(table, "Source\tState\tAddress prefix\tNext hop type\tNext hop IP")
+	for _, route := range effective.Value {
+		fmt.Fprintf(table, "%s\t%s\t%s\t%s\t%s\n",
+			route.Source, route.State, strings.Join(route.AddressPrefix, ", "), route.NextHopType, strings.Join(route.NextHopIPAddress, ", "))
+	}
+	table.Flush() //nolint: errcheck
+
+	return ExpanderResult{
+		Response:          ExpanderResponse{Response: buf.String(), ResponseType: ResponsePlainText},
+		SourceDescription: "NetworkExpander request",
+		IsPrimaryResponse: true,
+	}
+}
+
+// expandAttachedInterfaces lists the NICs an NSG or subnet applies to as effective rules and routes are per NIC
+func (e *NetworkExpander) expandAttachedInterfaces(ctx context.Context, currentItem *TreeNode) ExpanderResult {
+	scope := currentItem.Metadata[networkScopeMeta]
+	data, err := e.client.DoRequest(ctx, "GET", scope+"?api-version="+networkAPIVersion)
+	if err != nil {
+		return e.errorResult(fmt.Errorf("Failed retrieving network interfaces: %s", err), data)
+	}
+	var resource struct {
+		Properties struct {
+			NetworkInterfaces []struct {
+				ID string `json:"id"`
+			} `json:"networkInterfaces"`
+			Subnets []struct {
+				ID string `json:"id"`
+			} `json:"subnets"`
+			IPConfigurations []struct {
+				ID string `json:"id"`
+			} `json:"ipConfigurations"`
+		} `json:"properties"`
+	}
+	err = json.Unmarshal([]byte(data), &resource)
+	if err != nil {
+		return e.errorResult(fmt.Errorf("Error unmarshalling network interfaces: %s", err), data)
+	}
+
+	nicIDs := []string{}
+	for _, nic := range resource.Properties.NetworkInterfaces {
+		nicIDs = append(nicIDs, nic.ID)
+	}
+	ipConfigurationIDs := []string{}
+	for _, ipConfiguration := range resource.Properties.IPConfigurations {
+		ipConfigurationIDs = append(ipConfigurationIDs, ipConfiguration.ID)
+	}
+	// An NSG applies to the NICs in the subnets it is associated with
+	for _, subnet := range resource.Properties.Subnets {
+		subnetData, err := e.client.DoRequest(ctx, "GET", subnet.ID+"?api-version="+networkAPIVersion)
+		if err != nil {
+			return e.errorResult(fmt.Errorf("Failed retrieving subnet: %s", err), subnetData)
+		}
+		var subnetResource networkSubnet
+		err = json.Unmarshal([]byte(subnetData), &subnetResource)
+		if err != nil {
+			return e.errorResult(fmt.Errorf("Error unmarshalling subnet: %s", err), subnetData)
+		}
+		for _, ipConfiguration := range subnetResource.Properties.IPConfigurations {
+			ipConfigurationIDs = append(ipConfigurationIDs, ipConfiguration.ID)
+		}
+	}
+	for _, ipConfigurationID := range ipConfigurationIDs {
+		// Only NIC ip configurations have effective rules, skip those for gateways, load balancers etc
+		if nicID := getNetworkInterfaceID(ipConfigurationID); nicID != "" {
+			nicIDs = append(nicIDs, nicID)
+		}
+	}
+
+	newItems := []*TreeNode{}
+	seen := map[string]bool{}
+	for _, nicID := range nicIDs {
+		if seen[strings.ToLower(nicID)] {
+			continue
+		}
+		seen[strings.ToLower(nicID)] = true
+		newItems = append(newItems, &TreeNode{
+			Parentid:       currentItem.ID,
+			Namespace:      "None",
+			Display:        lastSegment(nicID) + "\n  " + style.Subtle("Resource group: "+armclient.GetResourceGroupFromResourceID(nicID)),
+			Name:           lastSegment(nicID),
+			ID:             nicID,
+			ExpandURL:      nicID + "?api-version=" + networkAPIVersion,
+			ItemType:       networkInterfaceType,
+			SubscriptionID: currentItem.SubscriptionID,
+			Metadata: map[string]string{
+				"SuppressSwaggerExpand": "true",
+				networkScopeMeta:        nicID,
+			},
+		})
+	}
+
+	if len(newItems) == 0 {
+		message := "No network interfaces use this network security group"
+		if strings.Contains(strings.ToLower(scope), "/subnets/") {
+			message = "No network interfaces are in this subnet"
+		}
+		return ExpanderResult{
+			Response:          ExpanderResponse{Response: message, ResponseType: ResponsePlainText},
+			SourceDescription: "NetworkExpander request",
+			IsPrimaryResponse: true,
+		}
+	}
+	return ExpanderResult{
+		Nodes:             newItems,
+		Response:          ExpanderResponse{Response: data, ResponseType: ResponseJSON},
+		SourceDescription: "NetworkExpander request",
+		IsPrimaryResponse: true,
+	}
+}
+
+// getNetworkInterfaceID returns the NIC ID for a NIC ip configuration ID
+func getNetworkInterfaceID(ipConfigurationID string) string {
+	index := strings.Index(strings.ToLower(ipConfigurationID), "/ipconfigurations/")
+	if index < 0 || !strings.Contains(strings.ToLower(ipConfigurationID), "/microsoft.network/networkinterfaces/") {
+		return ""
+	}
+	return ipConfigurationID[:index]
+}
+
+func (e *NetworkExpander) expandTopology(ctx context.Context, currentItem *TreeNode) ExpanderResult {
+	vnetID := currentItem.Metadata[networkScopeMeta]
+	data, err := e.client.DoRequest(ctx, "GET", vnetID+"?api-version="+networkAPIVersion)
+	if err != nil {
+		return e.errorResult(fmt.Errorf("Failed retrieving virtual network: %s", err), data)
+	}
+	var vnet networkVirtualNetwork
+	err = json.Unmarshal([]byte(data), &vnet)
+	if err != nil {
+		return e.errorResult(fmt.Errorf("Error unmarshalling virtual network: %s", err), data)
+	}
+
+	// Look up the NICs to show their IPs and VMs, each NIC may have ip configurations in several subnets.
+	// A NIC which can't be read (eg in a resource group the user can't access) is shown by its ID
+	nics := map[string]networkInterface{}
+	lookedUp := map[string]bool{}
+	for _, subnet := range vnet.Properties.Subnets {
+		for _, ipConfiguration := range subnet.Properties.IPConfigurations {
+			nicID := getNetworkInterfaceID(ipConfiguration.ID)
+			if nicID == "" || lookedUp[strings.ToLower(nicID)] {
+				continue
+			}
+			lookedUp[strings.ToLower(nicID)] = true
+			nicData, err := e.client.DoRequest(ctx, "GET", nicID+"?api-version="+networkAPIVersion)
+			if err != nil {
+				continue
+			}
+			var nic networkInterface
+			if err := json.Unmarshal([]byte(nicData), &nic); err != nil {
+				continue
+			}
+			nics[strings.ToLower(nicID)] = nic
+		}
+	}
+
+	return ExpanderResult{
+		Response:          ExpanderResponse{Response: renderNetworkTopology(vnet, nics), ResponseType: ResponsePlainText},
+		SourceDescription: "NetworkExpander request",
+		IsPrimaryResponse: true,
+	}
+}
+
+func renderNetworkTopology(vnet networkVirtualNetwork, nics map[string]networkInterface) string {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "VNet: %s [%s]\n", vnet.Name, strings.Join(vnet.Properties.AddressSpace.AddressPrefixes, ", "))
+
+	buf.WriteString("\nSubnets:\n")
+	for _, subnet := range vnet.Properties.Subnets {
+		prefixes := subnet.Properties.AddressPrefixes
+		if subnet.Properties.AddressPrefix != "" {
+			prefixes = append([]string{subnet.Properties.AddressPrefix}, prefixes...)
+		}
+		fmt.Fprintf(&buf, "  %s [%s]\n", subnet.Name, strings.Join(prefixes, ", "))
+		if subnet.Properties.NetworkSecurityGroup != nil {
+			fmt.Fprintf(&buf, "    NSG: %s\n", lastSegment(subnet.Properties.NetworkSecurityGroup.ID))
+		}
+		if subnet.Properties.RouteTable != nil {
+			fmt.Fprintf(&buf, "    Route table: %s\n", lastSegment(subnet.Properties.RouteTable.ID))
+		}
+		for _, ipConfiguration := range subnet.Properties.IPConfigurations {
+			nic, isNIC := nics[strings.ToLower(getNetworkInterfaceID(ipConfiguration.ID))]
+			if !isNIC {
+				// e.g. a gateway or load balancer frontend
+				fmt.Fprintf(&buf, "    - %s\n", ipConfiguration.ID[strings.Index(strings.ToLower(ipConfiguration.ID), "/providers/")+len("/providers/"):])
+				continue
+			}
+			line := "    - NIC " + nic.Name
+			for _, nicIPConfiguration := range nic.Properties.IPConfigurations {
+				if strings.EqualFold(nicIPConfiguration.ID, ipConfiguration.ID) {
+					line += " " + nicIPConfiguration.Properties.PrivateIPAddress
+				}
+			}
+			if nic.Properties.VirtualMachine != nil {
+				line += " (VM " + lastSegment(nic.Properties.VirtualMachine.ID) + ")"
+			}
+			buf.WriteString(line + "\n")
+		}
+	}
+
+	buf.WriteString("\nPeerings:\n")
+	if len(vnet.Properties.VirtualNetworkPeerings) == 0 {
+		buf.WriteString("  None\n")
+	}
+	for _, peering := range vnet.Properties.VirtualNetworkPeerings {
+		fmt.Fprintf(&buf, "  %s -> %s [%s] (%s)\n",
+			peering.Name,
+			lastSegment(peering.Properties.RemoteVirtualNetwork.ID),
+			strings.Join(peering.Properties.RemoteAddressSpace.AddressPrefixes, ", "),
+			peering.Properties.PeeringState)
+	}
+	return buf.String()
+}
+
+func (e *NetworkExpander) testCases() (bool, *[]expanderTestCase) {
+	const rgID = "/subscriptions/00000000-0000-0000-0000-000000000000/resourceGroups/stable"
+	const nicID = rgID + "/providers/Microsoft.Network/networkInterfaces/avm-nic"
+	const vnetID = rgID + "/providers/Microsoft.Network/virtualNetworks/avnet"
+	const testFolder = "./testdata/armsamples/network/"
+
+	nicNode := &TreeNode{
+		ID:                  nicID,
+		ItemType:            ResourceType,
+		SwaggerResourceType: &swagger.ResourceType{Endpoint: endpoints.MustGetEndpointInfoFromURL(networkInterfaceTemplateURL, networkAPIVersion)},
+	}
+	vnetNode := &TreeNode{ID: vnetID}
+
+	mockResponse := func(t *testing.T, fileName string) string {
+		data, err := ioutil.ReadFile(testFolder + fileName)
+		if err != nil {
+			t.Error(err)
+			t.FailNow()
+		}
+		return string(data)
+	}
+	noRequestGockConfig := func(t *testing.T) {}
+	effectiveRulesGockConfig := func(t *testing.T) {
+		gock.New("https://management.azure.com").
+			Post(nicID + "/effectiveNetworkSecurityGroups").
+			Reply(200).
+			JSON(mockResponse(t, "effectiveNetworkSecurityGroups.json"))
+	}
+	topologyGockConfig := func(t *testing.T) {
+		gock.New("https://management.azure.com").
+			Get(vnetID).
+			Reply(200).
+			JSON(mockResponse(t, "virtualNetwork.json"))
+		gock.New("https://management.azure.com").
+			Get(nicID).
+			Reply(200).
+			JSON(mockResponse(t, "networkInterface.json"))
+	}
+	topologyNICForbiddenGockConfig := func(t *testing.T) {
+		gock.New("https://management.azure.com").
+			Get(vnetID).
+			Reply(200).
+			JSON(mockResponse(t, "virtualNetwork.json"))
+		gock.New("https://management.azure.com").
+			Get(nicID).
+			Reply(403).
+			JSON(`{"error": {"code": "AuthorizationFailed"}}`)
+	}
+
+	return true, &[]expanderTestCase{
+		{
+			name:              "NetworkInterface->Nodes",
+			nodeToExpand:      nicNode,
+			statusCode:        200,
+			configureGockFunc: &noRequestGockConfig,
+			treeNodeCheckerFunc: func(t *testing.T, r ExpanderResult) {
+				st.Expect(t, r.Err, nil)
+				st.Expect(t, r.IsPrimaryResponse, false)
+				st.Expect(t, len(r.Nodes), 2)
+				st.Expect(t, r.Nodes[0].ItemType, networkEffectiveRulesType)
+				st.Expect(t, r.Nodes[0].Metadata[networkScopeMeta], nicID)
+				st.Expect(t, r.Nodes[1].ItemType, networkEffectiveRoutesType)
+			},
+		},
+		{
+			name:              "EffectiveRules->Rules",
+			nodeToExpand:      e.newEffectiveNodes(nicNode, nicID)[0],
+			statusCode:        200,
+			configureGockFunc: &effectiveRulesGockConfig,
+			treeNodeCheckerFunc: func(t *testing.T, r ExpanderResult) {
+				st.Expect(t, r.Err, nil)
+				st.Expect(t, r.IsPrimaryResponse, true)
+				lines := strings.Split(r.Response.Response, "\n")
+				st.Expect(t, lines[0], "NSG: avm-nsg (associated with network interface avm-nic)")
+				// Inbound rules come first, ordered by priority
+				st.Expect(t, strings.Fields(lines[3])[:4], []string{"Inbound", "300", "securityRules/SSH", "Allow"})
+				st.Expect(t, strings.Fields(lines[4])[:4], []string{"Inbound", "65000", "defaultSecurityRules/AllowVnetInBound", "Allow"})
+				st.Expect(t, strings.Fields(lines[5])[:4], []string{"Outbound", "65000", "defaultSecurityRules/AllowVnetOutBound", "Allow"})
+			},
+		},
+		{
+			name:              "Topology->Text",
+			nodeToExpand:      e.newNetworkNode(vnetNode, vnetID, "Topology", "topology", networkTopologyType),
+			statusCode:        200,
+			configureGockFunc: &topologyGockConfig,
+			treeNodeCheckerFunc: func(t *testing.T, r ExpanderResult) {
+				st.Expect(t, r.Err, nil)
+				st.Expect(t, r.Response.Response, "VNet: avnet [10.0.0.0/16]\n"+
+					"\nSubnets:\n"+
+					"  default [10.0.0.0/24]\n"+
+					"    NSG: avm-nsg\n"+
+					"    - NIC avm-nic 10.0.0.4 (VM avm)\n"+
+					"  empty [10.0.1.0/24]\n"+
+					"\nPeerings:\n"+
+					"  to-hub -> hub [10.1.0.0/16] (Connected)\n")
+			},
+		},
+		{
+			name:              "Topology->NICForbidden",
+			nodeToExpand:      e.newNetworkNode(vnetNode, vnetID, "Topology", "topology", networkTopologyType),
+			statusCode:        200,
+			configureGockFunc: &topologyNICForbiddenGockConfig,
+			treeNodeCheckerFunc: func(t *testing.T, r ExpanderResult) {
+				st.Expect(t, r.Err, nil)
+				// The NIC which couldn't be read is shown by its ID rather than failing the topology
+				st.Expect(t, strings.Contains(r.Response.Response, "    - Microsoft.Network/networkInterfaces/avm-nic/ipConfigurations/"), true)
+				st.Expect(t, strings.Contains(r.Response.Response, "to-hub -> hub"), true)
+			},
+		},
+	}
+}
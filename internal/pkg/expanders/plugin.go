@@ -0,0 +1,435 @@
+package expanders
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/lawrencegripper/azbrowse/internal/pkg/config"
+	"github.com/lawrencegripper/azbrowse/internal/pkg/eventing"
+	"github.com/lawrencegripper/azbrowse/pkg/armclient"
+)
+
+// Plugins are started as subprocesses and speak JSON-RPC 2.0 over stdio, one message per line.
+// The methods mirror the Expander interface: "name", "doesExpand", "expand" and "delete".
+// See docs/plugins.md for the protocol.
+
+// Timeouts for plugin calls. A plugin which doesn't respond in time is stopped so it can't hold up the UI
+var (
+	pluginStartTimeout      = 5 * time.Second
+	pluginDoesExpandTimeout = 2 * time.Second
+	pluginExpandTimeout     = 30 * time.Second
+	pluginDeleteTimeout     = 30 * time.Second
+	// pluginRestartInterval is how long to wait before restarting a plugin which crashed or timed out
+	pluginRestartInterval = 30 * time.Second
+)
+
+// maxPluginMessageSize limits the size of a single message from a plugin
+const maxPluginMessageSize = 64 * 1024 * 1024
+
+var errPluginUnavailable = errors.New("Plugin unavailable after failing, it will be restarted shortly")
+
+type pluginRequest struct {
+	JSONRPC string      `json:"jsonrpc"`
+	ID      int         `json:"id"`
+	Method  string      `json:"method"`
+	Params  interface{} `json:"params,omitempty"`
+}
+
+type pluginResponse struct {
+	ID     int             `json:"id"`
+	Result json.RawMessage `json:"result"`
+	Error  *pluginError    `json:"error"`
+}
+
+type pluginError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+// pluginInternalErrorCode is used for errors raised by azbrowse rather than the plugin
+const pluginInternalErrorCode = -32000
+
+// pluginParams are sent with the doesExpand, expand and delete methods.
+// The ARM token is only sent to expand and delete
+type pluginParams struct {
+	Node     *pluginNode           `json:"node"`
+	ArmToken *armclient.AzCLIToken `json:"armToken,omitempty"`
+}
+
+// pluginExpandResult is the result of the expand method
+type pluginExpandResult struct {
+	Nodes             []*pluginNode        `json:"nodes"`
+	Response          string               `json:"response"`
+	ResponseType      ExpanderResponseType `json:"responseType"`
+	IsPrimaryResponse bool                 `json:"isPrimaryResponse"`
+}
+
+// pluginNode is the serialisable part of a TreeNode sent to and from plugins
+type pluginNode struct {
+	Parentid         string            `json:"parentId"`
+	ID               string            `json:"id"`
+	Name             string            `json:"name"`
+	Display          string            `json:"display"`
+	ExpandURL        string            `json:"expandUrl"`
+	ItemType         string            `json:"itemType"`
+	ExpandReturnType string            `json:"expandReturnType"`
+	DeleteURL        string            `json:"deleteUrl"`
+	Namespace        string            `json:"namespace"`
+	ArmType          string            `json:"armType"`
+	Metadata         map[string]string `json:"metadata"`
+	SubscriptionID   string            `json:"subscriptionId"`
+	StatusIndicator  string            `json:"statusIndicator"`
+}
+
+func newPluginNode(node *TreeNode) *pluginNode {
+	return &pluginNode{
+		Parentid:         node.Parentid,
+		ID:               node.ID,
+		Name:             node.Name,
+		Display:          node.Display,
+		ExpandURL:        node.ExpandURL,
+		ItemType:         node.ItemType,
+		ExpandReturnType: node.ExpandReturnType,
+		DeleteURL:        node.DeleteURL,
+		Namespace:        node.Namespace,
+		ArmType:          node.ArmType,
+		Metadata:         node.Metadata,
+		SubscriptionID:   node.SubscriptionID,
+		StatusIndicator:  node.StatusIndicator,
+	}
+}
+
+func (n *pluginNode) treeNode() *TreeNode {
+	return &TreeNode{
+		Parentid:         n.Parentid,
+		ID:               n.ID,
+		Name:             n.Name,
+		Display:          n.Display,
+		ExpandURL:        n.ExpandURL,
+		ItemType:         n.ItemType,
+		ExpandReturnType: n.ExpandReturnType,
+		DeleteURL:        n.DeleteURL,
+		Namespace:        n.Namespace,
+		ArmType:          n.ArmType,
+		Metadata:         n.Metadata,
+		SubscriptionID:   n.SubscriptionID,
+		StatusIndicator:  n.StatusIndicator,
+	}
+}
+
+// Check interface
+var _ Expander = &PluginExpander{}
+
+// PluginExpander forwards expansion to a plugin running as a subprocess
+type PluginExpander struct {
+	client *armclient.Client
+	config config.PluginConfig
+	env    []string // extra environment variables for the plugin process, used by tests
+
+	writeMutex sync.Mutex // stops requests written to stdin interleaving
+
+	mutex    sync.Mutex // guards the fields below
+	name     string
+	process  *exec.Cmd
+	stdin    io.WriteCloser
+	pending  map[int]chan pluginResponse
+	nextID   int
+	failedAt time.Time
+}
+
+// NewPluginExpander creates an expander for the plugin. The plugin is started when first used
+func NewPluginExpander(client *armclient.Client, pluginConfig config.PluginConfig) *PluginExpander {
+	return &PluginExpander{
+		client: client,
+		config: pluginConfig,
+		name:   pluginConfig.Name,
+	}
+}
+
+// InitializePlugins registers an expander for each configured plugin and starts them
+func InitializePlugins(client *armclient.Client, plugins []config.PluginConfig) {
+	for _, pluginConfig := range plugins {
+		plugin := NewPluginExpander(client, pluginConfig)
+		register = append(register, plugin)
+		go plugin.Start(context.Background()) //nolint: errcheck
+	}
+}
+
+// StopPlugins stops the processes of all registered plugins
+func StopPlugins() {
+	for _, expander := range getRegisteredExpanders() {
+		if plugin, ok := expander.(*PluginExpander); ok {
+			plugin.Stop()
+		}
+	}
+}
+
+func (e *PluginExpander) setClient(c *armclient.Client) {
+	e.client = c
+}
+
+// Name returns the name of the plugin
+func (e *PluginExpander) Name() string {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	if e.name != "" {
+		return e.name
+	}
+	return "Plugin:" + filepath.Base(e.config.Command.Executable)
+}
+
+// Start starts the plugin process and asks it for its name
+func (e *PluginExpander) Start(ctx context.Context) error {
+	var name string
+	err := e.call(ctx, "name", nil, pluginStartTimeout, &name)
+	if err != nil {
+		return err
+	}
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	if e.name == "" {
+		e.name = name
+	}
+	return nil
+}
+
+// Stop stops the plugin process
+func (e *PluginExpander) Stop() {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	e.stop(errors.New("Plugin stopped"))
+}
+
+// DoesExpand is called on the UI goroutine so it doesn't wait for the plugin, instead Expand asks
+// the plugin whether it expands the node. A plugin which has failed is skipped until it can be restarted
+func (e *PluginExpander) DoesExpand(ctx context.Context, currentItem *TreeNode) (bool, error) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	return e.process != nil || e.failedAt.IsZero() || time.Since(e.failedAt) >= pluginRestartInterval, nil
+}
+
+// Expand asks the plugin whether it expands the node and, if it does, to expand it
+func (e *PluginExpander) Expand(ctx context.Context, currentItem *TreeNode) ExpanderResult {
+	var doesExpand bool
+	err := e.call(ctx, "doesExpand", pluginParams{Node: newPluginNode(currentItem)}, pluginDoesExpandTimeout, &doesExpand)
+	if err != nil {
+		return ExpanderResult{
+			Err:               fmt.Errorf("Plugin '%s' failed: %w", e.Name(), err),
+			SourceDescription: e.Name(),
+		}
+	}
+	if !doesExpand {
+		return ExpanderResult{SourceDescription: e.Name()}
+	}
+
+	token, err := e.client.GetToken()
+	if err != nil {
+		return ExpanderResult{
+			Err:               fmt.Errorf("Failed getting token for plugin: %s", err),
+			SourceDescription: e.Name(),
+		}
+	}
+
+	var result pluginExpandResult
+	err = e.call(ctx, "expand", pluginParams{Node: newPluginNode(currentItem), ArmToken: &token}, pluginExpandTimeout, &result)
+	if err != nil {
+		return ExpanderResult{
+			Err:               fmt.Errorf("Plugin '%s' failed: %w", e.Name(), err),
+			SourceDescription: e.Name(),
+		}
+	}
+
+	newItems := []*TreeNode{}
+	for _, node := range result.Nodes {
+		newItems = append(newItems, node.treeNode())
+	}
+	responseType := result.ResponseType
+	if responseType == "" {
+		responseType = ResponseJSON
+	}
+	return ExpanderResult{
+		Nodes:             newItems,
+		Response:          ExpanderResponse{Response: result.Response, ResponseType: responseType},
+		SourceDescription: e.Name(),
+		IsPrimaryResponse: result.IsPrimaryResponse,
+	}
+}
+
+// Delete asks the plugin to delete the item. Returns false if the plugin doesn't handle the delete
+func (e *PluginExpander) Delete(ctx context.Context, item *TreeNode) (bool, error) {
+	token, err := e.client.GetToken()
+	if err != nil {
+		return false, fmt.Errorf("Failed getting token for plugin: %s", err)
+	}
+
+	var deleted bool
+	err = e.call(ctx, "delete", pluginParams{Node: newPluginNode(item), ArmToken: &token}, pluginDeleteTimeout, &deleted)
+	if err != nil {
+		return false, fmt.Errorf("Plugin '%s' failed: %s", e.Name(), err)
+	}
+	return deleted, nil
+}
+
+// call sends a request to the plugin, starting it if needed, and waits for the response
+func (e *PluginExpander) call(ctx context.Context, method string, params interface{}, timeout time.Duration, result interface{}) error {
+	e.mutex.Lock()
+	if e.process == nil {
+		if !e.failedAt.IsZero() && time.Since(e.failedAt) < pluginRestartInterval {
+			e.mutex.Unlock()
+			return errPluginUnavailable
+		}
+		if err := e.start(); err != nil {
+			e.stop(err)
+			e.mutex.Unlock()
+			return err
+		}
+	}
+
+	e.nextID++
+	id := e.nextID
+	responseChan := make(chan pluginResponse, 1)
+	e.pending[id] = responseChan
+	process := e.process
+	stdin := e.stdin
+	e.mutex.Unlock()
+
+	request, err := json.Marshal(pluginRequest{JSONRPC: "2.0", ID: id, Method: method, Params: params})
+	if err != nil {
+		e.mutex.Lock()
+		delete(e.pending, id)
+		e.mutex.Unlock()
+		return err
+	}
+
+	// Write without holding the mutex, and under the timeout, so a plugin which has stopped
+	// reading its input can't block other calls
+	written := make(chan error, 1)
+	go func() {
+		e.writeMutex.Lock()
+		defer e.writeMutex.Unlock()
+		_, err := stdin.Write(append(request, '\n'))
+		written <- err
+	}()
+
+	deadline := time.After(timeout)
+	for {
+		select {
+		case err := <-written:
+			if err != nil {
+				e.mutex.Lock()
+				delete(e.pending, id)
+				if e.process == process {
+					e.stop(err)
+				}
+				e.mutex.Unlock()
+				return err
+			}
+		case response := <-responseChan:
+			if response.Error != nil {
+				return fmt.Errorf("%s (code %d)", response.Error.Message, response.Error.Code)
+			}
+			if result == nil {
+				return nil
+			}
+			return json.Unmarshal(response.Result, result)
+		case <-ctx.Done():
+			e.mutex.Lock()
+			delete(e.pending, id)
+			e.mutex.Unlock()
+			return ctx.Err()
+		case <-deadline:
+			// Don't let a plugin which has stopped responding hold up every expand
+			err := fmt.Errorf("%w waiting for '%s' after %s", errExpanderTimedOut, method, timeout)
+			e.mutex.Lock()
+			delete(e.pending, id)
+			if e.process == process {
+				e.stop(err)
+			}
+			e.mutex.Unlock()
+			return err
+		}
+	}
+}
+
+// start runs the plugin process. Must be called with the mutex held
+func (e *PluginExpander) start() error {
+	process := exec.Command(e.config.Command.Executable, e.config.Command.Arguments...) //nolint:gosec
+	if len(e.env) > 0 {
+		process.Env = append(os.Environ(), e.env...)
+	}
+	stdin, err := process.StdinPipe()
+	if err != nil {
+		return err
+	}
+	stdout, err := process.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	if err := process.Start(); err != nil {
+		return fmt.Errorf("Failed starting plugin %s: %s", e.config.Command.Executable, err)
+	}
+
+	e.process = process
+	e.stdin = stdin
+	e.pending = map[int]chan pluginResponse{}
+	go e.readResponses(process, stdout)
+	return nil
+}
+
+// stop kills the plugin process and fails any requests waiting on it. Must be called with the mutex held
+func (e *PluginExpander) stop(reason error) {
+	e.failedAt = time.Now()
+	if e.process == nil {
+		return
+	}
+	_ = e.process.Process.Kill()
+	_ = e.stdin.Close()
+	for id, responseChan := range e.pending {
+		responseChan <- pluginResponse{ID: id, Error: &pluginError{Code: pluginInternalErrorCode, Message: reason.Error()}}
+	}
+	e.pending = map[int]chan pluginResponse{}
+	e.process = nil
+}
+
+// readResponses passes responses from the plugin to the waiting calls until the plugin exits
+func (e *PluginExpander) readResponses(process *exec.Cmd, stdout io.Reader) {
+	scanner := bufio.NewScanner(stdout)
+	scanner.Buffer(make([]byte, 64*1024), maxPluginMessageSize)
+	for scanner.Scan() {
+		var response pluginResponse
+		if err := json.Unmarshal(scanner.Bytes(), &response); err != nil {
+			// Ignore anything which isn't a response, e.g. logging written to stdout by mistake
+			continue
+		}
+		e.mutex.Lock()
+		if responseChan, found := e.pending[response.ID]; found && e.process == process {
+			delete(e.pending, response.ID)
+			responseChan <- response
+		}
+		e.mutex.Unlock()
+	}
+
+	err := process.Wait()
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	if e.process == process {
+		// The plugin exited without being stopped
+		reason := fmt.Errorf("Plugin '%s' exited unexpectedly: %v", e.config.Command.Executable, err)
+		eventing.SendFailureStatus(reason.Error())
+		e.stop(reason)
+	}
+}
+
+func (e *PluginExpander) testCases() (bool, *[]expanderTestCase) {
+	// Plugins aren't registered in tests, see plugin_test.go
+	return false, nil
+}
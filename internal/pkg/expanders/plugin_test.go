@@ -0,0 +1,192 @@
+package expanders
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/lawrencegripper/azbrowse/internal/pkg/config"
+	"github.com/lawrencegripper/azbrowse/pkg/armclient"
+	"github.com/nbio/st"
+)
+
+// Test_PluginHelperProcess isn't a real test, it's run as the plugin by the other tests
+func Test_PluginHelperProcess(t *testing.T) {
+	if os.Getenv("AZBROWSE_TEST_PLUGIN") != "1" {
+		return
+	}
+
+	scanner := bufio.NewScanner(os.Stdin)
+	for scanner.Scan() {
+		var request struct {
+			ID     int          `json:"id"`
+			Method string       `json:"method"`
+			Params pluginParams `json:"params"`
+		}
+		if err := json.Unmarshal(scanner.Bytes(), &request); err != nil {
+			os.Exit(2)
+		}
+
+		var result interface{}
+		switch request.Method {
+		case "name":
+			result = "TestPlugin"
+		case "doesExpand":
+			result = request.Params.Node.ItemType == "test.plugin"
+		case "expand":
+			switch request.Params.Node.Name {
+			case "hang":
+				time.Sleep(time.Minute)
+			case "crash":
+				os.Exit(1)
+			}
+			result = pluginExpandResult{
+				Nodes: []*pluginNode{
+					{ID: request.Params.Node.ID + "/child", Name: "child", Display: "Child", ItemType: "test.plugin"},
+				},
+				Response:          "token:" + request.Params.ArmToken.AccessToken,
+				ResponseType:      ResponsePlainText,
+				IsPrimaryResponse: true,
+			}
+		case "delete":
+			result = request.Params.Node.DeleteURL != ""
+		}
+
+		response, _ := json.Marshal(map[string]interface{}{"jsonrpc": "2.0", "id": request.ID, "result": result})
+		fmt.Println(string(response))
+	}
+	os.Exit(0)
+}
+
+func newTestPluginExpander(t *testing.T) *PluginExpander {
+	tokenFunc := func(clearCache bool) (armclient.AzCLIToken, error) {
+		return armclient.AzCLIToken{AccessToken: "abc", TokenType: "Bearer"}, nil
+	}
+	client := armclient.NewClientFromConfig(&http.Client{}, tokenFunc, 5000)
+
+	plugin := NewPluginExpander(client, config.PluginConfig{
+		Command: config.CommandConfig{
+			Executable: os.Args[0],
+			Arguments:  []string{"-test.run=Test_PluginHelperProcess"},
+		},
+	})
+	// Only the plugin process acts as the plugin, the test process must not
+	plugin.env = []string{"AZBROWSE_TEST_PLUGIN=1"}
+	st.Expect(t, plugin.Start(context.Background()), nil)
+	return plugin
+}
+
+func Test_Plugin_Expand(t *testing.T) {
+	plugin := newTestPluginExpander(t)
+	defer plugin.Stop()
+	ctx := context.Background()
+
+	st.Expect(t, plugin.Name(), "TestPlugin")
+
+	// The plugin is only asked whether it expands the node by Expand, which runs off the UI goroutine
+	doesExpand, err := plugin.DoesExpand(ctx, &TreeNode{ItemType: "resource"})
+	st.Expect(t, err, nil)
+	st.Expect(t, doesExpand, true)
+	result := plugin.Expand(ctx, &TreeNode{ItemType: "resource"})
+	st.Expect(t, result.Err, nil)
+	st.Expect(t, result.IsPrimaryResponse, false)
+	st.Expect(t, len(result.Nodes), 0)
+
+	node := &TreeNode{ID: "/test", Name: "test", ItemType: "test.plugin", DeleteURL: "/test"}
+	result = plugin.Expand(ctx, node)
+	st.Expect(t, result.Err, nil)
+	st.Expect(t, result.IsPrimaryResponse, true)
+	st.Expect(t, result.Response.Response, "token:abc")
+	st.Expect(t, result.Response.ResponseType, ResponsePlainText)
+	st.Expect(t, len(result.Nodes), 1)
+	st.Expect(t, result.Nodes[0].ID, "/test/child")
+
+	deleted, err := plugin.Delete(ctx, node)
+	st.Expect(t, err, nil)
+	st.Expect(t, deleted, true)
+}
+
+func Test_Plugin_TimeoutIsIsolated(t *testing.T) {
+	defer func(timeout, restart time.Duration) {
+		pluginExpandTimeout, pluginRestartInterval = timeout, restart
+	}(pluginExpandTimeout, pluginRestartInterval)
+	pluginExpandTimeout = 200 * time.Millisecond
+	pluginRestartInterval = time.Hour
+
+	plugin := newTestPluginExpander(t)
+	defer plugin.Stop()
+	ctx := context.Background()
+
+	result := plugin.Expand(ctx, &TreeNode{ID: "/hang", Name: "hang", ItemType: "test.plugin"})
+	st.Reject(t, result.Err, nil)
+	// Timeouts count towards disabling the plugin
+	st.Expect(t, isExpanderFault(result.Err), true)
+
+	// The hung plugin is stopped and not used until it's restarted
+	doesExpand, err := plugin.DoesExpand(ctx, &TreeNode{ItemType: "test.plugin"})
+	st.Expect(t, err, nil)
+	st.Expect(t, doesExpand, false)
+}
+
+func Test_Plugin_BlockedWriteIsIsolated(t *testing.T) {
+	defer func(timeout, restart time.Duration) {
+		pluginExpandTimeout, pluginRestartInterval = timeout, restart
+	}(pluginExpandTimeout, pluginRestartInterval)
+	pluginExpandTimeout = 500 * time.Millisecond
+	pluginRestartInterval = time.Hour
+
+	plugin := newTestPluginExpander(t)
+	defer plugin.Stop()
+	ctx := context.Background()
+
+	// The plugin stops reading its input while it hangs, so the large request fills the pipe and blocks
+	go plugin.Expand(ctx, &TreeNode{ID: "/hang", Name: "hang", ItemType: "test.plugin"})
+	time.Sleep(100 * time.Millisecond)
+	blocked := make(chan ExpanderResult, 1)
+	go func() {
+		blocked <- plugin.Expand(ctx, &TreeNode{ID: "/" + strings.Repeat("x", 1024*1024), Name: "large", ItemType: "test.plugin"})
+	}()
+	time.Sleep(100 * time.Millisecond)
+
+	named := make(chan string, 1)
+	go func() {
+		named <- plugin.Name()
+	}()
+	select {
+	case name := <-named:
+		st.Expect(t, name, "TestPlugin")
+	case <-time.After(time.Second):
+		t.Fatal("Name() was blocked by the write to the plugin")
+	}
+
+	select {
+	case result := <-blocked:
+		st.Reject(t, result.Err, nil)
+	case <-time.After(5 * time.Second):
+		t.Fatal("The blocked write didn't time out")
+	}
+}
+
+func Test_Plugin_RestartsAfterCrash(t *testing.T) {
+	defer func(restart time.Duration) {
+		pluginRestartInterval = restart
+	}(pluginRestartInterval)
+	pluginRestartInterval = 0
+
+	plugin := newTestPluginExpander(t)
+	defer plugin.Stop()
+	ctx := context.Background()
+
+	result := plugin.Expand(ctx, &TreeNode{ID: "/crash", Name: "crash", ItemType: "test.plugin"})
+	st.Reject(t, result.Err, nil)
+
+	result = plugin.Expand(ctx, &TreeNode{ID: "/test", Name: "test", ItemType: "test.plugin"})
+	st.Expect(t, result.Err, nil)
+	st.Expect(t, len(result.Nodes), 1)
+}
@@ -0,0 +1,474 @@
+package expanders
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/lawrencegripper/azbrowse/internal/pkg/style"
+	"github.com/lawrencegripper/azbrowse/pkg/armclient"
+	"github.com/nbio/st"
+	"gopkg.in/h2non/gock.v1"
+)
+
+const (
+	policyAssignmentsAPIVersion = "2019-09-01"
+	policyInsightsAPIVersion    = "2019-10-01"
+
+	policyType               = "policy"
+	policyAssignmentType     = "policy.assignment"
+	policyResourceStatesType = "policy.resourceStates"
+	policyStateType          = "policy.state"
+
+	policyScopeMeta        = "Scope"
+	policyAssignmentIDMeta = "PolicyAssignmentID"
+)
+
+// PolicyAssignmentListResponse is returned when listing policy assignments at a scope
+type PolicyAssignmentListResponse struct {
+	Value []struct {
+		ID         string `json:"id"`
+		Name       string `json:"name"`
+		Properties struct {
+			DisplayName        string `json:"displayName"`
+			Description        string `json:"description"`
+			PolicyDefinitionID string `json:"policyDefinitionId"`
+			Scope              string `json:"scope"`
+			EnforcementMode    string `json:"enforcementMode"`
+		} `json:"properties"`
+	} `json:"value"`
+}
+
+// PolicySummaryResponse is returned when summarizing policy states at a scope
+type PolicySummaryResponse struct {
+	Value []struct {
+		Results struct {
+			NonCompliantResources int `json:"nonCompliantResources"`
+			NonCompliantPolicies  int `json:"nonCompliantPolicies"`
+		} `json:"results"`
+		PolicyAssignments []struct {
+			PolicyAssignmentID string `json:"policyAssignmentId"`
+			Results            struct {
+				NonCompliantResources int `json:"nonCompliantResources"`
+				NonCompliantPolicies  int `json:"nonCompliantPolicies"`
+			} `json:"results"`
+		} `json:"policyAssignments"`
+	} `json:"value"`
+}
+
+// PolicyStatesResponse is returned when querying policy states
+type PolicyStatesResponse struct {
+	Value []struct {
+		ResourceID                  string `json:"resourceId"`
+		ResourceType                string `json:"resourceType"`
+		PolicyAssignmentID          string `json:"policyAssignmentId"`
+		PolicyAssignmentName        string `json:"policyAssignmentName"`
+		PolicyDefinitionName        string `json:"policyDefinitionName"`
+		PolicyDefinitionAction      string `json:"policyDefinitionAction"`
+		PolicyDefinitionReferenceID string `json:"policyDefinitionReferenceId"`
+		ComplianceState             string `json:"complianceState"`
+		PolicyEvaluationDetails     struct {
+			EvaluatedExpressions []PolicyEvaluatedExpression `json:"evaluatedExpressions"`
+		} `json:"policyEvaluationDetails"`
+	} `json:"value"`
+}
+
+// PolicyEvaluatedExpression is a policy rule expression evaluated against a resource
+type PolicyEvaluatedExpression struct {
+	Result          string      `json:"result"`
+	Expression      string      `json:"expression"`
+	Path            string      `json:"path"`
+	ExpressionValue interface{} `json:"expressionValue"`
+	TargetValue     interface{} `json:"targetValue"`
+	Operator        string      `json:"operator"`
+}
+
+// Check interface
+var _ Expander = &PolicyExpander{}
+
+// PolicyExpander shows policy assignments and compliance from Microsoft.PolicyInsights
+type PolicyExpander struct {
+	ExpanderBase
+	client *armclient.Client
+}
+
+func (e *PolicyExpander) setClient(c *armclient.Client) {
+	e.client = c
+}
+
+// Name returns the name of the expander
+func (e *PolicyExpander) Name() string {
+	return "PolicyExpander"
+}
+
+// DoesExpand checks if this is a resource or one of the policy nodes
+func (e *PolicyExpander) DoesExpand(ctx context.Context, currentItem *TreeNode) (bool, error) {
+	switch currentItem.ItemType {
+	case ResourceType, policyType, policyAssignmentType, policyResourceStatesType:
+		return true, nil
+	}
+	return false, nil
+}
+
+// Expand adds the policy state node to a resource or expands the policy nodes
+func (e *PolicyExpander) Expand(ctx context.Context, currentItem *TreeNode) ExpanderResult {
+	switch currentItem.ItemType {
+	case policyType:
+		return e.expandPolicy(ctx, currentItem)
+	case policyAssignmentType:
+		filter := "complianceState eq 'NonCompliant' and policyAssignmentId eq '" + currentItem.Metadata[policyAssignmentIDMeta] + "'"
+		return e.expandPolicyStates(ctx, currentItem, currentItem.Metadata[policyScopeMeta], filter, true)
+	case policyResourceStatesType:
+		return e.expandPolicyStates(ctx, currentItem, currentItem.Metadata[policyScopeMeta], "complianceState eq 'NonCompliant'", false)
+	}
+
+	return ExpanderResult{
+		Nodes: []*TreeNode{
+			{
+				Parentid:       currentItem.ID,
+				Namespace:      "None",
+				Display:        style.Subtle("[Microsoft.PolicyInsights]") + "\n  Policy compliance",
+				Name:           "Policy compliance",
+				ID:             currentItem.ID + "/<policystates>",
+				ExpandURL:      ExpandURLNotSupported,
+				ItemType:       policyResourceStatesType,
+				SubscriptionID: currentItem.SubscriptionID,
+				Metadata: map[string]string{
+					"SuppressSwaggerExpand": "true",
+					"SuppressGenericExpand": "true",
+					policyScopeMeta:         currentItem.ID,
+				},
+			},
+		},
+		Response:          ExpanderResponse{Response: ""}, // Swagger expander will supply the response
+		SourceDescription: "PolicyExpander request",
+		IsPrimaryResponse: false,
+	}
+}
+
+// newPolicyNode creates the "Policy" node showing assignments and compliance at scope
+func newPolicyNode(parent *TreeNode, scope string) *TreeNode {
+	return &TreeNode{
+		Parentid:       parent.ID,
+		Namespace:      "None",
+		Display:        style.Subtle("[Microsoft.PolicyInsights]") + "\n  Policy",
+		Name:           "Policy",
+		ID:             scope + "/<policy>",
+		ExpandURL:      ExpandURLNotSupported,
+		ItemType:       policyType,
+		SubscriptionID: parent.SubscriptionID,
+		Metadata: map[string]string{
+			"SuppressSwaggerExpand": "true",
+			"SuppressGenericExpand": "true",
+			policyScopeMeta:         scope,
+		},
+	}
+}
+
+func (e *PolicyExpander) expandPolicy(ctx context.Context, currentItem *TreeNode) ExpanderResult {
+	scope := currentItem.Metadata[policyScopeMeta]
+
+	data, err := e.client.DoRequest(ctx, "GET", scope+"/providers/Microsoft.Authorization/policyAssignments?$filter=atScope()&api-version="+policyAssignmentsAPIVersion)
+	if err != nil {
+		return ExpanderResult{
+			Err:               fmt.Errorf("Failed retrieving policy assignments: %s", err),
+			Response:          ExpanderResponse{Response: data, ResponseType: ResponseJSON},
+			SourceDescription: "PolicyExpander request",
+			IsPrimaryResponse: true,
+		}
+	}
+	var assignments PolicyAssignmentListResponse
+	err = json.Unmarshal([]byte(data), &assignments)
+	if err != nil {
+		return ExpanderResult{
+			Err:               fmt.Errorf("Error unmarshalling policy assignments: %s", err),
+			Response:          ExpanderResponse{Response: data, ResponseType: ResponseJSON},
+			SourceDescription: "PolicyExpander request",
+			IsPrimaryResponse: true,
+		}
+	}
+
+	// The compliance summary adds counts to the assignments so carry on without it if it fails
+	response := data
+	nonCompliantCounts := map[string]int{}
+	summaryData, err := e.client.DoRequestWithBody(ctx, "POST", scope+"/providers/Microsoft.PolicyInsights/policyStates/latest/summarize?api-version="+policyInsightsAPIVersion, "")
+	if err == nil {
+		var summary PolicySummaryResponse
+		if err = json.Unmarshal([]byte(summaryData), &summary); err == nil {
+			response = summaryData
+			for _, value := range summary.Value {
+				for _, assignment := range value.PolicyAssignments {
+					nonCompliantCounts[strings.ToLower(assignment.PolicyAssignmentID)] = assignment.Results.NonCompliantResources
+				}
+			}
+		}
+	}
+
+	newItems := []*TreeNode{}
+	for _, assignment := range assignments.Value {
+		name := assignment.Properties.DisplayName
+		if name == "" {
+			name = assignment.Name
+		}
+		display := name
+		statusIndicator := ""
+		if count, found := nonCompliantCounts[strings.ToLower(assignment.ID)]; found {
+			display += "\n  " + style.Subtle("Non-compliant resources: "+strconv.Itoa(count))
+			if count > 0 {
+				statusIndicator = DrawStatus("NonCompliant")
+			}
+		}
+		if assignment.Properties.EnforcementMode == "DoNotEnforce" {
+			display += "\n  " + style.Subtle("Enforcement: disabled")
+		}
+		if !strings.EqualFold(assignment.Properties.Scope, scope) {
+			display += "\n  " + style.Subtle("Inherited from: "+assignment.Properties.Scope)
+		}
+
+		newItems = append(newItems, &TreeNode{
+			Parentid:        currentItem.ID,
+			Namespace:       "None",
+			Name:            name,
+			Display:         display,
+			ID:              assignment.ID,
+			ExpandURL:       ExpandURLNotSupported,
+			ItemType:        policyAssignmentType,
+			SubscriptionID:  currentItem.SubscriptionID,
+			StatusIndicator: statusIndicator,
+			Metadata: map[string]string{
+				"SuppressSwaggerExpand": "true",
+				"SuppressGenericExpand": "true",
+				policyScopeMeta:         scope,
+				policyAssignmentIDMeta:  assignment.ID,
+			},
+		})
+	}
+
+	return ExpanderResult{
+		Nodes:             newItems,
+		Response:          ExpanderResponse{Response: response, ResponseType: ResponseJSON},
+		SourceDescription: "PolicyExpander request",
+		IsPrimaryResponse: true,
+	}
+}
+
+// expandPolicyStates lists the policy states matching filter, naming the nodes by resource or by policy
+func (e *PolicyExpander) expandPolicyStates(ctx context.Context, currentItem *TreeNode, scope string, filter string, byResource bool) ExpanderResult {
+	data, err := e.client.DoRequestWithBody(ctx, "POST", scope+"/providers/Microsoft.PolicyInsights/policyStates/latest/queryResults?api-version="+policyInsightsAPIVersion+
+		"&$expand=PolicyEvaluationDetails&$filter="+url.QueryEscape(filter), "")
+	if err != nil {
+		return ExpanderResult{
+			Err:               fmt.Errorf("Failed retrieving policy states: %s", err),
+			Response:          ExpanderResponse{Response: data, ResponseType: ResponseJSON},
+			SourceDescription: "PolicyExpander request",
+			IsPrimaryResponse: true,
+		}
+	}
+	var states PolicyStatesResponse
+	err = json.Unmarshal([]byte(data), &states)
+	if err != nil {
+		return ExpanderResult{
+			Err:               fmt.Errorf("Error unmarshalling policy states: %s", err),
+			Response:          ExpanderResponse{Response: data, ResponseType: ResponseJSON},
+			SourceDescription: "PolicyExpander request",
+			IsPrimaryResponse: true,
+		}
+	}
+
+	value, err := fastJSONParser.Parse(data)
+	if err != nil {
+		return ExpanderResult{
+			Err:               fmt.Errorf("Error parsing policy states: %s", err),
+			Response:          ExpanderResponse{Response: data, ResponseType: ResponseJSON},
+			SourceDescription: "PolicyExpander request",
+			IsPrimaryResponse: true,
+		}
+	}
+	stateValues := value.GetArray("value")
+
+	newItems := []*TreeNode{}
+	for i, state := range states.Value {
+		name := state.PolicyAssignmentName
+		if state.PolicyDefinitionReferenceID != "" {
+			name += "/" + state.PolicyDefinitionReferenceID
+		}
+		if byResource {
+			name = lastSegment(state.ResourceID)
+		}
+
+		display := name + "\n  " + style.Subtle("Effect: "+state.PolicyDefinitionAction)
+		if byResource {
+			display += "\n  " + style.Subtle("Type: "+state.ResourceType)
+		} else {
+			display += "\n  " + style.Subtle("Definition: "+state.PolicyDefinitionName)
+		}
+		for _, reason := range getPolicyStateReasons(state.PolicyEvaluationDetails.EvaluatedExpressions) {
+			display += "\n  " + style.Subtle("Reason: "+reason)
+		}
+
+		objectJSON := ""
+		if i < len(stateValues) {
+			objectJSON = string(stateValues[i].MarshalTo([]byte("")))
+		}
+
+		newItems = append(newItems, &TreeNode{
+			Parentid:        currentItem.ID,
+			Namespace:       "None",
+			Name:            name,
+			Display:         display,
+			ID:              currentItem.ID + "/" + state.PolicyAssignmentName + "/" + state.PolicyDefinitionReferenceID + "/" + state.ResourceID,
+			ExpandURL:       ExpandURLNotSupported,
+			ItemType:        policyStateType,
+			SubscriptionID:  currentItem.SubscriptionID,
+			StatusIndicator: DrawStatus(state.ComplianceState),
+			Metadata: map[string]string{
+				"jsonItem": objectJSON,
+			},
+		})
+	}
+
+	return ExpanderResult{
+		Nodes:             newItems,
+		Response:          ExpanderResponse{Response: data, ResponseType: ResponseJSON},
+		SourceDescription: "PolicyExpander request",
+		IsPrimaryResponse: true,
+	}
+}
+
+// getPolicyStateReasons describes the evaluated expressions which caused a policy to match
+func getPolicyStateReasons(expressions []PolicyEvaluatedExpression) []string {
+	reasons := []string{}
+	for _, expression := range expressions {
+		if expression.Result != "True" {
+			continue
+		}
+		target, _ := json.Marshal(expression.TargetValue)
+		actual, _ := json.Marshal(expression.ExpressionValue)
+		reasons = append(reasons, fmt.Sprintf("%s %s %s (actual: %s)", expression.Expression, expression.Operator, target, actual))
+	}
+	return reasons
+}
+
+// GetNonCompliantResourceIDs returns the (lower cased) IDs of resources under scope which are not compliant with a policy
+func GetNonCompliantResourceIDs(ctx context.Context, client *armclient.Client, scope string) (map[string]bool, error) {
+	data, err := client.DoRequestWithBody(ctx, "POST", scope+"/providers/Microsoft.PolicyInsights/policyStates/latest/queryResults?api-version="+policyInsightsAPIVersion+
+		"&$filter="+url.QueryEscape("complianceState eq 'NonCompliant'")+"&$apply="+url.QueryEscape("groupby((resourceId))"), "")
+	if err != nil {
+		return nil, fmt.Errorf("Failed retrieving policy states: %s", err)
+	}
+	var states PolicyStatesResponse
+	err = json.Unmarshal([]byte(data), &states)
+	if err != nil {
+		return nil, fmt.Errorf("Error unmarshalling policy states: %s", err)
+	}
+
+	resourceIDs := map[string]bool{}
+	for _, state := range states.Value {
+		resourceIDs[strings.ToLower(state.ResourceID)] = true
+	}
+	return resourceIDs, nil
+}
+
+func (e *PolicyExpander) testCases() (bool, *[]expanderTestCase) {
+	const rgID = "/subscriptions/00000000-0000-0000-0000-000000000000/resourceGroups/stable"
+	const storageID = rgID + "/providers/Microsoft.Storage/storageAccounts/astorageaccount"
+	const assignmentsResponseFile = "./testdata/armsamples/policy/assignments.json"
+	const summaryResponseFile = "./testdata/armsamples/policy/summary.json"
+	const statesResponseFile = "./testdata/armsamples/policy/states.json"
+
+	readFile := func(t *testing.T, file string) string {
+		dat, err := ioutil.ReadFile(file)
+		if err != nil {
+			t.Error(err)
+			t.FailNow()
+		}
+		return string(dat)
+	}
+
+	policyGockConfig := func(t *testing.T) {
+		gock.New("https://management.azure.com").
+			Get(rgID+"/providers/Microsoft.Authorization/policyAssignments").
+			MatchParam("$filter", `atScope\(\)`).
+			Reply(200).
+			JSON(readFile(t, assignmentsResponseFile))
+		gock.New("https://management.azure.com").
+			Post(rgID + "/providers/Microsoft.PolicyInsights/policyStates/latest/summarize").
+			Reply(200).
+			JSON(readFile(t, summaryResponseFile))
+	}
+
+	statesGockConfig := func(t *testing.T) {
+		gock.New("https://management.azure.com").
+			Post(storageID+"/providers/Microsoft.PolicyInsights/policyStates/latest/queryResults").
+			MatchParam("$filter", "complianceState eq 'NonCompliant'").
+			Reply(200).
+			JSON(readFile(t, statesResponseFile))
+	}
+
+	noRequestGockConfig := func(t *testing.T) {}
+
+	return true, &[]expanderTestCase{
+		{
+			name: "Resource->PolicyStatesNode",
+			nodeToExpand: &TreeNode{
+				ID:       storageID,
+				ItemType: ResourceType,
+			},
+			statusCode:        200,
+			configureGockFunc: &noRequestGockConfig,
+			treeNodeCheckerFunc: func(t *testing.T, r ExpanderResult) {
+				st.Expect(t, r.Err, nil)
+				st.Expect(t, r.IsPrimaryResponse, false)
+				st.Expect(t, len(r.Nodes), 1)
+				st.Expect(t, r.Nodes[0].ItemType, policyResourceStatesType)
+			},
+		},
+		{
+			name:              "PolicyNode->Assignments",
+			nodeToExpand:      newPolicyNode(&TreeNode{ID: rgID}, rgID),
+			statusCode:        200,
+			configureGockFunc: &policyGockConfig,
+			treeNodeCheckerFunc: func(t *testing.T, r ExpanderResult) {
+				st.Expect(t, r.Err, nil)
+				st.Expect(t, r.IsPrimaryResponse, true)
+				st.Expect(t, len(r.Nodes), 2)
+
+				st.Expect(t, r.Nodes[0].Name, "Allowed locations")
+				st.Expect(t, r.Nodes[0].StatusIndicator, DrawStatus("NonCompliant"))
+				st.Expect(t, strings.Contains(r.Nodes[0].Display, "Non-compliant resources: 2"), true)
+				st.Expect(t, strings.Contains(r.Nodes[0].Display, "Inherited from"), true)
+
+				st.Expect(t, r.Nodes[1].Name, "Require a tag on resources")
+				st.Expect(t, r.Nodes[1].StatusIndicator, "")
+				st.Expect(t, r.Nodes[1].Metadata[policyAssignmentIDMeta], rgID+"/providers/Microsoft.Authorization/policyAssignments/requiretag")
+			},
+		},
+		{
+			name: "PolicyStatesNode->NonCompliantPolicies",
+			nodeToExpand: &TreeNode{
+				ID:       storageID + "/<policystates>",
+				ItemType: policyResourceStatesType,
+				Metadata: map[string]string{
+					policyScopeMeta: storageID,
+				},
+			},
+			statusCode:        200,
+			configureGockFunc: &statesGockConfig,
+			treeNodeCheckerFunc: func(t *testing.T, r ExpanderResult) {
+				st.Expect(t, r.Err, nil)
+				st.Expect(t, r.IsPrimaryResponse, true)
+				st.Expect(t, len(r.Nodes), 1)
+
+				st.Expect(t, r.Nodes[0].Name, "allowedlocations")
+				st.Expect(t, r.Nodes[0].StatusIndicator, DrawStatus("NonCompliant"))
+				st.Expect(t, strings.Contains(r.Nodes[0].Display, `Reason: location NotIn ["westeurope","northeurope"] (actual: "eastus")`), true)
+				st.Expect(t, r.Nodes[0].Metadata["jsonItem"] != "", true)
+			},
+		},
+	}
+}
@@ -0,0 +1,406 @@
+package expanders
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strings"
+	"testing"
+	"text/tabwriter"
+
+	"github.com/lawrencegripper/azbrowse/internal/pkg/style"
+	"github.com/lawrencegripper/azbrowse/pkg/armclient"
+	"github.com/nbio/st"
+	"gopkg.in/h2non/gock.v1"
+)
+
+const quotaLocationsAPIVersion = "2020-01-01"
+
+// Item types for the nodes added by the QuotaExpander
+const (
+	quotasType         = "quotas"
+	quotasRegionType   = "quotas.region"
+	quotasProviderType = "quotas.provider"
+)
+
+// Metadata keys used on quota nodes
+const (
+	quotaLocationMeta = "QuotaLocation" // the region usage is listed for
+	quotaProviderMeta = "QuotaProvider" // the resource provider usage is listed for
+)
+
+// quotaWarningPercent is the usage at which a quota is highlighted
+const quotaWarningPercent = 80
+
+// quotaProviders lists the resource providers usage is shown for along with the API version of their usages API
+var quotaProviders = []struct {
+	Name       string
+	Display    string
+	APIVersion string
+}{
+	{Name: "Microsoft.Compute", Display: "Compute", APIVersion: "2020-06-01"},
+	{Name: "Microsoft.Network", Display: "Network", APIVersion: "2020-05-01"},
+	{Name: "Microsoft.Storage", Display: "Storage", APIVersion: "2019-06-01"},
+}
+
+// quotaUsage is the current usage of a quota in a region
+type quotaUsage struct {
+	CurrentValue float64 `json:"currentValue"`
+	Limit        float64 `json:"limit"`
+	Unit         string  `json:"unit"`
+	Name         struct {
+		Value          string `json:"value"`
+		LocalizedValue string `json:"localizedValue"`
+	} `json:"name"`
+}
+
+// percent returns the percentage of the limit used
+func (u quotaUsage) percent() float64 {
+	if u.Limit <= 0 {
+		return 0
+	}
+	return u.CurrentValue / u.Limit * 100
+}
+
+// Check interface
+var _ Expander = &QuotaExpander{}
+
+// QuotaExpander adds a "Usage & quotas" node to subscriptions showing usage against
+// limits for compute, network and storage in a region
+type QuotaExpander struct {
+	ExpanderBase
+	client *armclient.Client
+}
+
+func (e *QuotaExpander) setClient(c *armclient.Client) {
+	e.client = c
+}
+
+// Name returns the name of the expander
+func (e *QuotaExpander) Name() string {
+	return "QuotaExpander"
+}
+
+// DoesExpand checks if this is one of the quota nodes
+func (e *QuotaExpander) DoesExpand(ctx context.Context, currentItem *TreeNode) (bool, error) {
+	switch currentItem.ItemType {
+	case quotasType, quotasRegionType, quotasProviderType:
+		return true, nil
+	}
+	return false, nil
+}
+
+// Expand lists regions for the quotas node, providers for a region or the usage for a provider
+func (e *QuotaExpander) Expand(ctx context.Context, currentItem *TreeNode) ExpanderResult {
+	switch currentItem.ItemType {
+	case quotasRegionType:
+		return e.expandRegion(ctx, currentItem)
+	case quotasProviderType:
+		return e.expandProvider(ctx, currentItem)
+	}
+	return e.expandQuotas(ctx, currentItem)
+}
+
+// newQuotasNode creates the "Usage & quotas" node for a subscription
+func newQuotasNode(parent *TreeNode) *TreeNode {
+	return &TreeNode{
+		Parentid:       parent.ID,
+		Namespace:      "None",
+		Display:        style.Subtle("[Microsoft.Compute/Network/Storage]") + "\n  Usage & quotas",
+		Name:           "Usage & quotas",
+		ID:             parent.ID + "/<quotas>",
+		ExpandURL:      ExpandURLNotSupported,
+		ItemType:       quotasType,
+		SubscriptionID: parent.SubscriptionID,
+		Metadata: map[string]string{
+			"SuppressSwaggerExpand": "true",
+			"SuppressGenericExpand": "true",
+		},
+	}
+}
+
+func (e *QuotaExpander) expandQuotas(ctx context.Context, currentItem *TreeNode) ExpanderResult {
+	data, err := e.client.DoRequest(ctx, "GET", "/subscriptions/"+currentItem.SubscriptionID+"/locations?api-version="+quotaLocationsAPIVersion)
+	if err != nil {
+		return ExpanderResult{
+			Err:               fmt.Errorf("Failed retrieving locations: %s", err),
+			Response:          ExpanderResponse{Response: data, ResponseType: ResponseJSON},
+			SourceDescription: "QuotaExpander request",
+			IsPrimaryResponse: true,
+		}
+	}
+
+	var locations struct {
+		Value []struct {
+			Name        string `json:"name"`
+			DisplayName string `json:"displayName"`
+			Metadata    struct {
+				RegionType string `json:"regionType"`
+			} `json:"metadata"`
+		} `json:"value"`
+	}
+	err = json.Unmarshal([]byte(data), &locations)
+	if err != nil {
+		return ExpanderResult{
+			Err:               fmt.Errorf("Error unmarshalling locations: %s", err),
+			Response:          ExpanderResponse{Response: data, ResponseType: ResponseJSON},
+			SourceDescription: "QuotaExpander request",
+			IsPrimaryResponse: true,
+		}
+	}
+
+	// Logical regions (e.g. "United States") don't have resources deployed to them
+	regions := locations.Value[:0]
+	for _, location := range locations.Value {
+		if location.Metadata.RegionType != "Logical" {
+			regions = append(regions, location)
+		}
+	}
+	sort.Slice(regions, func(i, j int) bool {
+		return regions[i].DisplayName < regions[j].DisplayName
+	})
+
+	newItems := []*TreeNode{}
+	for _, region := range regions {
+		newItems = append(newItems, &TreeNode{
+			Parentid:       currentItem.ID,
+			Namespace:      "None",
+			Name:           region.DisplayName,
+			Display:        region.DisplayName + "\n  " + style.Subtle(region.Name),
+			ID:             currentItem.ID + "/" + region.Name,
+			ExpandURL:      ExpandURLNotSupported,
+			ItemType:       quotasRegionType,
+			SubscriptionID: currentItem.SubscriptionID,
+			Metadata: map[string]string{
+				"SuppressSwaggerExpand": "true",
+				"SuppressGenericExpand": "true",
+				quotaLocationMeta:       region.Name,
+			},
+		})
+	}
+
+	return ExpanderResult{
+		Nodes:             newItems,
+		Response:          ExpanderResponse{Response: data, ResponseType: ResponseJSON},
+		SourceDescription: "QuotaExpander request",
+		IsPrimaryResponse: true,
+	}
+}
+
+func (e *QuotaExpander) expandRegion(ctx context.Context, currentItem *TreeNode) ExpanderResult {
+	location := currentItem.Metadata[quotaLocationMeta]
+
+	newItems := []*TreeNode{}
+	nearLimit := []quotaUsage{}
+	var buf bytes.Buffer
+	for _, provider := range quotaProviders {
+		node := &TreeNode{
+			Parentid:       currentItem.ID,
+			Namespace:      "None",
+			Name:           provider.Display,
+			Display:        provider.Display,
+			ID:             currentItem.ID + "/" + provider.Name,
+			ExpandURL:      ExpandURLNotSupported,
+			ItemType:       quotasProviderType,
+			SubscriptionID: currentItem.SubscriptionID,
+			Metadata: map[string]string{
+				"SuppressSwaggerExpand": "true",
+				"SuppressGenericExpand": "true",
+				quotaLocationMeta:       location,
+				quotaProviderMeta:       provider.Name,
+			},
+		}
+		newItems = append(newItems, node)
+
+		// A provider failing (e.g. not registered) shouldn't stop the others being shown
+		_, usages, err := getQuotaUsages(ctx, e.client, currentItem.SubscriptionID, provider.Name, provider.APIVersion, location)
+		if err != nil {
+			fmt.Fprintf(&buf, "%s: %s\n", provider.Display, err)
+			continue
+		}
+		providerNearLimit := getQuotaUsagesNearLimit(usages)
+		if len(providerNearLimit) > 0 {
+			node.Display += "\n  " + style.Subtle(fmt.Sprintf("%d near limit", len(providerNearLimit)))
+			node.StatusIndicator = DrawStatus("NonCompliant")
+		}
+		nearLimit = append(nearLimit, providerNearLimit...)
+	}
+
+	response := fmt.Sprintf("No usage is over %d%% of its limit in %s\n", quotaWarningPercent, location)
+	if len(nearLimit) > 0 {
+		response = fmt.Sprintf("Usage over %d%% of its limit in %s:\n\n", quotaWarningPercent, location) + renderQuotaUsages(nearLimit)
+	}
+	if buf.Len() > 0 {
+		response += "\n" + buf.String()
+	}
+
+	return ExpanderResult{
+		Nodes:             newItems,
+		Response:          ExpanderResponse{Response: response, ResponseType: ResponsePlainText},
+		SourceDescription: "QuotaExpander request",
+		IsPrimaryResponse: true,
+	}
+}
+
+func (e *QuotaExpander) expandProvider(ctx context.Context, currentItem *TreeNode) ExpanderResult {
+	providerName := currentItem.Metadata[quotaProviderMeta]
+	apiVersion := ""
+	for _, provider := range quotaProviders {
+		if provider.Name == providerName {
+			apiVersion = provider.APIVersion
+		}
+	}
+
+	data, usages, err := getQuotaUsages(ctx, e.client, currentItem.SubscriptionID, providerName, apiVersion, currentItem.Metadata[quotaLocationMeta])
+	if err != nil {
+		return ExpanderResult{
+			Err:               err,
+			Response:          ExpanderResponse{Response: data, ResponseType: ResponseJSON},
+			SourceDescription: "QuotaExpander request",
+			IsPrimaryResponse: true,
+		}
+	}
+
+	return ExpanderResult{
+		Response:          ExpanderResponse{Response: renderQuotaUsages(usages), ResponseType: ResponsePlainText},
+		SourceDescription: "QuotaExpander request",
+		IsPrimaryResponse: true,
+	}
+}
+
+// getQuotaUsages returns the usages for a resource provider in a region, sorted by the percentage of the limit used
+func getQuotaUsages(ctx context.Context, client *armclient.Client, subscriptionID string, provider string, apiVersion string, location string) (string, []quotaUsage, error) {
+	data, err := client.DoRequest(ctx, "GET", "/subscriptions/"+subscriptionID+"/providers/"+provider+"/locations/"+location+"/usages?api-version="+apiVersion)
+	if err != nil {
+		return data, nil, fmt.Errorf("Failed retrieving usages: %s", err)
+	}
+	var usages struct {
+		Value []quotaUsage `json:"value"`
+	}
+	err = json.Unmarshal([]byte(data), &usages)
+	if err != nil {
+		return data, nil, fmt.Errorf("Error unmarshalling usages: %s", err)
+	}
+
+	sort.SliceStable(usages.Value, func(i, j int) bool {
+		return usages.Value[i].percent() > usages.Value[j].percent()
+	})
+	return data, usages.Value, nil
+}
+
+func getQuotaUsagesNearLimit(usages []quotaUsage) []quotaUsage {
+	nearLimit := []quotaUsage{}
+	for _, usage := range usages {
+		if usage.percent() >= quotaWarningPercent {
+			nearLimit = append(nearLimit, usage)
+		}
+	}
+	return nearLimit
+}
+
+// renderQuotaUsages renders the usages as a table highlighting those over quotaWarningPercent
+func renderQuotaUsages(usages []quotaUsage) string {
+	var buf bytes.Buffer
+	table := tabwriter.NewWriter(&buf, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(table, "Name\tUsage\tLimit\tPercent")
+	for _, usage := range usages {
+		name := usage.Name.LocalizedValue
+		if name == "" {
+			name = usage.Name.Value
+		}
+		fmt.Fprintf(table, "%s\t%.0f\t%.0f\t%.0f%%\n", name, usage.CurrentValue, usage.Limit, usage.percent())
+	}
+	_ = table.Flush()
+
+	// Highlight after laying out the table as the colour codes would throw out the column widths
+	lines := strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+	for index, usage := range usages {
+		if usage.percent() >= quotaWarningPercent {
+			lines[index+1] = style.Warning(lines[index+1])
+		}
+	}
+	return strings.Join(lines, "\n") + "\n"
+}
+
+func (e *QuotaExpander) testCases() (bool, *[]expanderTestCase) {
+	const subID = "00000000-0000-0000-0000-000000000000"
+	const testFolder = "./testdata/armsamples/quotas/"
+
+	quotasNode := newQuotasNode(&TreeNode{ID: "/subscriptions/" + subID, SubscriptionID: subID})
+	regionNode := &TreeNode{
+		ID:             quotasNode.ID + "/westeurope",
+		ItemType:       quotasRegionType,
+		SubscriptionID: subID,
+		Metadata: map[string]string{
+			quotaLocationMeta: "westeurope",
+		},
+	}
+
+	mockResponse := func(t *testing.T, fileName string) string {
+		data, err := ioutil.ReadFile(testFolder + fileName)
+		if err != nil {
+			t.Error(err)
+			t.FailNow()
+		}
+		return string(data)
+	}
+	locationsGockConfig := func(t *testing.T) {
+		gock.New("https://management.azure.com").
+			Get("/subscriptions/" + subID + "/locations").
+			Reply(200).
+			JSON(mockResponse(t, "locations.json"))
+	}
+	regionGockConfig := func(t *testing.T) {
+		gock.New("https://management.azure.com").
+			Get("/subscriptions/" + subID + "/providers/Microsoft.Compute/locations/westeurope/usages").
+			Reply(200).
+			JSON(mockResponse(t, "computeUsages.json"))
+		gock.New("https://management.azure.com").
+			Get("/subscriptions/" + subID + "/providers/Microsoft.Network/locations/westeurope/usages").
+			Reply(200).
+			JSON(mockResponse(t, "networkUsages.json"))
+		gock.New("https://management.azure.com").
+			Get("/subscriptions/" + subID + "/providers/Microsoft.Storage/locations/westeurope/usages").
+			Reply(404).
+			JSON(`{"error":{"code":"SubscriptionNotFound","message":"Subscription is not registered with Microsoft.Storage"}}`)
+	}
+
+	return true, &[]expanderTestCase{
+		{
+			name:              "Quotas->Regions",
+			nodeToExpand:      quotasNode,
+			statusCode:        200,
+			configureGockFunc: &locationsGockConfig,
+			treeNodeCheckerFunc: func(t *testing.T, r ExpanderResult) {
+				st.Expect(t, r.Err, nil)
+				// The logical "Europe" region is skipped and the rest sorted by name
+				st.Expect(t, len(r.Nodes), 2)
+				st.Expect(t, r.Nodes[0].Name, "North Europe")
+				st.Expect(t, r.Nodes[1].Metadata[quotaLocationMeta], "westeurope")
+			},
+		},
+		{
+			name:              "Quotas->Region",
+			nodeToExpand:      regionNode,
+			statusCode:        200,
+			configureGockFunc: &regionGockConfig,
+			treeNodeCheckerFunc: func(t *testing.T, r ExpanderResult) {
+				st.Expect(t, r.Err, nil)
+				st.Expect(t, len(r.Nodes), 3)
+				st.Expect(t, r.Nodes[0].StatusIndicator, DrawStatus("NonCompliant"))
+				st.Expect(t, r.Nodes[1].StatusIndicator, "")
+				st.Expect(t, r.Nodes[2].Metadata[quotaProviderMeta], "Microsoft.Storage")
+
+				lines := strings.Split(r.Response.Response, "\n")
+				st.Expect(t, lines[0], "Usage over 80% of its limit in westeurope:")
+				// Most used first, with the headings on line 2
+				st.Expect(t, strings.Contains(lines[3], "Total Regional vCPUs"), true)
+				st.Expect(t, strings.Contains(lines[4], "Standard DSv3 Family vCPUs"), true)
+				st.Expect(t, strings.Contains(r.Response.Response, "Storage: Failed retrieving usages"), true)
+			},
+		},
+	}
+}
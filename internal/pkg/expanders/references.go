@@ -0,0 +1,230 @@
+package expanders
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/lawrencegripper/azbrowse/internal/pkg/style"
+	"github.com/lawrencegripper/azbrowse/pkg/armclient"
+	"github.com/nbio/st"
+	"gopkg.in/h2non/gock.v1"
+)
+
+// Item types for the nodes added by the ReferencedByExpander
+const (
+	referencedByType         = "referencedBy"
+	referencedByResourceType = "referencedBy.resource"
+)
+
+// Metadata keys used on "Referenced by" nodes
+const (
+	referencedByScopeMeta = "ReferencedByScope" // the resource to find references to
+)
+
+// resourceIDRegex matches ARM resource IDs, eg /subscriptions/<guid>/resourceGroups/rg/providers/Microsoft.Network/virtualNetworks/vnet/subnets/default
+var resourceIDRegex = regexp.MustCompile(`(?i)/subscriptions/[0-9a-f]{8}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{12}(/resourceGroups/[^/"'\s]+)?/providers/[^/"'\s]+(/[^/"'\s?]+/[^/"'\s?]+)+`)
+
+// FindReferencedResourceIDs returns the IDs of the resources referenced in a response, in the order they first appear.
+// The current resource, its parents and its child resources are excluded
+func FindReferencedResourceIDs(response string, currentID string) []string {
+	current := strings.ToLower(strings.TrimSuffix(currentID, "/"))
+	seen := map[string]bool{}
+	ids := []string{}
+	for _, id := range resourceIDRegex.FindAllString(response, -1) {
+		lowerID := strings.ToLower(id)
+		if seen[lowerID] || isSameResourceTree(lowerID, current) {
+			continue
+		}
+		seen[lowerID] = true
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+// isSameResourceTree checks if either lower cased ID is the other or one of its parents
+func isSameResourceTree(id string, other string) bool {
+	if other == "" {
+		return false
+	}
+	return id == other || strings.HasPrefix(id, other+"/") || strings.HasPrefix(other, id+"/")
+}
+
+// Check interface
+var _ Expander = &ReferencedByExpander{}
+
+// ReferencedByExpander adds a "Referenced by" node to resources which uses Resource Graph
+// to list the resources whose properties contain the resource's ID
+type ReferencedByExpander struct {
+	ExpanderBase
+	client *armclient.Client
+}
+
+func (e *ReferencedByExpander) setClient(c *armclient.Client) {
+	e.client = c
+}
+
+// Name returns the name of the expander
+func (e *ReferencedByExpander) Name() string {
+	return "ReferencedByExpander"
+}
+
+// DoesExpand checks if this is a resource or a "Referenced by" node
+func (e *ReferencedByExpander) DoesExpand(ctx context.Context, currentItem *TreeNode) (bool, error) {
+	switch currentItem.ItemType {
+	case ResourceType, referencedByType:
+		return true, nil
+	}
+	return false, nil
+}
+
+// Expand adds the "Referenced by" node to a resource or lists the referencing resources for a "Referenced by" node
+func (e *ReferencedByExpander) Expand(ctx context.Context, currentItem *TreeNode) ExpanderResult {
+	if currentItem.ItemType == referencedByType {
+		return e.expandReferencedBy(ctx, currentItem)
+	}
+
+	return ExpanderResult{
+		Nodes:             []*TreeNode{newReferencedByNode(currentItem, currentItem.ID)},
+		Response:          ExpanderResponse{Response: ""}, // Swagger expander will supply the response
+		SourceDescription: "ReferencedByExpander request",
+		IsPrimaryResponse: false,
+	}
+}
+
+// newReferencedByNode creates the "Referenced by" node listing the resources which reference the resource
+func newReferencedByNode(parent *TreeNode, resourceID string) *TreeNode {
+	return &TreeNode{
+		Parentid:       parent.ID,
+		Namespace:      "None",
+		Display:        style.Subtle("[Microsoft.ResourceGraph]") + "\n  Referenced by",
+		Name:           "Referenced by",
+		ID:             resourceID + "/<referencedby>",
+		ExpandURL:      ExpandURLNotSupported,
+		ItemType:       referencedByType,
+		SubscriptionID: parent.SubscriptionID,
+		Metadata: map[string]string{
+			"SuppressSwaggerExpand": "true",
+			"SuppressGenericExpand": "true",
+			referencedByScopeMeta:   resourceID,
+		},
+	}
+}
+
+func (e *ReferencedByExpander) expandReferencedBy(ctx context.Context, currentItem *TreeNode) ExpanderResult {
+	resourceID := currentItem.Metadata[referencedByScopeMeta]
+	// The query is embedded in a JSON string by DoResourceGraphQuery so a quote in the ID
+	// is escaped for both KQL and JSON
+	quotedID := "'" + strings.Replace(resourceID, "'", "\\\\'", -1) + "'"
+	query := "where tostring(properties) contains " + quotedID + " and id !~ " + quotedID + " | project name, type, id | order by type asc, name asc"
+	data, err := e.client.DoResourceGraphQuery(ctx, currentItem.SubscriptionID, query)
+	if err != nil {
+		return ExpanderResult{
+			Err:               fmt.Errorf("Failed querying resource graph for references: %s", err),
+			Response:          ExpanderResponse{Response: data, ResponseType: ResponseJSON},
+			SourceDescription: "ReferencedByExpander request",
+			IsPrimaryResponse: true,
+		}
+	}
+
+	var result struct {
+		Data struct {
+			Rows [][]string `json:"rows"`
+		} `json:"data"`
+	}
+	err = json.Unmarshal([]byte(data), &result)
+	if err != nil {
+		return ExpanderResult{
+			Err:               fmt.Errorf("Error unmarshalling resource graph response: %s", err),
+			Response:          ExpanderResponse{Response: data, ResponseType: ResponseJSON},
+			SourceDescription: "ReferencedByExpander request",
+			IsPrimaryResponse: true,
+		}
+	}
+
+	newItems := []*TreeNode{}
+	for _, row := range result.Data.Rows {
+		if len(row) < 3 {
+			continue
+		}
+		name, resourceType, id := row[0], row[1], row[2]
+		newItems = append(newItems, &TreeNode{
+			Parentid:       currentItem.ID,
+			Namespace:      "None",
+			Name:           name,
+			Display:        name + "\n  " + style.Subtle("Type: "+resourceType),
+			ID:             currentItem.ID + id,
+			ExpandURL:      ExpandURLNotSupported,
+			ItemType:       referencedByResourceType,
+			SubscriptionID: currentItem.SubscriptionID,
+			Metadata: map[string]string{
+				"SuppressSwaggerExpand": "true",
+				"SuppressGenericExpand": "true",
+				// Selecting the resource takes you to it in the tree
+				NavigateToIDMeta: id,
+			},
+		})
+	}
+
+	return ExpanderResult{
+		Nodes:             newItems,
+		Response:          ExpanderResponse{Response: data, ResponseType: ResponseJSON},
+		SourceDescription: "ReferencedByExpander request",
+		IsPrimaryResponse: true,
+	}
+}
+
+func (e *ReferencedByExpander) testCases() (bool, *[]expanderTestCase) {
+	const subID = "00000000-0000-0000-0000-000000000000"
+	const vnetID = "/subscriptions/" + subID + "/resourceGroups/stable/providers/Microsoft.Network/virtualNetworks/vnet"
+	const testFolder = "./testdata/armsamples/references/"
+
+	vnetNode := &TreeNode{ID: vnetID, ItemType: ResourceType, SubscriptionID: subID}
+
+	noRequestGockConfig := func(t *testing.T) {}
+	referencedByGockConfig := func(t *testing.T) {
+		data, err := ioutil.ReadFile(testFolder + "referencedBy.json")
+		if err != nil {
+			t.Error(err)
+			t.FailNow()
+		}
+		gock.New("https://management.azure.com").
+			Post("/providers/Microsoft.ResourceGraph/resources").
+			Reply(200).
+			JSON(string(data))
+	}
+
+	return true, &[]expanderTestCase{
+		{
+			name:              "Resource->ReferencedByNode",
+			nodeToExpand:      vnetNode,
+			statusCode:        200,
+			configureGockFunc: &noRequestGockConfig,
+			treeNodeCheckerFunc: func(t *testing.T, r ExpanderResult) {
+				st.Expect(t, r.Err, nil)
+				st.Expect(t, r.IsPrimaryResponse, false)
+				st.Expect(t, len(r.Nodes), 1)
+				st.Expect(t, r.Nodes[0].ItemType, referencedByType)
+				st.Expect(t, r.Nodes[0].Metadata[referencedByScopeMeta], vnetID)
+			},
+		},
+		{
+			name:              "ReferencedBy->Resources",
+			nodeToExpand:      newReferencedByNode(vnetNode, vnetID),
+			statusCode:        200,
+			configureGockFunc: &referencedByGockConfig,
+			treeNodeCheckerFunc: func(t *testing.T, r ExpanderResult) {
+				st.Expect(t, r.Err, nil)
+				st.Expect(t, len(r.Nodes), 2)
+				st.Expect(t, r.Nodes[0].Name, "avm-nic")
+				st.Expect(t, r.Nodes[0].ItemType, referencedByResourceType)
+				st.Expect(t, r.Nodes[0].Metadata[NavigateToIDMeta], "/subscriptions/"+subID+"/resourceGroups/stable/providers/Microsoft.Network/networkInterfaces/avm-nic")
+				st.Expect(t, r.Nodes[1].Name, "peering-vnet")
+			},
+		},
+	}
+}
@@ -0,0 +1,33 @@
+package expanders
+
+import (
+	"testing"
+
+	"github.com/nbio/st"
+)
+
+func TestFindReferencedResourceIDs(t *testing.T) {
+	const rgID = "/subscriptions/00000000-0000-0000-0000-000000000000/resourceGroups/stable"
+	const nicID = rgID + "/providers/Microsoft.Network/networkInterfaces/avm-nic"
+	response := `{
+		"id": "` + nicID + `",
+		"properties": {
+			"ipConfigurations": [{
+				"id": "` + nicID + `/ipConfigurations/ipconfig1",
+				"properties": {
+					"subnet": {"id": "` + rgID + `/providers/Microsoft.Network/virtualNetworks/vnet/subnets/default"},
+					"publicIPAddress": {"id": "` + rgID + `/providers/Microsoft.Network/publicIPAddresses/avm-ip"}
+				}
+			}],
+			"virtualMachine": {"id": "` + rgID + `/providers/Microsoft.Compute/virtualMachines/avm"},
+			"networkSecurityGroup": {"id": "/SUBSCRIPTIONS/00000000-0000-0000-0000-000000000000/RESOURCEGROUPS/STABLE/PROVIDERS/MICROSOFT.NETWORK/PUBLICIPADDRESSES/AVM-IP"}
+		}
+	}`
+
+	ids := FindReferencedResourceIDs(response, nicID)
+	st.Expect(t, ids, []string{
+		rgID + "/providers/Microsoft.Network/virtualNetworks/vnet/subnets/default",
+		rgID + "/providers/Microsoft.Network/publicIPAddresses/avm-ip",
+		rgID + "/providers/Microsoft.Compute/virtualMachines/avm",
+	})
+}
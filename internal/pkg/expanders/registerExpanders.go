@@ -56,6 +56,7 @@ func InitializeExpanders(client *armclient.Client) {
 			client: client,
 		},
 		&JSONExpander{},
+		&LoadMoreExpander{},
 		&StorageManagementPoliciesExpander{}, // Needs to be registered after SwaggerResourceExpander as it depends on SwaggerResourceType being set
 		NewContainerRegistryExpander(client), // Needs to be registered after SwaggerResourceExpander as it depends on SwaggerResourceType being set
 		NewStorageBlobExpander(client),       // Needs to be registered after SwaggerResourceExpander as it depends on SwaggerResourceType being set
@@ -77,6 +78,45 @@ func InitializeExpanders(client *armclient.Client) {
 		&DiagnosticSettingsExpander{
 			client: client,
 		},
+		&LogAnalyticsExpander{
+			client: client,
+		}, // Needs to be registered after SwaggerResourceExpander as it depends on SwaggerResourceType being set
+		&RoleAssignmentsExpander{
+			client: client,
+		},
+		&PolicyExpander{
+			client: client,
+		},
+		&LocksExpander{
+			client: client,
+		},
+		&ManagementGroupsExpander{
+			client: client,
+		},
+		&AppServiceExpander{
+			client: client,
+		}, // Needs to be registered after SwaggerResourceExpander as it depends on SwaggerResourceType being set
+		&VirtualMachineExpander{
+			client: client,
+		},
+		&NetworkExpander{
+			client: client,
+		}, // Needs to be registered after SwaggerResourceExpander as it depends on SwaggerResourceType being set
+		&CostExpander{
+			client: client,
+		},
+		&HealthExpander{
+			client: client,
+		},
+		&AdvisorExpander{
+			client: client,
+		},
+		&ReferencedByExpander{
+			client: client,
+		},
+		&QuotaExpander{
+			client: client,
+		},
 	}
 }
 
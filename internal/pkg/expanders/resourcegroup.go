@@ -23,6 +23,10 @@ import (
 // Check interface
 var _ Expander = &ResourceGroupResourceExpander{}
 
+// indicatorLookupTimeout is how long each of the policy, health and advisor lookups has to complete
+// before the resources are listed without its indicators. The lookups start alongside the ARM request
+const indicatorLookupTimeout = 3 * time.Second
+
 // ResourceGroupResourceExpander expands resource under an RG
 type ResourceGroupResourceExpander struct {
 	ExpanderBase
@@ -60,7 +64,7 @@ func (e *ResourceGroupResourceExpander) Expand(ctx context.Context, currentItem
 		defer errorhandling.RecoveryWithCleanup()
 
 		// Use resource graph to enrich response
-		query := "where resourceGroup=='" + currentItem.Name + "' | project name, id, sku, kind, location, tags, properties.provisioningState"
+		query := "where resourceGroup=='" + currentItem.Name + "' | project name, id, sku, kind, location, tags, properties.provisioningState, properties.extended.instanceView.powerState.code"
 		queryData, err := e.client.DoResourceGraphQuery(ctx, currentItem.SubscriptionID, query)
 		span.SetTag("queryResponse", queryData)
 		span.SetTag("queryError", err)
@@ -92,6 +96,12 @@ func (e *ResourceGroupResourceExpander) Expand(ctx context.Context, currentItem
 				panic(err)
 			}
 			currentState := string(rowValues[6].GetStringBytes())
+			// Show whether VMs are running once they have provisioned
+			if len(rowValues) > 7 && currentState == "Succeeded" {
+				if powerState := string(rowValues[7].GetStringBytes()); powerState != "" {
+					currentState = powerState
+				}
+			}
 			itemID := string(rowValues[1].GetStringBytes())
 			stateMap[itemID] = currentState
 		}
@@ -100,6 +110,53 @@ func (e *ResourceGroupResourceExpander) Expand(ctx context.Context, currentItem
 		span.SetTag("stateMap", stateMap)
 	}()
 
+	// Policy compliance is also a value add so is looked up alongside the ARM request.
+	// Each lookup has its own timeout so a slow one doesn't stop the others being shown
+	nonCompliantChan := make(chan map[string]bool, 1)
+	go func() {
+		// recover from panic, if one occurrs, and leave terminal usable
+		defer errorhandling.RecoveryWithCleanup()
+
+		lookupCtx, cancel := context.WithTimeout(ctx, indicatorLookupTimeout)
+		defer cancel()
+		nonCompliant, err := GetNonCompliantResourceIDs(lookupCtx, e.client, currentItem.ID)
+		span.SetTag("policyError", err)
+		span.SetTag("policyQueryTimedout", lookupCtx.Err() == context.DeadlineExceeded)
+		nonCompliantChan <- nonCompliant
+	}()
+
+	// As is Resource Health, which flags resources which are degraded or unavailable
+	unhealthyChan := make(chan map[string]string, 1)
+	go func() {
+		// recover from panic, if one occurrs, and leave terminal usable
+		defer errorhandling.RecoveryWithCleanup()
+
+		lookupCtx, cancel := context.WithTimeout(ctx, indicatorLookupTimeout)
+		defer cancel()
+		unhealthy, err := GetUnhealthyResourceIDs(lookupCtx, e.client, currentItem.ID)
+		span.SetTag("healthError", err)
+		span.SetTag("healthQueryTimedout", lookupCtx.Err() == context.DeadlineExceeded)
+		unhealthyChan <- unhealthy
+	}()
+
+	// Advisor recommendations are only looked up when the indicators are turned on
+	recommendedChan := make(chan map[string]string, 1)
+	if ShowAdvisorIndicators {
+		go func() {
+			// recover from panic, if one occurrs, and leave terminal usable
+			defer errorhandling.RecoveryWithCleanup()
+
+			lookupCtx, cancel := context.WithTimeout(ctx, indicatorLookupTimeout)
+			defer cancel()
+			recommended, err := GetAdvisorRecommendedResourceIDs(lookupCtx, e.client, currentItem.SubscriptionID, currentItem.ID)
+			span.SetTag("advisorError", err)
+			span.SetTag("advisorQueryTimedout", lookupCtx.Err() == context.DeadlineExceeded)
+			recommendedChan <- recommended
+		}()
+	} else {
+		recommendedChan <- nil
+	}
+
 	// Add deployment item
 	newItems := []*TreeNode{}
 	newItems = append(newItems, &TreeNode{
@@ -127,6 +184,13 @@ func (e *ResourceGroupResourceExpander) Expand(ctx context.Context, currentItem
 		SubscriptionID: currentItem.SubscriptionID,
 	})
 
+	// Add Access control (IAM), Policy, Locks, Cost and Advisor items
+	newItems = append(newItems, newRoleAssignmentsNode(currentItem, currentItem.ID))
+	newItems = append(newItems, newPolicyNode(currentItem, currentItem.ID))
+	newItems = append(newItems, newLocksNode(currentItem, currentItem.ID))
+	newItems = append(newItems, newCostNode(currentItem, currentItem.ID))
+	newItems = append(newItems, newAdvisorNode(currentItem, currentItem.ID))
+
 	// Get the latest from the ARM API
 	method := "GET"
 	responseChan := e.client.DoRequestAsync(ctx, method, currentItem.ExpandURL)
@@ -164,6 +228,12 @@ func (e *ResourceGroupResourceExpander) Expand(ctx context.Context, currentItem
 	wg.Wait()
 	// .....
 
+	// The policy, health and advisor lookups give up after indicatorLookupTimeout so these always complete.
+	// A lookup which failed returns nil, which is safe to read from
+	nonCompliant := <-nonCompliantChan
+	unhealthy := <-unhealthyChan
+	recommended := <-recommendedChan
+
 	err := armResponse.Error
 
 	if err != nil {
@@ -210,12 +280,24 @@ func (e *ResourceGroupResourceExpander) Expand(ctx context.Context, currentItem
 			ItemType:         ResourceType,
 			DeleteURL:        resourceIdWithVersion,
 			SubscriptionID:   currentItem.SubscriptionID,
+			Metadata: map[string]string{
+				resourceKindMeta: resource.Kind,
+			},
 		}
 
 		state, exists := stateMap[item.ID]
 		if exists {
 			item.StatusIndicator = DrawStatus(state)
 		}
+		if nonCompliant[strings.ToLower(item.ID)] {
+			item.StatusIndicator = strings.TrimSpace(item.StatusIndicator + " " + DrawStatus("NonCompliant"))
+		}
+		if health, found := unhealthy[strings.ToLower(item.ID)]; found {
+			item.StatusIndicator = strings.TrimSpace(item.StatusIndicator + " " + DrawStatus("Health/"+health))
+		}
+		if impact, found := recommended[strings.ToLower(item.ID)]; found {
+			item.StatusIndicator = strings.TrimSpace(item.StatusIndicator + " " + DrawStatus("Advisor/"+impact))
+		}
 
 		resourceTreeItems = append(resourceTreeItems, item)
 	}
@@ -267,6 +349,53 @@ func (e *ResourceGroupResourceExpander) testCases() (bool, *[]expanderTestCase)
 			JSON(expectedJSONResponse)
 	}
 
+	// The resource group is expanded with the policy, health and advisor indicators and the
+	// resource graph query returning the provisioning and power states
+	const rgID = "/subscriptions/00000000-0000-0000-0000-000000000000/resourceGroups/1testrg"
+	const storageID = rgID + "/providers/Microsoft.Storage/storageAccounts/1teststorageaccount"
+	const vmssID = rgID + "/providers/Microsoft.Compute/virtualMachineScaleSets/aks-nodepool1-27758783-vmss"
+	const nsgID = rgID + "/providers/Microsoft.Network/networkSecurityGroups/aks-agentpool-27758783-nsg"
+	const vnetID = rgID + "/providers/Microsoft.Network/virtualNetworks/aks-vnet-27758783"
+	const routeTableID = rgID + "/providers/Microsoft.Network/routeTables/aks-agentpool-27758783-routetable"
+	rgItemToExpand := &TreeNode{
+		ID:             rgID,
+		Name:           "1testrg",
+		ItemType:       resourceGroupType,
+		ExpandURL:      "https://management.azure.com/" + expandURL,
+		SubscriptionID: "00000000-0000-0000-0000-000000000000",
+	}
+
+	indicatorsGockConfig := func(t *testing.T) {
+		ShowAdvisorIndicators = true // turned off again by the checker
+		gockConfig(t)
+		gock.New("https://management.azure.com").
+			Post("/providers/Microsoft.ResourceGraph/resources").
+			Reply(200).
+			JSON(`{"data": {"rows": [
+				["aks-nodepool1-27758783-vmss", "` + vmssID + `", null, null, "westeurope", null, "Succeeded", "PowerState/running"],
+				["aks-agentpool-27758783-nsg", "` + nsgID + `", null, null, "westeurope", null, "Succeeded", ""]
+			]}}`)
+		gock.New("https://management.azure.com").
+			Post(rgID + "/providers/Microsoft.PolicyInsights/policyStates/latest/queryResults").
+			Reply(200).
+			JSON(`{"value": [{"resourceId": "` + strings.ToUpper(storageID) + `"}]}`)
+		gock.New("https://management.azure.com").
+			Get(rgID + "/providers/Microsoft.ResourceHealth/availabilityStatuses").
+			Reply(200).
+			JSON(`{"value": [
+				{"id": "` + nsgID + `/providers/Microsoft.ResourceHealth/availabilityStatuses/current", "properties": {"availabilityState": "Unavailable"}},
+				{"id": "` + routeTableID + `/providers/Microsoft.ResourceHealth/availabilityStatuses/current", "properties": {"availabilityState": "Available"}}
+			]}`)
+		gock.New("https://management.azure.com").
+			Get("/subscriptions/00000000-0000-0000-0000-000000000000/providers/Microsoft.Advisor/recommendations").
+			MatchParam("$filter", "^ResourceGroup eq '1testrg'$").
+			Reply(200).
+			JSON(`{"value": [
+				{"properties": {"impact": "Low", "resourceMetadata": {"resourceId": "` + vnetID + `"}}},
+				{"properties": {"impact": "High", "resourceMetadata": {"resourceId": "` + vnetID + `"}}}
+			]}`)
+	}
+
 	return true, &[]expanderTestCase{
 		{
 			name:              "ResourceGroup->Resources",
@@ -278,13 +407,35 @@ func (e *ResourceGroupResourceExpander) testCases() (bool, *[]expanderTestCase)
 			treeNodeCheckerFunc: func(t *testing.T, r ExpanderResult) {
 				st.Expect(t, r.Err, nil)
 
-				// Logs, Diagnostic settings and deployment always added to an RG
-				additionalItemsAddedToRG := 3
+				// Logs, IAM, Policy, Locks, Cost, Advisor, Diagnostic settings and deployment always added to an RG
+				additionalItemsAddedToRG := 8
 
 				st.Expect(t, len(r.Nodes), 10+additionalItemsAddedToRG)
 
 				// Validate content
-				st.Expect(t, r.Nodes[3].Name, "1teststorageaccount")
+				st.Expect(t, r.Nodes[additionalItemsAddedToRG].Name, "1teststorageaccount")
+			},
+		},
+		{
+			name:              "ResourceGroup->Resources with status indicators",
+			statusCode:        200,
+			responseFile:      testResponseFile,
+			nodeToExpand:      rgItemToExpand,
+			urlPath:           expandURL,
+			configureGockFunc: &indicatorsGockConfig,
+			treeNodeCheckerFunc: func(t *testing.T, r ExpanderResult) {
+				ShowAdvisorIndicators = false
+				st.Expect(t, r.Err, nil)
+
+				indicators := map[string]string{}
+				for _, node := range r.Nodes {
+					indicators[node.ID] = node.StatusIndicator
+				}
+				st.Expect(t, indicators[storageID], DrawStatus("NonCompliant"))
+				st.Expect(t, indicators[vmssID], DrawStatus("PowerState/running"))
+				st.Expect(t, indicators[nsgID], DrawStatus("Succeeded")+" "+DrawStatus("Health/Unavailable"))
+				st.Expect(t, indicators[vnetID], DrawStatus("Advisor/High"))
+				st.Expect(t, indicators[routeTableID], "")
 			},
 		},
 	}
@@ -0,0 +1,337 @@
+package expanders
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/lawrencegripper/azbrowse/internal/pkg/style"
+	"github.com/lawrencegripper/azbrowse/pkg/armclient"
+	"github.com/nbio/st"
+	uuid "github.com/satori/go.uuid"
+	"gopkg.in/h2non/gock.v1"
+)
+
+const (
+	roleAssignmentsAPIVersion = "2018-09-01-preview"
+	roleDefinitionsAPIVersion = "2018-01-01-preview"
+)
+
+// RoleAssignmentsType identifies the "Access control (IAM)" node added to subscriptions, resource groups and resources
+const RoleAssignmentsType = "roleAssignments"
+
+// RoleAssignmentType identifies a single role assignment
+const RoleAssignmentType = "roleAssignment"
+
+// Metadata keys used on role assignment nodes
+const (
+	RoleAssignmentScopeMeta     = "Scope"       // the scope the assignments are listed for
+	RoleAssignmentInheritedMeta = "Inherited"   // "true" when the assignment is made at a parent scope
+	RoleAssignmentPrincipalMeta = "PrincipalID" // the object ID of the assigned principal
+)
+
+// RoleAssignmentPrincipalTypes lists the principal types which can be assigned a role
+var RoleAssignmentPrincipalTypes = []string{"User", "Group", "ServicePrincipal"}
+
+// RoleAssignmentListResponse is returned when listing role assignments at a scope
+type RoleAssignmentListResponse struct {
+	Value []struct {
+		ID         string `json:"id"`
+		Name       string `json:"name"`
+		Properties struct {
+			RoleDefinitionID string `json:"roleDefinitionId"`
+			PrincipalID      string `json:"principalId"`
+			PrincipalType    string `json:"principalType"`
+			Scope            string `json:"scope"`
+		} `json:"properties"`
+	} `json:"value"`
+}
+
+// RoleDefinition is a role which can be assigned at a scope
+type RoleDefinition struct {
+	ID         string `json:"id"`
+	Name       string `json:"name"`
+	Properties struct {
+		RoleName    string `json:"roleName"`
+		Type        string `json:"type"`
+		Description string `json:"description"`
+	} `json:"properties"`
+}
+
+// Check interface
+var _ Expander = &RoleAssignmentsExpander{}
+
+// RoleAssignmentsExpander adds an "Access control (IAM)" node to resources and lists the role assignments at a scope
+type RoleAssignmentsExpander struct {
+	ExpanderBase
+	client *armclient.Client
+}
+
+func (e *RoleAssignmentsExpander) setClient(c *armclient.Client) {
+	e.client = c
+}
+
+// Name returns the name of the expander
+func (e *RoleAssignmentsExpander) Name() string {
+	return "RoleAssignmentsExpander"
+}
+
+// DoesExpand checks if this is a resource or an IAM node
+func (e *RoleAssignmentsExpander) DoesExpand(ctx context.Context, currentItem *TreeNode) (bool, error) {
+	if currentItem.ItemType == ResourceType || currentItem.ItemType == RoleAssignmentsType {
+		return true, nil
+	}
+	return false, nil
+}
+
+// Expand adds the IAM node to a resource or lists the role assignments for an IAM node
+func (e *RoleAssignmentsExpander) Expand(ctx context.Context, currentItem *TreeNode) ExpanderResult {
+	if currentItem.ItemType == RoleAssignmentsType {
+		return e.expandRoleAssignments(ctx, currentItem)
+	}
+
+	return ExpanderResult{
+		Nodes:             []*TreeNode{newRoleAssignmentsNode(currentItem, currentItem.ID)},
+		Response:          ExpanderResponse{Response: ""}, // Swagger expander will supply the response
+		SourceDescription: "RoleAssignmentsExpander request",
+		IsPrimaryResponse: false,
+	}
+}
+
+// newRoleAssignmentsNode creates the "Access control (IAM)" node listing role assignments at scope
+func newRoleAssignmentsNode(parent *TreeNode, scope string) *TreeNode {
+	return &TreeNode{
+		Parentid:       parent.ID,
+		Namespace:      "None",
+		Display:        style.Subtle("[Microsoft.Authorization]") + "\n  Access control (IAM)",
+		Name:           "Access control (IAM)",
+		ID:             scope + "/<iam>",
+		ExpandURL:      ExpandURLNotSupported,
+		ItemType:       RoleAssignmentsType,
+		SubscriptionID: parent.SubscriptionID,
+		Metadata: map[string]string{
+			"SuppressSwaggerExpand": "true",
+			"SuppressGenericExpand": "true",
+			RoleAssignmentScopeMeta: scope,
+		},
+	}
+}
+
+func (e *RoleAssignmentsExpander) expandRoleAssignments(ctx context.Context, currentItem *TreeNode) ExpanderResult {
+	scope := currentItem.Metadata[RoleAssignmentScopeMeta]
+
+	// atScope() returns assignments at this scope and the scopes above it, but not those below
+	data, err := e.client.DoRequest(ctx, "GET", scope+"/providers/Microsoft.Authorization/roleAssignments?$filter=atScope()&api-version="+roleAssignmentsAPIVersion)
+	if err != nil {
+		return ExpanderResult{
+			Err:               fmt.Errorf("Failed retrieving role assignments: %s", err),
+			Response:          ExpanderResponse{Response: data, ResponseType: ResponseJSON},
+			SourceDescription: "RoleAssignmentsExpander request",
+			IsPrimaryResponse: true,
+		}
+	}
+
+	var assignments RoleAssignmentListResponse
+	err = json.Unmarshal([]byte(data), &assignments)
+	if err != nil {
+		return ExpanderResult{
+			Err:               fmt.Errorf("Error unmarshalling role assignments: %s", err),
+			Response:          ExpanderResponse{Response: data, ResponseType: ResponseJSON},
+			SourceDescription: "RoleAssignmentsExpander request",
+			IsPrimaryResponse: true,
+		}
+	}
+
+	// Resolving role names is a nice to have so fall back to the definition ID if it fails
+	roleNames := map[string]string{}
+	roleDefinitions, err := ListRoleDefinitions(ctx, e.client, scope)
+	if err == nil {
+		for _, roleDefinition := range roleDefinitions {
+			roleNames[strings.ToLower(roleDefinition.Name)] = roleDefinition.Properties.RoleName
+		}
+	}
+
+	newItems := []*TreeNode{}
+	for _, assignment := range assignments.Value {
+		roleDefinitionID := assignment.Properties.RoleDefinitionID
+		roleName, found := roleNames[strings.ToLower(lastSegment(roleDefinitionID))]
+		if !found {
+			roleName = lastSegment(roleDefinitionID)
+		}
+
+		principalType := assignment.Properties.PrincipalType
+		if principalType == "" {
+			principalType = "Unknown"
+		}
+		display := roleName + "\n  " + style.Subtle("Principal: "+assignment.Properties.PrincipalID+" ("+principalType+")")
+
+		assignmentURL := assignment.ID + "?api-version=" + roleAssignmentsAPIVersion
+		deleteURL := assignmentURL
+		inherited := !strings.EqualFold(strings.TrimSuffix(assignment.Properties.Scope, "/"), strings.TrimSuffix(scope, "/"))
+		if inherited {
+			// Inherited assignments have to be removed at the scope they were made
+			display += "\n  " + style.Subtle("Inherited from: "+assignment.Properties.Scope)
+			deleteURL = ""
+		}
+
+		newItems = append(newItems, &TreeNode{
+			Parentid:       currentItem.ID,
+			Namespace:      "None",
+			Name:           roleName,
+			Display:        display,
+			ID:             assignment.ID,
+			ExpandURL:      assignmentURL,
+			DeleteURL:      deleteURL,
+			ItemType:       RoleAssignmentType,
+			SubscriptionID: currentItem.SubscriptionID,
+			Metadata: map[string]string{
+				"SuppressSwaggerExpand":     "true",
+				RoleAssignmentScopeMeta:     assignment.Properties.Scope,
+				RoleAssignmentInheritedMeta: fmt.Sprintf("%t", inherited),
+				RoleAssignmentPrincipalMeta: assignment.Properties.PrincipalID,
+			},
+		})
+	}
+
+	return ExpanderResult{
+		Nodes:             newItems,
+		Response:          ExpanderResponse{Response: data, ResponseType: ResponseJSON},
+		SourceDescription: "RoleAssignmentsExpander request",
+		IsPrimaryResponse: true,
+	}
+}
+
+func lastSegment(id string) string {
+	return id[strings.LastIndex(id, "/")+1:]
+}
+
+// ListRoleDefinitions returns the roles which can be assigned at scope, sorted by name
+func ListRoleDefinitions(ctx context.Context, client *armclient.Client, scope string) ([]RoleDefinition, error) {
+	data, err := client.DoRequest(ctx, "GET", scope+"/providers/Microsoft.Authorization/roleDefinitions?api-version="+roleDefinitionsAPIVersion)
+	if err != nil {
+		return nil, fmt.Errorf("Failed retrieving role definitions: %s", err)
+	}
+
+	var response struct {
+		Value []RoleDefinition `json:"value"`
+	}
+	err = json.Unmarshal([]byte(data), &response)
+	if err != nil {
+		return nil, fmt.Errorf("Error unmarshalling role definitions: %s", err)
+	}
+
+	sort.Slice(response.Value, func(i, j int) bool {
+		return strings.ToLower(response.Value[i].Properties.RoleName) < strings.ToLower(response.Value[j].Properties.RoleName)
+	})
+	return response.Value, nil
+}
+
+// CreateRoleAssignment assigns the role to the principal at scope
+func CreateRoleAssignment(ctx context.Context, client *armclient.Client, scope string, roleDefinitionID string, principalID string, principalType string) error {
+	body, err := json.Marshal(map[string]interface{}{
+		"properties": map[string]string{
+			"roleDefinitionId": roleDefinitionID,
+			"principalId":      principalID,
+			"principalType":    principalType,
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	assignmentURL := scope + "/providers/Microsoft.Authorization/roleAssignments/" + uuid.NewV4().String() + "?api-version=" + roleAssignmentsAPIVersion
+	data, err := client.DoRequestWithBody(ctx, "PUT", assignmentURL, string(body))
+	if err != nil {
+		return fmt.Errorf("Failed creating role assignment: %s %s", err, data)
+	}
+	return nil
+}
+
+// DeleteRoleAssignment removes a role assignment
+func DeleteRoleAssignment(ctx context.Context, client *armclient.Client, assignmentID string) error {
+	data, err := client.DoRequest(ctx, "DELETE", assignmentID+"?api-version="+roleAssignmentsAPIVersion)
+	if err != nil {
+		return fmt.Errorf("Failed removing role assignment: %s %s", err, data)
+	}
+	return nil
+}
+
+func (e *RoleAssignmentsExpander) testCases() (bool, *[]expanderTestCase) {
+	const rgID = "/subscriptions/00000000-0000-0000-0000-000000000000/resourceGroups/stable"
+	const assignmentsResponseFile = "./testdata/armsamples/roleAssignments/assignments.json"
+	const definitionsResponseFile = "./testdata/armsamples/roleAssignments/roleDefinitions.json"
+
+	iamNode := newRoleAssignmentsNode(&TreeNode{ID: rgID, SubscriptionID: "00000000-0000-0000-0000-000000000000"}, rgID)
+	assignmentsGockConfig := func(t *testing.T) {
+		assignments, err := ioutil.ReadFile(assignmentsResponseFile)
+		if err != nil {
+			t.Error(err)
+			t.FailNow()
+		}
+		definitions, err := ioutil.ReadFile(definitionsResponseFile)
+		if err != nil {
+			t.Error(err)
+			t.FailNow()
+		}
+		gock.New("https://management.azure.com").
+			Get(rgID+"/providers/Microsoft.Authorization/roleAssignments").
+			MatchParam("$filter", `atScope\(\)`).
+			Reply(200).
+			JSON(string(assignments))
+		gock.New("https://management.azure.com").
+			Get(rgID + "/providers/Microsoft.Authorization/roleDefinitions").
+			Reply(200).
+			JSON(string(definitions))
+	}
+
+	noRequestGockConfig := func(t *testing.T) {}
+
+	return true, &[]expanderTestCase{
+		{
+			name: "Resource->IAMNode",
+			nodeToExpand: &TreeNode{
+				ID:       rgID + "/providers/Microsoft.Storage/storageAccounts/astorageaccount",
+				ItemType: ResourceType,
+			},
+			statusCode:        200,
+			configureGockFunc: &noRequestGockConfig,
+			treeNodeCheckerFunc: func(t *testing.T, r ExpanderResult) {
+				st.Expect(t, r.Err, nil)
+				st.Expect(t, r.IsPrimaryResponse, false)
+				st.Expect(t, len(r.Nodes), 1)
+				st.Expect(t, r.Nodes[0].ItemType, RoleAssignmentsType)
+				st.Expect(t, r.Nodes[0].Metadata[RoleAssignmentScopeMeta], rgID+"/providers/Microsoft.Storage/storageAccounts/astorageaccount")
+			},
+		},
+		{
+			name:              "IAMNode->RoleAssignments",
+			nodeToExpand:      iamNode,
+			statusCode:        200,
+			configureGockFunc: &assignmentsGockConfig,
+			treeNodeCheckerFunc: func(t *testing.T, r ExpanderResult) {
+				st.Expect(t, r.Err, nil)
+				st.Expect(t, r.IsPrimaryResponse, true)
+				st.Expect(t, len(r.Nodes), 3)
+
+				// Assigned at the RG
+				st.Expect(t, r.Nodes[0].Name, "Contributor")
+				st.Expect(t, r.Nodes[0].Metadata[RoleAssignmentInheritedMeta], "false")
+				st.Expect(t, r.Nodes[0].Metadata[RoleAssignmentPrincipalMeta], "11111111-1111-1111-1111-111111111111")
+				st.Expect(t, r.Nodes[0].DeleteURL, r.Nodes[0].ID+"?api-version="+roleAssignmentsAPIVersion)
+
+				// Inherited from the subscription
+				st.Expect(t, r.Nodes[1].Name, "Reader")
+				st.Expect(t, r.Nodes[1].Metadata[RoleAssignmentInheritedMeta], "true")
+				st.Expect(t, r.Nodes[1].DeleteURL, "")
+				st.Expect(t, strings.Contains(r.Nodes[1].Display, "Inherited from: /subscriptions/00000000-0000-0000-0000-000000000000"), true)
+
+				// Unknown role definitions fall back to the ID
+				st.Expect(t, r.Nodes[2].Name, "99999999-9999-9999-9999-999999999999")
+			},
+		},
+	}
+}
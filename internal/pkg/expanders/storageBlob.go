@@ -221,7 +221,7 @@ func (e *StorageBlobExpander) expandMetadataList(ctx context.Context, currentIte
 
 			return &node, nil
 		},
-		storageBlobNodeListBlobMetadata)
+		"")
 }
 
 func (e *StorageBlobExpander) expandBlobList(ctx context.Context, currentItem *TreeNode) ExpanderResult {
@@ -246,10 +246,11 @@ func (e *StorageBlobExpander) expandBlobList(ctx context.Context, currentItem *T
 
 			return &node, nil
 		},
-		storageBlobNodeListBlob)
+		"")
 }
 
-func (e *StorageBlobExpander) expandList(ctx context.Context, currentItem *TreeNode, createNodeFunc func(currentItem *TreeNode, blob Blob) (*TreeNode, error), continuationItemType string) ExpanderResult {
+// expandList lists the blobs starting from marker, setting the continuation token to the next marker if there are more blobs
+func (e *StorageBlobExpander) expandList(ctx context.Context, currentItem *TreeNode, createNodeFunc func(currentItem *TreeNode, blob Blob) (*TreeNode, error), marker string) ExpanderResult {
 
 	// https://docs.microsoft.com/en-us/rest/api/storageservices/enumerating-blob-resources#Subheading5
 
@@ -257,7 +258,6 @@ func (e *StorageBlobExpander) expandList(ctx context.Context, currentItem *TreeN
 	containerName := e.getContainerName(containerID)
 	accountName := e.getAccountName(containerID)
 	accountKey, err := e.getAccountKey(ctx, containerID)
-	marker := currentItem.Metadata["Marker"]
 	if err != nil {
 		err = fmt.Errorf("Error getting account key: %s", err)
 		return ExpanderResult{
@@ -313,33 +313,19 @@ func (e *StorageBlobExpander) expandList(ctx context.Context, currentItem *TreeN
 
 		nodes = append(nodes, node)
 	}
-	if response.NextMarker != "" {
-		node := TreeNode{
-			Parentid:  currentItem.ID,
-			Namespace: "storageBlob",
-			ID:        currentItem.ID + "/" + "...more",
-			Name:      "more...",
-			Display:   "more...",
-			ItemType:  continuationItemType,
-			ExpandURL: ExpandURLNotSupported,
-			Metadata: map[string]string{
-				"ContainerID": containerID, // save resourceID of blob
-				"AccountName": accountName,
-				"AccountKey":  accountKey,
-				"Marker":      response.NextMarker,
-			},
-		}
-
-		nodes = append(nodes, &node)
-	}
-
-	result := string(buf)
-	return ExpanderResult{
-		Response:          ExpanderResponse{Response: result, ResponseType: ResponseXML},
+	result := ExpanderResult{
+		Response:          ExpanderResponse{Response: string(buf), ResponseType: ResponseXML},
 		SourceDescription: "StorageBlobExpander request",
 		Nodes:             nodes,
 		IsPrimaryResponse: true,
 	}
+	if response.NextMarker != "" {
+		result.ContinuationToken = response.NextMarker
+		result.LoadMore = func(ctx context.Context, marker string) ExpanderResult {
+			return e.expandList(ctx, currentItem, createNodeFunc, marker)
+		}
+	}
+	return result
 }
 
 func (e *StorageBlobExpander) expandMetadata(ctx context.Context, currentItem *TreeNode) ExpanderResult {
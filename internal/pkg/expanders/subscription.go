@@ -47,6 +47,15 @@ func (e *SubscriptionExpander) Expand(ctx context.Context, currentItem *TreeNode
 			panic(err)
 		}
 
+		// Add Access control (IAM), Policy, Locks, Cost, Service Health, Advisor and Usage & quotas items
+		newItems = append(newItems, newRoleAssignmentsNode(currentItem, currentItem.ID))
+		newItems = append(newItems, newPolicyNode(currentItem, currentItem.ID))
+		newItems = append(newItems, newLocksNode(currentItem, currentItem.ID))
+		newItems = append(newItems, newCostNode(currentItem, currentItem.ID))
+		newItems = append(newItems, newServiceHealthNode(currentItem, currentItem.ID))
+		newItems = append(newItems, newAdvisorNode(currentItem, currentItem.ID))
+		newItems = append(newItems, newQuotasNode(currentItem))
+
 		for _, rg := range rgResponse.Groups {
 			newItems = append(newItems, &TreeNode{
 				Name:             rg.Name,
@@ -105,11 +114,19 @@ func (e *SubscriptionExpander) testCases() (bool, *[]expanderTestCase) {
 			statusCode:   200,
 			treeNodeCheckerFunc: func(t *testing.T, r ExpanderResult) {
 				st.Expect(t, r.Err, nil)
-				st.Expect(t, len(r.Nodes), 6)
+				// IAM, Policy, Locks, Cost, Service Health, Advisor and Usage & quotas nodes are added ahead of the RGs
+				st.Expect(t, len(r.Nodes), 13)
+				st.Expect(t, r.Nodes[0].ItemType, RoleAssignmentsType)
+				st.Expect(t, r.Nodes[1].ItemType, policyType)
+				st.Expect(t, r.Nodes[2].ItemType, LocksType)
+				st.Expect(t, r.Nodes[3].ItemType, costType)
+				st.Expect(t, r.Nodes[4].ItemType, serviceHealthType)
+				st.Expect(t, r.Nodes[5].ItemType, advisorType)
+				st.Expect(t, r.Nodes[6].ItemType, quotasType)
 
 				// Validate content
-				st.Expect(t, r.Nodes[0].Name, "1testrg")
-				st.Expect(t, r.Nodes[0].ExpandURL, "/subscriptions/00000000-0000-0000-0000-000000000000/resourceGroups/1testrg/resources?api-version=2017-05-10")
+				st.Expect(t, r.Nodes[7].Name, "1testrg")
+				st.Expect(t, r.Nodes[7].ExpandURL, "/subscriptions/00000000-0000-0000-0000-000000000000/resourceGroups/1testrg/resources?api-version=2017-05-10")
 			},
 		},
 		{
@@ -61,8 +61,17 @@ type ExpanderResult struct {
 	// When set to true this causes the response
 	// in the result to be displayed in the content panel
 	IsPrimaryResponse bool
+	// Set when the nodes are a page of a paged API (eg ARM nextLink, Storage marker).
+	// A "more..." node is added after the nodes which calls LoadMore with the
+	// ContinuationToken to get the next page when expanded
+	ContinuationToken string
+	LoadMore          LoadMoreFunc
 }
 
+// LoadMoreFunc returns the next page of nodes for the continuation token, setting
+// ContinuationToken and LoadMore in the result if there are further pages
+type LoadMoreFunc func(ctx context.Context, continuationToken string) ExpanderResult
+
 // TreeNode is an item in the ListWidget
 type TreeNode struct {
 	Parentid            string                // The ID of the parent resource
@@ -78,8 +87,13 @@ type TreeNode struct {
 	Metadata            map[string]string     // Metadata is used to pass arbritray data between `Expander`'s
 	SubscriptionID      string                // The SubId of this item
 	StatusIndicator     string                // Displays the resources status
+	Stale               bool                  // Set when the node was built from cached responses while offline (see armclient.ResponseCache)
 	SwaggerResourceType *swagger.ResourceType // caches the swagger ResourceType to avoid repeated lookups
 	Expander            Expander              // The Expander that created the node (set automatically by the list)
+
+	continuationToken string             // The token to pass to loadMore for LoadMoreType nodes
+	loadMore          LoadMoreFunc       // Loads the next page for LoadMoreType nodes
+	expandExplanation *ExpandExplanation // Records which expanders ran the last time the node was expanded
 }
 
 const (
@@ -102,9 +116,26 @@ const (
 	diagnosticSettingsType  = "diagnosticSettings"
 	// ActionType defines an action like `listkey` etc
 	ActionType = "action"
+	// LoadingType defines the placeholder shown while an expander is running when streaming results
+	LoadingType = "loading"
+	// ErrorType defines a node reporting that an expander failed
+	ErrorType = "error"
+	// LoadMoreType defines the node which loads the next page of a paged result
+	LoadMoreType = "loadMore"
 
 	// Used to store resourceIds as CVS in TreeItem Metadata
 	resourceIdsMeta = "resourceIds"
+	// Used to store the kind of resource from the resource list (e.g. "functionapp") in TreeItem Metadata
+	resourceKindMeta = "kind"
+
+	// ShowManagementGroupsMeta is set to "true" on the tenant node to add the "Management Groups" root
+	ShowManagementGroupsMeta = "ShowManagementGroups"
+	// DescendantIDsMeta stores the IDs of nodes below a node as CSV in TreeItem Metadata
+	// for nodes whose children don't have IDs prefixed with their parent's ID
+	DescendantIDsMeta = "DescendantIDs"
+	// NavigateToIDMeta is set on nodes which navigate to another item in the tree when selected
+	// rather than being expanded (e.g. an Advisor recommendation navigates to the affected resource)
+	NavigateToIDMeta = "NavigateToID"
 
 	// ExpandURLNotSupported is used to identify items which don't support generic expansion
 	ExpandURLNotSupported = "notsupported"
@@ -41,6 +41,22 @@ func DrawStatus(s string) string {
 		return "⛔"
 	case "Succeeded":
 		return "☼"
+	case "NonCompliant":
+		return "⚠"
+	case "Health/Degraded":
+		return "⚠"
+	case "Health/Unavailable":
+		return "✖"
+	case "Advisor/High", "Advisor/Medium", "Advisor/Low":
+		return "💡"
+	case "PowerState/running":
+		return "▶"
+	case "PowerState/starting":
+		return "⛅"
+	case "PowerState/stopping", "PowerState/deallocating":
+		return "⟳"
+	case "PowerState/stopped", "PowerState/deallocated":
+		return "⏹"
 	}
 	return ""
 }
@@ -0,0 +1,370 @@
+package expanders
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/lawrencegripper/azbrowse/internal/pkg/style"
+	"github.com/lawrencegripper/azbrowse/pkg/armclient"
+	"github.com/nbio/st"
+	"gopkg.in/h2non/gock.v1"
+)
+
+const virtualMachineTemplateURL = "/subscriptions/{subscriptionId}/resourceGroups/{resourceGroupName}/providers/Microsoft.Compute/virtualMachines/{vmName}"
+const virtualMachineAPIVersion = "2020-06-01"
+
+// Item types for the nodes added under a virtual machine
+const (
+	virtualMachineInstanceViewType = "virtualMachine.instanceView"
+	virtualMachineSerialLogType    = "virtualMachine.serialLog"
+)
+
+// VMRunCommandIDs lists the built-in run commands which run a script on a virtual machine
+var VMRunCommandIDs = []string{"RunShellScript", "RunPowerShellScript"}
+
+// IsVirtualMachine checks if the node is a virtual machine
+func IsVirtualMachine(node *TreeNode) bool {
+	if node == nil || node.ItemType != ResourceType {
+		return false
+	}
+	if node.SwaggerResourceType != nil && node.SwaggerResourceType.Endpoint.TemplateURL == virtualMachineTemplateURL {
+		return true
+	}
+	return strings.EqualFold(node.ArmType, "Microsoft.Compute/virtualMachines")
+}
+
+type virtualMachineInstanceView struct {
+	Statuses   []virtualMachineStatus `json:"statuses"`
+	Extensions []struct {
+		Name               string                 `json:"name"`
+		Type               string                 `json:"type"`
+		TypeHandlerVersion string                 `json:"typeHandlerVersion"`
+		Statuses           []virtualMachineStatus `json:"statuses"`
+	} `json:"extensions"`
+}
+
+type virtualMachineStatus struct {
+	Code          string `json:"code"`
+	Level         string `json:"level"`
+	DisplayStatus string `json:"displayStatus"`
+	Message       string `json:"message"`
+}
+
+// Check interface
+var _ Expander = &VirtualMachineExpander{}
+
+// VirtualMachineExpander adds the instance view and serial log to virtual machines
+type VirtualMachineExpander struct {
+	ExpanderBase
+	client *armclient.Client
+}
+
+func (e *VirtualMachineExpander) setClient(c *armclient.Client) {
+	e.client = c
+}
+
+// Name returns the name of the expander
+func (e *VirtualMachineExpander) Name() string {
+	return "VirtualMachineExpander"
+}
+
+// DoesExpand checks if this is a virtual machine or one of the nodes added to it
+func (e *VirtualMachineExpander) DoesExpand(ctx context.Context, currentItem *TreeNode) (bool, error) {
+	if IsVirtualMachine(currentItem) {
+		return true, nil
+	}
+	if currentItem.ItemType == virtualMachineInstanceViewType || currentItem.ItemType == virtualMachineSerialLogType {
+		return true, nil
+	}
+	return false, nil
+}
+
+// Expand adds the instance view and serial log nodes to a virtual machine
+func (e *VirtualMachineExpander) Expand(ctx context.Context, currentItem *TreeNode) ExpanderResult {
+	switch currentItem.ItemType {
+	case virtualMachineInstanceViewType:
+		return e.expandInstanceView(ctx, currentItem)
+	case virtualMachineSerialLogType:
+		return e.expandSerialLog(ctx, currentItem)
+	}
+
+	return ExpanderResult{
+		Nodes: []*TreeNode{
+			e.newVirtualMachineNode(currentItem, "Instance view", "instanceview", virtualMachineInstanceViewType),
+			e.newVirtualMachineNode(currentItem, "Serial log", "seriallog", virtualMachineSerialLogType),
+		},
+		Response:          ExpanderResponse{Response: ""}, // Swagger expander will supply the response
+		SourceDescription: "VirtualMachineExpander request",
+		IsPrimaryResponse: false,
+	}
+}
+
+func (e *VirtualMachineExpander) newVirtualMachineNode(vm *TreeNode, name string, tag string, itemType string) *TreeNode {
+	return &TreeNode{
+		Parentid:       vm.ID,
+		Namespace:      "None",
+		Display:        style.Subtle("[Microsoft.Compute]") + "\n  " + name,
+		Name:           name,
+		ID:             vm.ID + "/<" + tag + ">",
+		ExpandURL:      ExpandURLNotSupported,
+		ItemType:       itemType,
+		SubscriptionID: vm.SubscriptionID,
+		Metadata: map[string]string{
+			"SuppressSwaggerExpand": "true",
+			"SuppressGenericExpand": "true",
+			"VirtualMachineID":      vm.ID,
+		},
+	}
+}
+
+func (e *VirtualMachineExpander) expandInstanceView(ctx context.Context, currentItem *TreeNode) ExpanderResult {
+	vmID := currentItem.Metadata["VirtualMachineID"]
+	data, err := e.client.DoRequest(ctx, "GET", vmID+"/instanceView?api-version="+virtualMachineAPIVersion)
+	if err != nil {
+		return ExpanderResult{
+			Err:               fmt.Errorf("Failed retrieving instance view: %s", err),
+			Response:          ExpanderResponse{Response: data, ResponseType: ResponseJSON},
+			SourceDescription: "VirtualMachineExpander request",
+			IsPrimaryResponse: true,
+		}
+	}
+	var instanceView virtualMachineInstanceView
+	err = json.Unmarshal([]byte(data), &instanceView)
+	if err != nil {
+		return ExpanderResult{
+			Err:               fmt.Errorf("Error unmarshalling instance view: %s", err),
+			Response:          ExpanderResponse{Response: data, ResponseType: ResponseJSON},
+			SourceDescription: "VirtualMachineExpander request",
+			IsPrimaryResponse: true,
+		}
+	}
+
+	newItems := []*TreeNode{}
+	for _, extension := range instanceView.Extensions {
+		display := extension.Name + "\n  " + style.Subtle("Type: "+extension.Type+" "+extension.TypeHandlerVersion)
+		statusIndicator := ""
+		for _, status := range extension.Statuses {
+			display += "\n  " + style.Subtle("Status: "+status.DisplayStatus)
+			if statusIndicator == "" {
+				statusIndicator = DrawStatus(getStatusFromCode(status.Code))
+			}
+		}
+		extensionID := vmID + "/extensions/" + extension.Name
+		newItems = append(newItems, &TreeNode{
+			Parentid:        currentItem.ID,
+			Namespace:       "None",
+			Display:         display,
+			Name:            extension.Name,
+			ID:              extensionID,
+			ExpandURL:       extensionID + "?api-version=" + virtualMachineAPIVersion,
+			ItemType:        SubResourceType,
+			SubscriptionID:  currentItem.SubscriptionID,
+			StatusIndicator: statusIndicator,
+			Metadata: map[string]string{
+				"SuppressSwaggerExpand": "true",
+			},
+		})
+	}
+
+	return ExpanderResult{
+		Nodes:             newItems,
+		Response:          ExpanderResponse{Response: data, ResponseType: ResponseJSON},
+		SourceDescription: "VirtualMachineExpander request",
+		IsPrimaryResponse: true,
+	}
+}
+
+// getStatusFromCode converts an instance view status code, e.g. "ProvisioningState/failed/1",
+// into the state understood by DrawStatus. Power states keep their full code, e.g. "PowerState/running".
+func getStatusFromCode(code string) string {
+	if strings.HasPrefix(code, "PowerState/") {
+		return code
+	}
+	parts := strings.Split(code, "/")
+	if len(parts) < 2 || parts[1] == "" {
+		return ""
+	}
+	return strings.ToUpper(parts[1][:1]) + parts[1][1:]
+}
+
+func (e *VirtualMachineExpander) expandSerialLog(ctx context.Context, currentItem *TreeNode) ExpanderResult {
+	vmID := currentItem.Metadata["VirtualMachineID"]
+	data, err := e.client.DoRequest(ctx, "POST", vmID+"/retrieveBootDiagnosticsData?api-version="+virtualMachineAPIVersion)
+	if err != nil {
+		return ExpanderResult{
+			Err:               fmt.Errorf("Failed retrieving boot diagnostics, check boot diagnostics are enabled: %s", err),
+			Response:          ExpanderResponse{Response: data, ResponseType: ResponseJSON},
+			SourceDescription: "VirtualMachineExpander request",
+			IsPrimaryResponse: true,
+		}
+	}
+	var bootDiagnostics struct {
+		SerialConsoleLogBlobURI string `json:"serialConsoleLogBlobUri"`
+	}
+	err = json.Unmarshal([]byte(data), &bootDiagnostics)
+	if err != nil {
+		return ExpanderResult{
+			Err:               fmt.Errorf("Error unmarshalling boot diagnostics: %s", err),
+			Response:          ExpanderResponse{Response: data, ResponseType: ResponseJSON},
+			SourceDescription: "VirtualMachineExpander request",
+			IsPrimaryResponse: true,
+		}
+	}
+	if bootDiagnostics.SerialConsoleLogBlobURI == "" {
+		return ExpanderResult{
+			Response:          ExpanderResponse{Response: "No serial log available", ResponseType: ResponsePlainText},
+			SourceDescription: "VirtualMachineExpander request",
+			IsPrimaryResponse: true,
+		}
+	}
+
+	// The blob URI includes a SAS token so doesn't need the ARM token
+	request, err := http.NewRequest("GET", bootDiagnostics.SerialConsoleLogBlobURI, nil)
+	if err != nil {
+		return ExpanderResult{
+			Err:               fmt.Errorf("Failed to create request: %s", err),
+			SourceDescription: "VirtualMachineExpander request",
+			IsPrimaryResponse: true,
+		}
+	}
+	response, err := http.DefaultClient.Do(request.WithContext(ctx))
+	if err != nil {
+		return ExpanderResult{
+			Err:               fmt.Errorf("Failed retrieving serial log: %s", err),
+			SourceDescription: "VirtualMachineExpander request",
+			IsPrimaryResponse: true,
+		}
+	}
+	defer response.Body.Close() //nolint: errcheck
+	buf, err := ioutil.ReadAll(response.Body)
+	if err != nil || response.StatusCode < 200 || response.StatusCode >= 300 {
+		return ExpanderResult{
+			Err:               fmt.Errorf("Failed retrieving serial log: %s %s", response.Status, err),
+			Response:          ExpanderResponse{Response: string(buf), ResponseType: ResponsePlainText},
+			SourceDescription: "VirtualMachineExpander request",
+			IsPrimaryResponse: true,
+		}
+	}
+
+	return ExpanderResult{
+		Response:          ExpanderResponse{Response: string(buf), ResponseType: ResponsePlainText},
+		SourceDescription: "VirtualMachineExpander request",
+		IsPrimaryResponse: true,
+	}
+}
+
+// RunVMCommand runs the script on the virtual machine, waiting for the command to complete, and
+// returns the output
+func RunVMCommand(ctx context.Context, client *armclient.Client, vmID string, commandID string, script string) (string, error) {
+	body, err := json.Marshal(map[string]interface{}{
+		"commandId": commandID,
+		"script":    strings.Split(script, "\n"),
+	})
+	if err != nil {
+		return "", err
+	}
+
+	data, err := client.DoLongRunningRequestWithBody(ctx, "POST", vmID+"/runCommand?api-version="+virtualMachineAPIVersion, string(body))
+	if err != nil {
+		return "", fmt.Errorf("Failed running command: %s %s", err, data)
+	}
+	return formatRunCommandOutput(data)
+}
+
+// formatRunCommandOutput shows the stdout and stderr from the run command result
+func formatRunCommandOutput(data string) (string, error) {
+	var result struct {
+		Value []virtualMachineStatus `json:"value"`
+	}
+	err := json.Unmarshal([]byte(data), &result)
+	if err != nil {
+		return "", fmt.Errorf("Error unmarshalling run command result: %s", err)
+	}
+
+	output := ""
+	for _, status := range result.Value {
+		// Codes are of the form "ComponentStatus/StdOut/succeeded"
+		parts := strings.Split(status.Code, "/")
+		name := status.Code
+		if len(parts) == 3 {
+			name = parts[1] + " (" + parts[2] + ")"
+		}
+		output += name + ":\n" + strings.TrimRight(status.Message, "\n") + "\n\n"
+	}
+	return output, nil
+}
+
+func (e *VirtualMachineExpander) testCases() (bool, *[]expanderTestCase) {
+	const vmID = "/subscriptions/00000000-0000-0000-0000-000000000000/resourceGroups/stable/providers/Microsoft.Compute/virtualMachines/avm"
+	const blobURL = "https://astorageaccount.blob.core.windows.net/bootdiagnostics-avm/avm.serialconsole.log"
+
+	vmNode := &TreeNode{
+		ID:       vmID,
+		ItemType: ResourceType,
+		ArmType:  "Microsoft.Compute/virtualMachines",
+	}
+	instanceViewNode := e.newVirtualMachineNode(vmNode, "Instance view", "instanceview", virtualMachineInstanceViewType)
+	serialLogNode := e.newVirtualMachineNode(vmNode, "Serial log", "seriallog", virtualMachineSerialLogType)
+
+	noRequestGockConfig := func(t *testing.T) {}
+	serialLogGockConfig := func(t *testing.T) {
+		gock.New("https://management.azure.com").
+			Post(vmID + "/retrieveBootDiagnosticsData").
+			Reply(200).
+			JSON(`{"consoleScreenshotBlobUri": "` + blobURL + `.bmp?sv=sas", "serialConsoleLogBlobUri": "` + blobURL + `?sv=sas"}`)
+		gock.New("https://astorageaccount.blob.core.windows.net").
+			Get("/bootdiagnostics-avm/avm.serialconsole.log").
+			MatchParam("sv", "sas").
+			Reply(200).
+			BodyString("Ubuntu 18.04.4 LTS avm ttyS0\n\navm login:")
+	}
+
+	return true, &[]expanderTestCase{
+		{
+			name:              "VirtualMachine->Nodes",
+			nodeToExpand:      vmNode,
+			statusCode:        200,
+			configureGockFunc: &noRequestGockConfig,
+			treeNodeCheckerFunc: func(t *testing.T, r ExpanderResult) {
+				st.Expect(t, r.Err, nil)
+				st.Expect(t, r.IsPrimaryResponse, false)
+				st.Expect(t, len(r.Nodes), 2)
+				st.Expect(t, r.Nodes[0].ItemType, virtualMachineInstanceViewType)
+				st.Expect(t, r.Nodes[1].ItemType, virtualMachineSerialLogType)
+			},
+		},
+		{
+			name:         "InstanceView->Extensions",
+			nodeToExpand: instanceViewNode,
+			urlPath:      vmID + "/instanceView",
+			responseFile: "./testdata/armsamples/virtualMachine/instanceView.json",
+			statusCode:   200,
+			treeNodeCheckerFunc: func(t *testing.T, r ExpanderResult) {
+				st.Expect(t, r.Err, nil)
+				st.Expect(t, r.IsPrimaryResponse, true)
+				st.Expect(t, len(r.Nodes), 2)
+
+				st.Expect(t, r.Nodes[0].Name, "AzureMonitorLinuxAgent")
+				st.Expect(t, r.Nodes[0].ID, vmID+"/extensions/AzureMonitorLinuxAgent")
+				st.Expect(t, r.Nodes[0].StatusIndicator, DrawStatus("Succeeded"))
+				st.Expect(t, r.Nodes[1].StatusIndicator, DrawStatus("Failed"))
+			},
+		},
+		{
+			name:              "SerialLog->Log",
+			nodeToExpand:      serialLogNode,
+			statusCode:        200,
+			configureGockFunc: &serialLogGockConfig,
+			treeNodeCheckerFunc: func(t *testing.T, r ExpanderResult) {
+				st.Expect(t, r.Err, nil)
+				st.Expect(t, r.Response.ResponseType, ResponsePlainText)
+				st.Expect(t, r.Response.Response, "Ubuntu 18.04.4 LTS avm ttyS0\n\navm login:")
+			},
+		},
+	}
+}
@@ -0,0 +1,33 @@
+package expanders
+
+import (
+	"testing"
+
+	"github.com/nbio/st"
+)
+
+func TestGetStatusFromCode(t *testing.T) {
+	tests := []struct {
+		code     string
+		expected string
+	}{
+		{code: "ProvisioningState/succeeded", expected: "Succeeded"},
+		{code: "ProvisioningState/failed/1", expected: "Failed"},
+		{code: "PowerState/deallocated", expected: "PowerState/deallocated"},
+		{code: "", expected: ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.code, func(t *testing.T) {
+			st.Expect(t, getStatusFromCode(tt.code), tt.expected)
+		})
+	}
+}
+
+func TestFormatRunCommandOutput(t *testing.T) {
+	output, err := formatRunCommandOutput(`{"value": [
+		{"code": "ComponentStatus/StdOut/succeeded", "level": "Info", "displayStatus": "Provisioning succeeded", "message": "hello\n"},
+		{"code": "ComponentStatus/StdErr/succeeded", "level": "Info", "displayStatus": "Provisioning succeeded", "message": ""}
+	]}`)
+	st.Expect(t, err, nil)
+	st.Expect(t, output, "StdOut (succeeded):\nhello\n\nStdErr (succeeded):\n\n\n")
+}
@@ -176,10 +176,21 @@ func (d *Folder) LoadNodeFromARM() {
 		rootContent.Response = views.StripSecretVals(rootContent.Response)
 	}
 
-	d.items = newItems
+	d.items = withoutErrorNodes(newItems)
 	d.indexContent = rootContent
 }
 
+// withoutErrorNodes removes the nodes reporting expander failures as they don't map to files
+func withoutErrorNodes(nodes []*expanders.TreeNode) []*expanders.TreeNode {
+	filtered := []*expanders.TreeNode{}
+	for _, node := range nodes {
+		if node.ItemType != expanders.ErrorType {
+			filtered = append(filtered, node)
+		}
+	}
+	return filtered
+}
+
 func (d *Folder) ReadDirAll(ctx context.Context) ([]fuse.Dirent, error) {
 	d.mu.Lock()
 	defer d.mu.Unlock()
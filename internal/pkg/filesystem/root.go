@@ -32,6 +32,7 @@ func (f *FS) Root() (fs.Node, error) {
 	if err != nil {
 		panic(err)
 	}
+	newItems = withoutErrorNodes(newItems)
 
 	// Filter to a single subscription if specified
 	if f.filterToSubscription != "" {
@@ -23,6 +23,7 @@ var DefaultKeys = map[string]interface{}{
 	"listexpand":          gocui.KeyEnter,
 	"listopen":            gocui.KeyCtrlO,
 	"listrefresh":         gocui.KeyF5,
+	"listcancelexpand":    gocui.KeyCtrlX,
 	"listupdate":          gocui.KeyCtrlU,
 	"listpagedown":        gocui.KeyPgdn,
 	"listpageup":          gocui.KeyPgup,
@@ -281,6 +281,43 @@ func (h *ToggleDemoModeHandler) Invoke() error {
 
 ////////////////////////////////////////////////////////////////////
 
+////////////////////////////////////////////////////////////////////
+type ExpanderHealthHandler struct {
+	GlobalHandler
+	Content *views.ItemWidget
+}
+
+var _ Command = &ExpanderHealthHandler{}
+
+func NewExpanderHealthHandler(content *views.ItemWidget) *ExpanderHealthHandler {
+	handler := &ExpanderHealthHandler{
+		Content: content,
+	}
+	handler.id = HandlerIDExpanderHealth
+	return handler
+}
+
+func (h ExpanderHealthHandler) Fn() func(g *gocui.Gui, v *gocui.View) error {
+	return func(g *gocui.Gui, v *gocui.View) error {
+		return h.Invoke()
+	}
+}
+
+func (h *ExpanderHealthHandler) DisplayText() string {
+	return "DEBUG: Show expander health"
+}
+
+func (h *ExpanderHealthHandler) IsEnabled() bool {
+	return true
+}
+
+func (h *ExpanderHealthHandler) Invoke() error {
+	h.Content.SetContent(nil, expanders.RenderExpanderHealth(expanders.GetExpanderHealth()), expanders.ResponsePlainText, "Expander health")
+	return nil
+}
+
+////////////////////////////////////////////////////////////////////
+
 ////////////////////////////////////////////////////////////////////
 type OpenCommandPanelHandler struct {
 	GlobalHandler
@@ -23,6 +23,7 @@ const (
 	HandlerIDListExpand              HandlerID = "listexpand"            //nolint:golint
 	HandlerIDListOpen                HandlerID = "listopen"              //nolint:golint
 	HandlerIDListRefresh             HandlerID = "listrefresh"           //nolint:golint
+	HandlerIDListCancelExpand        HandlerID = "listcancelexpand"      //nolint:golint
 	HandlerIDListUpdate              HandlerID = "listupdate"            //nolint:golint
 	HandlerIDListPageDown            HandlerID = "listpagedown"          //nolint:golint
 	HandlerIDListPageUp              HandlerID = "listpageup"            //nolint:golint
@@ -44,6 +45,23 @@ const (
 	HandlerIDAzureSearchQuery        HandlerID = "azuresearchquery"      //nolist:golint
 	HandlerIDToggleDemoMode          HandlerID = "toggledemomode"        //nolist:golint
 	HandlerIDListSort                HandlerID = "listsort"              //nolint:golint
+	HandlerIDKQLQuery                HandlerID = "kqlquery"              //nolint:golint
+	HandlerIDKQLQueryTimeRange       HandlerID = "kqlquerytimerange"     //nolint:golint
+	HandlerIDKQLQueryExport          HandlerID = "kqlqueryexport"        //nolint:golint
+	HandlerIDRoleAssignmentAdd       HandlerID = "roleassignmentadd"     //nolint:golint
+	HandlerIDRoleAssignmentRemove    HandlerID = "roleassignmentremove"  //nolint:golint
+	HandlerIDLockAdd                 HandlerID = "lockadd"               //nolint:golint
+	HandlerIDLockRemove              HandlerID = "lockremove"            //nolint:golint
+	HandlerIDMetricsTimeRange        HandlerID = "metricstimerange"      //nolint:golint
+	HandlerIDMetricsInterval         HandlerID = "metricsinterval"       //nolint:golint
+	HandlerIDMetricsAggregation      HandlerID = "metricsaggregation"    //nolint:golint
+	HandlerIDMetricsDimension        HandlerID = "metricsdimension"      //nolint:golint
+	HandlerIDListWatch               HandlerID = "listwatch"             //nolint:golint
+	HandlerIDTailLogs                HandlerID = "taillogs"              //nolint:golint
+	HandlerIDVMRunCommand            HandlerID = "vmruncommand"          //nolint:golint
+	HandlerIDExpanderHealth          HandlerID = "expanderhealth"        //nolint:golint
+	HandlerIDGoToReference           HandlerID = "gotoreference"         //nolint:golint
+	HandlerIDListExplainExpand       HandlerID = "listexplainexpand"     //nolint:golint
 )
 
 // KeyHandler is an interface that all key handlers must implement
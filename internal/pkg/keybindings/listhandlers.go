@@ -8,10 +8,14 @@ import (
 	"io/ioutil"
 	"os"
 	"os/exec"
+	"path/filepath"
+	"strings"
 	"time"
 
 	"github.com/go-xmlfmt/xmlfmt"
+	"github.com/lawrencegripper/azbrowse/internal/pkg/automation"
 	"github.com/lawrencegripper/azbrowse/internal/pkg/config"
+	"github.com/lawrencegripper/azbrowse/internal/pkg/errorhandling"
 	"github.com/lawrencegripper/azbrowse/internal/pkg/eventing"
 	"github.com/lawrencegripper/azbrowse/internal/pkg/expanders"
 	"github.com/lawrencegripper/azbrowse/internal/pkg/tracing"
@@ -320,7 +324,15 @@ func NewListExpandHandler(list *views.ListWidget) *ListExpandHandler {
 
 func (h ListExpandHandler) Fn() func(g *gocui.Gui, v *gocui.View) error {
 	return func(g *gocui.Gui, v *gocui.View) error {
-		h.List.ExpandCurrentSelection()
+		// Some items (e.g. Advisor recommendations) take you to another item rather than expanding
+		if h.List.HasCurrentItem() {
+			if navigateToID := h.List.CurrentItem().Metadata[expanders.NavigateToIDMeta]; navigateToID != "" {
+				automation.NavigateTo(h.List, navigateToID)
+				h.List.GoHome()
+				return nil
+			}
+		}
+		h.List.ExpandCurrentSelectionStreaming()
 		return nil
 	}
 }
@@ -409,6 +421,29 @@ func (h ListRefreshHandler) Fn() func(g *gocui.Gui, v *gocui.View) error {
 
 ////////////////////////////////////////////////////////////////////
 
+////////////////////////////////////////////////////////////////////
+type ListCancelExpandHandler struct {
+	ListHandler
+	List *views.ListWidget
+}
+
+func NewListCancelExpandHandler(list *views.ListWidget) *ListCancelExpandHandler {
+	handler := &ListCancelExpandHandler{
+		List: list,
+	}
+	handler.id = HandlerIDListCancelExpand
+	return handler
+}
+
+func (h ListCancelExpandHandler) Fn() func(g *gocui.Gui, v *gocui.View) error {
+	return func(g *gocui.Gui, v *gocui.View) error {
+		h.List.CancelExpand()
+		return nil
+	}
+}
+
+////////////////////////////////////////////////////////////////////
+
 ////////////////////////////////////////////////////////////////////
 type ListDeleteHandler struct {
 	ListHandler
@@ -459,7 +494,7 @@ func NewListUpdateHandler(list *views.ListWidget, statusbar *views.StatusbarWidg
 	return handler
 }
 
-func (h ListUpdateHandler) getEditorConfig() (config.EditorConfig, error) {
+func getEditorConfig() (config.EditorConfig, error) {
 	userConfig, err := config.Load()
 	if err != nil {
 		return config.EditorConfig{}, err
@@ -508,7 +543,7 @@ func (h *ListUpdateHandler) Invoke() error {
 		return nil
 	}
 
-	editorConfig, err := h.getEditorConfig()
+	editorConfig, err := getEditorConfig()
 	if err != nil {
 		return err
 	}
@@ -545,47 +580,68 @@ func (h *ListUpdateHandler) Invoke() error {
 
 	}
 
+	h.status.Status("Opening JSON in editor...", false)
+	updatedJSON, err := editInEditor(h.Gui, editorConfig, formattedContent, fileExtension)
+	if err != nil {
+		h.status.Status(err.Error(), false)
+		return nil
+	}
+	if updatedJSON == formattedContent {
+		h.status.Status("No changes to JSON - no further action.", false)
+		return nil
+	}
+	if updatedJSON == "" {
+		h.status.Status("Updated JSON empty - no further action.", false)
+		return nil
+	}
+
+	apiSetID := item.Metadata["SwaggerAPISetID"]
+	apiSetPtr := expanders.GetSwaggerResourceExpander().GetAPISet(apiSetID)
+	if apiSetPtr == nil {
+		return nil
+	}
+	apiSet := *apiSetPtr
+
+	err = apiSet.Update(h.Context, item, updatedJSON)
+	if err != nil {
+		h.status.Status(fmt.Sprintf("Error updating: %s", err), false)
+		return nil
+	}
+
+	h.status.Status("Done", false)
+	return nil
+
+}
+
+// editInEditor opens the content in the user's editor and returns the content once the editor is closed
+func editInEditor(gui *gocui.Gui, editorConfig config.EditorConfig, content string, fileExtension string) (string, error) {
 	tempDir := editorConfig.TempDir
 	if tempDir == "" {
 		tempDir = os.TempDir() // fall back to Temp dir as default
 	}
 	tmpFile, err := ioutil.TempFile(tempDir, "azbrowse-*"+fileExtension)
 	if err != nil {
-		h.status.Status(fmt.Sprintf("Cannot create temporary file: %s", err), false)
-		return err
+		return "", fmt.Errorf("Cannot create temporary file: %s", err)
 	}
 
 	// Remember to clean up the file afterwards
 	defer os.Remove(tmpFile.Name()) //nolint: errcheck
 
-	_, err = tmpFile.WriteString(formattedContent)
+	_, err = tmpFile.WriteString(content)
 	if err != nil {
-		eventing.SendStatusEvent(&eventing.StatusEvent{
-			InProgress: false,
-			Failure:    true,
-			Message:    "Failed saving file for editing: " + err.Error(),
-			Timeout:    time.Duration(time.Second * 4),
-		})
-		return nil
+		return "", fmt.Errorf("Failed saving file for editing: %s", err)
 	}
 	err = tmpFile.Close()
 	if err != nil {
-		eventing.SendStatusEvent(&eventing.StatusEvent{
-			InProgress: false,
-			Failure:    true,
-			Message:    "Failed closing file: " + err.Error(),
-			Timeout:    time.Duration(time.Second * 4),
-		})
-		return nil
+		return "", fmt.Errorf("Failed closing file: %s", err)
 	}
 
-	h.status.Status("Opening JSON in editor...", false)
 	editorTmpFile := tmpFile.Name()
 	// check if we should perform path translation for WSL (Windows Subsytem for Linux)
 	if editorConfig.TranslateFilePathForWSL {
 		editorTmpFile, err = wsl.TranslateToWindowsPath(editorTmpFile)
 		if err != nil {
-			return err
+			return "", err
 		}
 	}
 
@@ -599,50 +655,22 @@ func (h *ListUpdateHandler) Invoke() error {
 		// Init termbox to switch back to alternate buffer and Flush content
 		err = termbox.Init()
 		if err != nil {
-			return fmt.Errorf("Failed to reinitialise termbox: %v", err)
+			return "", fmt.Errorf("Failed to reinitialise termbox: %v", err)
 		}
-		err = h.Gui.Flush()
+		err = gui.Flush()
 		if err != nil {
-			return fmt.Errorf("Failed to reinitialise termbox: %v", err)
+			return "", fmt.Errorf("Failed to reinitialise termbox: %v", err)
 		}
 	}
 	if editorErr != nil {
-		h.status.Status(fmt.Sprintf("Cannot open editor (ensure https://code.visualstudio.com is installed): %s", editorErr), false)
-		return nil
-	}
-
-	updatedJSONBytes, err := ioutil.ReadFile(tmpFile.Name())
-	if err != nil {
-		h.status.Status(fmt.Sprintf("Cannot open edited file: %s", err), false)
-		return nil
-	}
-
-	updatedJSON := string(updatedJSONBytes)
-	if updatedJSON == formattedContent {
-		h.status.Status("No changes to JSON - no further action.", false)
-		return nil
-	}
-	if updatedJSON == "" {
-		h.status.Status("Updated JSON empty - no further action.", false)
-		return nil
-	}
-
-	apiSetID := item.Metadata["SwaggerAPISetID"]
-	apiSetPtr := expanders.GetSwaggerResourceExpander().GetAPISet(apiSetID)
-	if apiSetPtr == nil {
-		return nil
+		return "", fmt.Errorf("Cannot open editor (ensure https://code.visualstudio.com is installed): %s", editorErr)
 	}
-	apiSet := *apiSetPtr
 
-	err = apiSet.Update(h.Context, item, updatedJSON)
+	updatedBytes, err := ioutil.ReadFile(tmpFile.Name())
 	if err != nil {
-		h.status.Status(fmt.Sprintf("Error updating: %s", err), false)
-		return nil
+		return "", fmt.Errorf("Cannot open edited file: %s", err)
 	}
-
-	h.status.Status("Done", false)
-	return nil
-
+	return string(updatedBytes), nil
 }
 
 func openEditor(command config.CommandConfig, filename string) error {
@@ -754,50 +782,1628 @@ func (h *CommandPanelAzureSearchQueryHandler) CommandPanelNotification(state vie
 ////////////////////////////////////////////////////////////////////
 
 ////////////////////////////////////////////////////////////////////
-type ListDebugCopyItemDataHandler struct {
+type CommandPanelKQLQueryHandler struct {
 	ListHandler
-	List      *views.ListWidget
-	StatusBar *views.StatusbarWidget
+	commandPanelWidget *views.CommandPanelWidget
+	list               *views.ListWidget
 }
 
-var _ Command = &ListDebugCopyItemDataHandler{}
+var _ Command = &CommandPanelKQLQueryHandler{}
 
-func NewListDebugCopyItemDataHandler(list *views.ListWidget, statusBar *views.StatusbarWidget) *ListDebugCopyItemDataHandler {
-	handler := &ListDebugCopyItemDataHandler{
-		List:      list,
-		StatusBar: statusBar,
+func NewCommandPanelKQLQueryHandler(commandPanelWidget *views.CommandPanelWidget, list *views.ListWidget) *CommandPanelKQLQueryHandler {
+	handler := &CommandPanelKQLQueryHandler{
+		commandPanelWidget: commandPanelWidget,
+		list:               list,
 	}
-	handler.id = HandlerIDListDebugCopyItemData
+	handler.id = HandlerIDKQLQuery
+
 	return handler
 }
 
-func (h ListDebugCopyItemDataHandler) Fn() func(g *gocui.Gui, v *gocui.View) error {
+func (h *CommandPanelKQLQueryHandler) Fn() func(g *gocui.Gui, v *gocui.View) error {
 	return func(g *gocui.Gui, v *gocui.View) error {
-		return h.Invoke()
+		if h.IsEnabled() {
+			return h.Invoke()
+		}
+		return nil
 	}
 }
 
-func (h *ListDebugCopyItemDataHandler) DisplayText() string {
-	return "DEBUG: Copy current node data"
+func (h *CommandPanelKQLQueryHandler) DisplayText() string {
+	return "Run KQL query"
 }
-func (h *ListDebugCopyItemDataHandler) IsEnabled() bool {
-	return h.List.CurrentExpandedItem() != nil
+
+func (h *CommandPanelKQLQueryHandler) IsEnabled() bool {
+	currentItem := h.list.CurrentItem()
+	return currentItem != nil && currentItem.ItemType == expanders.LogAnalyticsQueryType
 }
-func (h *ListDebugCopyItemDataHandler) Invoke() error {
-	item := h.List.CurrentExpandedItem()
-	if item != nil {
-		buf, err := json.MarshalIndent(item, "", "  ")
+
+func (h *CommandPanelKQLQueryHandler) Invoke() error {
+	currentItem := h.list.CurrentItem()
+	timespan := expanders.GetLogAnalyticsTimespanDisplay(currentItem.Metadata[expanders.LogAnalyticsQueryTimespanMeta])
+	h.commandPanelWidget.ShowWithText("KQL query ("+timespan+"):", currentItem.Metadata[expanders.LogAnalyticsQueryMeta], nil, h.CommandPanelNotification)
+	return nil
+}
+
+func (h *CommandPanelKQLQueryHandler) CommandPanelNotification(state views.CommandPanelNotification) {
+	if state.EnterPressed {
+		h.commandPanelWidget.Hide()
+
+		currentItem := h.list.CurrentItem()
+		if currentItem == nil || currentItem.ItemType != expanders.LogAnalyticsQueryType {
+			return
+		}
+		currentItem.Metadata[expanders.LogAnalyticsQueryMeta] = strings.TrimSpace(state.CurrentText)
+		h.list.ExpandCurrentSelectionStreaming()
+	}
+}
+
+////////////////////////////////////////////////////////////////////
+
+////////////////////////////////////////////////////////////////////
+type CommandPanelKQLQueryTimeRangeHandler struct {
+	ListHandler
+	commandPanelWidget *views.CommandPanelWidget
+	list               *views.ListWidget
+}
+
+var _ Command = &CommandPanelKQLQueryTimeRangeHandler{}
+
+func NewCommandPanelKQLQueryTimeRangeHandler(commandPanelWidget *views.CommandPanelWidget, list *views.ListWidget) *CommandPanelKQLQueryTimeRangeHandler {
+	handler := &CommandPanelKQLQueryTimeRangeHandler{
+		commandPanelWidget: commandPanelWidget,
+		list:               list,
+	}
+	handler.id = HandlerIDKQLQueryTimeRange
+
+	return handler
+}
+
+func (h *CommandPanelKQLQueryTimeRangeHandler) Fn() func(g *gocui.Gui, v *gocui.View) error {
+	return func(g *gocui.Gui, v *gocui.View) error {
+		if h.IsEnabled() {
+			return h.Invoke()
+		}
+		return nil
+	}
+}
+
+func (h *CommandPanelKQLQueryTimeRangeHandler) DisplayText() string {
+	return "Set KQL query time range"
+}
+
+func (h *CommandPanelKQLQueryTimeRangeHandler) IsEnabled() bool {
+	currentItem := h.list.CurrentItem()
+	return currentItem != nil && currentItem.ItemType == expanders.LogAnalyticsQueryType
+}
+
+func (h *CommandPanelKQLQueryTimeRangeHandler) Invoke() error {
+	options := []views.CommandPanelListOption{}
+	for _, timespan := range expanders.LogAnalyticsTimespans {
+		options = append(options, views.CommandPanelListOption{
+			ID:          timespan.Value,
+			DisplayText: timespan.Display,
+		})
+	}
+	h.commandPanelWidget.ShowWithText("KQL query time range", "", &options, h.CommandPanelNotification)
+	return nil
+}
+
+func (h *CommandPanelKQLQueryTimeRangeHandler) CommandPanelNotification(state views.CommandPanelNotification) {
+	if state.EnterPressed {
+		h.commandPanelWidget.Hide()
+
+		currentItem := h.list.CurrentItem()
+		if state.SelectedID == "" || currentItem == nil || currentItem.ItemType != expanders.LogAnalyticsQueryType {
+			return
+		}
+		currentItem.Metadata[expanders.LogAnalyticsQueryTimespanMeta] = state.SelectedID
+		if currentItem.Metadata[expanders.LogAnalyticsQueryMeta] != "" {
+			h.list.ExpandCurrentSelectionStreaming()
+		}
+	}
+}
+
+////////////////////////////////////////////////////////////////////
+
+////////////////////////////////////////////////////////////////////
+type CommandPanelKQLQueryExportHandler struct {
+	ListHandler
+	commandPanelWidget *views.CommandPanelWidget
+	content            *views.ItemWidget
+}
+
+var _ Command = &CommandPanelKQLQueryExportHandler{}
+
+func NewCommandPanelKQLQueryExportHandler(commandPanelWidget *views.CommandPanelWidget, content *views.ItemWidget) *CommandPanelKQLQueryExportHandler {
+	handler := &CommandPanelKQLQueryExportHandler{
+		commandPanelWidget: commandPanelWidget,
+		content:            content,
+	}
+	handler.id = HandlerIDKQLQueryExport
+
+	return handler
+}
+
+func (h *CommandPanelKQLQueryExportHandler) Fn() func(g *gocui.Gui, v *gocui.View) error {
+	return func(g *gocui.Gui, v *gocui.View) error {
+		if h.IsEnabled() {
+			return h.Invoke()
+		}
+		return nil
+	}
+}
+
+func (h *CommandPanelKQLQueryExportHandler) DisplayText() string {
+	return "Export KQL query results"
+}
+
+func (h *CommandPanelKQLQueryExportHandler) IsEnabled() bool {
+	node := h.content.GetNode()
+	return node != nil && node.ItemType == expanders.LogAnalyticsQueryType && node.Metadata[expanders.LogAnalyticsQueryResultMeta] != ""
+}
+
+func (h *CommandPanelKQLQueryExportHandler) Invoke() error {
+	options := []views.CommandPanelListOption{
+		{ID: "csv", DisplayText: "CSV"},
+		{ID: "json", DisplayText: "JSON"},
+	}
+	h.commandPanelWidget.ShowWithText("Export format", "", &options, h.CommandPanelNotification)
+	return nil
+}
+
+func (h *CommandPanelKQLQueryExportHandler) CommandPanelNotification(state views.CommandPanelNotification) {
+	if !state.EnterPressed {
+		return
+	}
+	h.commandPanelWidget.Hide()
+
+	node := h.content.GetNode()
+	if state.SelectedID == "" || node == nil {
+		return
+	}
+	result := node.Metadata[expanders.LogAnalyticsQueryResultMeta]
+	fileNamePrefix := "azbrowse-query-" + time.Now().Format("20060102-150405")
+
+	// JSON exports the whole response, CSV exports each table to its own file
+	fileNames := []string{fileNamePrefix + ".json"}
+	contents := []string{result}
+	if state.SelectedID == "csv" {
+		tables, err := expanders.LogAnalyticsQueryResultToCSV(result)
 		if err != nil {
-			h.StatusBar.Status(fmt.Sprintf("Failed to generate node summary: %s", err.Error()), false)
-			return nil
+			eventing.SendStatusEvent(&eventing.StatusEvent{
+				InProgress: false,
+				Failure:    true,
+				Message:    "Failed converting query results to CSV: " + err.Error(),
+				Timeout:    time.Duration(time.Second * 4),
+			})
+			return
 		}
-		if err := copyToClipboard(string(buf)); err != nil {
-			h.StatusBar.Status(fmt.Sprintf("Failed to copy node data to clipboard: %s", err.Error()), false)
-			return nil
+		fileNames, contents = []string{}, []string{}
+		for index, table := range tables {
+			fileName := fileNamePrefix + ".csv"
+			if len(tables) > 1 {
+				fileName = fmt.Sprintf("%s-%d-%s.csv", fileNamePrefix, index+1, table.Name)
+			}
+			fileNames = append(fileNames, fileName)
+			contents = append(contents, table.CSV)
 		}
-		h.StatusBar.Status("Current node data copied to clipboard", false)
+	}
+
+	filePaths := []string{}
+	for index, fileName := range fileNames {
+		filePath, err := filepath.Abs(fileName)
+		if err == nil {
+			err = ioutil.WriteFile(filePath, []byte(contents[index]), 0644)
+		}
+		if err != nil {
+			eventing.SendStatusEvent(&eventing.StatusEvent{
+				InProgress: false,
+				Failure:    true,
+				Message:    "Failed exporting query results: " + err.Error(),
+				Timeout:    time.Duration(time.Second * 4),
+			})
+			return
+		}
+		filePaths = append(filePaths, filePath)
+	}
+
+	message := "Exported query results to " + filePaths[0]
+	if len(filePaths) > 1 {
+		message = fmt.Sprintf("Exported %d query result tables to %s", len(filePaths), strings.Join(filePaths, ", "))
+	}
+	eventing.SendStatusEvent(&eventing.StatusEvent{
+		InProgress: false,
+		Message:    message,
+		Timeout:    time.Duration(time.Second * 6),
+	})
+}
+
+////////////////////////////////////////////////////////////////////
+
+////////////////////////////////////////////////////////////////////
+type CommandPanelAddRoleAssignmentHandler struct {
+	ListHandler
+	commandPanelWidget *views.CommandPanelWidget
+	list               *views.ListWidget
+	gui                *gocui.Gui
+	ctx                context.Context
+
+	// state captured as the user steps through the panels
+	scope            string
+	roleDefinitionID string
+	principalID      string
+	refreshList      bool
+}
+
+var _ Command = &CommandPanelAddRoleAssignmentHandler{}
+
+func NewCommandPanelAddRoleAssignmentHandler(gui *gocui.Gui, commandPanelWidget *views.CommandPanelWidget, list *views.ListWidget, ctx context.Context) *CommandPanelAddRoleAssignmentHandler {
+	handler := &CommandPanelAddRoleAssignmentHandler{
+		commandPanelWidget: commandPanelWidget,
+		list:               list,
+		gui:                gui,
+		ctx:                ctx,
+	}
+	handler.id = HandlerIDRoleAssignmentAdd
+
+	return handler
+}
+
+func (h *CommandPanelAddRoleAssignmentHandler) Fn() func(g *gocui.Gui, v *gocui.View) error {
+	return func(g *gocui.Gui, v *gocui.View) error {
+		if h.IsEnabled() {
+			return h.Invoke()
+		}
+		return nil
+	}
+}
+
+func (h *CommandPanelAddRoleAssignmentHandler) DisplayText() string {
+	return "Add role assignment"
+}
+
+// getRoleAssignmentsNode returns the IAM node that is selected or currently expanded
+func (h *CommandPanelAddRoleAssignmentHandler) getRoleAssignmentsNode() *expanders.TreeNode {
+	if item := h.list.CurrentItem(); item != nil && item.ItemType == expanders.RoleAssignmentsType {
+		return item
+	}
+	if item := h.list.CurrentExpandedItem(); item != nil && item.ItemType == expanders.RoleAssignmentsType {
+		return item
+	}
+	return nil
+}
+
+func (h *CommandPanelAddRoleAssignmentHandler) IsEnabled() bool {
+	return h.getRoleAssignmentsNode() != nil
+}
+
+func (h *CommandPanelAddRoleAssignmentHandler) Invoke() error {
+	node := h.getRoleAssignmentsNode()
+	if node == nil {
+		return nil
+	}
+	h.scope = node.Metadata[expanders.RoleAssignmentScopeMeta]
+	h.refreshList = h.list.CurrentExpandedItem() == node
+	h.roleDefinitionID = ""
+	h.principalID = ""
+
+	roleDefinitions, err := expanders.ListRoleDefinitions(h.ctx, armclient.LegacyInstance, h.scope)
+	if err != nil {
+		eventing.SendStatusEvent(&eventing.StatusEvent{
+			InProgress: false,
+			Failure:    true,
+			Message:    err.Error(),
+			Timeout:    time.Duration(time.Second * 4),
+		})
+		return nil
+	}
+
+	options := []views.CommandPanelListOption{}
+	for _, roleDefinition := range roleDefinitions {
+		options = append(options, views.CommandPanelListOption{
+			ID:          roleDefinition.ID,
+			DisplayText: roleDefinition.Properties.RoleName,
+		})
+	}
+	h.commandPanelWidget.ShowWithText("Role to assign", "", &options, h.roleSelected)
+	return nil
+}
+
+func (h *CommandPanelAddRoleAssignmentHandler) roleSelected(state views.CommandPanelNotification) {
+	if !state.EnterPressed {
+		return
+	}
+	h.commandPanelWidget.Hide()
+	if state.SelectedID == "" {
+		return
+	}
+	h.roleDefinitionID = state.SelectedID
+
+	// show the next panel via Update to allow Hide to restore preview view state
+	h.gui.Update(func(gui *gocui.Gui) error {
+		h.commandPanelWidget.ShowWithText("Principal object ID", "", nil, h.principalEntered)
+		return nil
+	})
+}
+
+func (h *CommandPanelAddRoleAssignmentHandler) principalEntered(state views.CommandPanelNotification) {
+	if !state.EnterPressed {
+		return
+	}
+	h.commandPanelWidget.Hide()
+	h.principalID = strings.TrimSpace(state.CurrentText)
+	if h.principalID == "" {
+		return
+	}
+
+	options := []views.CommandPanelListOption{}
+	for _, principalType := range expanders.RoleAssignmentPrincipalTypes {
+		options = append(options, views.CommandPanelListOption{
+			ID:          principalType,
+			DisplayText: principalType,
+		})
+	}
+	h.gui.Update(func(gui *gocui.Gui) error {
+		h.commandPanelWidget.ShowWithText("Principal type", "", &options, h.principalTypeSelected)
+		return nil
+	})
+}
+
+func (h *CommandPanelAddRoleAssignmentHandler) principalTypeSelected(state views.CommandPanelNotification) {
+	if !state.EnterPressed {
+		return
+	}
+	h.commandPanelWidget.Hide()
+	if state.SelectedID == "" {
+		return
+	}
+
+	event, _ := eventing.SendStatusEvent(&eventing.StatusEvent{
+		InProgress: true,
+		Message:    "Adding role assignment for " + h.principalID,
+	})
+	err := expanders.CreateRoleAssignment(h.ctx, armclient.LegacyInstance, h.scope, h.roleDefinitionID, h.principalID, state.SelectedID)
+	if err != nil {
+		event.Failure = true
+		event.Message = err.Error()
+		event.InProgress = false
+		event.Update()
+		return
+	}
+	event.Message = "Added role assignment for " + h.principalID
+	event.InProgress = false
+	event.Update()
+
+	if h.refreshList {
+		h.list.Refresh()
+	}
+}
+
+////////////////////////////////////////////////////////////////////
+
+////////////////////////////////////////////////////////////////////
+type CommandPanelRemoveRoleAssignmentHandler struct {
+	ListHandler
+	commandPanelWidget *views.CommandPanelWidget
+	list               *views.ListWidget
+	ctx                context.Context
+}
+
+var _ Command = &CommandPanelRemoveRoleAssignmentHandler{}
+
+func NewCommandPanelRemoveRoleAssignmentHandler(commandPanelWidget *views.CommandPanelWidget, list *views.ListWidget, ctx context.Context) *CommandPanelRemoveRoleAssignmentHandler {
+	handler := &CommandPanelRemoveRoleAssignmentHandler{
+		commandPanelWidget: commandPanelWidget,
+		list:               list,
+		ctx:                ctx,
+	}
+	handler.id = HandlerIDRoleAssignmentRemove
+
+	return handler
+}
+
+func (h *CommandPanelRemoveRoleAssignmentHandler) Fn() func(g *gocui.Gui, v *gocui.View) error {
+	return func(g *gocui.Gui, v *gocui.View) error {
+		if h.IsEnabled() {
+			return h.Invoke()
+		}
+		return nil
+	}
+}
+
+func (h *CommandPanelRemoveRoleAssignmentHandler) DisplayText() string {
+	return "Remove role assignment"
+}
+
+func (h *CommandPanelRemoveRoleAssignmentHandler) IsEnabled() bool {
+	item := h.list.CurrentItem()
+	// Inherited assignments can only be removed at the scope they were made
+	return item != nil && item.ItemType == expanders.RoleAssignmentType && item.Metadata[expanders.RoleAssignmentInheritedMeta] != "true"
+}
+
+func (h *CommandPanelRemoveRoleAssignmentHandler) Invoke() error {
+	item := h.list.CurrentItem()
+	options := []views.CommandPanelListOption{
+		{ID: "remove", DisplayText: "Remove '" + item.Name + "' from " + item.Metadata[expanders.RoleAssignmentPrincipalMeta]},
+		{ID: "cancel", DisplayText: "Cancel"},
+	}
+	h.commandPanelWidget.ShowWithText("Remove role assignment?", "", &options, h.CommandPanelNotification)
+	return nil
+}
+
+func (h *CommandPanelRemoveRoleAssignmentHandler) CommandPanelNotification(state views.CommandPanelNotification) {
+	if !state.EnterPressed {
+		return
+	}
+	h.commandPanelWidget.Hide()
+	if state.SelectedID != "remove" {
+		return
+	}
+
+	item := h.list.CurrentItem()
+	event, _ := eventing.SendStatusEvent(&eventing.StatusEvent{
+		InProgress: true,
+		Message:    "Removing role assignment " + item.Name,
+	})
+	err := expanders.DeleteRoleAssignment(h.ctx, armclient.LegacyInstance, item.ID)
+	if err != nil {
+		event.Failure = true
+		event.Message = err.Error()
+		event.InProgress = false
+		event.Update()
+		return
+	}
+	event.Message = "Removed role assignment " + item.Name
+	event.InProgress = false
+	event.Update()
+
+	h.list.Refresh()
+}
+
+////////////////////////////////////////////////////////////////////
+
+////////////////////////////////////////////////////////////////////
+type CommandPanelAddLockHandler struct {
+	ListHandler
+	commandPanelWidget *views.CommandPanelWidget
+	list               *views.ListWidget
+	gui                *gocui.Gui
+	ctx                context.Context
+
+	// state captured as the user steps through the panels
+	scope       string
+	level       string
+	refreshList bool
+}
+
+var _ Command = &CommandPanelAddLockHandler{}
+
+func NewCommandPanelAddLockHandler(gui *gocui.Gui, commandPanelWidget *views.CommandPanelWidget, list *views.ListWidget, ctx context.Context) *CommandPanelAddLockHandler {
+	handler := &CommandPanelAddLockHandler{
+		commandPanelWidget: commandPanelWidget,
+		list:               list,
+		gui:                gui,
+		ctx:                ctx,
+	}
+	handler.id = HandlerIDLockAdd
+
+	return handler
+}
+
+func (h *CommandPanelAddLockHandler) Fn() func(g *gocui.Gui, v *gocui.View) error {
+	return func(g *gocui.Gui, v *gocui.View) error {
+		if h.IsEnabled() {
+			return h.Invoke()
+		}
+		return nil
+	}
+}
+
+func (h *CommandPanelAddLockHandler) DisplayText() string {
+	return "Add lock"
+}
+
+// getLocksNode returns the Locks node that is selected or currently expanded
+func (h *CommandPanelAddLockHandler) getLocksNode() *expanders.TreeNode {
+	if item := h.list.CurrentItem(); item != nil && item.ItemType == expanders.LocksType {
+		return item
+	}
+	if item := h.list.CurrentExpandedItem(); item != nil && item.ItemType == expanders.LocksType {
+		return item
+	}
+	return nil
+}
+
+func (h *CommandPanelAddLockHandler) IsEnabled() bool {
+	return h.getLocksNode() != nil
+}
+
+func (h *CommandPanelAddLockHandler) Invoke() error {
+	node := h.getLocksNode()
+	if node == nil {
+		return nil
+	}
+	h.scope = node.Metadata[expanders.LockScopeMeta]
+	h.refreshList = h.list.CurrentExpandedItem() == node
+	h.level = ""
+
+	options := []views.CommandPanelListOption{}
+	for _, level := range expanders.LockLevels {
+		options = append(options, views.CommandPanelListOption{
+			ID:          level,
+			DisplayText: level,
+		})
+	}
+	h.commandPanelWidget.ShowWithText("Lock level", "", &options, h.levelSelected)
+	return nil
+}
+
+func (h *CommandPanelAddLockHandler) levelSelected(state views.CommandPanelNotification) {
+	if !state.EnterPressed {
+		return
+	}
+	h.commandPanelWidget.Hide()
+	if state.SelectedID == "" {
+		return
+	}
+	h.level = state.SelectedID
+
+	// show the next panel via Update to allow Hide to restore preview view state
+	h.gui.Update(func(gui *gocui.Gui) error {
+		h.commandPanelWidget.ShowWithText("Lock name", "", nil, h.nameEntered)
+		return nil
+	})
+}
+
+func (h *CommandPanelAddLockHandler) nameEntered(state views.CommandPanelNotification) {
+	if !state.EnterPressed {
+		return
+	}
+	h.commandPanelWidget.Hide()
+	name := strings.TrimSpace(state.CurrentText)
+	if name == "" {
+		return
+	}
+
+	event, _ := eventing.SendStatusEvent(&eventing.StatusEvent{
+		InProgress: true,
+		Message:    "Adding " + h.level + " lock " + name,
+	})
+	err := expanders.CreateLock(h.ctx, armclient.LegacyInstance, h.scope, name, h.level, "")
+	if err != nil {
+		event.Failure = true
+		event.Message = err.Error()
+		event.InProgress = false
+		event.Update()
+		return
+	}
+	event.Message = "Added " + h.level + " lock " + name
+	event.InProgress = false
+	event.Update()
+
+	if h.refreshList {
+		h.list.Refresh()
+	}
+}
+
+////////////////////////////////////////////////////////////////////
+
+////////////////////////////////////////////////////////////////////
+type CommandPanelRemoveLockHandler struct {
+	ListHandler
+	commandPanelWidget *views.CommandPanelWidget
+	list               *views.ListWidget
+	ctx                context.Context
+}
+
+var _ Command = &CommandPanelRemoveLockHandler{}
+
+func NewCommandPanelRemoveLockHandler(commandPanelWidget *views.CommandPanelWidget, list *views.ListWidget, ctx context.Context) *CommandPanelRemoveLockHandler {
+	handler := &CommandPanelRemoveLockHandler{
+		commandPanelWidget: commandPanelWidget,
+		list:               list,
+		ctx:                ctx,
+	}
+	handler.id = HandlerIDLockRemove
+
+	return handler
+}
+
+func (h *CommandPanelRemoveLockHandler) Fn() func(g *gocui.Gui, v *gocui.View) error {
+	return func(g *gocui.Gui, v *gocui.View) error {
+		if h.IsEnabled() {
+			return h.Invoke()
+		}
+		return nil
+	}
+}
+
+func (h *CommandPanelRemoveLockHandler) DisplayText() string {
+	return "Remove lock"
+}
+
+func (h *CommandPanelRemoveLockHandler) IsEnabled() bool {
+	item := h.list.CurrentItem()
+	// Inherited locks can only be removed at the scope they were applied
+	return item != nil && item.ItemType == expanders.LockType && item.Metadata[expanders.LockInheritedMeta] != "true"
+}
+
+func (h *CommandPanelRemoveLockHandler) Invoke() error {
+	item := h.list.CurrentItem()
+	options := []views.CommandPanelListOption{
+		{ID: "remove", DisplayText: "Remove " + item.Metadata[expanders.LockLevelMeta] + " lock '" + item.Name + "'"},
+		{ID: "cancel", DisplayText: "Cancel"},
+	}
+	h.commandPanelWidget.ShowWithText("Remove lock?", "", &options, h.CommandPanelNotification)
+	return nil
+}
+
+func (h *CommandPanelRemoveLockHandler) CommandPanelNotification(state views.CommandPanelNotification) {
+	if !state.EnterPressed {
+		return
+	}
+	h.commandPanelWidget.Hide()
+	if state.SelectedID != "remove" {
+		return
+	}
+
+	item := h.list.CurrentItem()
+	event, _ := eventing.SendStatusEvent(&eventing.StatusEvent{
+		InProgress: true,
+		Message:    "Removing lock " + item.Name,
+	})
+	err := expanders.DeleteLock(h.ctx, armclient.LegacyInstance, item.ID)
+	if err != nil {
+		event.Failure = true
+		event.Message = err.Error()
+		event.InProgress = false
+		event.Update()
+		return
+	}
+	event.Message = "Removed lock " + item.Name
+	event.InProgress = false
+	event.Update()
+
+	h.list.Refresh()
+}
+
+////////////////////////////////////////////////////////////////////
+
+////////////////////////////////////////////////////////////////////
+type CommandPanelGoToReferenceHandler struct {
+	ListHandler
+	commandPanelWidget *views.CommandPanelWidget
+	list               *views.ListWidget
+	content            *views.ItemWidget
+}
+
+var _ Command = &CommandPanelGoToReferenceHandler{}
+
+func NewCommandPanelGoToReferenceHandler(commandPanelWidget *views.CommandPanelWidget, list *views.ListWidget, content *views.ItemWidget) *CommandPanelGoToReferenceHandler {
+	handler := &CommandPanelGoToReferenceHandler{
+		commandPanelWidget: commandPanelWidget,
+		list:               list,
+		content:            content,
+	}
+	handler.id = HandlerIDGoToReference
+
+	return handler
+}
+
+func (h *CommandPanelGoToReferenceHandler) Fn() func(g *gocui.Gui, v *gocui.View) error {
+	return func(g *gocui.Gui, v *gocui.View) error {
+		if h.IsEnabled() {
+			return h.Invoke()
+		}
+		return nil
+	}
+}
+
+func (h *CommandPanelGoToReferenceHandler) DisplayText() string {
+	return "Go to referenced resource"
+}
+
+func (h *CommandPanelGoToReferenceHandler) IsEnabled() bool {
+	return len(h.referencedIDs()) > 0
+}
+
+func (h *CommandPanelGoToReferenceHandler) Invoke() error {
+	options := []views.CommandPanelListOption{}
+	for _, id := range h.referencedIDs() {
+		options = append(options, views.CommandPanelListOption{ID: id, DisplayText: id})
+	}
+	h.commandPanelWidget.ShowWithText("Go to referenced resource", "", &options, h.CommandPanelNotification)
+	return nil
+}
+
+func (h *CommandPanelGoToReferenceHandler) CommandPanelNotification(state views.CommandPanelNotification) {
+	if !state.EnterPressed {
+		return
+	}
+	h.commandPanelWidget.Hide()
+	if state.SelectedID == "" {
+		return
+	}
+
+	automation.NavigateTo(h.list, state.SelectedID)
+	h.list.GoHome()
+}
+
+// referencedIDs returns the IDs of the resources referenced in the displayed response
+func (h *CommandPanelGoToReferenceHandler) referencedIDs() []string {
+	if h.content.GetContentType() != expanders.ResponseJSON {
+		return nil
+	}
+	currentID := ""
+	if node := h.content.GetNode(); node != nil {
+		currentID = node.ID
+	}
+	return expanders.FindReferencedResourceIDs(h.content.GetContent(), currentID)
+}
+
+////////////////////////////////////////////////////////////////////
+
+// getMetricsGraphNode returns the metric graph that is selected
+func getMetricsGraphNode(list *views.ListWidget) *expanders.TreeNode {
+	if item := list.CurrentItem(); item != nil && item.ItemType == expanders.MetricsGraphType {
+		return item
+	}
+	return nil
+}
+
+// updateMetricsGraph saves the settings for the graph and redraws it
+func updateMetricsGraph(list *views.ListWidget, node *expanders.TreeNode, settings expanders.MetricGraphSettings) {
+	err := expanders.SetMetricGraphSettings(node, settings)
+	if err != nil {
+		eventing.SendStatusEvent(&eventing.StatusEvent{
+			InProgress: false,
+			Failure:    true,
+			Message:    "Failed to save metric graph settings: " + err.Error(),
+			Timeout:    time.Duration(time.Second * 4),
+		})
+	}
+	list.ExpandCurrentSelection()
+}
+
+func metricsOptionsToCommandPanelOptions(metricsOptions []expanders.MetricsOption) []views.CommandPanelListOption {
+	options := []views.CommandPanelListOption{}
+	for _, option := range metricsOptions {
+		options = append(options, views.CommandPanelListOption{
+			ID:          option.Value,
+			DisplayText: option.Display,
+		})
+	}
+	return options
+}
+
+////////////////////////////////////////////////////////////////////
+type CommandPanelMetricsTimeRangeHandler struct {
+	ListHandler
+	commandPanelWidget *views.CommandPanelWidget
+	list               *views.ListWidget
+	gui                *gocui.Gui
+}
+
+var _ Command = &CommandPanelMetricsTimeRangeHandler{}
+
+func NewCommandPanelMetricsTimeRangeHandler(gui *gocui.Gui, commandPanelWidget *views.CommandPanelWidget, list *views.ListWidget) *CommandPanelMetricsTimeRangeHandler {
+	handler := &CommandPanelMetricsTimeRangeHandler{
+		commandPanelWidget: commandPanelWidget,
+		list:               list,
+		gui:                gui,
+	}
+	handler.id = HandlerIDMetricsTimeRange
+
+	return handler
+}
+
+func (h *CommandPanelMetricsTimeRangeHandler) Fn() func(g *gocui.Gui, v *gocui.View) error {
+	return func(g *gocui.Gui, v *gocui.View) error {
+		if h.IsEnabled() {
+			return h.Invoke()
+		}
+		return nil
+	}
+}
+
+func (h *CommandPanelMetricsTimeRangeHandler) DisplayText() string {
+	return "Set metric graph time range"
+}
+
+func (h *CommandPanelMetricsTimeRangeHandler) IsEnabled() bool {
+	return getMetricsGraphNode(h.list) != nil
+}
+
+func (h *CommandPanelMetricsTimeRangeHandler) Invoke() error {
+	options := metricsOptionsToCommandPanelOptions(expanders.MetricsTimeRanges)
+	options = append(options, views.CommandPanelListOption{
+		ID:          expanders.MetricsCustomTimeRange,
+		DisplayText: "Custom",
+	})
+	h.commandPanelWidget.ShowWithText("Metric graph time range", "", &options, h.timeRangeSelected)
+	return nil
+}
+
+func (h *CommandPanelMetricsTimeRangeHandler) timeRangeSelected(state views.CommandPanelNotification) {
+	if !state.EnterPressed {
+		return
+	}
+	h.commandPanelWidget.Hide()
+
+	node := getMetricsGraphNode(h.list)
+	if state.SelectedID == "" || node == nil {
+		return
+	}
+	if state.SelectedID != expanders.MetricsCustomTimeRange {
+		settings := expanders.GetMetricGraphSettings(node)
+		settings.TimeRange = state.SelectedID
+		updateMetricsGraph(h.list, node, settings)
+		return
+	}
+
+	// show the next panel via Update to allow Hide to restore preview view state
+	h.gui.Update(func(gui *gocui.Gui) error {
+		now := time.Now().UTC()
+		example := now.Add(-24*time.Hour).Format(time.RFC3339) + "/" + now.Format(time.RFC3339)
+		h.commandPanelWidget.ShowWithText("Time range (start/end)", example, nil, h.customTimeRangeEntered)
+		return nil
+	})
+}
+
+func (h *CommandPanelMetricsTimeRangeHandler) customTimeRangeEntered(state views.CommandPanelNotification) {
+	if !state.EnterPressed {
+		return
+	}
+	h.commandPanelWidget.Hide()
+
+	node := getMetricsGraphNode(h.list)
+	timeRange := strings.TrimSpace(state.CurrentText)
+	if timeRange == "" || node == nil {
+		return
+	}
+	settings := expanders.GetMetricGraphSettings(node)
+	settings.TimeRange = timeRange
+	updateMetricsGraph(h.list, node, settings)
+}
+
+////////////////////////////////////////////////////////////////////
+
+////////////////////////////////////////////////////////////////////
+type CommandPanelMetricsIntervalHandler struct {
+	ListHandler
+	commandPanelWidget *views.CommandPanelWidget
+	list               *views.ListWidget
+}
+
+var _ Command = &CommandPanelMetricsIntervalHandler{}
+
+func NewCommandPanelMetricsIntervalHandler(commandPanelWidget *views.CommandPanelWidget, list *views.ListWidget) *CommandPanelMetricsIntervalHandler {
+	handler := &CommandPanelMetricsIntervalHandler{
+		commandPanelWidget: commandPanelWidget,
+		list:               list,
+	}
+	handler.id = HandlerIDMetricsInterval
+
+	return handler
+}
+
+func (h *CommandPanelMetricsIntervalHandler) Fn() func(g *gocui.Gui, v *gocui.View) error {
+	return func(g *gocui.Gui, v *gocui.View) error {
+		if h.IsEnabled() {
+			return h.Invoke()
+		}
+		return nil
+	}
+}
+
+func (h *CommandPanelMetricsIntervalHandler) DisplayText() string {
+	return "Set metric graph interval"
+}
+
+func (h *CommandPanelMetricsIntervalHandler) IsEnabled() bool {
+	return getMetricsGraphNode(h.list) != nil
+}
+
+func (h *CommandPanelMetricsIntervalHandler) Invoke() error {
+	options := metricsOptionsToCommandPanelOptions(expanders.MetricsIntervals)
+	h.commandPanelWidget.ShowWithText("Metric graph interval", "", &options, h.CommandPanelNotification)
+	return nil
+}
+
+func (h *CommandPanelMetricsIntervalHandler) CommandPanelNotification(state views.CommandPanelNotification) {
+	if !state.EnterPressed {
+		return
+	}
+	h.commandPanelWidget.Hide()
+
+	node := getMetricsGraphNode(h.list)
+	if state.SelectedID == "" || node == nil {
+		return
+	}
+	settings := expanders.GetMetricGraphSettings(node)
+	settings.Interval = state.SelectedID
+	updateMetricsGraph(h.list, node, settings)
+}
+
+////////////////////////////////////////////////////////////////////
+
+////////////////////////////////////////////////////////////////////
+type CommandPanelMetricsAggregationHandler struct {
+	ListHandler
+	commandPanelWidget *views.CommandPanelWidget
+	list               *views.ListWidget
+}
+
+var _ Command = &CommandPanelMetricsAggregationHandler{}
+
+func NewCommandPanelMetricsAggregationHandler(commandPanelWidget *views.CommandPanelWidget, list *views.ListWidget) *CommandPanelMetricsAggregationHandler {
+	handler := &CommandPanelMetricsAggregationHandler{
+		commandPanelWidget: commandPanelWidget,
+		list:               list,
+	}
+	handler.id = HandlerIDMetricsAggregation
+
+	return handler
+}
+
+func (h *CommandPanelMetricsAggregationHandler) Fn() func(g *gocui.Gui, v *gocui.View) error {
+	return func(g *gocui.Gui, v *gocui.View) error {
+		if h.IsEnabled() {
+			return h.Invoke()
+		}
+		return nil
+	}
+}
+
+func (h *CommandPanelMetricsAggregationHandler) DisplayText() string {
+	return "Set metric graph aggregation"
+}
+
+func (h *CommandPanelMetricsAggregationHandler) IsEnabled() bool {
+	node := getMetricsGraphNode(h.list)
+	return node != nil && node.Metadata[expanders.MetricsSupportedAggregationsMeta] != ""
+}
+
+func (h *CommandPanelMetricsAggregationHandler) Invoke() error {
+	node := getMetricsGraphNode(h.list)
+	options := []views.CommandPanelListOption{}
+	for _, aggregation := range strings.Split(node.Metadata[expanders.MetricsSupportedAggregationsMeta], ",") {
+		options = append(options, views.CommandPanelListOption{
+			ID:          aggregation,
+			DisplayText: aggregation,
+		})
+	}
+	h.commandPanelWidget.ShowWithText("Metric graph aggregation", "", &options, h.CommandPanelNotification)
+	return nil
+}
+
+func (h *CommandPanelMetricsAggregationHandler) CommandPanelNotification(state views.CommandPanelNotification) {
+	if !state.EnterPressed {
+		return
+	}
+	h.commandPanelWidget.Hide()
+
+	node := getMetricsGraphNode(h.list)
+	if state.SelectedID == "" || node == nil {
+		return
+	}
+	settings := expanders.GetMetricGraphSettings(node)
+	settings.Aggregation = state.SelectedID
+	updateMetricsGraph(h.list, node, settings)
+}
+
+////////////////////////////////////////////////////////////////////
+
+////////////////////////////////////////////////////////////////////
+type CommandPanelMetricsDimensionHandler struct {
+	ListHandler
+	commandPanelWidget *views.CommandPanelWidget
+	list               *views.ListWidget
+	gui                *gocui.Gui
+
+	// state captured as the user steps through the panels
+	dimension string
+}
+
+var _ Command = &CommandPanelMetricsDimensionHandler{}
+
+const clearMetricsDimensionsID = "<clear>"
+
+func NewCommandPanelMetricsDimensionHandler(gui *gocui.Gui, commandPanelWidget *views.CommandPanelWidget, list *views.ListWidget) *CommandPanelMetricsDimensionHandler {
+	handler := &CommandPanelMetricsDimensionHandler{
+		commandPanelWidget: commandPanelWidget,
+		list:               list,
+		gui:                gui,
+	}
+	handler.id = HandlerIDMetricsDimension
+
+	return handler
+}
+
+func (h *CommandPanelMetricsDimensionHandler) Fn() func(g *gocui.Gui, v *gocui.View) error {
+	return func(g *gocui.Gui, v *gocui.View) error {
+		if h.IsEnabled() {
+			return h.Invoke()
+		}
+		return nil
+	}
+}
+
+func (h *CommandPanelMetricsDimensionHandler) DisplayText() string {
+	return "Filter or split metric graph by dimension"
+}
+
+func (h *CommandPanelMetricsDimensionHandler) IsEnabled() bool {
+	node := getMetricsGraphNode(h.list)
+	return node != nil && node.Metadata[expanders.MetricsDimensionsMeta] != ""
+}
+
+func (h *CommandPanelMetricsDimensionHandler) Invoke() error {
+	node := getMetricsGraphNode(h.list)
+	settings := expanders.GetMetricGraphSettings(node)
+
+	options := []views.CommandPanelListOption{}
+	for _, dimension := range strings.Split(node.Metadata[expanders.MetricsDimensionsMeta], ",") {
+		displayText := dimension
+		if value, found := settings.Dimensions[dimension]; found {
+			displayText += " (" + value + ")"
+		}
+		options = append(options, views.CommandPanelListOption{
+			ID:          dimension,
+			DisplayText: displayText,
+		})
+	}
+	options = append(options, views.CommandPanelListOption{
+		ID:          clearMetricsDimensionsID,
+		DisplayText: "Clear dimension filters",
+	})
+	h.commandPanelWidget.ShowWithText("Metric graph dimension", "", &options, h.dimensionSelected)
+	return nil
+}
+
+func (h *CommandPanelMetricsDimensionHandler) dimensionSelected(state views.CommandPanelNotification) {
+	if !state.EnterPressed {
+		return
+	}
+	h.commandPanelWidget.Hide()
+
+	node := getMetricsGraphNode(h.list)
+	if state.SelectedID == "" || node == nil {
+		return
+	}
+	if state.SelectedID == clearMetricsDimensionsID {
+		settings := expanders.GetMetricGraphSettings(node)
+		settings.Dimensions = map[string]string{}
+		updateMetricsGraph(h.list, node, settings)
+		return
+	}
+	h.dimension = state.SelectedID
+
+	// show the next panel via Update to allow Hide to restore preview view state
+	h.gui.Update(func(gui *gocui.Gui) error {
+		h.commandPanelWidget.ShowWithText("Value for "+h.dimension+" ('*' to split, empty to remove)", "*", nil, h.valueEntered)
+		return nil
+	})
+}
+
+func (h *CommandPanelMetricsDimensionHandler) valueEntered(state views.CommandPanelNotification) {
+	if !state.EnterPressed {
+		return
+	}
+	h.commandPanelWidget.Hide()
+
+	node := getMetricsGraphNode(h.list)
+	if node == nil {
+		return
+	}
+	settings := expanders.GetMetricGraphSettings(node)
+	value := strings.TrimSpace(state.CurrentText)
+	if value == "" {
+		delete(settings.Dimensions, h.dimension)
+	} else {
+		settings.Dimensions[h.dimension] = value
+	}
+	updateMetricsGraph(h.list, node, settings)
+}
+
+////////////////////////////////////////////////////////////////////
+
+////////////////////////////////////////////////////////////////////
+type CommandPanelListWatchHandler struct {
+	ListHandler
+	commandPanelWidget *views.CommandPanelWidget
+	list               *views.ListWidget
+}
+
+var _ Command = &CommandPanelListWatchHandler{}
+
+// watchIntervals lists the refresh intervals offered when watching a node
+var watchIntervals = []time.Duration{5 * time.Second, 10 * time.Second, 30 * time.Second, time.Minute, 5 * time.Minute}
+
+const stopWatchingID = "stop"
+
+func NewCommandPanelListWatchHandler(commandPanelWidget *views.CommandPanelWidget, list *views.ListWidget) *CommandPanelListWatchHandler {
+	handler := &CommandPanelListWatchHandler{
+		commandPanelWidget: commandPanelWidget,
+		list:               list,
+	}
+	handler.id = HandlerIDListWatch
+
+	return handler
+}
+
+func (h *CommandPanelListWatchHandler) Fn() func(g *gocui.Gui, v *gocui.View) error {
+	return func(g *gocui.Gui, v *gocui.View) error {
+		if h.IsEnabled() {
+			return h.Invoke()
+		}
+		return nil
+	}
+}
+
+func (h *CommandPanelListWatchHandler) DisplayText() string {
+	return "Watch (auto-refresh) current item"
+}
+
+func (h *CommandPanelListWatchHandler) IsEnabled() bool {
+	return h.list.CurrentExpandedItem() != nil || h.list.WatchedNode() != nil
+}
+
+func (h *CommandPanelListWatchHandler) Invoke() error {
+	options := []views.CommandPanelListOption{}
+	if watchedNode := h.list.WatchedNode(); watchedNode != nil {
+		options = append(options, views.CommandPanelListOption{
+			ID:          stopWatchingID,
+			DisplayText: "Stop watching " + watchedNode.Name,
+		})
+	}
+	for _, interval := range watchIntervals {
+		options = append(options, views.CommandPanelListOption{
+			ID:          interval.String(),
+			DisplayText: "Refresh every " + interval.String(),
+		})
+	}
+	h.commandPanelWidget.ShowWithText("Watch interval", "", &options, h.CommandPanelNotification)
+	return nil
+}
+
+func (h *CommandPanelListWatchHandler) CommandPanelNotification(state views.CommandPanelNotification) {
+	if !state.EnterPressed {
+		return
+	}
+	h.commandPanelWidget.Hide()
+
+	if state.SelectedID == stopWatchingID {
+		h.list.StopWatching()
+		return
+	}
+	interval, err := time.ParseDuration(state.SelectedID)
+	if err != nil {
+		return
+	}
+	h.list.Watch(interval)
+}
+
+////////////////////////////////////////////////////////////////////
+
+////////////////////////////////////////////////////////////////////
+type CommandPanelTailLogsHandler struct {
+	ListHandler
+	commandPanelWidget *views.CommandPanelWidget
+	list               *views.ListWidget
+	logTail            *views.LogTail
+	gui                *gocui.Gui
+	ctx                context.Context
+
+	// state captured as the user steps through the panels
+	node *expanders.TreeNode
+}
+
+var _ Command = &CommandPanelTailLogsHandler{}
+
+const (
+	tailLogsPauseID     = "pause"
+	tailLogsFollowID    = "follow"
+	tailLogsFilterID    = "filter"
+	tailLogsContainerID = "container"
+	tailLogsStopID      = "stop"
+)
+
+func NewCommandPanelTailLogsHandler(gui *gocui.Gui, commandPanelWidget *views.CommandPanelWidget, list *views.ListWidget, logTail *views.LogTail, ctx context.Context) *CommandPanelTailLogsHandler {
+	handler := &CommandPanelTailLogsHandler{
+		commandPanelWidget: commandPanelWidget,
+		list:               list,
+		logTail:            logTail,
+		gui:                gui,
+		ctx:                ctx,
+	}
+	handler.id = HandlerIDTailLogs
+
+	return handler
+}
+
+func (h *CommandPanelTailLogsHandler) Fn() func(g *gocui.Gui, v *gocui.View) error {
+	return func(g *gocui.Gui, v *gocui.View) error {
+		if h.IsEnabled() {
+			return h.Invoke()
+		}
+		return nil
+	}
+}
+
+func (h *CommandPanelTailLogsHandler) DisplayText() string {
+	if h.logTail.IsTailing() {
+		return "Tail logs (pause, filter, stop)"
+	}
+	return "Tail logs"
+}
+
+func (h *CommandPanelTailLogsHandler) IsEnabled() bool {
+	return h.logTail.IsTailing() || expanders.CanStreamLogs(h.list.CurrentItem())
+}
+
+func (h *CommandPanelTailLogsHandler) Invoke() error {
+	if !h.logTail.IsTailing() {
+		h.node = h.list.CurrentItem()
+		h.chooseContainer()
+		return nil
+	}
+
+	h.node = h.logTail.Node()
+	options := []views.CommandPanelListOption{}
+	if h.logTail.IsPaused() {
+		options = append(options, views.CommandPanelListOption{ID: tailLogsFollowID, DisplayText: "Follow"})
+	} else {
+		options = append(options, views.CommandPanelListOption{ID: tailLogsPauseID, DisplayText: "Pause"})
+	}
+	options = append(options,
+		views.CommandPanelListOption{ID: tailLogsFilterID, DisplayText: "Filter"},
+		views.CommandPanelListOption{ID: tailLogsContainerID, DisplayText: "Switch container"},
+		views.CommandPanelListOption{ID: tailLogsStopID, DisplayText: "Stop tailing"},
+	)
+	h.commandPanelWidget.ShowWithText("Tail logs", "", &options, h.actionSelected)
+	return nil
+}
+
+// chooseContainer looks up the node's containers off the UI goroutine, then starts tailing the only
+// container or asks which one to tail
+func (h *CommandPanelTailLogsHandler) chooseContainer() {
+	node := h.node
+	go func() {
+		// recover from panic, if one occurrs, and leave terminal usable
+		defer errorhandling.RecoveryWithCleanup()
+
+		event, _ := eventing.SendStatusEvent(&eventing.StatusEvent{
+			InProgress: true,
+			Message:    "Finding containers for " + node.Name,
+		})
+		containers, err := expanders.GetLogStreamContainers(h.ctx, armclient.LegacyInstance, node)
+		if err != nil {
+			event.Failure = true
+			event.InProgress = false
+			event.Message = err.Error()
+			event.SetTimeout(time.Second * 4)
+			event.Update()
+			return
+		}
+		event.Done()
+
+		h.gui.Update(func(gui *gocui.Gui) error {
+			if len(containers) == 1 {
+				h.logTail.Start(h.ctx, node, containers[0])
+				return nil
+			}
+
+			options := []views.CommandPanelListOption{}
+			for _, container := range containers {
+				options = append(options, views.CommandPanelListOption{
+					ID:          container,
+					DisplayText: container,
+				})
+			}
+			h.commandPanelWidget.ShowWithText("Container", "", &options, h.containerSelected)
+			return nil
+		})
+	}()
+}
+
+func (h *CommandPanelTailLogsHandler) containerSelected(state views.CommandPanelNotification) {
+	if !state.EnterPressed {
+		return
+	}
+	h.commandPanelWidget.Hide()
+	if state.SelectedID == "" || h.node == nil {
+		return
+	}
+	h.logTail.Start(h.ctx, h.node, state.SelectedID)
+}
+
+func (h *CommandPanelTailLogsHandler) actionSelected(state views.CommandPanelNotification) {
+	if !state.EnterPressed {
+		return
+	}
+	h.commandPanelWidget.Hide()
+
+	switch state.SelectedID {
+	case tailLogsPauseID:
+		h.logTail.SetPaused(true)
+	case tailLogsFollowID:
+		h.logTail.SetPaused(false)
+	case tailLogsStopID:
+		h.logTail.Stop()
+	case tailLogsContainerID:
+		// show the next panel via Update to allow Hide to restore preview view state
+		h.gui.Update(func(gui *gocui.Gui) error {
+			h.chooseContainer()
+			return nil
+		})
+	case tailLogsFilterID:
+		h.gui.Update(func(gui *gocui.Gui) error {
+			h.commandPanelWidget.ShowWithText("Filter logs (regular expression)", "", nil, h.filterEntered)
+			return nil
+		})
+	}
+}
+
+func (h *CommandPanelTailLogsHandler) filterEntered(state views.CommandPanelNotification) {
+	if !state.EnterPressed {
+		return
+	}
+	h.commandPanelWidget.Hide()
+
+	err := h.logTail.SetFilter(strings.TrimSpace(state.CurrentText))
+	if err != nil {
+		eventing.SendStatusEvent(&eventing.StatusEvent{
+			InProgress: false,
+			Failure:    true,
+			Message:    "Invalid filter: " + err.Error(),
+			Timeout:    time.Duration(time.Second * 4),
+		})
+	}
+}
+
+////////////////////////////////////////////////////////////////////
+
+////////////////////////////////////////////////////////////////////
+type CommandPanelVMRunCommandHandler struct {
+	ListHandler
+	commandPanelWidget *views.CommandPanelWidget
+	list               *views.ListWidget
+	content            *views.ItemWidget
+	gui                *gocui.Gui
+	ctx                context.Context
+
+	// state captured as the user steps through the panels
+	vm *expanders.TreeNode
+}
+
+var _ Command = &CommandPanelVMRunCommandHandler{}
+
+func NewCommandPanelVMRunCommandHandler(gui *gocui.Gui, commandPanelWidget *views.CommandPanelWidget, list *views.ListWidget, content *views.ItemWidget, ctx context.Context) *CommandPanelVMRunCommandHandler {
+	handler := &CommandPanelVMRunCommandHandler{
+		commandPanelWidget: commandPanelWidget,
+		list:               list,
+		content:            content,
+		gui:                gui,
+		ctx:                ctx,
+	}
+	handler.id = HandlerIDVMRunCommand
+
+	return handler
+}
+
+func (h *CommandPanelVMRunCommandHandler) Fn() func(g *gocui.Gui, v *gocui.View) error {
+	return func(g *gocui.Gui, v *gocui.View) error {
+		if h.IsEnabled() {
+			return h.Invoke()
+		}
+		return nil
+	}
+}
+
+func (h *CommandPanelVMRunCommandHandler) DisplayText() string {
+	return "Run command on VM"
+}
+
+// getVMNode returns the virtual machine that is selected or currently expanded
+func (h *CommandPanelVMRunCommandHandler) getVMNode() *expanders.TreeNode {
+	if item := h.list.CurrentItem(); expanders.IsVirtualMachine(item) {
+		return item
+	}
+	if item := h.list.CurrentExpandedItem(); expanders.IsVirtualMachine(item) {
+		return item
+	}
+	return nil
+}
+
+func (h *CommandPanelVMRunCommandHandler) IsEnabled() bool {
+	return h.getVMNode() != nil
+}
+
+func (h *CommandPanelVMRunCommandHandler) Invoke() error {
+	h.vm = h.getVMNode()
+	if h.vm == nil {
+		return nil
+	}
+
+	options := []views.CommandPanelListOption{}
+	for _, commandID := range expanders.VMRunCommandIDs {
+		options = append(options, views.CommandPanelListOption{
+			ID:          commandID,
+			DisplayText: commandID,
+		})
+	}
+	h.commandPanelWidget.ShowWithText("Command", "", &options, h.commandSelected)
+	return nil
+}
+
+func (h *CommandPanelVMRunCommandHandler) commandSelected(state views.CommandPanelNotification) {
+	if !state.EnterPressed {
+		return
+	}
+	h.commandPanelWidget.Hide()
+	if state.SelectedID == "" {
+		return
+	}
+	commandID := state.SelectedID
+
+	// open the editor via Update to allow Hide to restore preview view state
+	h.gui.Update(func(gui *gocui.Gui) error {
+		return h.editAndRunScript(commandID)
+	})
+}
+
+func (h *CommandPanelVMRunCommandHandler) editAndRunScript(commandID string) error {
+	editorConfig, err := getEditorConfig()
+	if err != nil {
+		return err
+	}
+
+	template := "#!/bin/bash\n"
+	fileExtension := ".sh"
+	if commandID == "RunPowerShellScript" {
+		template = ""
+		fileExtension = ".ps1"
+	}
+	script, err := editInEditor(h.gui, editorConfig, template, fileExtension)
+	if err != nil {
+		eventing.SendFailureStatus(err.Error())
+		return nil
+	}
+	if strings.TrimSpace(script) == strings.TrimSpace(template) {
+		eventing.SendStatusEvent(&eventing.StatusEvent{
+			Message: "No script entered - no further action.",
+			Timeout: time.Second * 3,
+		})
+		return nil
+	}
+
+	vm := h.vm
+	go func() {
+		// recover from panic, if one occurrs, and leave terminal usable
+		defer errorhandling.RecoveryWithCleanup()
+
+		// Run command waits for the script to complete on the VM which can take a while
+		event, _ := eventing.SendStatusEvent(&eventing.StatusEvent{
+			InProgress: true,
+			Message:    "Running " + commandID + " on " + vm.Name,
+			Timeout:    time.Minute * 15,
+		})
+		output, err := expanders.RunVMCommand(h.ctx, armclient.LegacyInstance, vm.ID, commandID, script)
+		if err != nil {
+			event.Failure = true
+			event.InProgress = false
+			event.Message = err.Error()
+			event.SetTimeout(time.Second * 10)
+			event.Update()
+			return
+		}
+		event.InProgress = false
+		event.Message = "Completed " + commandID + " on " + vm.Name
+		event.SetTimeout(time.Second * 3)
+		event.Update()
+
+		h.gui.Update(func(gui *gocui.Gui) error {
+			h.content.SetContent(vm, output, expanders.ResponsePlainText, "[Run command] "+vm.Name)
+			return nil
+		})
+	}()
+	return nil
+}
+
+////////////////////////////////////////////////////////////////////
+
+////////////////////////////////////////////////////////////////////
+type ListDebugCopyItemDataHandler struct {
+	ListHandler
+	List      *views.ListWidget
+	StatusBar *views.StatusbarWidget
+}
+
+var _ Command = &ListDebugCopyItemDataHandler{}
+
+func NewListDebugCopyItemDataHandler(list *views.ListWidget, statusBar *views.StatusbarWidget) *ListDebugCopyItemDataHandler {
+	handler := &ListDebugCopyItemDataHandler{
+		List:      list,
+		StatusBar: statusBar,
+	}
+	handler.id = HandlerIDListDebugCopyItemData
+	return handler
+}
+
+func (h ListDebugCopyItemDataHandler) Fn() func(g *gocui.Gui, v *gocui.View) error {
+	return func(g *gocui.Gui, v *gocui.View) error {
+		return h.Invoke()
+	}
+}
+
+func (h *ListDebugCopyItemDataHandler) DisplayText() string {
+	return "DEBUG: Copy current node data"
+}
+func (h *ListDebugCopyItemDataHandler) IsEnabled() bool {
+	return h.List.CurrentExpandedItem() != nil
+}
+func (h *ListDebugCopyItemDataHandler) Invoke() error {
+	item := h.List.CurrentExpandedItem()
+	if item != nil {
+		buf, err := json.MarshalIndent(item, "", "  ")
+		if err != nil {
+			h.StatusBar.Status(fmt.Sprintf("Failed to generate node summary: %s", err.Error()), false)
+			return nil
+		}
+		if err := copyToClipboard(string(buf)); err != nil {
+			h.StatusBar.Status(fmt.Sprintf("Failed to copy node data to clipboard: %s", err.Error()), false)
+			return nil
+		}
+		h.StatusBar.Status("Current node data copied to clipboard", false)
+		return nil
+	}
+	return nil
+}
+
+////////////////////////////////////////////////////////////////////
+
+////////////////////////////////////////////////////////////////////
+type ListExplainExpandHandler struct {
+	ListHandler
+	List    *views.ListWidget
+	Content *views.ItemWidget
+}
+
+var _ Command = &ListExplainExpandHandler{}
+
+func NewListExplainExpandHandler(list *views.ListWidget, content *views.ItemWidget) *ListExplainExpandHandler {
+	handler := &ListExplainExpandHandler{
+		List:    list,
+		Content: content,
+	}
+	handler.id = HandlerIDListExplainExpand
+	return handler
+}
+
+func (h ListExplainExpandHandler) Fn() func(g *gocui.Gui, v *gocui.View) error {
+	return func(g *gocui.Gui, v *gocui.View) error {
+		if h.IsEnabled() {
+			return h.Invoke()
+		}
+		return nil
+	}
+}
+
+func (h *ListExplainExpandHandler) DisplayText() string {
+	return "DEBUG: Explain expansion of current node"
+}
+func (h *ListExplainExpandHandler) IsEnabled() bool {
+	item := h.List.CurrentExpandedItem()
+	return item != nil && item.ExpandExplanation() != nil
+}
+func (h *ListExplainExpandHandler) Invoke() error {
+	item := h.List.CurrentExpandedItem()
+	if item == nil || item.ExpandExplanation() == nil {
 		return nil
 	}
+	h.Content.SetContent(nil, expanders.RenderExpandExplanation(item.ExpandExplanation()), expanders.ResponsePlainText, "Expansion of "+item.Name)
 	return nil
 }
 
@@ -25,6 +25,11 @@ func LoadDB() {
 	initDb(diskLocation, mockableClock.New())
 }
 
+// LoadDBFromDir initializes and loads the DB instance from the directory, for example a temp directory for tests
+func LoadDBFromDir(location string) {
+	initDb(location, mockableClock.New())
+}
+
 func initDb(location string, inputClock mockableClock.Clock) {
 	clock = inputClock
 	flatTransform := func(s string) []string { return []string{} }
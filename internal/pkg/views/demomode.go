@@ -13,6 +13,9 @@ type NameAndNodeType struct {
 // Matcher for connectionstrings config
 var globalConnectionStringsConfig = NameAndNodeType{"connectionstrings", "Microsoft.Web/sites/config"}
 
+// Matcher for appsettings config
+var globalAppSettingsConfig = NameAndNodeType{"appsettings", "Microsoft.Web/sites/config"}
+
 // getNameAndType of a json object, if possible.
 func getNameAndType(s string) (NameAndNodeType, bool) {
 	typRe := regexp.MustCompile(`"type":\s*"(.+?(?:\\"|[^"])*)"`)
@@ -46,6 +49,18 @@ func StripSecretVals(s string) string {
 	fqdnRegex := regexp.MustCompile(`"fqdn":\s*".+?(?:\\"|[^"])*"`)
 	s = fqdnRegex.ReplaceAllString(s, `"fqdn": "HIDDEN-FQDN"`)
 
+	s = StripSecrets(s)
+
+	// name must run after StripSecrets, since type-specific redaction parses the name node
+	nameRegex := regexp.MustCompile(`"name":\s*".+?(?:\\"|[^"])*"`)
+	s = nameRegex.ReplaceAllString(s, `"name": "HIDDEN-NAME"`)
+
+	return s
+}
+
+// StripSecrets removes keys, passwords and other secrets but leaves IDs, names and locations.
+// It's used by StripSecretVals and when capturing test fixtures
+func StripSecrets(s string) string {
 	scmURIRegex := regexp.MustCompile(`"scmUri":\s*".+?(?:\\"|[^"])*"`)
 	s = scmURIRegex.ReplaceAllString(s, `"scmUri": "HIDDEN-URI"`)
 
@@ -65,6 +80,10 @@ func StripSecretVals(s string) string {
 	anyPasswordRegex := regexp.MustCompile(`(".*[pP]assword":\s*").+?(?:\\"|[^"])*(")`)
 	s = anyPasswordRegex.ReplaceAllString(s, "${1}HIDDEN-PASSWORD${2}")
 
+	// secrets embedded in connection strings, e.g. decrypted app settings
+	connectionStringSecretRegex := regexp.MustCompile(`((?i:AccountKey|SharedAccessKey|SharedAccessSignature|Password|Pwd)=)[^;"]+`)
+	s = connectionStringSecretRegex.ReplaceAllString(s, "${1}HIDDEN")
+
 	sshRegex := regexp.MustCompile(`ssh-rsa AAAA[0-9A-Za-z+/]+[=]{0,3}[ ]?(?:[^@]+@[^@"]+)?`)
 	s = sshRegex.ReplaceAllString(s, "SSH-PUBLIC-KEY-HIDDEN")
 
@@ -75,12 +94,15 @@ func StripSecretVals(s string) string {
 		case globalConnectionStringsConfig:
 			valueRegex := regexp.MustCompile(`"value":\s*".+?(?:\\"|[^"])*"`)
 			s = valueRegex.ReplaceAllString(s, `"value": "HIDDEN"`)
+		case globalAppSettingsConfig:
+			// any app setting can hold a secret so hide all of the values
+			propertiesRegex := regexp.MustCompile(`"properties":\s*\{[^{}]*\}`)
+			settingRegex := regexp.MustCompile(`("(?:\\"|[^"])*":\s*)"(?:\\"|[^"])*"`)
+			s = propertiesRegex.ReplaceAllStringFunc(s, func(properties string) string {
+				return settingRegex.ReplaceAllString(properties, `${1}"HIDDEN"`)
+			})
 		}
 	}
 
-	// name must run after type-specific, since type-specific parses the name node
-	nameRegex := regexp.MustCompile(`"name":\s*".+?(?:\\"|[^"])*"`)
-	s = nameRegex.ReplaceAllString(s, `"name": "HIDDEN-NAME"`)
-
 	return s
 }
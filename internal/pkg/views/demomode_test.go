@@ -530,10 +530,39 @@ var successfullyReplaceSecretsTestData = []struct {
 			"type": "Microsoft.Web/sites/config",
 			"location": "HIDDEN-LOCATION",
 			"properties": {
-				"ProductionSetting1": "PublicValue",
-				"ProductionSecret": "HIDDEN-SECRET",
-				"ProductionKey": "HIDDEN-KEY",
-				"ProductionSetting2": "StillPublicValue"
+				"ProductionSetting1": "HIDDEN",
+				"ProductionSecret": "HIDDEN",
+				"ProductionKey": "HIDDEN",
+				"ProductionSetting2": "HIDDEN"
+			}
+		}`,
+	},
+	{
+		desc: "webapp/config/appsettings with connection strings",
+		input: `
+		{
+			"id": "/subscriptions/abcdef12-0751-dead-beef-6150896ac498/resourceGroups/my-rg/providers/Microsoft.Web/sites/my-site/config/appsettings",
+			"name": "appsettings",
+			"type": "Microsoft.Web/sites/config",
+			"location": "Central US",
+			"properties": {
+				"AzureWebJobsStorage": "DefaultEndpointsProtocol=https;AccountName=mystorage;AccountKey=c2VjcmV0;EndpointSuffix=core.windows.net",
+				"ServiceBus": "Endpoint=sb://my-bus.servicebus.windows.net/;SharedAccessKeyName=RootManageSharedAccessKey;SharedAccessKey=c2VjcmV0",
+				"Database": "Server=tcp:my-db.database.windows.net,1433;User ID=admin;Password=real-password;",
+				"FUNCTIONS_WORKER_RUNTIME": "node"
+			}
+		}`,
+		expected: `
+		{
+			"id": "HIDDEN",
+			"name": "HIDDEN-NAME",
+			"type": "Microsoft.Web/sites/config",
+			"location": "HIDDEN-LOCATION",
+			"properties": {
+				"AzureWebJobsStorage": "HIDDEN",
+				"ServiceBus": "HIDDEN",
+				"Database": "HIDDEN",
+				"FUNCTIONS_WORKER_RUNTIME": "HIDDEN"
 			}
 		}`,
 	},
@@ -22,6 +22,7 @@ const tmplText = `
 | Go back                  | {{ index . "listback" }}
 | Expand/View resource     | {{ index . "listexpand" }}
 | Refresh                  | {{ index . "listrefresh" }}
+| Cancel loading           | {{ index . "listcancelexpand" }}
 | Filter                   | {{ index . "filter" }}
 | Clear filter             | {{ index . "listclearfilter" }}
 | Open Command Panel       | {{ index . "commandpanelopen" }}
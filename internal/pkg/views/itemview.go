@@ -4,12 +4,15 @@ import (
 	"bytes"
 	"encoding/json"
 	"fmt"
+	"io"
+	"regexp"
 	"strings"
 	"time"
 
 	"github.com/go-xmlfmt/xmlfmt"
 	"github.com/lawrencegripper/azbrowse/internal/pkg/eventing"
 	"github.com/lawrencegripper/azbrowse/internal/pkg/expanders"
+	"github.com/lawrencegripper/azbrowse/internal/pkg/style"
 	"github.com/stuartleeks/colorjson"
 	"github.com/stuartleeks/gocui"
 
@@ -30,8 +33,13 @@ type ItemWidget struct {
 	view         *gocui.View
 	shouldRender bool
 	g            *gocui.Gui
+
+	renderedLines []string // Lines last rendered, without colours
+	previousLines []string // Lines rendered before UpdateContent, used to highlight changes
 }
 
+var ansiEscapeRegex = regexp.MustCompile("\x1b\\[[0-9;]*m")
+
 // NewItemWidget creates a new instance of ItemWidget
 func NewItemWidget(x, y, w, h int, hideGuids bool, shouldRender bool, content string) *ItemWidget {
 	configureYAMLHighlighting()
@@ -65,53 +73,69 @@ func (w *ItemWidget) Layout(g *gocui.Gui) error {
 		if w.hideGuids {
 			w.content = StripSecretVals(w.content)
 		}
-		switch w.contentType {
-		case expanders.ResponseJSON:
-			d := json.NewDecoder(strings.NewReader(w.content))
-			d.UseNumber()
-			var obj interface{}
-			err = d.Decode(&obj)
-			if err != nil {
-				eventing.SendStatusEvent(&eventing.StatusEvent{
-					InProgress: false,
-					Failure:    true,
-					Message:    "Failed to display as JSON: " + err.Error(),
-					Timeout:    time.Duration(time.Second * 4),
-				})
-				fmt.Fprint(v, w.content)
-				return nil
-			}
-
-			f := colorjson.NewFormatter()
-			f.Indent = 2
-			s, err := f.Marshal(obj)
-			if err != nil {
-				fmt.Fprint(v, w.content)
-			} else {
-				fmt.Fprint(v, string(s))
-			}
-		case expanders.ResponseYAML:
-			var buf bytes.Buffer
-			err = quick.Highlight(&buf, w.content, "YAML-azbrowse", "terminal", "azbrowse")
-			if err != nil {
-				fmt.Fprint(v, w.content)
-			} else {
-				fmt.Fprint(v, buf.String())
-			}
-
-		case expanders.ResponseXML:
-			formattedContent := strings.TrimSpace(xmlfmt.FormatXML(w.content, "", "  "))
-			formattedContent = strings.ReplaceAll(formattedContent, "\r", "")
-			fmt.Fprint(v, formattedContent)
-
-		default:
-			fmt.Fprint(v, w.content)
-		}
+		w.renderedLines = w.writeContent(v, w.render())
 	}
 
 	return nil
 }
 
+// render formats the content based on its type
+func (w *ItemWidget) render() string {
+	switch w.contentType {
+	case expanders.ResponseJSON:
+		d := json.NewDecoder(strings.NewReader(w.content))
+		d.UseNumber()
+		var obj interface{}
+		err := d.Decode(&obj)
+		if err != nil {
+			eventing.SendStatusEvent(&eventing.StatusEvent{
+				InProgress: false,
+				Failure:    true,
+				Message:    "Failed to display as JSON: " + err.Error(),
+				Timeout:    time.Duration(time.Second * 4),
+			})
+			return w.content
+		}
+
+		f := colorjson.NewFormatter()
+		f.Indent = 2
+		s, err := f.Marshal(obj)
+		if err != nil {
+			return w.content
+		}
+		return string(s)
+	case expanders.ResponseYAML:
+		var buf bytes.Buffer
+		err := quick.Highlight(&buf, w.content, "YAML-azbrowse", "terminal", "azbrowse")
+		if err != nil {
+			return w.content
+		}
+		return buf.String()
+
+	case expanders.ResponseXML:
+		formattedContent := strings.TrimSpace(xmlfmt.FormatXML(w.content, "", "  "))
+		return strings.ReplaceAll(formattedContent, "\r", "")
+
+	default:
+		return w.content
+	}
+}
+
+// writeContent writes the rendered content to the view, highlighting lines which
+// changed since UpdateContent was called, and returns the lines without colours
+func (w *ItemWidget) writeContent(v io.Writer, rendered string) []string {
+	lines := strings.Split(rendered, "\n")
+	plainLines := make([]string, len(lines))
+	for i, line := range lines {
+		plainLines[i] = ansiEscapeRegex.ReplaceAllString(line, "")
+		if w.previousLines != nil && (i >= len(w.previousLines) || w.previousLines[i] != plainLines[i]) {
+			lines[i] = style.Highlight(plainLines[i])
+		}
+	}
+	fmt.Fprint(v, strings.Join(lines, "\n"))
+	return plainLines
+}
+
 // PageDown move the view down a page
 func (w *ItemWidget) PageDown() {
 	_, maxHeight := w.view.Size()
@@ -163,6 +187,7 @@ func (w *ItemWidget) SetContent(node *expanders.TreeNode, content string, conten
 		w.node = node
 		w.content = content
 		w.contentType = contentType
+		w.previousLines = nil
 		// Reset the cursor and origin (scroll poisition)
 		// so we don't start at the bottom of a new doc
 		w.view.SetCursor(0, 0) //nolint: errcheck
@@ -172,6 +197,32 @@ func (w *ItemWidget) SetContent(node *expanders.TreeNode, content string, conten
 	})
 }
 
+// UpdateContent replaces the content for the current node keeping the scroll
+// position, lines which differ from the previous content are highlighted
+func (w *ItemWidget) UpdateContent(content string, contentType expanders.ExpanderResponseType) {
+	w.previousLines = w.renderedLines
+	w.content = content
+	w.contentType = contentType
+}
+
+// ShowLogTail shows the tailed log lines for the node, scrolling to the end when following.
+// This must be called on the UI goroutine.
+func (w *ItemWidget) ShowLogTail(node *expanders.TreeNode, content string, title string, follow bool) {
+	w.node = node
+	w.content = content
+	w.contentType = expanders.ResponsePlainText
+	w.previousLines = nil
+	w.view.Title = title
+	if follow {
+		_, height := w.view.Size()
+		y := strings.Count(content, "\n") + 1 - height
+		if y < 0 {
+			y = 0
+		}
+		w.view.SetOrigin(0, y) //nolint: errcheck
+	}
+}
+
 // GetContent returns the current content
 func (w *ItemWidget) GetContent() string {
 	return w.content
@@ -5,10 +5,13 @@ import (
 	"fmt"
 	"sort"
 	"strings"
+	"time"
 
+	"github.com/lawrencegripper/azbrowse/internal/pkg/errorhandling"
 	"github.com/lawrencegripper/azbrowse/internal/pkg/eventing"
 	"github.com/lawrencegripper/azbrowse/internal/pkg/expanders"
 	"github.com/lawrencegripper/azbrowse/internal/pkg/style"
+	"github.com/lawrencegripper/azbrowse/pkg/armclient"
 	"github.com/stuartleeks/gocui"
 )
 
@@ -30,6 +33,11 @@ type ListWidget struct {
 	lastTopIndex         int
 	shouldRender         bool
 	lastCalculatedHeight int
+	watchedNode          *expanders.TreeNode
+	watchInterval        time.Duration
+	stopWatching         context.CancelFunc
+	expandGeneration     int                // incremented on each navigation so streamed results for a superseded expansion aren't shown
+	cancelExpand         context.CancelFunc // cancels the in-flight expansion until its page is shown, when it moves to Page.cancelExpand
 }
 
 // ListNavigatedEventState captures the state when raising a `list.navigated` event
@@ -63,22 +71,25 @@ func (w *ListWidget) SetFilter(filterString string) {
 	if w.currentPage == nil {
 		return
 	}
-	filteredItems := []*expanders.TreeNode{}
-	for _, item := range w.currentPage.Items {
-		if strings.Contains(strings.ToLower(item.Display), filterString) {
-			filteredItems = append(filteredItems, item)
-		}
-	}
-
 	w.currentPage.Selection = 0
 	w.currentPage.FilterString = filterString
-	w.currentPage.FilteredItems = filteredItems
+	w.currentPage.FilteredItems = filterItems(w.currentPage.Items, filterString)
 
 	w.g.Update(func(gui *gocui.Gui) error {
 		return nil
 	})
 }
 
+func filterItems(items []*expanders.TreeNode, filterString string) []*expanders.TreeNode {
+	filteredItems := []*expanders.TreeNode{}
+	for _, item := range items {
+		if strings.Contains(strings.ToLower(item.Display), filterString) {
+			filteredItems = append(filteredItems, item)
+		}
+	}
+	return filteredItems
+}
+
 // ClearFilter clears a filter if applied
 func (w *ListWidget) ClearFilter() {
 	if w.currentPage != nil {
@@ -139,7 +150,14 @@ func (w *ListWidget) Layout(g *gocui.Gui) error {
 				itemToShow = "  "
 			}
 
-			itemToShow = itemToShow + highlightText(s.Display, w.currentPage.FilterString) + " " + s.StatusIndicator + "\n" + style.Separator("  ---") + "\n"
+			itemToShow = itemToShow + highlightText(s.Display, w.currentPage.FilterString) + " " + s.StatusIndicator
+			if s.Stale {
+				itemToShow = itemToShow + " " + style.Subtle("(cached)")
+			}
+			if w.currentPage.ChangedItemIDs[s.ID] {
+				itemToShow = itemToShow + " " + style.Highlight("●")
+			}
+			itemToShow = itemToShow + "\n" + style.Separator("  ---") + "\n"
 
 			linesUsedCount += strings.Count(itemToShow, "\n")
 			renderedItems = append(renderedItems, itemToShow)
@@ -185,6 +203,9 @@ func (w *ListWidget) Layout(g *gocui.Gui) error {
 		if w.currentPage.FilterString != "" {
 			title += "[filter=" + w.currentPage.FilterString + "]"
 		}
+		if w.watchedNode != nil {
+			title += "[watch=" + w.watchInterval.String() + "]"
+		}
 		if len(title) > width {
 			trimLength := len(title) - width + 5 // Add five for spacing and elipsis
 			title = ".." + title[trimLength:]
@@ -217,7 +238,8 @@ func (w *ListWidget) Refresh() {
 	}
 
 	w.GoBack()
-	w.ExpandCurrentSelection()
+	// Bypass the response cache so the latest state is shown
+	w.expandCurrentSelection(armclient.WithoutResponseCache(w.newExpandContext()))
 
 	w.ChangeSelection(currentSelection)
 
@@ -235,6 +257,8 @@ func (w *ListWidget) Refresh() {
 // GoBack takes the user back to preview view
 func (w *ListWidget) GoBack() {
 	eventing.Publish("list.prenavigate", "GOBACK")
+	w.cancelPendingExpand()
+	w.expandGeneration++
 
 	if w.currentPage == nil {
 		return
@@ -245,6 +269,10 @@ func (w *ListWidget) GoBack() {
 		return
 	}
 	previousPage := w.navStack.Pop()
+	if previousPage != nil {
+		// The current page is discarded so stop streaming items to it
+		cancelPageExpand(w.currentPage)
+	}
 	if previousPage == nil {
 		eventing.Publish("list.navigated", ListNavigatedEventState{Success: false})
 		return
@@ -264,8 +292,39 @@ func (w *ListWidget) GoBack() {
 	})
 }
 
+// GoHome takes the user back to the first page of the list
+func (w *ListWidget) GoHome() {
+	eventing.Publish("list.prenavigate", "GOHOME")
+	w.cancelPendingExpand()
+	w.expandGeneration++
+
+	homePage := w.currentPage
+	for page := w.navStack.Pop(); page != nil; page = w.navStack.Pop() {
+		cancelPageExpand(homePage)
+		homePage = page
+	}
+	if homePage == nil {
+		eventing.Publish("list.navigated", ListNavigatedEventState{Success: false})
+		return
+	}
+	w.currentPage = homePage
+	w.ClearFilter()
+	w.contentView.SetContent(homePage.ExpandedNodeItem, homePage.Data, homePage.DataType, "Response")
+
+	eventing.Publish("list.navigated", ListNavigatedEventState{
+		Success:      true,
+		NewNodes:     w.currentPage.Items,
+		ParentNodeID: "root",
+		IsBack:       true,
+	})
+}
+
 // ExpandCurrentSelection opens the resource Sub->RG for example
 func (w *ListWidget) ExpandCurrentSelection() {
+	w.expandCurrentSelection(w.newExpandContext())
+}
+
+func (w *ListWidget) expandCurrentSelection(ctx context.Context) {
 
 	suppressPreviousTitle := false
 	if w.currentPage != nil && w.currentPage.Title == "Subscriptions" {
@@ -277,8 +336,9 @@ func (w *ListWidget) ExpandCurrentSelection() {
 	newTitle := fmt.Sprintf("[%s-> Fullscreen|%s -> Actions] %s", strings.ToUpper(w.FullscreenKeyBinding), strings.ToUpper(w.ActionKeyBinding), currentItem.Name)
 
 	eventing.Publish("list.prenavigate", currentItem.ID)
+	w.expandGeneration++
 
-	newContent, newItems, err := expanders.ExpandItem(w.ctx, currentItem)
+	newContent, newItems, err := expanders.ExpandItem(ctx, currentItem)
 	if err != nil { // Don't need to display error as expander emits status event on error
 		// Set parameters to trigger non-successful `list.navigated` event
 		newItems = []*expanders.TreeNode{}
@@ -288,8 +348,246 @@ func (w *ListWidget) ExpandCurrentSelection() {
 	w.Navigate(newItems, newContent, newTitle, suppressPreviousTitle)
 }
 
+// ExpandCurrentSelectionStreaming opens the current selection without waiting for all expanders to complete.
+// The list navigates when the first nodes arrive with a placeholder for each expander still running,
+// and nodes from the remaining expanders are added as they complete
+func (w *ListWidget) ExpandCurrentSelectionStreaming() {
+
+	suppressPreviousTitle := false
+	if w.currentPage != nil && w.currentPage.Title == "Subscriptions" {
+		suppressPreviousTitle = true
+	}
+
+	currentItem := w.CurrentItem()
+	if currentItem == nil || currentItem.ItemType == expanders.LoadingType {
+		return
+	}
+	if currentItem.ItemType == expanders.LoadMoreType {
+		w.loadMore(currentItem)
+		return
+	}
+
+	newTitle := fmt.Sprintf("[%s-> Fullscreen|%s -> Actions] %s", strings.ToUpper(w.FullscreenKeyBinding), strings.ToUpper(w.ActionKeyBinding), currentItem.Name)
+
+	eventing.Publish("list.prenavigate", currentItem.ID)
+	w.expandGeneration++
+	stream := &expandStream{
+		generation:            w.expandGeneration,
+		item:                  currentItem,
+		title:                 newTitle,
+		suppressPreviousTitle: suppressPreviousTitle,
+	}
+
+	progressChan := expanders.StreamExpandItem(w.newExpandContext(), currentItem)
+	stream.cancel = w.cancelExpand
+	go func() {
+		// recover from panic, if one occurrs, and leave terminal usable
+		defer errorhandling.RecoveryWithCleanup()
+
+		for progress := range progressChan {
+			progress := progress
+			// stream is only used in g.Update so is only accessed from the gocui main loop
+			w.g.Update(func(gui *gocui.Gui) error {
+				w.applyExpandProgress(stream, progress)
+				return nil
+			})
+		}
+	}()
+}
+
+// loadMore replaces the "more..." node with the next page of nodes, staying on the current page
+func (w *ListWidget) loadMore(node *expanders.TreeNode) {
+	page := w.currentPage
+	ctx := w.newExpandContext()
+	go func() {
+		// recover from panic, if one occurrs, and leave terminal usable
+		defer errorhandling.RecoveryWithCleanup()
+
+		_, nodes, err := expanders.ExpandItem(ctx, node)
+		if err != nil || ctx.Err() != nil { // Don't need to display error as expander emits status event on error
+			return
+		}
+		for _, newNode := range nodes {
+			if newNode.ItemType == expanders.ErrorType {
+				// Keep the "more..." node so the user can retry, the failure is shown in the status bar
+				return
+			}
+		}
+		w.g.Update(func(gui *gocui.Gui) error {
+			w.replacePageItem(page, node, nodes)
+			return nil
+		})
+	}()
+}
+
+// replacePageItem replaces the node on the page with the new nodes, selecting the first of them
+func (w *ListWidget) replacePageItem(page *Page, node *expanders.TreeNode, newNodes []*expanders.TreeNode) {
+	items := make([]*expanders.TreeNode, 0, len(page.Items)+len(newNodes))
+	found := false
+	for _, item := range page.Items {
+		if item == node {
+			items = append(items, newNodes...)
+			found = true
+			continue
+		}
+		items = append(items, item)
+	}
+	if !found {
+		// The page has been updated since the node was expanded (eg refreshed)
+		return
+	}
+
+	w.setPageItems(page, items)
+	if len(newNodes) == 0 {
+		return
+	}
+	pageItems := page.Items
+	if page.FilterString != "" {
+		pageItems = page.FilteredItems
+	}
+	for index, item := range pageItems {
+		if item == newNodes[0] {
+			page.Selection = index
+			break
+		}
+	}
+}
+
+// newExpandContext cancels the in-flight expansion, if its page hasn't been shown, and returns the context for a new one.
+// Expansions whose page has been shown continue so the page is complete when the user goes back to it
+func (w *ListWidget) newExpandContext() context.Context {
+	w.cancelPendingExpand()
+	ctx, cancel := context.WithCancel(w.ctx)
+	w.cancelExpand = cancel
+	return ctx
+}
+
+// CancelExpand cancels the in-flight expansion (if any) and the expansion streaming items
+// to the current page, aborting their requests
+func (w *ListWidget) CancelExpand() {
+	w.cancelPendingExpand()
+	if w.currentPage != nil {
+		cancelPageExpand(w.currentPage)
+	}
+}
+
+// cancelPendingExpand cancels the in-flight expansion whose page hasn't been shown
+func (w *ListWidget) cancelPendingExpand() {
+	if w.cancelExpand != nil {
+		w.cancelExpand()
+		w.cancelExpand = nil
+	}
+}
+
+// cancelPageExpand cancels the expansion streaming items to the page
+func cancelPageExpand(page *Page) {
+	if page.cancelExpand != nil {
+		page.cancelExpand()
+		page.cancelExpand = nil
+	}
+}
+
+// expandStream tracks a streamed expansion started by ExpandCurrentSelectionStreaming
+type expandStream struct {
+	generation            int
+	item                  *expanders.TreeNode
+	title                 string
+	suppressPreviousTitle bool
+	page                  *Page // the page listing the item's nodes, nil until the first nodes arrive
+	contentShown          bool
+	cancel                context.CancelFunc
+}
+
+// applyExpandProgress updates the list with streamed results. Once the page listing the nodes
+// is created it continues to be updated even if the user has navigated away from it
+func (w *ListWidget) applyExpandProgress(stream *expandStream, progress expanders.ExpandProgress) {
+	isCurrentExpansion := stream.generation == w.expandGeneration
+
+	if stream.page == nil {
+		if !isCurrentExpansion {
+			// Superseded before any nodes were shown
+			return
+		}
+		if !hasLoadedNodes(progress.Nodes) {
+			if progress.Done {
+				// No child nodes so show the content (or failure) for the item as ExpandCurrentSelection does
+				if progress.Err != nil {
+					w.navigate(stream.item, []*expanders.TreeNode{}, nil, "", stream.suppressPreviousTitle)
+				} else {
+					w.navigate(stream.item, []*expanders.TreeNode{}, progress.Content, stream.title, stream.suppressPreviousTitle)
+				}
+				return
+			}
+			w.showStreamedContent(stream, progress.Content)
+			return
+		}
+
+		titlePrefix := ""
+		if !stream.suppressPreviousTitle && w.currentPage != nil {
+			titlePrefix = w.currentPage.Title + ">"
+		}
+		w.SetNewNodes(progress.Nodes)
+		stream.page = w.currentPage
+		stream.page.Title = titlePrefix + stream.item.Name
+		stream.page.ExpandedNodeItem = stream.item
+		// Now the page is shown, navigating to a child no longer cancels the expansion (see newExpandContext)
+		stream.page.cancelExpand = stream.cancel
+		w.cancelExpand = nil
+		if progress.Content == nil && !stream.contentShown {
+			w.contentView.SetContent(stream.item, "Loading...", expanders.ResponsePlainText, stream.title)
+		}
+	} else {
+		w.setPageItems(stream.page, progress.Nodes)
+	}
+
+	if progress.Content != nil {
+		stream.page.Data = progress.Content.Response
+		stream.page.DataType = progress.Content.ResponseType
+		if isCurrentExpansion {
+			w.showStreamedContent(stream, progress.Content)
+		}
+	}
+
+	if progress.Done {
+		// Release the expansion's context
+		cancelPageExpand(stream.page)
+	}
+	if progress.Done && isCurrentExpansion {
+		eventing.Publish("list.navigated", ListNavigatedEventState{
+			Success:      true,
+			NewNodes:     stream.page.Items,
+			ParentNodeID: stream.item.ID,
+			NodeID:       stream.item.ID,
+		})
+	}
+}
+
+// showStreamedContent shows the primary response the first time it's received
+func (w *ListWidget) showStreamedContent(stream *expandStream, content *expanders.ExpanderResponse) {
+	if content == nil || stream.contentShown {
+		return
+	}
+	stream.contentShown = true
+	w.contentView.SetContent(stream.item, content.Response, content.ResponseType, stream.title)
+}
+
+// hasLoadedNodes checks whether there are any nodes other than loading placeholders
+func hasLoadedNodes(nodes []*expanders.TreeNode) bool {
+	for _, node := range nodes {
+		if node.ItemType != expanders.LoadingType {
+			return true
+		}
+	}
+	return false
+}
+
 // Navigate updates the currently selected list nodes, title and details content
 func (w *ListWidget) Navigate(nodes []*expanders.TreeNode, content *expanders.ExpanderResponse, title string, suppressPreviousTitle bool) {
+	w.navigate(w.CurrentItem(), nodes, content, title, suppressPreviousTitle)
+}
+
+// navigate updates the list nodes, title and details content for the expanded item
+func (w *ListWidget) navigate(currentItem *expanders.TreeNode, nodes []*expanders.TreeNode, content *expanders.ExpanderResponse, title string, suppressPreviousTitle bool) {
 
 	titlePrefix := ""
 	if !suppressPreviousTitle && w.currentPage != nil {
@@ -297,8 +595,8 @@ func (w *ListWidget) Navigate(nodes []*expanders.TreeNode, content *expanders.Ex
 	}
 	if len(nodes) == 0 && content == nil && title == "" {
 		eventing.Publish("list.navigated", ListNavigatedEventState{Success: false})
+		return
 	}
-	currentItem := w.CurrentItem()
 
 	if len(nodes) > 0 {
 		// Saves to current page to the nav stack and creates new current page.
@@ -465,15 +763,167 @@ func (w *ListWidget) SortItems() {
 	if w.currentPage == nil {
 		return
 	}
+	sortItemsByName(w.currentPage.Items)
+	w.currentPage.Sorted = true
+}
+
+func sortItemsByName(items []*expanders.TreeNode) {
 	getSortName := func(itemName string) string {
 		return strings.ToLower(itemName)
 	}
 	sortFunc := func(i, j int) bool {
-		iValue := getSortName(w.currentPage.Items[i].Name)
-		jValue := getSortName(w.currentPage.Items[j].Name)
+		iValue := getSortName(items[i].Name)
+		jValue := getSortName(items[j].Name)
 		return iValue < jValue
 	}
 
-	sort.Slice(w.currentPage.Items, sortFunc)
-	w.currentPage.Sorted = true
+	sort.Slice(items, sortFunc)
+}
+
+// Watch re-expands the node shown in the item view every interval, updating the
+// item view and list in place. Only one node is watched at a time and refreshes
+// are skipped while the node isn't being shown.
+func (w *ListWidget) Watch(interval time.Duration) {
+	w.StopWatching()
+
+	node := w.contentView.GetNode()
+	if node == nil {
+		eventing.SendStatusEvent(&eventing.StatusEvent{
+			Failure: true,
+			Message: "Nothing to watch, expand an item first",
+			Timeout: time.Second * 5,
+		})
+		return
+	}
+
+	ctx, cancel := context.WithCancel(expanders.WithoutExpandStatus(armclient.WithoutResponseCache(w.ctx)))
+	w.watchedNode = node
+	w.watchInterval = interval
+	w.stopWatching = cancel
+
+	go func() {
+		// recover from panic, if one occurrs, and leave terminal usable
+		defer errorhandling.RecoveryWithCleanup()
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		shownNode := make(chan *expanders.TreeNode, 1)
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+
+			// The item view is read on the UI goroutine
+			w.g.Update(func(gui *gocui.Gui) error {
+				// Match on ID as refreshing the list creates new nodes
+				current := w.contentView.GetNode()
+				if current != nil && current.ID != node.ID {
+					current = nil
+				}
+				shownNode <- current
+				return nil
+			})
+			var current *expanders.TreeNode
+			select {
+			case <-ctx.Done():
+				return
+			case current = <-shownNode:
+			}
+			if current == nil {
+				continue
+			}
+			content, nodes, err := expanders.ExpandItem(ctx, current)
+			if err != nil || ctx.Err() != nil {
+				// Expanders emit status events on error so keep watching
+				continue
+			}
+
+			w.g.Update(func(gui *gocui.Gui) error {
+				if ctx.Err() == nil && w.contentView.GetNode() == current {
+					w.updateWatchedNode(current, content, nodes)
+				}
+				return nil
+			})
+		}
+	}()
+}
+
+// StopWatching stops refreshing the watched node
+func (w *ListWidget) StopWatching() {
+	if w.stopWatching != nil {
+		w.stopWatching()
+	}
+	w.watchedNode = nil
+	w.stopWatching = nil
+}
+
+// WatchedNode returns the node being watched or nil if not watching
+func (w *ListWidget) WatchedNode() *expanders.TreeNode {
+	return w.watchedNode
+}
+
+// updateWatchedNode shows the latest content for the node and, if the node's
+// children are listed, replaces them keeping the selection, sort and filter
+func (w *ListWidget) updateWatchedNode(node *expanders.TreeNode, content *expanders.ExpanderResponse, nodes []*expanders.TreeNode) {
+	w.contentView.UpdateContent(content.Response, content.ResponseType)
+
+	if w.currentPage == nil || w.currentPage.ExpandedNodeItem != node || len(nodes) == 0 {
+		return
+	}
+
+	previousItems := map[string]*expanders.TreeNode{}
+	for _, item := range w.currentPage.Items {
+		previousItems[item.ID] = item
+	}
+	changedItemIDs := map[string]bool{}
+	for _, item := range nodes {
+		previous, found := previousItems[item.ID]
+		if !found || previous.Display != item.Display || previous.StatusIndicator != item.StatusIndicator {
+			changedItemIDs[item.ID] = true
+		}
+	}
+
+	w.currentPage.Data = content.Response
+	w.currentPage.DataType = content.ResponseType
+	w.currentPage.ChangedItemIDs = changedItemIDs
+	w.setPageItems(w.currentPage, nodes)
+}
+
+// setPageItems replaces the items on the page keeping the selection, sort and filter
+func (w *ListWidget) setPageItems(page *Page, nodes []*expanders.TreeNode) {
+	pageItems := func() []*expanders.TreeNode {
+		if page.FilterString == "" {
+			return page.Items
+		}
+		return page.FilteredItems
+	}
+
+	selectedID := ""
+	if items := pageItems(); page.Selection >= 0 && page.Selection < len(items) {
+		selectedID = items[page.Selection].ID
+	}
+
+	page.Items = nodes
+	if page.Sorted {
+		sortItemsByName(page.Items)
+	}
+	if page.FilterString != "" {
+		page.FilteredItems = filterItems(page.Items, page.FilterString)
+	}
+
+	items := pageItems()
+	for index, item := range items {
+		if item.ID == selectedID {
+			page.Selection = index
+			return
+		}
+	}
+	if page.Selection >= len(items) {
+		page.Selection = len(items) - 1
+	}
+	if page.Selection < 0 {
+		page.Selection = 0
+	}
 }
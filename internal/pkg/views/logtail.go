@@ -0,0 +1,193 @@
+package views
+
+import (
+	"context"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/lawrencegripper/azbrowse/internal/pkg/errorhandling"
+	"github.com/lawrencegripper/azbrowse/internal/pkg/eventing"
+	"github.com/lawrencegripper/azbrowse/internal/pkg/expanders"
+	"github.com/lawrencegripper/azbrowse/pkg/armclient"
+	"github.com/stuartleeks/gocui"
+)
+
+// maxLogTailLines limits how many lines are kept while tailing logs
+const maxLogTailLines = 5000
+
+// LogTail streams logs for a container into the item view
+type LogTail struct {
+	gui       *gocui.Gui
+	content   *ItemWidget
+	node      *expanders.TreeNode
+	container string
+	lines     []string
+	filter    *regexp.Regexp
+	paused    bool
+	cancel    context.CancelFunc
+}
+
+// NewLogTail creates a new instance
+func NewLogTail(gui *gocui.Gui, content *ItemWidget) *LogTail {
+	return &LogTail{gui: gui, content: content}
+}
+
+// Start stops any current tail and starts streaming logs for the container into the item view
+func (t *LogTail) Start(ctx context.Context, node *expanders.TreeNode, container string) {
+	t.Stop()
+
+	ctx, cancel := context.WithCancel(ctx)
+	t.node = node
+	t.container = container
+	t.lines = []string{}
+	t.paused = false
+	t.cancel = cancel
+	t.gui.Update(func(g *gocui.Gui) error {
+		t.render()
+		return nil
+	})
+
+	// Stop when the user navigates, rather than waiting for the next line to arrive
+	navigating := eventing.SubscribeToTopic("list.prenavigate")
+	go func() {
+		// recover from panic, if one occurrs, and leave terminal usable
+		defer errorhandling.RecoveryWithCleanup()
+		defer eventing.Unsubscribe(navigating)
+
+		select {
+		case <-navigating:
+			t.gui.Update(func(g *gocui.Gui) error {
+				// The context is cancelled if this tail has already been stopped or replaced
+				if ctx.Err() == nil {
+					t.Stop()
+				}
+				return nil
+			})
+		case <-ctx.Done():
+		}
+	}()
+
+	lines := make(chan string, 100)
+	go func() {
+		// recover from panic, if one occurrs, and leave terminal usable
+		defer errorhandling.RecoveryWithCleanup()
+		defer close(lines)
+
+		err := expanders.StreamLogs(ctx, armclient.LegacyInstance, node, container, lines)
+		if err != nil {
+			eventing.SendStatusEvent(&eventing.StatusEvent{
+				Failure: true,
+				Message: "Failed tailing logs: " + err.Error(),
+				Timeout: time.Second * 5,
+			})
+		}
+	}()
+
+	go func() {
+		// recover from panic, if one occurrs, and leave terminal usable
+		defer errorhandling.RecoveryWithCleanup()
+
+		for line := range lines {
+			newLines := []string{line}
+			// Batch up lines which have already arrived to avoid redrawing for each one
+			for len(lines) > 0 {
+				newLines = append(newLines, <-lines)
+			}
+			t.gui.Update(func(g *gocui.Gui) error {
+				if ctx.Err() != nil {
+					return nil
+				}
+				// Stop if the user has moved on to another item
+				if t.content.GetNode() != node {
+					t.Stop()
+					return nil
+				}
+				t.lines = append(t.lines, newLines...)
+				if len(t.lines) > maxLogTailLines {
+					t.lines = t.lines[len(t.lines)-maxLogTailLines:]
+				}
+				if !t.paused {
+					t.render()
+				}
+				return nil
+			})
+		}
+	}()
+}
+
+// Stop stops streaming logs
+func (t *LogTail) Stop() {
+	if t.cancel != nil {
+		t.cancel()
+	}
+	t.cancel = nil
+	t.node = nil
+}
+
+// IsTailing checks if logs are currently being streamed
+func (t *LogTail) IsTailing() bool {
+	return t.cancel != nil
+}
+
+// Node returns the node logs are being streamed for
+func (t *LogTail) Node() *expanders.TreeNode {
+	return t.node
+}
+
+// IsPaused checks if updates to the item view are paused
+func (t *LogTail) IsPaused() bool {
+	return t.paused
+}
+
+// SetPaused pauses or resumes following the log. Lines are still collected while paused.
+func (t *LogTail) SetPaused(paused bool) {
+	t.paused = paused
+	t.gui.Update(func(g *gocui.Gui) error {
+		t.render()
+		return nil
+	})
+}
+
+// SetFilter only shows lines matching the regular expression, an empty filter shows all lines
+func (t *LogTail) SetFilter(filter string) error {
+	if filter == "" {
+		t.filter = nil
+	} else {
+		filterRegex, err := regexp.Compile(filter)
+		if err != nil {
+			return err
+		}
+		t.filter = filterRegex
+	}
+	t.gui.Update(func(g *gocui.Gui) error {
+		t.render()
+		return nil
+	})
+	return nil
+}
+
+// render must be called on the UI goroutine
+func (t *LogTail) render() {
+	if t.node == nil {
+		return
+	}
+	lines := t.lines
+	if t.filter != nil {
+		lines = []string{}
+		for _, line := range t.lines {
+			if t.filter.MatchString(line) {
+				lines = append(lines, line)
+			}
+		}
+	}
+
+	title := "Tailing logs: " + t.container
+	if t.filter != nil {
+		title += " [filter=" + t.filter.String() + "]"
+	}
+	if t.paused {
+		title += " [paused]"
+	}
+	t.content.ShowLogTail(t.node, strings.Join(lines, "\n"), title, !t.paused)
+}
@@ -123,6 +123,27 @@ func (w *NotificationWidget) ConfirmDelete() {
 		ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
 		defer cancel()
 
+		// Check for locks up front so we don't fail part way through the batch.
+		// The pending list is kept so the locks can be removed and the delete retried.
+		for _, i := range pending {
+			locks, err := expanders.GetBlockingLocks(ctx, w.client, i)
+			if err != nil {
+				// Not being able to read locks shouldn't stop the delete, ARM will still enforce them
+				continue
+			}
+			if len(locks) > 0 {
+				lockNames := make([]string, len(locks))
+				for index, lock := range locks {
+					lockNames[index] = lock.Name + " (" + lock.Properties.Level + ")"
+				}
+				event.Failure = true
+				event.InProgress = false
+				event.Message = "Can't delete `" + i.Name + "` as it is locked by: " + strings.Join(lockNames, ", ") + ". Nothing has been deleted."
+				event.Update()
+				return
+			}
+		}
+
 		for _, i := range pending {
 			var err error
 			fallback := true
@@ -234,3 +234,46 @@ func Test_Delete_RefusedDeleteWhileInprogress(t *testing.T) {
 		t.Errorf("Expected message 'Delete already in progress. Please wait for completion.' Got: %s", failureStatus.Message)
 	}
 }
+
+func Test_Delete_StopWhenLocked(t *testing.T) {
+	statusEvents := eventing.SubscribeToStatusEvents()
+	defer eventing.Unsubscribe(statusEvents)
+
+	deleteCount := 0
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Logf("SEVER MESSAGE: received: %s method: %s", r.URL.String(), r.Method)
+		if r.Method == "DELETE" {
+			deleteCount = deleteCount + 1
+			return
+		}
+		// rg2 is protected by a lock applied to its subscription
+		if strings.HasPrefix(r.URL.Path, "/subscriptions/1/resourceGroups/rg2/") {
+			_, _ = w.Write([]byte(`{"value": [{"id": "/subscriptions/1/providers/Microsoft.Authorization/locks/keep", "name": "keep", "properties": {"level": "CanNotDelete"}}]}`))
+			return
+		}
+		_, _ = w.Write([]byte(`{"value": []}`))
+	}))
+	defer ts.Close()
+
+	client := armclient.NewClientFromConfig(ts.Client(), dummyTokenFunc(), 5000)
+
+	// The widget isn't drawn so doesn't need a gui
+	notView := &NotificationWidget{client: client}
+	notView.pendingDeletes = []*expanders.TreeNode{
+		{Name: "rg1", ID: ts.URL + "/subscriptions/1/resourceGroups/rg1", ItemType: expanders.ResourceType, DeleteURL: ts.URL + "/subscriptions/1/resourceGroups/rg1"},
+		{Name: "rg2", ID: ts.URL + "/subscriptions/1/resourceGroups/rg2", ItemType: expanders.ResourceType, DeleteURL: ts.URL + "/subscriptions/1/resourceGroups/rg2"},
+	}
+	notView.ConfirmDelete()
+
+	// ConfirmDelete returns before it's finished
+	failureStatus := eventing.WaitForFailureStatusEvent(t, statusEvents, 5)
+	if !strings.Contains(failureStatus.Message, "`rg2`") || !strings.Contains(failureStatus.Message, "keep (CanNotDelete)") {
+		t.Errorf("Expected the message to name the locked item and lock. Got: %s", failureStatus.Message)
+	}
+	if deleteCount != 0 {
+		t.Errorf("Expected no deletes to be sent. Got: %d", deleteCount)
+	}
+	if len(notView.pendingDeletes) != 2 {
+		t.Errorf("Expected the pending deletes to be kept. Got: %d", len(notView.pendingDeletes))
+	}
+}
@@ -1,6 +1,10 @@
 package views
 
-import "github.com/lawrencegripper/azbrowse/internal/pkg/expanders"
+import (
+	"context"
+
+	"github.com/lawrencegripper/azbrowse/internal/pkg/expanders"
+)
 
 // Page represents a previous view in the nav stack
 type Page struct {
@@ -13,6 +17,9 @@ type Page struct {
 	FilteredItems    []*expanders.TreeNode
 	ExpandedNodeItem *expanders.TreeNode
 	Sorted           bool
+	ChangedItemIDs   map[string]bool // Items which changed the last time a watched page was refreshed
+
+	cancelExpand context.CancelFunc // cancels the streamed expansion still adding items to the page
 }
 
 // Stack is a basic LIFO stack that resizes as needed.
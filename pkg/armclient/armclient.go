@@ -8,7 +8,9 @@ import (
 	"fmt"
 	"io/ioutil"
 	"net/http"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"golang.org/x/time/rate"
@@ -37,6 +39,9 @@ type Client struct {
 	tenantID           string
 	responseProcessors []ResponseProcessor
 	limiter            *rate.Limiter
+	responseCache      *ResponseCache
+	responseCacheMutex sync.Mutex // guards lastCachedTenantID and the response cache indexes
+	lastCachedTenantID string
 
 	acquireToken TokenFunc
 }
@@ -122,9 +127,17 @@ func (c *Client) DoRequest(ctx context.Context, method, path string) (string, er
 
 // DoRawRequest makes a raw request with ARM authentication headers set
 func (c *Client) DoRawRequest(ctx context.Context, req *http.Request) (*http.Response, error) {
+	// Don't use up the rate limit on requests which are no longer needed
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if c.IsOffline() {
+		return nil, fmt.Errorf("%w: %s %s", ErrOffline, req.Method, req.URL)
+	}
+
 	cliToken, err := c.acquireToken(false)
 	if err != nil {
-		return nil, errors.New("Failed to acquire auth token: " + err.Error())
+		return nil, fmt.Errorf("Failed to acquire auth token: %w", err)
 	}
 	c.tenantID = cliToken.Tenant
 
@@ -144,15 +157,31 @@ func (c *Client) DoRawRequest(ctx context.Context, req *http.Request) (*http.Res
 		span, _ = tracing.StartSpanFromContext(ctx, "ratelimitted")
 		eventing.SendFailureStatus("Request rate limitted due to high call volume")
 	}
-	time.Sleep(reservation.Delay())
+	select {
+	case <-time.After(delay):
+	case <-ctx.Done():
+		// Return the reservation so the cancelled request doesn't delay others
+		reservation.Cancel()
+		if span != nil {
+			span.Finish()
+		}
+		return nil, ctx.Err()
+	}
 	if span != nil {
 		span.Finish()
 	}
 	return c.client.Do(req.WithContext(ctx))
 }
 
-// DoRequestWithBody makes an ARM rest request
+// DoRequestWithBody makes an ARM rest request, using the response cache for GET requests if it's enabled
 func (c *Client) DoRequestWithBody(ctx context.Context, method, path, body string) (string, error) {
+	if c.responseCache != nil {
+		return c.doCachedRequest(ctx, method, path, body)
+	}
+	return c.doRequestWithBody(ctx, method, path, body)
+}
+
+func (c *Client) doRequestWithBody(ctx context.Context, method, path, body string) (string, error) {
 	span, _ := tracing.StartSpanFromContext(ctx, "request:"+method, tracing.SetTag("path", path))
 	defer span.Finish()
 
@@ -218,6 +247,63 @@ func (c *Client) DoRequestWithBody(ctx context.Context, method, path, body strin
 	return string(buf), responseErr
 }
 
+// longRunningPollInterval is how often a long running operation is polled
+// when ARM doesn't return a Retry-After header
+var longRunningPollInterval = 5 * time.Second
+
+// DoLongRunningRequestWithBody makes an ARM rest request and, if ARM accepts it as a
+// long running operation, polls the operation's Location until it completes returning the final body
+func (c *Client) DoLongRunningRequestWithBody(ctx context.Context, method, path, body string) (string, error) {
+	span, _ := tracing.StartSpanFromContext(ctx, "longrunningrequest:"+method, tracing.SetTag("path", path))
+	defer span.Finish()
+
+	url, err := getRequestURL(path)
+	if err != nil {
+		return "", err
+	}
+
+	req, err := http.NewRequest(method, url, bytes.NewReader([]byte(body)))
+	if err != nil {
+		return "", fmt.Errorf("Failed to create request for body: %w", err)
+	}
+
+	for {
+		response, err := c.DoRawRequest(ctx, req)
+		if err != nil {
+			return "", fmt.Errorf("Request failed: %w", err)
+		}
+		buf, err := ioutil.ReadAll(response.Body)
+		response.Body.Close() //nolint: errcheck
+		if err != nil {
+			return "", fmt.Errorf("Request failed: %w", err)
+		}
+
+		if response.StatusCode < 200 || response.StatusCode > 299 {
+			return string(buf), fmt.Errorf("Request returned a non-success status code of %v with a status message of %s", response.StatusCode, response.Status)
+		}
+
+		pollURL := response.Header.Get("Location")
+		if response.StatusCode != http.StatusAccepted || pollURL == "" {
+			return string(buf), nil
+		}
+
+		pollInterval := longRunningPollInterval
+		if retryAfter, err := strconv.Atoi(response.Header.Get("Retry-After")); err == nil {
+			pollInterval = time.Duration(retryAfter) * time.Second
+		}
+		select {
+		case <-time.After(pollInterval):
+		case <-ctx.Done():
+			return "", ctx.Err()
+		}
+
+		req, err = http.NewRequest("GET", pollURL, nil)
+		if err != nil {
+			return "", fmt.Errorf("Failed to create poll request: %w", err)
+		}
+	}
+}
+
 // DoResourceGraphQuery performs an azure graph query
 func (c *Client) DoResourceGraphQuery(ctx context.Context, subscription, query string) (string, error) {
 	messageBody := `{"subscriptions": ["SUB_HERE"], "query": "QUERY_HERE", "options": {"$top": 1000, "$skip": 0}}`
@@ -2,10 +2,16 @@ package armclient
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
+	"os"
 	"testing"
 	"time"
+
+	"github.com/lawrencegripper/azbrowse/internal/pkg/storage"
 )
 
 func Test_ArmClient_AzCliToken_Refresh(t *testing.T) {
@@ -59,3 +65,259 @@ func Test_ArmClient_AzCliToken_DontRefresh(t *testing.T) {
 		t.Error("Expected cache not to be cleared for azcli token")
 	}
 }
+
+func Test_ArmClient_LongRunningRequest_PollsUntilComplete(t *testing.T) {
+	polls := 0
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Logf("SEVER MESSAGE: received: %s method: %s", r.URL.String(), r.Method)
+		if r.Method == "POST" || polls < 2 {
+			if r.Method == "GET" {
+				polls++
+			}
+			w.Header().Set("Location", "http://"+r.Host+"/subscriptions/1/providers/Microsoft.Compute/locations/westeurope/operations/1")
+			w.Header().Set("Retry-After", "0")
+			w.WriteHeader(http.StatusAccepted)
+			return
+		}
+		fmt.Fprint(w, `{"value": "done"}`)
+	}))
+	defer ts.Close()
+
+	tokenFunc := func(clearCache bool) (AzCLIToken, error) {
+		return AzCLIToken{}, nil
+	}
+	client := NewClientFromConfig(ts.Client(), tokenFunc, 5000)
+
+	body, err := client.DoLongRunningRequestWithBody(context.Background(), "POST", ts.URL+"/subscriptions/1/resourceGroups/rg1/providers/Microsoft.Compute/virtualMachines/vm1/runCommand", "{}")
+	if err != nil {
+		t.Errorf("Expected no error, got: %s", err)
+	}
+	if polls != 2 {
+		t.Errorf("Expected 2 polls, got: %d", polls)
+	}
+	if body != `{"value": "done"}` {
+		t.Errorf("Expected the body of the completed operation, got: %s", body)
+	}
+}
+
+func Test_ArmClient_LongRunningRequest_ReturnsCancellation(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Location", "http://"+r.Host+"/subscriptions/1/providers/Microsoft.Compute/locations/westeurope/operations/1")
+		w.Header().Set("Retry-After", "1")
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer ts.Close()
+
+	tokenFunc := func(clearCache bool) (AzCLIToken, error) {
+		return AzCLIToken{}, nil
+	}
+	client := NewClientFromConfig(ts.Client(), tokenFunc, 5000)
+	path := ts.URL + "/subscriptions/1/resourceGroups/rg1/providers/Microsoft.Compute/virtualMachines/vm1/runCommand"
+
+	cancelledCtx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err := client.DoLongRunningRequestWithBody(cancelledCtx, "POST", path, "{}")
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected the request error to wrap context.Canceled, got: %v", err)
+	}
+
+	// Cancelled while waiting to poll the operation
+	timeoutCtx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	_, err = client.DoLongRunningRequestWithBody(timeoutCtx, "POST", path, "{}")
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expected context.DeadlineExceeded, got: %v", err)
+	}
+}
+
+func Test_ArmClient_CancelledRequest_DoesntWaitForRateLimit(t *testing.T) {
+	requestCount := 0
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requestCount++
+		fmt.Fprint(w, "{}")
+	}))
+	defer ts.Close()
+
+	tokenFunc := func(clearCache bool) (AzCLIToken, error) {
+		return AzCLIToken{}, nil
+	}
+	client := NewClientFromConfig(ts.Client(), tokenFunc, 1)
+
+	// Use up the burst so the next request has to wait
+	for i := 0; i < 10; i++ {
+		client.limiter.Reserve()
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	_, err := client.DoRequest(ctx, "GET", ts.URL+"/subscriptions/1")
+	if err == nil {
+		t.Error("Expected an error for the cancelled request")
+	}
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Errorf("Expected cancelled request to return without waiting for the rate limit, took %s", elapsed)
+	}
+
+	cancelledCtx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = client.DoRequest(cancelledCtx, "GET", ts.URL+"/subscriptions/1")
+	if err == nil {
+		t.Error("Expected an error for the cancelled request")
+	}
+	if requestCount != 0 {
+		t.Errorf("Expected no requests to be sent, got %d", requestCount)
+	}
+}
+
+func Test_ArmClient_ResponseCache(t *testing.T) {
+	dir, err := ioutil.TempDir("", "azb-responsecache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir) //nolint: errcheck
+	storage.LoadDBFromDir(dir)
+
+	requestCount := 0
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requestCount++
+		fmt.Fprintf(w, `{"request": %d}`, requestCount)
+	}))
+	defer ts.Close()
+
+	tokenFunc := func(clearCache bool) (AzCLIToken, error) {
+		return AzCLIToken{Tenant: "tenant1"}, nil
+	}
+	client := NewClientFromConfig(ts.Client(), tokenFunc, 5000)
+	client.SetResponseCache(&ResponseCache{
+		DefaultTTL: time.Hour,
+		TypeTTLs:   map[string]time.Duration{"Microsoft.Insights/metrics": 0},
+	})
+
+	resourceURL := ts.URL + "/subscriptions/1/resourceGroups/rg1?api-version=2018-05-01"
+	metricsURL := ts.URL + "/subscriptions/1/resourceGroups/rg1/providers/Microsoft.Web/sites/site1/providers/Microsoft.Insights/metrics?api-version=2018-01-01"
+
+	get := func(ctx context.Context, url string) string {
+		body, err := client.DoRequest(ctx, "GET", url)
+		if err != nil {
+			t.Errorf("Expected no error, got: %s", err)
+		}
+		return body
+	}
+
+	// Served from the cache after the first request
+	first := get(context.Background(), resourceURL)
+	second := get(context.Background(), resourceURL)
+	if first != second || requestCount != 1 {
+		t.Errorf("Expected second request to be served from the cache, got %s after %s with %d requests", second, first, requestCount)
+	}
+
+	// Bypassing the cache updates it
+	refreshed := get(WithoutResponseCache(context.Background()), resourceURL)
+	if refreshed == first || get(context.Background(), resourceURL) != refreshed {
+		t.Errorf("Expected bypassing the cache to send the request and update the cache, got %s", refreshed)
+	}
+
+	// Types with a TTL of 0 aren't cached
+	get(context.Background(), metricsURL)
+	get(context.Background(), metricsURL)
+	if requestCount != 4 {
+		t.Errorf("Expected metrics requests not to be cached, got %d requests", requestCount)
+	}
+
+	// Offline serves cached responses and marks them stale
+	client.SetResponseCache(&ResponseCache{DefaultTTL: time.Hour, Offline: true})
+	ctx, usage := WithResponseCacheUsage(context.Background())
+	if offline := get(ctx, resourceURL); offline != refreshed {
+		t.Errorf("Expected offline request to be served from the cache, got %s", offline)
+	}
+	if !usage.Stale() {
+		t.Error("Expected offline response to be marked stale")
+	}
+	_, err = client.DoRequest(context.Background(), "GET", metricsURL)
+	if !errors.Is(err, ErrOffline) {
+		t.Errorf("Expected ErrOffline for a request which isn't cached, got: %v", err)
+	}
+	_, err = client.DoRequestWithBody(context.Background(), "PUT", resourceURL, "{}")
+	if !errors.Is(err, ErrOffline) {
+		t.Errorf("Expected ErrOffline for a PUT request, got: %v", err)
+	}
+	if requestCount != 4 {
+		t.Errorf("Expected no requests to be sent while offline, got %d requests", requestCount)
+	}
+}
+
+func Test_ArmClient_ResponseCache_WriteInvalidatesCollections(t *testing.T) {
+	dir, err := ioutil.TempDir("", "azb-responsecache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir) //nolint: errcheck
+	storage.LoadDBFromDir(dir)
+
+	requestCount := 0
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requestCount++
+		fmt.Fprintf(w, `{"request": %d}`, requestCount)
+	}))
+	defer ts.Close()
+
+	tokenFunc := func(clearCache bool) (AzCLIToken, error) {
+		return AzCLIToken{Tenant: "tenant1"}, nil
+	}
+	client := NewClientFromConfig(ts.Client(), tokenFunc, 5000)
+	client.SetResponseCache(&ResponseCache{DefaultTTL: time.Hour})
+
+	resourcesURL := ts.URL + "/subscriptions/1/resourceGroups/rg1/resources?api-version=2017-05-10"
+	sitesURL := ts.URL + "/subscriptions/1/resourceGroups/rg1/providers/Microsoft.Web/sites?api-version=2019-08-01"
+	otherRGURL := ts.URL + "/subscriptions/1/resourceGroups/rg2/resources?api-version=2017-05-10"
+	siteURL := ts.URL + "/subscriptions/1/resourceGroups/rg1/providers/Microsoft.Web/sites/site1?api-version=2019-08-01"
+
+	get := func(url string) string {
+		body, err := client.DoRequest(context.Background(), "GET", url)
+		if err != nil {
+			t.Errorf("Expected no error, got: %s", err)
+		}
+		return body
+	}
+
+	resources := get(resourcesURL)
+	sites := get(sitesURL)
+	otherRG := get(otherRGURL)
+	if get(resourcesURL) != resources || get(sitesURL) != sites || requestCount != 3 {
+		t.Fatalf("Expected the lists to be served from the cache, got %d requests", requestCount)
+	}
+
+	_, err = client.DoRequest(context.Background(), "DELETE", siteURL)
+	if err != nil {
+		t.Fatalf("Expected no error, got: %s", err)
+	}
+
+	// The lists containing the deleted site are requested again, others are still served from the cache
+	if get(resourcesURL) == resources {
+		t.Error("Expected the resource group's resources list to be requested after the delete")
+	}
+	if get(sitesURL) == sites {
+		t.Error("Expected the sites list to be requested after the delete")
+	}
+	if get(otherRGURL) != otherRG {
+		t.Error("Expected the other resource group's list to be served from the cache")
+	}
+	if requestCount != 6 {
+		t.Errorf("Expected 6 requests, got %d", requestCount)
+	}
+}
+
+func Test_ArmClient_ArmTypeFromPath(t *testing.T) {
+	tests := map[string]string{
+		"/subscriptions/1/resourceGroups?api-version=2018-05-01":                                                                "subscriptions/resourceGroups",
+		"/subscriptions/1/providers":                                                                                            "subscriptions/providers",
+		"/subscriptions/1/resourceGroups/rg1/providers/Microsoft.Web/sites/site1/config":                                        "Microsoft.Web/sites/config",
+		"https://management.azure.com/subscriptions/1/providers/Microsoft.Web/sites/site1/providers/Microsoft.Insights/metrics": "Microsoft.Insights/metrics",
+	}
+	for path, expected := range tests {
+		if armType := armTypeFromPath(path); armType != expected {
+			t.Errorf("Expected %s for %s, got %s", expected, path, armType)
+		}
+	}
+}
@@ -19,3 +19,15 @@ func GetSubscriptionIDFromResourceID(resourceID string) string {
 	}
 	return matches[1]
 }
+
+var resourceGroupRegex = regexp.MustCompile("(?i)/resourceGroups/([^/]+)")
+
+// GetResourceGroupFromResourceID returns the resource group name from a resource ID
+// Returns empty string on no match
+func GetResourceGroupFromResourceID(resourceID string) string {
+	matches := resourceGroupRegex.FindStringSubmatch(resourceID)
+	if matches == nil {
+		return ""
+	}
+	return matches[1]
+}
@@ -15,3 +15,13 @@ func Test_GetSubscriptionIDFromResourceID_WithInvalidResourceID(t *testing.T) {
 	result := GetSubscriptionIDFromResourceID("/blah/")
 	assert.Equal(t, result, "")
 }
+
+func Test_GetResourceGroupFromResourceID_WithValidResourceID(t *testing.T) {
+	result := GetResourceGroupFromResourceID("/subscriptions/247bb195-ea6f-415c-b5b9-719c92d3cdab/resourcegroups/stable/providers/Microsoft.Network/networkInterfaces/nic")
+	assert.Equal(t, result, "stable")
+}
+
+func Test_GetResourceGroupFromResourceID_WithInvalidResourceID(t *testing.T) {
+	result := GetResourceGroupFromResourceID("/subscriptions/247bb195-ea6f-415c-b5b9-719c92d3cdab")
+	assert.Equal(t, result, "")
+}
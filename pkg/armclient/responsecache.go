@@ -0,0 +1,251 @@
+package armclient
+
+import (
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/lawrencegripper/azbrowse/internal/pkg/storage"
+)
+
+const (
+	responseCacheKeyPrefix   = "responseCache-"
+	responseCacheIndexPrefix = "responseCacheIndex-"
+	responseCacheTenantKey   = "responseCacheTenant"
+)
+
+// ErrOffline is returned for requests which can't be served from the response cache in offline mode
+var ErrOffline = errors.New("Not available offline")
+
+// ResponseCache configures caching of GET responses from ARM.
+// Responses are keyed by URL and tenant and held in the storage package
+type ResponseCache struct {
+	DefaultTTL time.Duration            // How long responses are served from the cache
+	TypeTTLs   map[string]time.Duration // Overrides DefaultTTL by ARM type (eg Microsoft.Insights/metrics), a TTL of 0 disables caching for the type
+	TenantID   string                   // The tenant to use when offline (defaults to the tenant last used online)
+	Offline    bool                     // Serve responses only from the cache regardless of their age, failing other requests
+}
+
+// SetResponseCache enables caching of GET responses. Pass nil to disable caching
+func (c *Client) SetResponseCache(cache *ResponseCache) {
+	if cache != nil && cache.TypeTTLs != nil {
+		typeTTLs := map[string]time.Duration{}
+		for armType, ttl := range cache.TypeTTLs {
+			typeTTLs[strings.ToLower(armType)] = ttl
+		}
+		cache.TypeTTLs = typeTTLs
+	}
+	c.responseCache = cache
+}
+
+// IsOffline returns true if the client only serves responses from the response cache
+func (c *Client) IsOffline() bool {
+	return c.responseCache != nil && c.responseCache.Offline
+}
+
+type responseCacheContextKey int
+
+const (
+	responseCacheBypassKey responseCacheContextKey = iota
+	responseCacheUsageKey
+)
+
+// WithoutResponseCache returns a context for which GET requests are sent to ARM (updating the cache)
+// rather than served from the response cache. Cached responses are still used when offline
+func WithoutResponseCache(ctx context.Context) context.Context {
+	return context.WithValue(ctx, responseCacheBypassKey, true)
+}
+
+// ResponseCacheUsage records whether the requests made with a context were served from the response cache
+type ResponseCacheUsage struct {
+	mu    sync.Mutex
+	stale bool
+}
+
+// WithResponseCacheUsage returns a context which records the use of the response cache
+func WithResponseCacheUsage(ctx context.Context) (context.Context, *ResponseCacheUsage) {
+	usage := &ResponseCacheUsage{}
+	return context.WithValue(ctx, responseCacheUsageKey, usage), usage
+}
+
+// Stale returns true if any of the responses were served from the cache while offline
+// or after their TTL had expired
+func (u *ResponseCacheUsage) Stale() bool {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	return u.stale
+}
+
+func (u *ResponseCacheUsage) recordStale() {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.stale = true
+}
+
+// doCachedRequest serves GET requests from the response cache when possible
+func (c *Client) doCachedRequest(ctx context.Context, method, path, body string) (string, error) {
+	cache := c.responseCache
+	key, tenantID, requestPath, err := c.responseCacheKey(path)
+	if err != nil {
+		return "", err
+	}
+
+	if method != "GET" {
+		if cache.Offline {
+			return "", fmt.Errorf("%w: %s %s", ErrOffline, method, path)
+		}
+		response, err := c.doRequestWithBody(ctx, method, path, body)
+		if err == nil {
+			// Don't serve the previous state of a resource which has been updated or deleted,
+			// either directly or in the lists that contain it
+			storage.DeleteCache(key) //nolint: errcheck
+			c.invalidateResponseCacheCollections(tenantID, requestPath)
+		}
+		return response, err
+	}
+
+	ttl := cache.ttlFor(path)
+	if cache.Offline || (ttl > 0 && ctx.Value(responseCacheBypassKey) == nil) {
+		valid, value, err := storage.GetCacheWithTTL(key, ttl)
+		if err == nil && value != "" && (valid || cache.Offline) {
+			if usage, ok := ctx.Value(responseCacheUsageKey).(*ResponseCacheUsage); ok && (cache.Offline || !valid) {
+				usage.recordStale()
+			}
+			return value, nil
+		}
+	}
+	if cache.Offline {
+		return "", fmt.Errorf("%w: %s hasn't been cached", ErrOffline, path)
+	}
+
+	response, err := c.doRequestWithBody(ctx, method, path, body)
+	if err == nil && ttl > 0 {
+		storage.PutCacheForTTL(key, response) //nolint: errcheck
+		c.addToResponseCacheIndex(tenantID, requestPath, key)
+	}
+	return response, err
+}
+
+// responseCacheKey returns the storage key for the path, the key is hashed as storage
+// uses keys as file names. The tenant and the lower case path without the query are returned for
+// use with the response cache indexes
+func (c *Client) responseCacheKey(path string) (key string, tenantID string, requestPath string, err error) {
+	requestURL, err := getRequestURL(path)
+	if err != nil {
+		return "", "", "", err
+	}
+	tenantID, err = c.responseCacheTenantID()
+	if err != nil {
+		return "", "", "", err
+	}
+	hash := sha256.Sum256([]byte(tenantID + "|" + requestURL))
+	requestPath = requestURL
+	if u, err := url.Parse(requestURL); err == nil {
+		requestPath = u.Path
+	}
+	requestPath = strings.TrimSuffix(strings.ToLower(requestPath), "/")
+	return fmt.Sprintf("%s%x", responseCacheKeyPrefix, hash), tenantID, requestPath, nil
+}
+
+// responseCacheIndexKey returns the storage key for the index of the responses cached for a path.
+// A path can have several responses cached, eg for different api-versions
+func responseCacheIndexKey(tenantID string, requestPath string) string {
+	hash := sha256.Sum256([]byte(tenantID + "|" + requestPath))
+	return fmt.Sprintf("%s%x", responseCacheIndexPrefix, hash)
+}
+
+// addToResponseCacheIndex records that a response for the path is cached under key
+func (c *Client) addToResponseCacheIndex(tenantID string, requestPath string, key string) {
+	c.responseCacheMutex.Lock()
+	defer c.responseCacheMutex.Unlock()
+
+	indexKey := responseCacheIndexKey(tenantID, requestPath)
+	index, _ := storage.GetCache(indexKey) //nolint: errcheck
+	for _, indexed := range strings.Split(index, "\n") {
+		if indexed == key {
+			return
+		}
+	}
+	storage.PutCache(indexKey, strings.TrimPrefix(index+"\n"+key, "\n")) //nolint: errcheck
+}
+
+// invalidateResponseCacheCollections removes the cached responses for the path, eg with other api-versions,
+// and for the paths above it which may list it, such as the resource group's resources list
+func (c *Client) invalidateResponseCacheCollections(tenantID string, requestPath string) {
+	c.responseCacheMutex.Lock()
+	defer c.responseCacheMutex.Unlock()
+
+	for parentPath := requestPath; parentPath != ""; parentPath = parentPath[:strings.LastIndex(parentPath, "/")] {
+		for _, path := range []string{parentPath, parentPath + "/resources"} {
+			indexKey := responseCacheIndexKey(tenantID, path)
+			index, _ := storage.GetCache(indexKey) //nolint: errcheck
+			if index == "" {
+				continue
+			}
+			for _, key := range strings.Split(index, "\n") {
+				storage.DeleteCache(key) //nolint: errcheck
+			}
+			storage.DeleteCache(indexKey) //nolint: errcheck
+		}
+	}
+}
+
+// responseCacheTenantID returns the tenant of the current token, or when offline
+// the configured tenant falling back to the tenant last used online
+func (c *Client) responseCacheTenantID() (string, error) {
+	if c.responseCache.Offline {
+		if c.responseCache.TenantID != "" {
+			return c.responseCache.TenantID, nil
+		}
+		return storage.GetCache(responseCacheTenantKey)
+	}
+
+	cliToken, err := c.acquireToken(false)
+	if err != nil {
+		return "", errors.New("Failed to acquire auth token: " + err.Error())
+	}
+	c.responseCacheMutex.Lock()
+	defer c.responseCacheMutex.Unlock()
+	if cliToken.Tenant != c.lastCachedTenantID {
+		storage.PutCache(responseCacheTenantKey, cliToken.Tenant) //nolint: errcheck
+		c.lastCachedTenantID = cliToken.Tenant
+	}
+	return cliToken.Tenant, nil
+}
+
+// ttlFor returns the TTL for the ARM type requested by the path
+func (cache *ResponseCache) ttlFor(path string) time.Duration {
+	if ttl, ok := cache.TypeTTLs[strings.ToLower(armTypeFromPath(path))]; ok {
+		return ttl
+	}
+	return cache.DefaultTTL
+}
+
+// armTypeFromPath returns the ARM type requested by the path,
+// eg /subscriptions/1/resourceGroups/rg/providers/Microsoft.Web/sites/site/config returns Microsoft.Web/sites/config
+// and /subscriptions/1/resourceGroups returns subscriptions/resourceGroups
+func armTypeFromPath(path string) string {
+	if u, err := url.Parse(path); err == nil {
+		path = u.Path
+	}
+	segments := strings.Split(strings.Trim(path, "/"), "/")
+
+	typeSegments := []string{}
+	start := 0
+	for i := len(segments) - 2; i >= 0; i-- {
+		if strings.EqualFold(segments[i], "providers") {
+			typeSegments = append(typeSegments, segments[i+1])
+			start = i + 2
+			break
+		}
+	}
+	for i := start; i < len(segments); i += 2 {
+		typeSegments = append(typeSegments, segments[i])
+	}
+	return strings.Join(typeSegments, "/")
+}